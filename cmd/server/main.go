@@ -3,13 +3,21 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"maps"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
+	_ "time/tzdata" // customer_timezone lookups; the runtime image has no zoneinfo
 
 	"github.com/eabugauch/zenithpay-retry/internal/domain"
 	"github.com/eabugauch/zenithpay-retry/internal/handler"
@@ -19,87 +27,332 @@ import (
 	"github.com/eabugauch/zenithpay-retry/internal/webhook"
 )
 
+// Build metadata, injected at build time via
+// -ldflags "-X main.Version=... -X main.Commit=... -X main.BuildTime=...".
+var (
+	Version   = "dev"
+	Commit    = "dev"
+	BuildTime = "dev"
+)
+
 func main() {
-	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	logger := newLogger(os.Stdout, os.Getenv("LOG_FORMAT"), os.Getenv("LOG_LEVEL"))
 
 	// Load retry strategy overrides from config file (if configured)
-	if configPath := os.Getenv("RETRY_CONFIG_PATH"); configPath != "" {
-		if err := domain.LoadRetryConfig(configPath); err != nil {
-			logger.Error("failed to load retry config", "path", configPath, "error", err)
-			os.Exit(1)
-		}
-		logger.Info("retry strategies loaded from config", "path", configPath)
+	if err := loadRetryConfig(logger, os.Getenv("RETRY_CONFIG_PATH")); err != nil {
+		logger.Error("failed to load retry config", "error", err)
+		os.Exit(1)
 	}
 
 	// Initialize dependencies
 	txStore := store.New()
 	notifier := webhook.NewNotifier(logger)
-	simulator := retry.NewSimulator(time.Now().UnixNano())
+	if v := os.Getenv("WEBHOOK_WORKERS"); v != "" {
+		workers, err := strconv.Atoi(v)
+		if err != nil || workers <= 0 {
+			logger.Error("invalid WEBHOOK_WORKERS", "value", v)
+			os.Exit(1)
+		}
+		cfg := webhook.QueueConfig{
+			Workers:      workers,
+			Size:         100 * workers,
+			Overflow:     webhook.OverflowDrop,
+			BlockTimeout: 500 * time.Millisecond,
+		}
+		if v := os.Getenv("WEBHOOK_QUEUE_SIZE"); v != "" {
+			size, err := strconv.Atoi(v)
+			if err != nil || size < 0 {
+				logger.Error("invalid WEBHOOK_QUEUE_SIZE", "value", v)
+				os.Exit(1)
+			}
+			cfg.Size = size
+		}
+		if v := os.Getenv("WEBHOOK_OVERFLOW_POLICY"); v != "" {
+			policy, err := webhook.ParseOverflowPolicy(v)
+			if err != nil {
+				logger.Error("invalid WEBHOOK_OVERFLOW_POLICY", "value", v, "error", err)
+				os.Exit(1)
+			}
+			cfg.Overflow = policy
+		}
+		notifier = webhook.NewQueuedNotifier(logger, cfg)
+	}
+	if v := os.Getenv("DEFAULT_WEBHOOK_URL"); v != "" {
+		mode := webhook.DefaultURLFallback
+		if m := os.Getenv("DEFAULT_WEBHOOK_MODE"); m != "" {
+			parsed, err := webhook.ParseDefaultURLMode(m)
+			if err != nil {
+				logger.Error("invalid DEFAULT_WEBHOOK_MODE", "value", m, "error", err)
+				os.Exit(1)
+			}
+			mode = parsed
+		}
+		if err := notifier.SetDefaultURL(v, mode); err != nil {
+			logger.Error("invalid DEFAULT_WEBHOOK_URL", "value", v, "error", err)
+			os.Exit(1)
+		}
+	}
+	if v := os.Getenv("WEBHOOK_RECOVERY_TOTALS"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			logger.Error("invalid WEBHOOK_RECOVERY_TOTALS", "value", v)
+			os.Exit(1)
+		}
+		if enabled {
+			notifier.SetRecoveryStats(txStore)
+		}
+	}
+	if v := os.Getenv("WEBHOOK_ACK_MERCHANTS"); v != "" {
+		for _, merchantID := range strings.Split(v, ",") {
+			if merchantID = strings.TrimSpace(merchantID); merchantID != "" {
+				notifier.RequireAck(merchantID, true)
+			}
+		}
+	}
+	if v := os.Getenv("WEBHOOK_BATCH_MERCHANTS"); v != "" {
+		cfg := webhook.BatchConfig{Size: 50, Interval: 5 * time.Second}
+		if v := os.Getenv("WEBHOOK_BATCH_SIZE"); v != "" {
+			size, err := strconv.Atoi(v)
+			if err != nil {
+				logger.Error("invalid WEBHOOK_BATCH_SIZE", "value", v)
+				os.Exit(1)
+			}
+			cfg.Size = size
+		}
+		if v := os.Getenv("WEBHOOK_BATCH_INTERVAL"); v != "" {
+			interval, err := time.ParseDuration(v)
+			if err != nil {
+				logger.Error("invalid WEBHOOK_BATCH_INTERVAL", "value", v)
+				os.Exit(1)
+			}
+			cfg.Interval = interval
+		}
+		if err := notifier.SetBatching(cfg); err != nil {
+			logger.Error("invalid webhook batching config", "error", err)
+			os.Exit(1)
+		}
+		for _, merchantID := range strings.Split(v, ",") {
+			if merchantID = strings.TrimSpace(merchantID); merchantID != "" {
+				notifier.BatchDeliveries(merchantID, true)
+			}
+		}
+	}
+	if v := os.Getenv("WEBHOOK_SUCCESS_DELAYS"); v != "" {
+		for _, pair := range strings.Split(v, ",") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			merchantID, raw, ok := strings.Cut(pair, ":")
+			delay, err := time.ParseDuration(strings.TrimSpace(raw))
+			if !ok || err != nil {
+				logger.Error("invalid WEBHOOK_SUCCESS_DELAYS", "value", v)
+				os.Exit(1)
+			}
+			if err := notifier.SetSuccessWebhookDelay(strings.TrimSpace(merchantID), delay); err != nil {
+				logger.Error("invalid WEBHOOK_SUCCESS_DELAYS", "value", v, "error", err)
+				os.Exit(1)
+			}
+		}
+	}
+	if ua, hv := os.Getenv("WEBHOOK_USER_AGENT"), os.Getenv("WEBHOOK_HEADERS"); ua != "" || hv != "" {
+		// A JSON object rather than a separated list, since header values
+		// (e.g. auth tokens) may contain commas or colons
+		headers := map[string]string{}
+		if hv != "" {
+			if err := json.Unmarshal([]byte(hv), &headers); err != nil {
+				logger.Error("invalid WEBHOOK_HEADERS", "error", err)
+				os.Exit(1)
+			}
+		}
+		if err := notifier.SetRequestHeaders(ua, headers); err != nil {
+			logger.Error("invalid webhook headers", "error", err)
+			os.Exit(1)
+		}
+	}
+	noiseStdDev := 0.0
+	if v := os.Getenv("SIMULATOR_NOISE_STDDEV"); v != "" {
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil || n < 0 {
+			logger.Error("invalid SIMULATOR_NOISE_STDDEV", "value", v)
+			os.Exit(1)
+		}
+		noiseStdDev = n
+	}
+	seed := time.Now().UnixNano()
+	if v := os.Getenv("SIMULATOR_SEED"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			logger.Error("invalid SIMULATOR_SEED", "value", v)
+			os.Exit(1)
+		}
+		seed = n
+	}
+	seedMode := retry.SeedShared
+	if v := os.Getenv("SIMULATOR_SEED_MODE"); v != "" {
+		mode, err := retry.ParseSeedMode(v)
+		if err != nil {
+			logger.Error("invalid SIMULATOR_SEED_MODE", "value", v, "error", err)
+			os.Exit(1)
+		}
+		seedMode = mode
+	}
+	simulator := retry.NewSimulator(seed, noiseStdDev, seedMode)
+	if v := os.Getenv("SIMULATOR_SWITCH_BONUS"); v != "" {
+		b, err := strconv.ParseFloat(v, 64)
+		if err != nil || b < 0 || b > 1 {
+			logger.Error("invalid SIMULATOR_SWITCH_BONUS", "value", v)
+			os.Exit(1)
+		}
+		simulator.SetProcessorSwitchBonus(b)
+	}
+	if path := os.Getenv("SIMULATOR_RESPONSE_CODES_PATH"); path != "" {
+		if err := simulator.LoadResponseCodes(path); err != nil {
+			logger.Error("failed to load simulator response codes", "path", path, "error", err)
+			os.Exit(1)
+		}
+		logger.Info("loaded simulator response codes", "path", path)
+	}
 	engine := retry.NewEngine(txStore, simulator, notifier, logger)
+	if v := os.Getenv("PROCESS_ALL_MAX_ATTEMPTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			logger.Error("invalid PROCESS_ALL_MAX_ATTEMPTS", "value", v)
+			os.Exit(1)
+		}
+		engine.SetMaxProcessAttempts(n)
+	}
+	if v := os.Getenv("CONFIRMATION_MERCHANTS"); v != "" {
+		for _, merchantID := range strings.Split(v, ",") {
+			if merchantID = strings.TrimSpace(merchantID); merchantID != "" {
+				engine.RequireConfirmation(merchantID, true)
+			}
+		}
+	}
+
+	if v := os.Getenv("RETRY_AFTER_SECONDS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			logger.Error("invalid RETRY_AFTER_SECONDS", "value", v)
+			os.Exit(1)
+		}
+		handler.SetRetryAfter(n)
+	}
 
 	// Initialize handlers
 	txHandler := handler.NewTransactionHandler(engine, txStore, notifier, logger)
+	if v := os.Getenv("ALLOW_INJECT"); v != "" {
+		allow, err := strconv.ParseBool(v)
+		if err != nil {
+			logger.Error("invalid ALLOW_INJECT", "value", v)
+			os.Exit(1)
+		}
+		if allow {
+			logger.Warn("attempt injection enabled; do not use in production")
+		}
+		txHandler.SetAllowInject(allow)
+	}
+	if v := os.Getenv("BULK_MAX_ITEMS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			logger.Error("invalid BULK_MAX_ITEMS", "value", v)
+			os.Exit(1)
+		}
+		txHandler.SetMaxBulkItems(n)
+	}
 	analyticsHandler := handler.NewAnalyticsHandler(txStore)
+	analyticsCacheInterval := time.Duration(0)
+	if v := os.Getenv("ANALYTICS_CACHE_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			logger.Error("invalid ANALYTICS_CACHE_INTERVAL", "value", v)
+			os.Exit(1)
+		}
+		analyticsHandler.EnableCache()
+		analyticsCacheInterval = d
+	}
+	healthHandler := handler.NewHealthHandler()
 
 	// Setup routes
-	mux := http.NewServeMux()
+	mux := handler.NewRouter()
 
-	// Health check
-	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"status": "ok", "service": "zenithpay-retry-engine"})
-	})
+	// Health checks: liveness and readiness
+	mux.HandleFunc("GET /health", healthHandler.Health)
+	mux.HandleFunc("GET /readyz", healthHandler.Ready)
 
 	// Transaction endpoints
 	mux.HandleFunc("POST /api/transactions", txHandler.Submit)
+	mux.HandleFunc("POST /api/transactions/bulk", txHandler.BulkSubmit)
+	mux.HandleFunc("GET /api/transactions/upcoming", txHandler.Upcoming)
+	mux.HandleFunc("GET /api/transactions/changed", txHandler.Changed)
+	mux.HandleFunc("GET /api/transactions/count", txHandler.Count)
 	mux.HandleFunc("GET /api/transactions/{id}", txHandler.Get)
 	mux.HandleFunc("GET /api/transactions", txHandler.List)
 	mux.HandleFunc("POST /api/transactions/{id}/retry", txHandler.Retry)
+	mux.HandleFunc("DELETE /api/idempotency-keys/{key}", txHandler.DeleteIdempotencyKey)
+	mux.HandleFunc("DELETE /api/idempotency-keys", txHandler.ClearIdempotencyKeys)
+	mux.HandleFunc("POST /api/transactions/{id}/ack", txHandler.Ack)
+	mux.HandleFunc("POST /api/transactions/{id}/confirm", txHandler.Confirm)
+	mux.HandleFunc("POST /api/transactions/{id}/inject-attempt", txHandler.InjectAttempt)
+	mux.HandleFunc("GET /api/transactions/{id}/timeline", txHandler.Timeline)
+	mux.HandleFunc("GET /api/transactions/{id}/report.html", txHandler.Report)
 
 	// Retry control
 	mux.HandleFunc("POST /api/retry/process-all", txHandler.ProcessAll)
+	mux.HandleFunc("POST /api/retry/resync", txHandler.Resync)
 
 	// Analytics endpoints
 	mux.HandleFunc("GET /api/analytics/overview", analyticsHandler.Overview)
 	mux.HandleFunc("GET /api/analytics/by-decline", analyticsHandler.ByDeclineReason)
 	mux.HandleFunc("GET /api/analytics/by-attempt", analyticsHandler.ByAttemptNumber)
+	mux.HandleFunc("POST /api/analytics/refresh", analyticsHandler.Refresh)
+	mux.HandleFunc("GET /api/analytics/attempt-distribution", analyticsHandler.AttemptDistribution)
+	mux.HandleFunc("GET /api/analytics/by-amount", analyticsHandler.ByAmount)
+	mux.HandleFunc("GET /api/analytics/by-tag", analyticsHandler.ByTag)
+	mux.HandleFunc("GET /api/analytics/by-customer", analyticsHandler.ByCustomer)
+	mux.HandleFunc("GET /api/analytics/sla", analyticsHandler.SLA)
+	mux.HandleFunc("POST /api/analytics/shadow", analyticsHandler.Shadow)
+	mux.HandleFunc("GET /api/analytics/routing", analyticsHandler.Routing)
+	mux.HandleFunc("GET /api/analytics/scheduler-drift", analyticsHandler.SchedulerDrift)
+	mux.HandleFunc("GET /api/analytics/report", analyticsHandler.Report)
+	mux.HandleFunc("GET /api/analytics/badge", analyticsHandler.Badge)
+
+	// Build metadata
+	mux.HandleFunc("GET /api/version", versionHandler)
 
 	// Reference data
 	mux.HandleFunc("GET /api/decline-codes", txHandler.GetDeclineCodes)
+	mux.HandleFunc("GET /api/processors", txHandler.GetProcessors)
+	mux.HandleFunc("PATCH /api/config/strategies/{code}", txHandler.PatchStrategy)
+	mux.HandleFunc("POST /api/config/strategies/{code}/disable", txHandler.DisableStrategy)
+	mux.HandleFunc("POST /api/config/strategies/{code}/enable", txHandler.EnableStrategy)
 
 	// Webhook events
 	mux.HandleFunc("GET /api/webhooks/events", txHandler.GetWebhookEvents)
+	mux.HandleFunc("GET /api/webhooks/events/export", txHandler.ExportWebhookEvents)
+	mux.HandleFunc("POST /api/webhooks/replay", txHandler.ReplayWebhooks)
 
-	// Seed endpoint
-	mux.HandleFunc("POST /api/seed", func(w http.ResponseWriter, r *http.Request) {
-		count := 200
-		txStore.Clear()
-		notifier.Clear()
+	// Admin
+	adminHandler := handler.NewAdminHandler(engine, txStore)
+	mux.HandleFunc("POST /api/admin/readonly", adminHandler.SetReadOnly)
+	mux.HandleFunc("POST /api/admin/suspend-retries", adminHandler.SuspendRetries)
+	mux.HandleFunc("POST /api/admin/purge", adminHandler.Purge)
+	mux.HandleFunc("GET /api/admin/pending-index", adminHandler.PendingIndex)
 
-		transactions := seed.GenerateTransactions(count, time.Now().UnixNano())
-		submitted := 0
-		for _, tx := range transactions {
-			if _, err := engine.Submit(tx); err != nil {
-				logger.Error("seed submit failed", "transaction_id", tx.TransactionID, "error", err)
-				continue
-			}
-			submitted++
-		}
+	// Simulator load-testing controls
+	simulatorHandler := handler.NewSimulatorHandler(simulator)
+	mux.HandleFunc("GET /api/simulator/latency", simulatorHandler.Latency)
+	mux.HandleFunc("POST /api/simulator/latency", simulatorHandler.SetLatency)
+	mux.HandleFunc("DELETE /api/simulator/latency", simulatorHandler.ClearLatency)
 
-		// Process all retries in accelerated mode
-		processed, recovered := engine.ProcessAllPending()
-
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]any{
-			"message":                fmt.Sprintf("Seeded %d transactions and processed retries", submitted),
-			"total_seeded":           submitted,
-			"retry_attempts_made":    processed,
-			"transactions_recovered": recovered,
-		})
-	})
+	// Seed endpoint
+	mux.HandleFunc("POST /api/seed", seedHandler(engine, txStore, notifier, logger))
 
 	// Reset endpoint
 	mux.HandleFunc("POST /api/reset", func(w http.ResponseWriter, r *http.Request) {
+		if engine.ReadOnly() {
+			handler.WriteReadOnly(w)
+			return
+		}
 		txStore.Clear()
 		notifier.Clear()
 		w.Header().Set("Content-Type", "application/json")
@@ -113,8 +366,28 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	scheduler := retry.NewScheduler(engine, txStore, 30*time.Second, logger)
+	maxPerTick := 0
+	if v := os.Getenv("SCHEDULER_MAX_PER_TICK"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			logger.Error("invalid SCHEDULER_MAX_PER_TICK", "value", v)
+			os.Exit(1)
+		}
+		maxPerTick = n
+	}
+	scheduler := retry.NewScheduler(engine, txStore, 30*time.Second, maxPerTick, logger)
+	if v := os.Getenv("SCHEDULER_OVERDUE_POLICY"); v != "" {
+		policy, err := retry.ParseOverduePolicy(v)
+		if err != nil {
+			logger.Error("invalid SCHEDULER_OVERDUE_POLICY", "value", v, "error", err)
+			os.Exit(1)
+		}
+		scheduler.SetOverduePolicy(policy)
+	}
 	go scheduler.Start(ctx)
+	if analyticsCacheInterval > 0 {
+		go analyticsHandler.RunCacheRefresh(ctx, analyticsCacheInterval)
+	}
 
 	// Start server
 	port := os.Getenv("PORT")
@@ -130,21 +403,31 @@ func main() {
 		IdleTimeout:  60 * time.Second,
 	}
 
-	// Graceful shutdown
+	// Graceful shutdown. ListenAndServe returns as soon as Shutdown starts, so
+	// main waits on shutdownDone for in-flight requests and webhooks to finish.
+	shutdownDone := make(chan struct{})
 	go func() {
+		defer close(shutdownDone)
 		sigCh := make(chan os.Signal, 1)
 		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 		<-sigCh
 		logger.Info("shutting down server...")
+		healthHandler.SetReady(false)
 		cancel()
 		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer shutdownCancel()
 		if err := server.Shutdown(shutdownCtx); err != nil {
 			logger.Error("server shutdown error", "error", err)
 		}
+		if err := notifier.Shutdown(shutdownCtx); err != nil {
+			logger.Error("webhook shutdown error", "error", err)
+		}
 	}()
 
-	logger.Info("ZenithPay Retry Engine starting", "port", port)
+	// Startup steps (config load, scheduler) are complete
+	healthHandler.SetReady(true)
+
+	logger.Info("ZenithPay Retry Engine starting", "port", port, "version", Version, "commit", Commit)
 	fmt.Printf("\n  ZenithPay Retry Engine\n")
 	fmt.Printf("  ──────────────────────\n")
 	fmt.Printf("  Server:     http://localhost:%s\n", port)
@@ -159,6 +442,201 @@ func main() {
 		logger.Error("server error", "error", err)
 		os.Exit(1)
 	}
+	<-shutdownDone
+}
+
+// versionHandler handles GET /api/version - build metadata of the running binary.
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"version":    Version,
+		"commit":     Commit,
+		"build_time": BuildTime,
+	})
+}
+
+// seedHandler handles POST /api/seed - generate 200 test transactions and
+// process their retries. The store is cleared first unless ?append=true, in
+// which case generated IDs continue after the highest ID this handler has
+// generated (or the current transaction count, if larger), so appends never
+// reuse an ID even after a purge.
+// A decline code mix can be given as {"weights": {"code": weight, ...}} in the
+// body or ?weights=code:weight,... (see seedWeights).
+func seedHandler(engine *retry.Engine, txStore *store.Store, notifier *webhook.Notifier, logger *slog.Logger) http.HandlerFunc {
+	var (
+		mu        sync.Mutex
+		generated int // highest seed ID number handed out since the last clear
+	)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if engine.ReadOnly() {
+			handler.WriteReadOnly(w)
+			return
+		}
+		weights, err := seedWeights(r)
+		if err == nil && weights != nil {
+			err = seed.ValidateWeights(weights)
+		}
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		count := 200
+		appendMode := r.URL.Query().Get("append") == "true"
+		mu.Lock()
+		offset := 0
+		if appendMode {
+			offset = max(generated, txStore.Count())
+		} else {
+			txStore.Clear()
+			notifier.Clear()
+		}
+		generated = offset + count
+		mu.Unlock()
+
+		transactions := seed.GenerateTransactionsWeighted(count, time.Now().UnixNano(), offset, weights)
+		submitted := 0
+		for _, tx := range transactions {
+			if _, err := engine.Submit(tx); err != nil {
+				logger.Error("seed submit failed", "transaction_id", tx.TransactionID, "error", err)
+				continue
+			}
+			submitted++
+		}
+
+		// Process all retries in accelerated mode
+		summary := engine.ProcessPending(r.Context())
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"message":                fmt.Sprintf("Seeded %d transactions and processed retries", submitted),
+			"total_seeded":           submitted,
+			"total_transactions":     txStore.Count(),
+			"appended":               appendMode,
+			"retry_attempts_made":    summary.Processed,
+			"transactions_recovered": summary.Recovered,
+			"truncated":              summary.Truncated,
+			"aborted":                summary.Aborted,
+		})
+	}
+}
+
+// seedWeights reads the optional decline code weights of a seed request, from
+// the ?weights query parameter ("code:weight,code:weight") or else the JSON
+// body's "weights" object. It returns nil when neither is given; an empty body
+// is allowed.
+func seedWeights(r *http.Request) (map[string]float64, error) {
+	if v := r.URL.Query().Get("weights"); v != "" {
+		weights := make(map[string]float64)
+		for _, pair := range strings.Split(v, ",") {
+			code, raw, ok := strings.Cut(strings.TrimSpace(pair), ":")
+			if !ok {
+				return nil, fmt.Errorf("weights: %q must be code:weight", pair)
+			}
+			weight, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				return nil, fmt.Errorf("weights: invalid weight %q for %s", raw, code)
+			}
+			weights[code] = weight
+		}
+		return weights, nil
+	}
+
+	var body struct {
+		Weights map[string]float64 `json:"weights"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid request body: %v", err)
+	}
+	return body.Weights, nil
+}
+
+// loadRetryConfig reads and applies the retry config at path, logging which
+// strategies it overrides. An empty path keeps the built-in strategies. The
+// returned error names the path and the invalid setting; callers should treat
+// it as fatal, since a partially applied config can be left behind.
+func loadRetryConfig(logger *slog.Logger, path string) error {
+	if path == "" {
+		return nil
+	}
+	config, err := domain.ReadRetryConfig(path)
+	if err != nil {
+		return err
+	}
+	if err := domain.ApplyRetryConfig(path, config); err != nil {
+		return err
+	}
+
+	strategies := slices.Sorted(maps.Keys(config.Strategies))
+	var processorStrategies []string
+	for processor, overrides := range config.ProcessorStrategies {
+		for code := range overrides {
+			processorStrategies = append(processorStrategies, processor+"/"+code)
+		}
+	}
+	slices.Sort(processorStrategies)
+	logger.Info("retry strategies loaded from config",
+		"path", path,
+		"overridden", strategies,
+		"processor_overrides", processorStrategies,
+	)
+	for _, label := range slices.Concat(strategies, processorStrategies) {
+		processor, code, ok := strings.Cut(label, "/")
+		if !ok {
+			processor, code = "", label
+		}
+		if s := domain.GetRetryStrategyForProcessor(code, processor); s != nil && s.DelaysOutOfOrder() {
+			logger.Warn("retry strategy delays are not increasing; scheduled attempts will be nudged apart",
+				"strategy", label,
+				"delays", s.Delays,
+			)
+		}
+	}
+	return nil
+}
+
+// newLogger builds the service logger from LOG_FORMAT ("text" or "json") and
+// LOG_LEVEL ("debug", "info", "warn", "error"). Empty values default to
+// text/info; invalid values fall back to the defaults and log a warning.
+func newLogger(out io.Writer, format, level string) *slog.Logger {
+	var lvl slog.Level
+	levelValid := true
+	switch strings.ToLower(level) {
+	case "", "info":
+		lvl = slog.LevelInfo
+	case "debug":
+		lvl = slog.LevelDebug
+	case "warn", "warning":
+		lvl = slog.LevelWarn
+	case "error":
+		lvl = slog.LevelError
+	default:
+		lvl = slog.LevelInfo
+		levelValid = false
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	var h slog.Handler
+	formatValid := true
+	switch strings.ToLower(format) {
+	case "json":
+		h = slog.NewJSONHandler(out, opts)
+	case "", "text":
+		h = slog.NewTextHandler(out, opts)
+	default:
+		h = slog.NewTextHandler(out, opts)
+		formatValid = false
+	}
+
+	logger := slog.New(h)
+	if !formatValid {
+		logger.Warn("invalid LOG_FORMAT, using text", "value", format)
+	}
+	if !levelValid {
+		logger.Warn("invalid LOG_LEVEL, using info", "value", level)
+	}
+	return logger
 }
 
 // responseWriter wraps http.ResponseWriter to capture the status code for logging.
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eabugauch/zenithpay-retry/internal/domain"
+	"github.com/eabugauch/zenithpay-retry/internal/retry"
+	"github.com/eabugauch/zenithpay-retry/internal/store"
+	"github.com/eabugauch/zenithpay-retry/internal/webhook"
+)
+
+func TestNewLogger_JSONWithLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newLogger(&buf, "json", "warn")
+
+	if _, ok := logger.Handler().(*slog.JSONHandler); !ok {
+		t.Fatalf("expected JSON handler, got %T", logger.Handler())
+	}
+	if logger.Enabled(context.Background(), slog.LevelInfo) {
+		t.Error("info should be disabled at warn level")
+	}
+	if !logger.Enabled(context.Background(), slog.LevelWarn) {
+		t.Error("warn should be enabled at warn level")
+	}
+
+	logger.Warn("hello")
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("expected JSON log line, got %q: %v", buf.String(), err)
+	}
+	if entry["msg"] != "hello" {
+		t.Errorf("expected msg hello, got %v", entry["msg"])
+	}
+}
+
+func TestNewLogger_Defaults(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newLogger(&buf, "", "")
+
+	if _, ok := logger.Handler().(*slog.TextHandler); !ok {
+		t.Errorf("expected text handler, got %T", logger.Handler())
+	}
+	if !logger.Enabled(context.Background(), slog.LevelInfo) {
+		t.Error("info should be enabled by default")
+	}
+	if logger.Enabled(context.Background(), slog.LevelDebug) {
+		t.Error("debug should be disabled by default")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expected no warnings for defaults, got %q", buf.String())
+	}
+}
+
+func TestNewLogger_InvalidFallsBack(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newLogger(&buf, "xml", "verbose")
+
+	if _, ok := logger.Handler().(*slog.TextHandler); !ok {
+		t.Errorf("expected text handler fallback, got %T", logger.Handler())
+	}
+	if !logger.Enabled(context.Background(), slog.LevelInfo) || logger.Enabled(context.Background(), slog.LevelDebug) {
+		t.Error("expected info level fallback")
+	}
+	out := buf.String()
+	if !strings.Contains(out, "LOG_FORMAT") || !strings.Contains(out, "LOG_LEVEL") {
+		t.Errorf("expected warnings for both options, got %q", out)
+	}
+}
+
+func TestVersionHandler_Defaults(t *testing.T) {
+	w := httptest.NewRecorder()
+	versionHandler(w, httptest.NewRequest(http.MethodGet, "/api/version", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var resp map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("expected JSON body: %v", err)
+	}
+	for _, key := range []string{"version", "commit", "build_time"} {
+		if resp[key] != "dev" {
+			t.Errorf("expected %s to default to dev, got %q", key, resp[key])
+		}
+	}
+}
+
+func TestSeedHandler_Append(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	txStore := store.New()
+	notifier := webhook.NewNotifier(logger)
+	engine := retry.NewEngine(txStore, retry.NewSimulator(42, 0, retry.SeedShared), notifier, logger)
+	seedFn := seedHandler(engine, txStore, notifier, logger)
+
+	w := httptest.NewRecorder()
+	seedFn(w, httptest.NewRequest(http.MethodPost, "/api/seed", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	firstIDs := make(map[string]bool)
+	for _, tx := range txStore.GetAll() {
+		firstIDs[tx.ID] = true
+	}
+	if len(firstIDs) != 200 {
+		t.Fatalf("expected 200 transactions after seed, got %d", len(firstIDs))
+	}
+
+	w = httptest.NewRecorder()
+	seedFn(w, httptest.NewRequest(http.MethodPost, "/api/seed?append=true", nil))
+	var resp map[string]any
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp["total_seeded"] != float64(200) {
+		t.Errorf("expected all 200 appended transactions to be accepted, got %v", resp["total_seeded"])
+	}
+	if got := txStore.Count(); got != 400 {
+		t.Fatalf("expected 400 transactions after append, got %d", got)
+	}
+	for id := range firstIDs {
+		if _, err := txStore.Get(id); err != nil {
+			t.Errorf("expected original transaction %s to survive append", id)
+		}
+	}
+
+	// A purge lowers the count; appending again must not reuse purged IDs.
+	if txStore.PurgeTerminal(time.Now().Add(time.Hour), domain.StatusRejected) == 0 {
+		t.Fatal("expected the purge to remove rejected transactions")
+	}
+	remaining := txStore.Count()
+	w = httptest.NewRecorder()
+	seedFn(w, httptest.NewRequest(http.MethodPost, "/api/seed?append=true", nil))
+	resp = nil
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp["total_seeded"] != float64(200) {
+		t.Errorf("expected all 200 transactions appended after a purge, got %v", resp["total_seeded"])
+	}
+	if got := txStore.Count(); got != remaining+200 {
+		t.Errorf("expected %d transactions after append, got %d", remaining+200, got)
+	}
+
+	// Default mode still clears first.
+	w = httptest.NewRecorder()
+	seedFn(w, httptest.NewRequest(http.MethodPost, "/api/seed", nil))
+	if got := txStore.Count(); got != 200 {
+		t.Errorf("expected seed without append to reset to 200, got %d", got)
+	}
+}
+
+func TestSeedHandler_Weights(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	txStore := store.New()
+	notifier := webhook.NewNotifier(logger)
+	engine := retry.NewEngine(txStore, retry.NewSimulator(42, 0, retry.SeedShared), notifier, logger)
+	seedFn := seedHandler(engine, txStore, notifier, logger)
+
+	body := `{"weights": {"insufficient_funds": 6, "do_not_honor": 3, "stolen_card": 1, "issuer_timeout": 0}}`
+	w := httptest.NewRecorder()
+	seedFn(w, httptest.NewRequest(http.MethodPost, "/api/seed", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	counts := make(map[string]int)
+	for _, tx := range txStore.GetAll() {
+		counts[tx.DeclineCode]++
+	}
+	total := txStore.Count()
+	if counts["issuer_timeout"] != 0 {
+		t.Errorf("zero-weight code should not be generated, got %d", counts["issuer_timeout"])
+	}
+	for code, want := range map[string]float64{"insufficient_funds": 0.6, "do_not_honor": 0.3, "stolen_card": 0.1} {
+		got := float64(counts[code]) / float64(total)
+		if got < want-0.1 || got > want+0.1 {
+			t.Errorf("%s: expected frequency near %.2f, got %.2f (%d of %d)", code, want, got, counts[code], total)
+		}
+	}
+	if len(counts) != 3 {
+		t.Errorf("expected only weighted codes, got %v", counts)
+	}
+
+	// The same mix via the query string
+	w = httptest.NewRecorder()
+	seedFn(w, httptest.NewRequest(http.MethodPost, "/api/seed?weights=processor_error:1", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	for _, tx := range txStore.GetAll() {
+		if tx.DeclineCode != "processor_error" {
+			t.Fatalf("expected only processor_error, got %s", tx.DeclineCode)
+		}
+	}
+
+	for _, tc := range []struct{ query, body string }{
+		{body: `{"weights": {"insufficient_funds": -1, "do_not_honor": 2}}`},
+		{body: `{"weights": {"insufficient_funds": 0}}`},
+		{body: `{"weights": {}}`},
+		{body: `{"weights": {"made_up_code": 1}}`},
+		{query: "?weights=insufficient_funds"},
+		{query: "?weights=insufficient_funds:lots"},
+	} {
+		w := httptest.NewRecorder()
+		seedFn(w, httptest.NewRequest(http.MethodPost, "/api/seed"+tc.query, strings.NewReader(tc.body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("query %q body %q: expected 400, got %d", tc.query, tc.body, w.Code)
+		}
+	}
+}
+
+func TestLoadRetryConfig(t *testing.T) {
+	dir := t.TempDir()
+	original := domain.GetRetryStrategy("do_not_honor").Description
+	defer domain.ApplyStrategyOverrides(map[string]domain.StrategyConfig{
+		"do_not_honor": {Description: original},
+	})
+
+	valid := filepath.Join(dir, "valid.json")
+	os.WriteFile(valid, []byte(`{"strategies": {"do_not_honor": {"description": "from config"}}}`), 0o644)
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	if err := loadRetryConfig(logger, valid); err != nil {
+		t.Fatalf("expected valid config to load, got %v", err)
+	}
+	if got := domain.GetRetryStrategy("do_not_honor").Description; got != "from config" {
+		t.Errorf("expected override to be applied, got description %q", got)
+	}
+	if !strings.Contains(buf.String(), "overridden=[do_not_honor]") {
+		t.Errorf("expected overridden strategies to be logged, got %q", buf.String())
+	}
+
+	invalid := filepath.Join(dir, "invalid.json")
+	os.WriteFile(invalid, []byte(`{"strategies": {"do_not_honor": {"backoff_type": "sometimes"}}}`), 0o644)
+	err := loadRetryConfig(logger, invalid)
+	if err == nil {
+		t.Fatal("expected invalid config to fail")
+	}
+	if !strings.Contains(err.Error(), "backoff_type") {
+		t.Errorf("expected error to name the invalid setting, got %v", err)
+	}
+
+	if err := loadRetryConfig(logger, filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("expected missing config file to fail")
+	}
+	if err := loadRetryConfig(logger, ""); err != nil {
+		t.Errorf("expected empty path to keep defaults, got %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package domain
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+// AmountBucket is a named range of normalized (USD-equivalent) amounts in cents.
+// MaxCents is the exclusive upper bound; 0 means unbounded.
+type AmountBucket struct {
+	Name     string `json:"name"`
+	MaxCents int64  `json:"max_cents,omitempty"`
+}
+
+// amountBuckets are the default size segments, in USD-equivalent cents:
+// micro < $10, small < $100, medium < $500, large >= $500. They can be
+// replaced while requests are being served, so they have their own lock.
+var (
+	amountBucketsMu sync.RWMutex
+	amountBuckets   = []AmountBucket{
+		{Name: "micro", MaxCents: 1000},
+		{Name: "small", MaxCents: 10000},
+		{Name: "medium", MaxCents: 50000},
+		{Name: "large"},
+	}
+)
+
+// currencyUSDFactors converts an amount in a currency's minor units to
+// approximate USD cents. Rates are static reference values — good enough to
+// place a transaction in a size segment, not for accounting. Unknown
+// currencies are treated as USD.
+var currencyUSDFactors = map[string]float64{
+	"USD": 1.0,
+	"BRL": 0.20,
+	"MXN": 0.058,
+	"COP": 0.00025,
+	"PEN": 0.27,
+}
+
+// NormalizeAmountCents converts an amount to approximate USD cents.
+func NormalizeAmountCents(amountCents int64, currency string) int64 {
+	factor, ok := currencyUSDFactors[currency]
+	if !ok {
+		return amountCents
+	}
+	return int64(math.Round(float64(amountCents) * factor))
+}
+
+// GetAmountBuckets returns the configured amount buckets in ascending order.
+func GetAmountBuckets() []AmountBucket {
+	amountBucketsMu.RLock()
+	defer amountBucketsMu.RUnlock()
+	result := make([]AmountBucket, len(amountBuckets))
+	copy(result, amountBuckets)
+	return result
+}
+
+// AmountBucketFor returns the name of the bucket a transaction amount falls into
+// after currency normalization.
+func AmountBucketFor(amountCents int64, currency string) string {
+	amountBucketsMu.RLock()
+	defer amountBucketsMu.RUnlock()
+	return BucketFor(amountBuckets, amountCents, currency)
+}
+
+// BucketFor is AmountBucketFor against the given buckets, e.g. a snapshot from
+// GetAmountBuckets, so a caller bucketing many amounts uses one consistent set.
+// Returns "" if no bucket takes the amount.
+func BucketFor(buckets []AmountBucket, amountCents int64, currency string) string {
+	normalized := NormalizeAmountCents(amountCents, currency)
+	for _, b := range buckets {
+		if b.MaxCents == 0 || normalized < b.MaxCents {
+			return b.Name
+		}
+	}
+	return ""
+}
+
+// SetAmountBuckets replaces the amount buckets. Thresholds must be strictly
+// increasing, names unique, and exactly the last bucket must be unbounded
+// (MaxCents 0), so every amount falls into some bucket.
+func SetAmountBuckets(buckets []AmountBucket) error {
+	if len(buckets) == 0 {
+		return fmt.Errorf("amount_buckets must not be empty")
+	}
+	var prev int64
+	seen := make(map[string]struct{}, len(buckets))
+	for i, b := range buckets {
+		if b.Name == "" {
+			return fmt.Errorf("amount_buckets[%d] must have a name", i)
+		}
+		if _, dup := seen[b.Name]; dup {
+			return fmt.Errorf("amount_buckets[%d]: duplicate name %q", i, b.Name)
+		}
+		seen[b.Name] = struct{}{}
+		last := i == len(buckets)-1
+		if b.MaxCents == 0 && !last {
+			return fmt.Errorf("amount_buckets[%d] (%s): only the last bucket may be unbounded", i, b.Name)
+		}
+		if b.MaxCents != 0 && last {
+			return fmt.Errorf("amount_buckets[%d] (%s): the last bucket must be unbounded (no max_cents), got %d", i, b.Name, b.MaxCents)
+		}
+		if b.MaxCents < 0 || (b.MaxCents != 0 && b.MaxCents <= prev) {
+			return fmt.Errorf("amount_buckets[%d] (%s): max_cents must be increasing, got %d after %d", i, b.Name, b.MaxCents, prev)
+		}
+		prev = b.MaxCents
+	}
+	amountBucketsMu.Lock()
+	amountBuckets = append([]AmountBucket(nil), buckets...)
+	amountBucketsMu.Unlock()
+	return nil
+}
@@ -0,0 +1,81 @@
+package domain
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAmountBucketFor_Boundaries(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   int64
+		currency string
+		want     string
+	}{
+		{"just under micro limit", 999, "USD", "micro"},
+		{"at micro limit", 1000, "USD", "small"},
+		{"just under small limit", 9999, "USD", "small"},
+		{"at small limit", 10000, "USD", "medium"},
+		{"at medium limit", 50000, "USD", "large"},
+		{"BRL normalized to micro", 4995, "BRL", "micro"}, // ~$9.99
+		{"BRL normalized to small", 5000, "BRL", "small"}, // $10.00
+		{"MXN large", 1000000, "MXN", "large"},            // ~$580
+		{"unknown currency treated as USD", 1000, "XYZ", "small"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AmountBucketFor(tt.amount, tt.currency); got != tt.want {
+				t.Errorf("AmountBucketFor(%d, %s) = %s, want %s", tt.amount, tt.currency, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetAmountBuckets(t *testing.T) {
+	original := amountBuckets
+	defer func() { amountBuckets = original }()
+
+	if err := SetAmountBuckets([]AmountBucket{{Name: "low", MaxCents: 5000}, {Name: "high"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := AmountBucketFor(4999, "USD"); got != "low" {
+		t.Errorf("expected low, got %s", got)
+	}
+	if got := AmountBucketFor(5000, "USD"); got != "high" {
+		t.Errorf("expected high, got %s", got)
+	}
+
+	invalid := []struct {
+		name    string
+		buckets []AmountBucket
+		wantErr string
+	}{
+		{"empty", nil, "must not be empty"},
+		{"unbounded in middle", []AmountBucket{{Name: "a"}, {Name: "b", MaxCents: 100}}, "only the last"},
+		{"bounded last bucket", []AmountBucket{{Name: "a", MaxCents: 100}, {Name: "b", MaxCents: 200}}, "must be unbounded"},
+		{"not increasing", []AmountBucket{{Name: "a", MaxCents: 100}, {Name: "b", MaxCents: 100}, {Name: "c"}}, "increasing"},
+		{"missing name", []AmountBucket{{MaxCents: 100}, {Name: "b"}}, "name"},
+		{"duplicate name", []AmountBucket{{Name: "a", MaxCents: 100}, {Name: "a"}}, "duplicate"},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			err := SetAmountBuckets(tt.buckets)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestBucketFor_Snapshot(t *testing.T) {
+	buckets := []AmountBucket{{Name: "low", MaxCents: 5000}, {Name: "high"}}
+	if got := BucketFor(buckets, 5000, "USD"); got != "high" {
+		t.Errorf("expected high, got %s", got)
+	}
+	// Without an unbounded tail an amount past the last bound has no bucket
+	// rather than being counted in the last one.
+	if got := BucketFor(buckets[:1], 5000, "USD"); got != "" {
+		t.Errorf("expected no bucket, got %q", got)
+	}
+}
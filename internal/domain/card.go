@@ -0,0 +1,66 @@
+package domain
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// cardRetryMinGap is the minimum spacing between retries of the same card
+// token across transactions. Zero disables the check. Guarded by cardGapMu.
+var (
+	cardGapMu       sync.RWMutex
+	cardRetryMinGap time.Duration
+)
+
+// GetCardRetryMinGap returns the configured minimum gap between retries of the same card.
+func GetCardRetryMinGap() time.Duration {
+	cardGapMu.RLock()
+	defer cardGapMu.RUnlock()
+	return cardRetryMinGap
+}
+
+// SetCardRetryMinGap sets the minimum gap between retries of the same card.
+// Returns an error if the gap is negative.
+func SetCardRetryMinGap(gap time.Duration) error {
+	if gap < 0 {
+		return fmt.Errorf("card_retry_min_gap must not be negative, got %s", gap)
+	}
+	cardGapMu.Lock()
+	defer cardGapMu.Unlock()
+	cardRetryMinGap = gap
+	return nil
+}
+
+// LastRetryTime returns the latest retry time recorded or planned for the
+// transaction: executed attempts, plus remaining scheduled attempts while it is
+// still pending. Returns the zero time if there are none.
+func (t *Transaction) LastRetryTime() time.Time {
+	var last time.Time
+	for _, a := range t.RetryAttempts {
+		if a.ExecutedAt.After(last) {
+			last = a.ExecutedAt
+		}
+	}
+	if t.RetryPlan != nil && (t.Status == StatusScheduled || t.Status == StatusRetrying) {
+		for i := len(t.RetryAttempts); i < len(t.RetryPlan.ScheduledTimes); i++ {
+			if t.RetryPlan.ScheduledTimes[i].After(last) {
+				last = t.RetryPlan.ScheduledTimes[i]
+			}
+		}
+	}
+	return last
+}
+
+// Shift moves every scheduled time in the plan later by d.
+func (p *RetryPlan) Shift(d time.Duration) {
+	p.ShiftFrom(0, d)
+}
+
+// ShiftFrom moves the scheduled times from index from onward later by d,
+// leaving earlier slots (e.g. attempts already made) alone.
+func (p *RetryPlan) ShiftFrom(from int, d time.Duration) {
+	for i := from; i < len(p.ScheduledTimes); i++ {
+		p.ScheduledTimes[i] = p.ScheduledTimes[i].Add(d)
+	}
+}
@@ -3,7 +3,10 @@ package domain
 import (
 	"encoding/json"
 	"fmt"
+	"maps"
+	"math"
 	"os"
+	"slices"
 	"time"
 )
 
@@ -18,21 +21,48 @@ const (
 
 // RetryConfig is the top-level configuration file structure.
 type RetryConfig struct {
-	Strategies map[string]StrategyConfig `json:"strategies"`
+	Strategies    map[string]StrategyConfig `json:"strategies"`
+	AmountBuckets []AmountBucket            `json:"amount_buckets,omitempty"` // segments for by-amount analytics
+	// ProcessorStrategies overrides strategies per original processor: processor -> decline code -> config.
+	ProcessorStrategies map[string]map[string]StrategyConfig `json:"processor_strategies,omitempty"`
+	// Processors replaces the default processor list used for failover, in order.
+	Processors []string `json:"processors,omitempty"`
+	// CardRetryMinGap is the minimum spacing between retries of the same card token, e.g. "24h".
+	CardRetryMinGap string `json:"card_retry_min_gap,omitempty"`
+	// DefaultUnknownStrategy, if set, retries unrecognized decline codes with
+	// this plan instead of rejecting them as hard declines.
+	DefaultUnknownStrategy *StrategyConfig `json:"default_unknown_strategy,omitempty"`
+	// MaxFallbackDepth caps how many fallback strategies one transaction can
+	// chain through (default 1; 0 disables fallbacks).
+	MaxFallbackDepth *int `json:"max_fallback_depth,omitempty"`
 }
 
 // StrategyConfig is the JSON representation of a retry strategy override.
 type StrategyConfig struct {
-	MaxAttempts        int       `json:"max_attempts"`
-	Delays             []string  `json:"delays,omitempty"`             // e.g. ["2h", "24h", "48h"]
-	PerAttemptRates    []float64 `json:"per_attempt_rates,omitempty"`
-	UseAltProcessor    bool      `json:"use_alt_processor"`
-	BackoffType        string    `json:"backoff_type,omitempty"`       // "fixed", "exponential", "business_hours"
-	BaseDelay          string    `json:"base_delay,omitempty"`         // for exponential backoff
-	BackoffMultiplier  float64   `json:"backoff_multiplier,omitempty"` // for exponential (default 2.0)
-	BusinessHoursStart int       `json:"business_hours_start,omitempty"` // hour (0-23) for business-hours mode
-	BusinessHoursEnd   int       `json:"business_hours_end,omitempty"`   // hour (0-23) for business-hours mode
-	Description        string    `json:"description,omitempty"`
+	MaxAttempts            int       `json:"max_attempts"`
+	Delays                 []string  `json:"delays,omitempty"` // e.g. ["2h", "24h", "48h"]
+	PerAttemptRates        []float64 `json:"per_attempt_rates,omitempty"`
+	UseAltProcessor        bool      `json:"use_alt_processor"`
+	BackoffType            string    `json:"backoff_type,omitempty"`         // "fixed", "exponential", "business_hours"
+	BaseDelay              string    `json:"base_delay,omitempty"`           // for exponential backoff
+	BackoffMultiplier      float64   `json:"backoff_multiplier,omitempty"`   // for exponential (default 2.0)
+	MaxDelay               string    `json:"max_delay,omitempty"`            // for exponential: cap on each step, e.g. "6h"
+	BusinessHoursStart     int       `json:"business_hours_start,omitempty"` // hour (0-23) for business-hours mode
+	BusinessHoursEnd       int       `json:"business_hours_end,omitempty"`   // hour (0-23) for business-hours mode
+	Description            string    `json:"description,omitempty"`
+	RetryableResponseCodes []string  `json:"retryable_response_codes,omitempty"` // only retry these original response codes
+	InitialCooldown        string    `json:"initial_cooldown,omitempty"`         // e.g. "1h": delay the whole schedule after submit
+	Enabled                *bool     `json:"enabled,omitempty"`                  // false switches retries off for the code (default true)
+	Tags                   []string  `json:"tags,omitempty"`                     // reporting categories, e.g. ["funding"]
+	TargetRecoveryRate     float64   `json:"target_recovery_rate,omitempty"`     // SLA target probability, e.g. 0.40
+	AttemptTimeout         string    `json:"attempt_timeout,omitempty"`          // e.g. "2s": slower simulated calls time out
+	MinGapAfterFailure     string    `json:"min_gap_after_failure,omitempty"`    // e.g. "10m": minimum wait after a failed attempt
+	MaxPending             int       `json:"max_pending,omitempty"`              // overflow submits are rejected once this many are pending
+	MaxBudgetResets        int       `json:"max_budget_resets,omitempty"`        // partial approvals that grant a fresh attempt budget
+	GuaranteeAttempts      int       `json:"guarantee_attempts,omitempty"`       // business hours: attempts packed onto consecutive days
+
+	CurrencyBusinessHours map[string]BusinessHoursWindow `json:"currency_business_hours,omitempty"` // e.g. {"BRL": {"start": 12, "end": 20}}
+	FallbackCode          string                         `json:"fallback_code,omitempty"`           // strategy to continue with once this one is exhausted
 }
 
 // LoadRetryConfig reads a JSON config file and applies strategy overrides.
@@ -42,30 +72,152 @@ func LoadRetryConfig(path string) error {
 	if path == "" {
 		return nil
 	}
+	config, err := ReadRetryConfig(path)
+	if err != nil {
+		return err
+	}
+	return ApplyRetryConfig(path, config)
+}
 
+// ReadRetryConfig reads and parses a JSON config file without applying it.
+func ReadRetryConfig(path string) (*RetryConfig, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
-		return fmt.Errorf("reading retry config %s: %w", path, err)
+		return nil, fmt.Errorf("reading retry config %s: %w", path, err)
 	}
 
 	var config RetryConfig
 	if err := json.Unmarshal(data, &config); err != nil {
-		return fmt.Errorf("parsing retry config %s: %w", path, err)
+		return nil, fmt.Errorf("parsing retry config %s: %w", path, err)
+	}
+	return &config, nil
+}
+
+// ApplyRetryConfig validates and applies a parsed config; path is only used
+// in error messages. Settings are applied section by section, so an invalid
+// section leaves the ones before it in effect.
+func ApplyRetryConfig(path string, config *RetryConfig) error {
+	if len(config.AmountBuckets) > 0 {
+		if err := SetAmountBuckets(config.AmountBuckets); err != nil {
+			return fmt.Errorf("invalid retry config %s: %w", path, err)
+		}
+	}
+
+	if len(config.Processors) > 0 {
+		if err := ApplyProcessorOverrides(config.Processors); err != nil {
+			return fmt.Errorf("invalid retry config %s: %w", path, err)
+		}
+	}
+
+	if config.CardRetryMinGap != "" {
+		gap, err := time.ParseDuration(config.CardRetryMinGap)
+		if err != nil {
+			return fmt.Errorf("invalid card_retry_min_gap %q in %s: %w", config.CardRetryMinGap, path, err)
+		}
+		if err := SetCardRetryMinGap(gap); err != nil {
+			return fmt.Errorf("invalid retry config %s: %w", path, err)
+		}
+	}
+
+	if config.MaxFallbackDepth != nil {
+		if err := SetMaxFallbackDepth(*config.MaxFallbackDepth); err != nil {
+			return fmt.Errorf("invalid retry config %s: %w", path, err)
+		}
+	}
+
+	if config.DefaultUnknownStrategy != nil {
+		if err := SetDefaultUnknownStrategy(config.DefaultUnknownStrategy); err != nil {
+			return fmt.Errorf("invalid retry config %s: %w", path, err)
+		}
+	}
+
+	if err := ApplyStrategyOverrides(config.Strategies); err != nil {
+		return err
+	}
+	return ApplyProcessorStrategyOverrides(config.ProcessorStrategies)
+}
+
+// SetDefaultUnknownStrategy configures the retry strategy applied to
+// unrecognized decline codes. The config must define a complete plan
+// (max_attempts plus delays or a non-fixed backoff). Nil restores the default
+// of treating unknown codes as hard declines.
+func SetDefaultUnknownStrategy(cfg *StrategyConfig) error {
+	if cfg == nil {
+		strategiesMu.Lock()
+		defaultUnknownStrategy = nil
+		strategiesMu.Unlock()
+		return nil
 	}
 
-	return ApplyStrategyOverrides(config.Strategies)
+	const label = "default_unknown_strategy"
+	if cfg.Enabled != nil {
+		return fmt.Errorf("%s: enabled is not supported", label)
+	}
+
+	strategy, err := mergeStrategyConfig(label, RetryStrategy{
+		Category:    SoftDecline,
+		Description: "Unrecognized decline code; retrying with the default strategy",
+	}, *cfg)
+	if err != nil {
+		return err
+	}
+	if err := validatePlanShape(label, strategy); err != nil {
+		return err
+	}
+	strategiesMu.Lock()
+	defer strategiesMu.Unlock()
+	if err := validateStrategy(label, strategy, retryStrategies); err != nil {
+		return err
+	}
+	defaultUnknownStrategy = &strategy
+	return nil
 }
 
 // ApplyStrategyOverrides merges strategy configurations into the runtime map.
 // Only fields with non-zero values override the defaults. Returns an error
-// if any configuration value is invalid.
+// if any configuration value is invalid, in which case no override is applied.
 func ApplyStrategyOverrides(overrides map[string]StrategyConfig) error {
-	for code, cfg := range overrides {
-		if err := validateStrategyConfig(code, cfg); err != nil {
+	strategiesMu.Lock()
+	defer strategiesMu.Unlock()
+	next, err := mergeStrategyOverrides(retryStrategies, overrides)
+	if err != nil {
+		return err
+	}
+	remerged := make(map[string]map[string]processorOverride, len(overrides))
+	for code := range overrides {
+		if remerged[code], err = remergeProcessorOverrides(code, next[code], next); err != nil {
 			return err
 		}
+	}
 
-		existing, ok := retryStrategies[code]
+	// Every overridden code now has a strategy, so the enabled flags cannot
+	// fail.
+	for code, cfg := range overrides {
+		retryStrategies[code] = next[code]
+		for processor, o := range remerged[code] {
+			processorStrategies[processor][code] = o
+		}
+		if cfg.Enabled != nil {
+			setStrategyEnabled(code, *cfg.Enabled)
+		}
+	}
+	return nil
+}
+
+// mergeStrategyOverrides returns a copy of strategies with every override
+// merged in and validated, leaving strategies untouched. All overrides are
+// merged before any is validated, so a fallback_code may name a code defined
+// in the same batch.
+func mergeStrategyOverrides(strategies map[string]RetryStrategy, overrides map[string]StrategyConfig) (map[string]RetryStrategy, error) {
+	next := maps.Clone(strategies)
+	for code, cfg := range overrides {
+		if code != NormalizeDeclineCode(code) {
+			return nil, fmt.Errorf("strategy code %q must be lowercase without surrounding whitespace", code)
+		}
+		if err := validateConfigCounts(code, cfg); err != nil {
+			return nil, err
+		}
+		existing, ok := next[code]
 		if !ok {
 			// New soft decline code — build from scratch
 			existing = RetryStrategy{
@@ -74,87 +226,395 @@ func ApplyStrategyOverrides(overrides map[string]StrategyConfig) error {
 			}
 		}
 
-		if cfg.MaxAttempts > 0 {
-			existing.MaxAttempts = cfg.MaxAttempts
+		merged, err := mergeStrategyConfig(code, existing, cfg)
+		if err != nil {
+			return nil, err
 		}
-		if len(cfg.Delays) > 0 {
-			delays := make([]time.Duration, len(cfg.Delays))
-			for i, d := range cfg.Delays {
-				parsed, err := time.ParseDuration(d)
-				if err != nil {
-					return fmt.Errorf("invalid delay %q for %s: %w", d, code, err)
-				}
-				delays[i] = parsed
-			}
-			existing.Delays = delays
+		next[code] = merged
+	}
+	for code := range overrides {
+		if err := validateStrategy(code, next[code], next); err != nil {
+			return nil, err
 		}
-		if len(cfg.PerAttemptRates) > 0 {
-			existing.PerAttemptRates = cfg.PerAttemptRates
+	}
+	return next, nil
+}
+
+// ShadowStrategy returns the strategy that ApplyStrategyOverrides would
+// produce for code with cfg, without registering it. The code must already
+// have a soft-decline strategy.
+func ShadowStrategy(code string, cfg StrategyConfig) (*RetryStrategy, error) {
+	if cfg.Enabled != nil {
+		return nil, fmt.Errorf("shadow strategy for %s: enabled is not supported", code)
+	}
+	existing, ok := softStrategy(code)
+	if !ok {
+		return nil, fmt.Errorf("shadow strategy: %w %q", ErrUnknownStrategy, code)
+	}
+	if err := validateConfigCounts(code, cfg); err != nil {
+		return nil, err
+	}
+	merged, err := mergeStrategyConfig(code, existing, cfg)
+	if err != nil {
+		return nil, err
+	}
+	strategiesMu.RLock()
+	err = validateStrategy(code, merged, retryStrategies)
+	strategiesMu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	return &merged, nil
+}
+
+// ApplyProcessorOverrides replaces the processor list used for multi-processor
+// failover. The list must be non-empty with unique, non-empty names, and must
+// still contain every processor that has a per-processor strategy override.
+func ApplyProcessorOverrides(processors []string) error {
+	if len(processors) == 0 {
+		return fmt.Errorf("processors must not be empty")
+	}
+	seen := make(map[string]struct{}, len(processors))
+	for i, p := range processors {
+		if p == "" {
+			return fmt.Errorf("processors[%d] must not be empty", i)
 		}
-		if cfg.UseAltProcessor {
-			existing.UseAltProcessor = true
+		if _, dup := seen[p]; dup {
+			return fmt.Errorf("processors: duplicate processor %q", p)
 		}
-		if cfg.Description != "" {
-			existing.Description = cfg.Description
+		seen[p] = struct{}{}
+	}
+	strategiesMu.RLock()
+	defer strategiesMu.RUnlock()
+	for p := range processorStrategies {
+		if _, ok := seen[p]; !ok {
+			return fmt.Errorf("processors: %q has strategy overrides but is not in the list", p)
 		}
+	}
 
-		// Backoff configuration
-		if cfg.BackoffType != "" {
-			existing.BackoffType = BackoffType(cfg.BackoffType)
+	availableProcessors = append([]string(nil), processors...)
+	return nil
+}
+
+// ApplyProcessorStrategyOverrides registers per-processor strategy overrides,
+// keyed by processor and then decline code. Each override is layered on top of
+// the code-level strategy, so only the fields that differ need to be set. The
+// processor must be in the processor list and the decline code must already
+// have a soft-decline strategy.
+func ApplyProcessorStrategyOverrides(overrides map[string]map[string]StrategyConfig) error {
+	strategiesMu.Lock()
+	defer strategiesMu.Unlock()
+	for processor, byCode := range overrides {
+		if !IsKnownProcessor(processor) {
+			return fmt.Errorf("processor_strategies: unknown processor %q", processor)
 		}
-		if cfg.BaseDelay != "" {
-			parsed, err := time.ParseDuration(cfg.BaseDelay)
+		for code, cfg := range byCode {
+			label := code + "@" + processor
+			if cfg.Enabled != nil {
+				return fmt.Errorf("processor override %s: enabled can only be set per decline code", label)
+			}
+			base, ok := retryStrategies[code]
+			if !ok {
+				return fmt.Errorf("processor override %s: %w %q", label, ErrUnknownStrategy, code)
+			}
+			if err := validateConfigCounts(label, cfg); err != nil {
+				return err
+			}
+
+			configs := append(slices.Clip(processorStrategies[processor][code].configs), cfg)
+			merged, err := mergeProcessorOverride(label, base, configs, retryStrategies)
 			if err != nil {
-				return fmt.Errorf("invalid base_delay %q for %s: %w", cfg.BaseDelay, code, err)
+				return err
 			}
-			existing.BaseDelay = parsed
-		}
-		if cfg.BackoffMultiplier > 0 {
-			existing.BackoffMultiplier = cfg.BackoffMultiplier
+			if processorStrategies[processor] == nil {
+				processorStrategies[processor] = make(map[string]processorOverride)
+			}
+			processorStrategies[processor][code] = processorOverride{configs: configs, strategy: merged}
 		}
-		if cfg.BusinessHoursStart > 0 || cfg.BusinessHoursEnd > 0 {
-			existing.BusinessHoursStart = cfg.BusinessHoursStart
-			existing.BusinessHoursEnd = cfg.BusinessHoursEnd
+	}
+	return nil
+}
+
+// mergeProcessorOverride layers a processor override's configs on top of the
+// code-level strategy and validates the result against strategies.
+func mergeProcessorOverride(label string, base RetryStrategy, configs []StrategyConfig, strategies map[string]RetryStrategy) (RetryStrategy, error) {
+	merged := base
+	for _, cfg := range configs {
+		var err error
+		if merged, err = mergeStrategyConfig(label, merged, cfg); err != nil {
+			return merged, err
 		}
+	}
+	if err := validateStrategy(label, merged, strategies); err != nil {
+		return merged, err
+	}
+	return merged, nil
+}
 
-		retryStrategies[code] = existing
+// remergeProcessorOverrides re-merges every processor override of code on top
+// of its new code-level strategy, keyed by processor, without storing them.
+// Callers must hold strategiesMu.
+func remergeProcessorOverrides(code string, base RetryStrategy, strategies map[string]RetryStrategy) (map[string]processorOverride, error) {
+	remerged := make(map[string]processorOverride)
+	for processor, byCode := range processorStrategies {
+		o, ok := byCode[code]
+		if !ok {
+			continue
+		}
+		merged, err := mergeProcessorOverride(code+"@"+processor, base, o.configs, strategies)
+		if err != nil {
+			return nil, err
+		}
+		remerged[processor] = processorOverride{configs: o.configs, strategy: merged}
 	}
-	return nil
+	return remerged, nil
 }
 
-// validateStrategyConfig validates a strategy configuration before applying it.
-func validateStrategyConfig(code string, cfg StrategyConfig) error {
-	// Validate backoff type
+// mergeStrategyConfig applies the non-zero fields of cfg on top of an existing
+// strategy. Negative values are applied too, so validateStrategy rejects them
+// instead of them being silently ignored. The result must be validated.
+func mergeStrategyConfig(code string, existing RetryStrategy, cfg StrategyConfig) (RetryStrategy, error) {
+	if cfg.MaxAttempts != 0 {
+		existing.MaxAttempts = cfg.MaxAttempts
+	}
+	if len(cfg.Delays) > 0 {
+		delays := make([]time.Duration, len(cfg.Delays))
+		for i, d := range cfg.Delays {
+			parsed, err := time.ParseDuration(d)
+			if err != nil {
+				return existing, fmt.Errorf("invalid delay %q for %s: %w", d, code, err)
+			}
+			delays[i] = parsed
+		}
+		existing.Delays = delays
+	}
+	if len(cfg.PerAttemptRates) > 0 {
+		existing.PerAttemptRates = cfg.PerAttemptRates
+	}
+	if cfg.UseAltProcessor {
+		existing.UseAltProcessor = true
+	}
+	if cfg.Description != "" {
+		existing.Description = cfg.Description
+	}
+	if len(cfg.RetryableResponseCodes) > 0 {
+		existing.RetryableResponseCodes = cfg.RetryableResponseCodes
+	}
+	if len(cfg.Tags) > 0 {
+		existing.Tags = cfg.Tags
+	}
+	if cfg.TargetRecoveryRate != 0 {
+		existing.TargetRecoveryRate = cfg.TargetRecoveryRate
+	}
+	if cfg.InitialCooldown != "" {
+		parsed, err := time.ParseDuration(cfg.InitialCooldown)
+		if err != nil {
+			return existing, fmt.Errorf("invalid initial_cooldown %q for %s: %w", cfg.InitialCooldown, code, err)
+		}
+		existing.InitialCooldown = parsed
+	}
+	if cfg.AttemptTimeout != "" {
+		parsed, err := time.ParseDuration(cfg.AttemptTimeout)
+		if err != nil {
+			return existing, fmt.Errorf("invalid attempt_timeout %q for %s: %w", cfg.AttemptTimeout, code, err)
+		}
+		existing.AttemptTimeout = parsed
+	}
+	if cfg.MinGapAfterFailure != "" {
+		parsed, err := time.ParseDuration(cfg.MinGapAfterFailure)
+		if err != nil {
+			return existing, fmt.Errorf("invalid min_gap_after_failure %q for %s: %w", cfg.MinGapAfterFailure, code, err)
+		}
+		existing.MinGapAfterFailure = parsed
+	}
+	if cfg.MaxPending != 0 {
+		existing.MaxPending = cfg.MaxPending
+	}
+	if cfg.MaxBudgetResets != 0 {
+		existing.MaxBudgetResets = cfg.MaxBudgetResets
+	}
+	if cfg.GuaranteeAttempts != 0 {
+		existing.GuaranteeAttempts = cfg.GuaranteeAttempts
+	}
+
+	// Backoff configuration
 	if cfg.BackoffType != "" {
-		switch BackoffType(cfg.BackoffType) {
-		case BackoffFixed, BackoffExponential, BackoffBusinessHours:
-			// valid
-		default:
-			return fmt.Errorf("invalid backoff_type %q for %s: must be \"fixed\", \"exponential\", or \"business_hours\"", cfg.BackoffType, code)
+		existing.BackoffType = BackoffType(cfg.BackoffType)
+	}
+	if cfg.BaseDelay != "" {
+		parsed, err := time.ParseDuration(cfg.BaseDelay)
+		if err != nil {
+			return existing, fmt.Errorf("invalid base_delay %q for %s: %w", cfg.BaseDelay, code, err)
+		}
+		existing.BaseDelay = parsed
+	}
+	if cfg.BackoffMultiplier != 0 {
+		existing.BackoffMultiplier = cfg.BackoffMultiplier
+	}
+	if cfg.MaxDelay != "" {
+		parsed, err := time.ParseDuration(cfg.MaxDelay)
+		if err != nil {
+			return existing, fmt.Errorf("invalid max_delay %q for %s: %w", cfg.MaxDelay, code, err)
+		}
+		existing.MaxDelay = parsed
+	}
+	if cfg.BusinessHoursStart != 0 || cfg.BusinessHoursEnd != 0 {
+		existing.BusinessHoursStart = cfg.BusinessHoursStart
+		existing.BusinessHoursEnd = cfg.BusinessHoursEnd
+	}
+	if len(cfg.CurrencyBusinessHours) > 0 {
+		existing.CurrencyBusinessHours = maps.Clone(cfg.CurrencyBusinessHours)
+	}
+	if cfg.FallbackCode != "" {
+		existing.FallbackCode = cfg.FallbackCode
+	}
+	return existing, nil
+}
+
+// isFinite reports whether f is neither NaN nor infinite. NaN fails every
+// comparison, so range checks alone would let it through.
+func isFinite(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
+// validateStrategy checks the field values of a merged strategy. Every path
+// that changes a strategy (config file, PATCH, shadow evaluation,
+// default_unknown_strategy) validates its result here; PATCH and
+// default_unknown_strategy also require a complete plan (validatePlanShape).
+// A fallback_code must name a code in strategies; callers passing
+// retryStrategies must hold strategiesMu.
+func validateStrategy(code string, s RetryStrategy, strategies map[string]RetryStrategy) error {
+	switch s.BackoffType {
+	case "", BackoffFixed, BackoffExponential, BackoffBusinessHours:
+	default:
+		return fmt.Errorf("invalid backoff_type %q for %s: must be \"fixed\", \"exponential\", or \"business_hours\"", s.BackoffType, code)
+	}
+
+	for i, d := range s.Delays {
+		if d < 0 {
+			return fmt.Errorf("delays[%d] for %s must not be negative, got %s", i, code, d)
 		}
 	}
+	if s.BaseDelay < 0 {
+		return fmt.Errorf("base_delay for %s must not be negative, got %s", code, s.BaseDelay)
+	}
+	if s.InitialCooldown < 0 {
+		return fmt.Errorf("initial_cooldown for %s must not be negative, got %s", code, s.InitialCooldown)
+	}
+	if s.AttemptTimeout < 0 {
+		return fmt.Errorf("attempt_timeout for %s must not be negative, got %s", code, s.AttemptTimeout)
+	}
+	if s.MinGapAfterFailure < 0 {
+		return fmt.Errorf("min_gap_after_failure for %s must not be negative, got %s", code, s.MinGapAfterFailure)
+	}
+	if s.MaxPending < 0 {
+		return fmt.Errorf("max_pending for %s must not be negative, got %d", code, s.MaxPending)
+	}
+	if s.MaxBudgetResets < 0 {
+		return fmt.Errorf("max_budget_resets for %s must not be negative, got %d", code, s.MaxBudgetResets)
+	}
+	if s.GuaranteeAttempts < 0 {
+		return fmt.Errorf("guarantee_attempts for %s must not be negative, got %d", code, s.GuaranteeAttempts)
+	}
 
-	// Validate multiplier (must produce increasing delays)
-	if cfg.BackoffMultiplier > 0 && cfg.BackoffMultiplier <= 1.0 {
-		return fmt.Errorf("backoff_multiplier for %s must be > 1.0, got %.2f", code, cfg.BackoffMultiplier)
+	if !isFinite(s.BackoffMultiplier) {
+		return fmt.Errorf("backoff_multiplier for %s must be a finite number, got %v", code, s.BackoffMultiplier)
+	}
+	if s.BackoffMultiplier != 0 && s.BackoffMultiplier <= 1.0 {
+		return fmt.Errorf("backoff_multiplier for %s must be > 1.0, got %.2f", code, s.BackoffMultiplier)
+	}
+	if s.BackoffType == BackoffExponential {
+		if err := validateMaxDelay(code, s); err != nil {
+			return err
+		}
 	}
 
-	// Validate business hours range (0-23, start < end)
-	if cfg.BusinessHoursStart > 0 || cfg.BusinessHoursEnd > 0 {
-		if cfg.BusinessHoursStart > 23 || cfg.BusinessHoursEnd > 23 {
-			return fmt.Errorf("business hours for %s must be 0-23, got start=%d end=%d", code, cfg.BusinessHoursStart, cfg.BusinessHoursEnd)
+	if s.BusinessHoursStart != 0 || s.BusinessHoursEnd != 0 {
+		if s.BusinessHoursStart < 0 || s.BusinessHoursEnd < 0 || s.BusinessHoursStart > 23 || s.BusinessHoursEnd > 23 {
+			return fmt.Errorf("business hours for %s must be 0-23, got start=%d end=%d", code, s.BusinessHoursStart, s.BusinessHoursEnd)
 		}
-		if cfg.BusinessHoursStart >= cfg.BusinessHoursEnd {
-			return fmt.Errorf("business_hours_start (%d) must be < business_hours_end (%d) for %s", cfg.BusinessHoursStart, cfg.BusinessHoursEnd, code)
+		if s.BusinessHoursStart >= s.BusinessHoursEnd {
+			return fmt.Errorf("business_hours_start (%d) must be < business_hours_end (%d) for %s", s.BusinessHoursStart, s.BusinessHoursEnd, code)
 		}
 	}
+	if err := validateCurrencyBusinessHours(code, s.CurrencyBusinessHours); err != nil {
+		return err
+	}
+	if err := validateFallbackCode(code, s.FallbackCode, strategies); err != nil {
+		return err
+	}
 
-	// Validate per-attempt success rates are probabilities
-	for i, rate := range cfg.PerAttemptRates {
+	for i, rate := range s.PerAttemptRates {
+		if !isFinite(rate) {
+			return fmt.Errorf("per_attempt_rates[%d] for %s must be a finite number, got %v", i, code, rate)
+		}
 		if rate < 0 || rate > 1.0 {
 			return fmt.Errorf("per_attempt_rates[%d] for %s must be between 0.0 and 1.0, got %.2f", i, code, rate)
 		}
 	}
+	if !isFinite(s.TargetRecoveryRate) {
+		return fmt.Errorf("target_recovery_rate for %s must be a finite number, got %v", code, s.TargetRecoveryRate)
+	}
+	if s.TargetRecoveryRate < 0 || s.TargetRecoveryRate > 1.0 {
+		return fmt.Errorf("target_recovery_rate for %s must be between 0.0 and 1.0, got %.2f", code, s.TargetRecoveryRate)
+	}
+	for i, tag := range s.Tags {
+		if tag == "" {
+			return fmt.Errorf("tags[%d] for %s must not be empty", i, code)
+		}
+	}
+	for i, rc := range s.RetryableResponseCodes {
+		if rc == "" {
+			return fmt.Errorf("retryable_response_codes[%d] for %s must not be empty", i, code)
+		}
+	}
+
+	if s.MaxAttempts < 0 {
+		return fmt.Errorf("max_attempts for %s must not be negative, got %d", code, s.MaxAttempts)
+	}
+	if s.GuaranteeAttempts > s.MaxAttempts {
+		return fmt.Errorf("guarantee_attempts for %s (%d) must not exceed max_attempts (%d)", code, s.GuaranteeAttempts, s.MaxAttempts)
+	}
+	if s.GuaranteeAttempts > 0 && s.BackoffType != BackoffBusinessHours {
+		return fmt.Errorf("guarantee_attempts for %s requires business_hours backoff, got %q", code, s.BackoffType)
+	}
+	return nil
+}
 
+// validateConfigCounts checks the counts a single config entry sets together:
+// per_attempt_rates and fixed delays must match a max_attempts given in the
+// same entry. Config overrides are otherwise layered on the existing strategy
+// without requiring it to be complete.
+func validateConfigCounts(code string, cfg StrategyConfig) error {
+	if cfg.MaxAttempts > 0 && len(cfg.PerAttemptRates) > 0 && len(cfg.PerAttemptRates) != cfg.MaxAttempts {
+		return fmt.Errorf("per_attempt_rates for %s has %d entries, must match max_attempts (%d)", code, len(cfg.PerAttemptRates), cfg.MaxAttempts)
+	}
+	isFixed := cfg.BackoffType == "" || BackoffType(cfg.BackoffType) == BackoffFixed
+	if isFixed && cfg.MaxAttempts > 0 && len(cfg.Delays) > 0 && len(cfg.Delays) != cfg.MaxAttempts {
+		return fmt.Errorf("delays for %s has %d entries, must match max_attempts (%d) for fixed backoff", code, len(cfg.Delays), cfg.MaxAttempts)
+	}
+	return nil
+}
+
+// validatePlanShape checks that a strategy defines a complete plan:
+// max_attempts, with delays and per_attempt_rates matching it. PATCH and
+// default_unknown_strategy require this on top of validateStrategy.
+func validatePlanShape(code string, s RetryStrategy) error {
+	if s.MaxAttempts < 1 {
+		return fmt.Errorf("max_attempts for %s must be >= 1, got %d", code, s.MaxAttempts)
+	}
+	switch s.BackoffType {
+	case "", BackoffFixed:
+		if len(s.Delays) != s.MaxAttempts {
+			return fmt.Errorf("delays for %s has %d entries, must match max_attempts (%d) for fixed backoff", code, len(s.Delays), s.MaxAttempts)
+		}
+	case BackoffBusinessHours:
+		if len(s.Delays) == 0 {
+			return fmt.Errorf("delays for %s must not be empty for business_hours backoff", code)
+		}
+	}
+	if len(s.PerAttemptRates) > 0 && len(s.PerAttemptRates) != s.MaxAttempts {
+		return fmt.Errorf("per_attempt_rates for %s has %d entries, must match max_attempts (%d)", code, len(s.PerAttemptRates), s.MaxAttempts)
+	}
 	return nil
 }
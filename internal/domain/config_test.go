@@ -1,6 +1,9 @@
 package domain
 
 import (
+	"errors"
+	"maps"
+	"math"
 	"os"
 	"strings"
 	"testing"
@@ -198,7 +201,7 @@ func TestBuildRetryPlan_ExponentialBackoff(t *testing.T) {
 	}
 
 	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
-	plan := BuildRetryPlan("issuer_timeout", "stripe_latam", base)
+	plan := BuildRetryPlan("issuer_timeout", "stripe_latam", base, PlanOptions{})
 
 	if plan == nil {
 		t.Fatal("expected plan")
@@ -232,7 +235,7 @@ func TestBuildRetryPlan_BusinessHours(t *testing.T) {
 
 	// Base time: 4pm (within business hours) -> +2h = 6pm (outside) -> snaps to next 9am
 	base := time.Date(2025, 1, 1, 16, 0, 0, 0, time.UTC)
-	plan := BuildRetryPlan("insufficient_funds", "stripe_latam", base)
+	plan := BuildRetryPlan("insufficient_funds", "stripe_latam", base, PlanOptions{})
 
 	if plan == nil {
 		t.Fatal("expected plan")
@@ -254,6 +257,172 @@ func TestBuildRetryPlan_BusinessHours(t *testing.T) {
 	}
 }
 
+func TestBuildRetryPlan_BusinessHoursGuaranteeAttempts(t *testing.T) {
+	original := retryStrategies["insufficient_funds"]
+	defer func() { retryStrategies["insufficient_funds"] = original }()
+
+	strategy := RetryStrategy{
+		DeclineCode:        "insufficient_funds",
+		Category:           SoftDecline,
+		MaxAttempts:        4,
+		Delays:             []time.Duration{1 * time.Hour, 2 * time.Hour, 72 * time.Hour, 96 * time.Hour},
+		BackoffType:        BackoffBusinessHours,
+		BusinessHoursStart: 9,
+		BusinessHoursEnd:   17,
+	}
+	retryStrategies["insufficient_funds"] = strategy
+
+	// Friday 6pm: the first two attempts skip the weekend, collapse onto
+	// Monday 9am and are only nudged a minute apart
+	friday := time.Date(2025, 1, 3, 18, 0, 0, 0, time.UTC)
+	plan := BuildRetryPlan("insufficient_funds", "stripe_latam", friday, PlanOptions{})
+	if want := plan.ScheduledTimes[0].Add(minAttemptSpacing); !plan.ScheduledTimes[1].Equal(want) {
+		t.Fatalf("expected collapsed attempts a minute apart without a guarantee, got %v", plan.ScheduledTimes)
+	}
+
+	strategy.GuaranteeAttempts = 3
+	retryStrategies["insufficient_funds"] = strategy
+	plan = BuildRetryPlan("insufficient_funds", "stripe_latam", friday, PlanOptions{})
+
+	days := map[time.Weekday]bool{}
+	for i, want := range []time.Weekday{time.Monday, time.Tuesday, time.Wednesday} {
+		got := plan.ScheduledTimes[i]
+		if got.Weekday() != want || got.Hour() < 9 || got.Hour() >= 17 {
+			t.Errorf("attempt %d: expected %s within business hours, got %s", i+1, want, got)
+		}
+		days[got.Weekday()] = true
+	}
+	if len(days) != 3 {
+		t.Errorf("expected 3 distinct business days, got %v", plan.ScheduledTimes)
+	}
+	// Attempt 4 (Tue 6pm -> Wed 9am) stays behind attempt 3 and in the window
+	if got := plan.ScheduledTimes[3]; !got.After(plan.ScheduledTimes[2]) || got.Weekday() != time.Wednesday || got.Hour() >= 17 {
+		t.Errorf("attempt 4: expected Wednesday after attempt 3, got %s", got)
+	}
+
+	// Attempts already on later business days are never pulled earlier
+	monday := time.Date(2025, 1, 6, 10, 0, 0, 0, time.UTC)
+	plan = BuildRetryPlan("insufficient_funds", "stripe_latam", monday, PlanOptions{})
+	if want := time.Date(2025, 1, 9, 10, 0, 0, 0, time.UTC); !plan.ScheduledTimes[2].Equal(want) {
+		t.Errorf("attempt 3: expected its own schedule %s, got %s", want, plan.ScheduledTimes[2])
+	}
+
+	five := 5
+	if _, err := PatchStrategy("insufficient_funds", StrategyPatch{GuaranteeAttempts: &five}); err == nil {
+		t.Error("expected guarantee_attempts above max_attempts to be rejected")
+	}
+
+	// Other backoff types would silently ignore the guarantee, so it is rejected.
+	retryStrategies["insufficient_funds"] = original
+	two := 2
+	if _, err := PatchStrategy("insufficient_funds", StrategyPatch{GuaranteeAttempts: &two}); err == nil {
+		t.Error("expected guarantee_attempts with fixed backoff to be rejected")
+	}
+	if err := ApplyStrategyOverrides(map[string]StrategyConfig{"insufficient_funds": {GuaranteeAttempts: two}}); err == nil {
+		t.Error("expected guarantee_attempts with fixed backoff to be rejected by config")
+	}
+}
+
+func TestBuildRetryPlan_BusinessHoursNudgeResnaps(t *testing.T) {
+	strategy := &RetryStrategy{
+		DeclineCode:        "insufficient_funds",
+		Category:           SoftDecline,
+		MaxAttempts:        2,
+		Delays:             []time.Duration{2 * time.Hour, 2 * time.Hour},
+		BackoffType:        BackoffBusinessHours,
+		BusinessHoursStart: 9,
+		BusinessHoursEnd:   17,
+	}
+
+	// Both attempts land on Wednesday 4:59:30pm; nudging the second a minute
+	// puts it past 5pm, so it moves on to Thursday 9am instead
+	base := time.Date(2025, 1, 8, 14, 59, 30, 0, time.UTC)
+	plan := BuildRetryPlan("insufficient_funds", "stripe_latam", base, PlanOptions{Strategy: strategy})
+
+	if want := time.Date(2025, 1, 8, 16, 59, 30, 0, time.UTC); !plan.ScheduledTimes[0].Equal(want) {
+		t.Errorf("attempt 1: expected %s, got %s", want, plan.ScheduledTimes[0])
+	}
+	if want := time.Date(2025, 1, 9, 9, 0, 0, 0, time.UTC); !plan.ScheduledTimes[1].Equal(want) {
+		t.Errorf("attempt 2: expected %s, got %s", want, plan.ScheduledTimes[1])
+	}
+}
+
+func TestBuildRetryPlan_Timezones(t *testing.T) {
+	original := retryStrategies["insufficient_funds"]
+	defer func() { retryStrategies["insufficient_funds"] = original }()
+
+	retryStrategies["insufficient_funds"] = RetryStrategy{
+		DeclineCode:        "insufficient_funds",
+		Category:           SoftDecline,
+		MaxAttempts:        1,
+		Delays:             []time.Duration{2 * time.Hour},
+		PerAttemptRates:    []float64{0.12},
+		BackoffType:        BackoffBusinessHours,
+		BusinessHoursStart: 9,
+		BusinessHoursEnd:   17,
+		Description:        "Customer timezone test",
+	}
+
+	// Submitted at 4pm UTC: +2h is 6pm UTC.
+	base := time.Date(2025, 1, 1, 16, 0, 0, 0, time.UTC)
+	tests := []struct {
+		timezone string
+		want     time.Time
+	}{
+		// 6pm UTC is 3pm in São Paulo (UTC-3): inside the window, unchanged.
+		{"America/Sao_Paulo", time.Date(2025, 1, 1, 18, 0, 0, 0, time.UTC)},
+		// 6pm UTC is 3am in Tokyo (UTC+9): snapped to 9am Tokyo = midnight UTC.
+		{"Asia/Tokyo", time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)},
+		// No customer timezone: the window applies in UTC.
+		{"", time.Date(2025, 1, 2, 9, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		plan := BuildRetryPlan("insufficient_funds", "stripe_latam", base, PlanOptions{Currency: "USD", Timezone: tt.timezone})
+		got := plan.ScheduledTimes[0]
+		if !got.Equal(tt.want) {
+			t.Errorf("timezone %q: expected %v, got %v", tt.timezone, tt.want, got)
+		}
+		if got.Location() != time.UTC {
+			t.Errorf("timezone %q: expected the scheduled time in UTC, got %v", tt.timezone, got.Location())
+		}
+	}
+}
+
+func TestBuildRetryPlan_BusinessHours_PerCurrency(t *testing.T) {
+	original := retryStrategies["insufficient_funds"]
+	defer func() { retryStrategies["insufficient_funds"] = original }()
+
+	retryStrategies["insufficient_funds"] = RetryStrategy{
+		DeclineCode:        "insufficient_funds",
+		Category:           SoftDecline,
+		MaxAttempts:        1,
+		Delays:             []time.Duration{2 * time.Hour},
+		PerAttemptRates:    []float64{0.12},
+		BackoffType:        BackoffBusinessHours,
+		BusinessHoursStart: 9,
+		BusinessHoursEnd:   17,
+		CurrencyBusinessHours: map[string]BusinessHoursWindow{
+			"BRL": {Start: 12, End: 20}, // 9am-5pm in São Paulo, in UTC
+		},
+	}
+
+	// 8am UTC + 2h = 10am: inside the default window, before the BRL one.
+	base := time.Date(2025, 1, 1, 8, 0, 0, 0, time.UTC)
+	usd := BuildRetryPlan("insufficient_funds", "stripe_latam", base, PlanOptions{Currency: "USD"})
+	brl := BuildRetryPlan("insufficient_funds", "stripe_latam", base, PlanOptions{Currency: "BRL"})
+	lower := BuildRetryPlan("insufficient_funds", "stripe_latam", base, PlanOptions{Currency: "brl"})
+
+	if got := usd.ScheduledTimes[0].Hour(); got != 10 {
+		t.Errorf("expected USD retry at 10:00 (default window), got %d:00", got)
+	}
+	if got := brl.ScheduledTimes[0].Hour(); got != 12 {
+		t.Errorf("expected BRL retry snapped to 12:00, got %d:00", got)
+	}
+	if !lower.ScheduledTimes[0].Equal(brl.ScheduledTimes[0]) {
+		t.Errorf("expected currency lookup to ignore case, got %v", lower.ScheduledTimes[0])
+	}
+}
+
 func TestBuildRetryPlan_BusinessHours_AlreadyInWindow(t *testing.T) {
 	original := retryStrategies["insufficient_funds"]
 	defer func() { retryStrategies["insufficient_funds"] = original }()
@@ -272,7 +441,7 @@ func TestBuildRetryPlan_BusinessHours_AlreadyInWindow(t *testing.T) {
 
 	// Base time: 10am -> +2h = 12pm (within business hours) -> no snap
 	base := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
-	plan := BuildRetryPlan("insufficient_funds", "stripe_latam", base)
+	plan := BuildRetryPlan("insufficient_funds", "stripe_latam", base, PlanOptions{})
 
 	first := plan.ScheduledTimes[0]
 	if first.Hour() != 12 {
@@ -280,6 +449,55 @@ func TestBuildRetryPlan_BusinessHours_AlreadyInWindow(t *testing.T) {
 	}
 }
 
+func TestBuildRetryPlan_ExponentialMaxDelayPlateaus(t *testing.T) {
+	strategy := &RetryStrategy{
+		DeclineCode:       "issuer_timeout",
+		MaxAttempts:       6,
+		BackoffType:       BackoffExponential,
+		BaseDelay:         10 * time.Minute,
+		BackoffMultiplier: 3.0,
+		MaxDelay:          time.Hour,
+	}
+
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	plan := BuildRetryPlan("", "stripe_latam", base, PlanOptions{Strategy: strategy})
+
+	// Steps: 10m, 30m, then 90m capped to 1h for every later step.
+	expected := []time.Duration{10 * time.Minute, 30 * time.Minute, time.Hour, time.Hour, time.Hour, time.Hour}
+	prev := base
+	for i, exp := range expected {
+		if step := plan.ScheduledTimes[i].Sub(prev); step != exp {
+			t.Errorf("attempt %d: expected step %v, got %v", i+1, exp, step)
+		}
+		prev = plan.ScheduledTimes[i]
+	}
+}
+
+func TestMaxDelay_MustExceedBaseDelay(t *testing.T) {
+	s := RetryStrategy{BackoffType: BackoffExponential, BaseDelay: time.Hour, MaxDelay: 30 * time.Minute}
+	if err := validateMaxDelay("issuer_timeout", s); err == nil {
+		t.Error("expected error for max_delay below base_delay")
+	}
+	// Unset base_delay means the 5m default.
+	s = RetryStrategy{BackoffType: BackoffExponential, MaxDelay: 5 * time.Minute}
+	if err := validateMaxDelay("issuer_timeout", s); err == nil {
+		t.Error("expected error for max_delay equal to the default base_delay")
+	}
+
+	orig := retryStrategies["issuer_timeout"]
+	t.Cleanup(func() { retryStrategies["issuer_timeout"] = orig })
+	maxDelay := "1m"
+	exponential := string(BackoffExponential)
+	if _, err := PatchStrategy("issuer_timeout", StrategyPatch{BackoffType: &exponential, MaxDelay: &maxDelay}); err == nil {
+		t.Error("expected patch with max_delay below base_delay to be rejected")
+	}
+
+	// max_delay only caps exponential steps, so other backoff types ignore it.
+	if _, err := PatchStrategy("issuer_timeout", StrategyPatch{MaxDelay: &maxDelay}); err != nil {
+		t.Errorf("expected max_delay on a fixed strategy to be accepted, got %v", err)
+	}
+}
+
 func TestBuildRetryPlan_ExponentialDefaults(t *testing.T) {
 	original := retryStrategies["processor_error"]
 	defer func() { retryStrategies["processor_error"] = original }()
@@ -295,7 +513,7 @@ func TestBuildRetryPlan_ExponentialDefaults(t *testing.T) {
 	}
 
 	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
-	plan := BuildRetryPlan("processor_error", "stripe_latam", base)
+	plan := BuildRetryPlan("processor_error", "stripe_latam", base, PlanOptions{})
 
 	if plan == nil {
 		t.Fatal("expected plan")
@@ -353,6 +571,66 @@ func TestApplyStrategyOverrides_ValidationErrors(t *testing.T) {
 			config:  StrategyConfig{PerAttemptRates: []float64{-0.1}},
 			wantErr: "per_attempt_rates",
 		},
+		{
+			name:    "fewer rates than attempts",
+			config:  StrategyConfig{MaxAttempts: 3, PerAttemptRates: []float64{0.1, 0.2}},
+			wantErr: "per_attempt_rates",
+		},
+		{
+			name:    "more rates than attempts",
+			config:  StrategyConfig{MaxAttempts: 2, PerAttemptRates: []float64{0.1, 0.2, 0.3, 0.4, 0.5}},
+			wantErr: "per_attempt_rates",
+		},
+		{
+			name:    "fewer delays than attempts",
+			config:  StrategyConfig{MaxAttempts: 3, Delays: []string{"1h", "2h"}},
+			wantErr: "delays",
+		},
+		{
+			name:    "more delays than attempts with explicit fixed backoff",
+			config:  StrategyConfig{BackoffType: "fixed", MaxAttempts: 1, Delays: []string{"1h", "2h"}},
+			wantErr: "delays",
+		},
+		{
+			name:    "empty retryable response code",
+			config:  StrategyConfig{RetryableResponseCodes: []string{"05", ""}},
+			wantErr: "retryable_response_codes",
+		},
+		{
+			name:    "target recovery rate above 1.0",
+			config:  StrategyConfig{TargetRecoveryRate: 42},
+			wantErr: "target_recovery_rate",
+		},
+		{
+			name:    "negative target recovery rate",
+			config:  StrategyConfig{TargetRecoveryRate: -0.1},
+			wantErr: "target_recovery_rate",
+		},
+		{
+			name:    "negative initial cooldown",
+			config:  StrategyConfig{InitialCooldown: "-30m"},
+			wantErr: "initial_cooldown",
+		},
+		{
+			name:    "unparseable initial cooldown",
+			config:  StrategyConfig{InitialCooldown: "an hour"},
+			wantErr: "initial_cooldown",
+		},
+		{
+			name:    "currency window start >= end",
+			config:  StrategyConfig{CurrencyBusinessHours: map[string]BusinessHoursWindow{"BRL": {Start: 20, End: 12}}},
+			wantErr: "business hours start",
+		},
+		{
+			name:    "currency window out of range",
+			config:  StrategyConfig{CurrencyBusinessHours: map[string]BusinessHoursWindow{"BRL": {Start: 12, End: 24}}},
+			wantErr: "must be 0-23",
+		},
+		{
+			name:    "lower-case currency key",
+			config:  StrategyConfig{CurrencyBusinessHours: map[string]BusinessHoursWindow{"brl": {Start: 12, End: 20}}},
+			wantErr: "currency_business_hours",
+		},
 	}
 
 	for _, tt := range tests {
@@ -394,6 +672,14 @@ func TestApplyStrategyOverrides_ValidConfigs(t *testing.T) {
 			name:   "valid multiplier > 1",
 			config: StrategyConfig{BackoffMultiplier: 1.5},
 		},
+		{
+			name:   "matching rates and delays",
+			config: StrategyConfig{MaxAttempts: 2, Delays: []string{"1h", "2h"}, PerAttemptRates: []float64{0.1, 0.2}},
+		},
+		{
+			name:   "business hours delays not tied to max attempts",
+			config: StrategyConfig{BackoffType: "business_hours", MaxAttempts: 3, Delays: []string{"2h"}},
+		},
 	}
 
 	for _, tt := range tests {
@@ -438,3 +724,539 @@ func TestSnapToBusinessHours(t *testing.T) {
 		})
 	}
 }
+
+func TestSnapToBusinessHours_SkipsWeekends(t *testing.T) {
+	monday := time.Date(2025, 1, 6, 9, 0, 0, 0, time.UTC)
+	for name, input := range map[string]time.Time{
+		"friday after end":      time.Date(2025, 1, 3, 18, 0, 0, 0, time.UTC),
+		"saturday within hours": time.Date(2025, 1, 4, 12, 0, 0, 0, time.UTC),
+		"sunday before start":   time.Date(2025, 1, 5, 7, 0, 0, 0, time.UTC),
+		"monday before start":   time.Date(2025, 1, 6, 7, 0, 0, 0, time.UTC),
+	} {
+		if got := snapToBusinessHours(input, 9, 17); !got.Equal(monday) {
+			t.Errorf("%s: expected %s, got %s", name, monday, got)
+		}
+	}
+}
+
+func TestResnapPlan_ShiftedTimesBackInBusinessHours(t *testing.T) {
+	strategy := &RetryStrategy{
+		MaxAttempts:        3,
+		BackoffType:        BackoffBusinessHours,
+		Delays:             []time.Duration{time.Hour, 2 * time.Hour, 3 * time.Hour},
+		BusinessHoursStart: 9,
+		BusinessHoursEnd:   17,
+	}
+	// Wednesday 10:00: all three attempts land inside the window.
+	plan := BuildRetryPlan("", "stripe_latam", time.Date(2025, 1, 8, 10, 0, 0, 0, time.UTC), PlanOptions{Strategy: strategy})
+	plan.ShiftFrom(1, 60*time.Hour) // attempts 2 and 3 now on Saturday night
+
+	ResnapPlan(plan, 1, strategy, "", "")
+	want := []time.Time{
+		time.Date(2025, 1, 8, 11, 0, 0, 0, time.UTC),
+		time.Date(2025, 1, 13, 9, 0, 0, 0, time.UTC),
+		time.Date(2025, 1, 13, 9, 1, 0, 0, time.UTC),
+	}
+	for i, got := range plan.ScheduledTimes {
+		if !got.Equal(want[i]) {
+			t.Errorf("attempt %d: expected %s, got %s", i+1, want[i], got)
+		}
+	}
+}
+
+func TestApplyStrategyOverrides_RetryableResponseCodes(t *testing.T) {
+	original := retryStrategies["do_not_honor"]
+	defer func() { retryStrategies["do_not_honor"] = original }()
+
+	if err := ApplyStrategyOverrides(map[string]StrategyConfig{
+		"do_not_honor": {RetryableResponseCodes: []string{"05"}},
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	strategy := GetRetryStrategy("do_not_honor")
+	if !strategy.AllowsResponseCode("05") {
+		t.Error("expected 05 to be retryable")
+	}
+	if strategy.AllowsResponseCode("57") {
+		t.Error("expected 57 to be blocked")
+	}
+	if !strategy.AllowsResponseCode("") {
+		t.Error("expected a missing response code to be retryable")
+	}
+	if !original.AllowsResponseCode("57") {
+		t.Error("strategy without a list should allow every response code")
+	}
+}
+
+// saveProcessorStrategies restores the processor overrides in place when the
+// test ends. Overrides are applied into the nested maps, so they are copied
+// rather than just keeping a reference.
+func saveProcessorStrategies(t *testing.T) {
+	saved := make(map[string]map[string]processorOverride, len(processorStrategies))
+	for processor, byCode := range processorStrategies {
+		saved[processor] = maps.Clone(byCode)
+	}
+	t.Cleanup(func() { processorStrategies = saved })
+}
+
+func TestApplyProcessorStrategyOverrides(t *testing.T) {
+	saveProcessorStrategies(t)
+
+	err := ApplyProcessorStrategyOverrides(map[string]map[string]StrategyConfig{
+		"adyen_apac": {
+			"processor_error": {
+				MaxAttempts:     5,
+				Delays:          []string{"0s", "5m", "15m", "1h", "2h"},
+				PerAttemptRates: []float64{0.35, 0.30, 0.25, 0.20, 0.15},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	reliable := BuildRetryPlan("processor_error", "adyen_apac", base, PlanOptions{})
+	flaky := BuildRetryPlan("processor_error", "payu_mx", base, PlanOptions{})
+	if reliable.MaxAttempts != 5 {
+		t.Errorf("expected 5 attempts for adyen_apac, got %d", reliable.MaxAttempts)
+	}
+	if flaky.MaxAttempts != 3 {
+		t.Errorf("expected default 3 attempts for payu_mx, got %d", flaky.MaxAttempts)
+	}
+
+	// Fields not set in the override inherit the code-level default
+	strategy := GetRetryStrategyForProcessor("processor_error", "adyen_apac")
+	if !strategy.UseAltProcessor || strategy.Description != retryStrategies["processor_error"].Description {
+		t.Error("expected unset fields to inherit from the code-level strategy")
+	}
+	if GetRetryStrategy("processor_error").MaxAttempts != 3 {
+		t.Error("processor override must not change the code-level default")
+	}
+}
+
+func TestApplyProcessorStrategyOverrides_Errors(t *testing.T) {
+	saveProcessorStrategies(t)
+
+	tests := []struct {
+		name    string
+		code    string
+		config  StrategyConfig
+		wantErr string
+	}{
+		{"unknown decline code", "not_a_code", StrategyConfig{MaxAttempts: 2}, "unknown soft decline code"},
+		{"hard decline code", "stolen_card", StrategyConfig{MaxAttempts: 2}, "unknown soft decline code"},
+		{"invalid config reuses validation", "processor_error", StrategyConfig{BackoffMultiplier: 0.5}, "backoff_multiplier"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ApplyProcessorStrategyOverrides(map[string]map[string]StrategyConfig{
+				"adyen_apac": {tt.code: tt.config},
+			})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLoadRetryConfig_CardRetryMinGap(t *testing.T) {
+	defer SetCardRetryMinGap(0)
+
+	tests := []struct {
+		name    string
+		config  string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"valid gap", `{"card_retry_min_gap": "24h"}`, 24 * time.Hour, false},
+		{"invalid duration", `{"card_retry_min_gap": "soon"}`, 0, true},
+		{"negative gap", `{"card_retry_min_gap": "-1h"}`, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetCardRetryMinGap(0)
+			f, _ := os.CreateTemp("", "retry_config_*.json")
+			f.WriteString(tt.config)
+			f.Close()
+			defer os.Remove(f.Name())
+
+			err := LoadRetryConfig(f.Name())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("wantErr=%v, got %v", tt.wantErr, err)
+			}
+			if got := GetCardRetryMinGap(); got != tt.want {
+				t.Errorf("expected gap %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestBuildRetryPlan_InitialCooldown(t *testing.T) {
+	original := retryStrategies["do_not_honor"]
+	defer func() { retryStrategies["do_not_honor"] = original }()
+
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	without := BuildRetryPlan("do_not_honor", "stripe_latam", base, PlanOptions{})
+
+	if err := ApplyStrategyOverrides(map[string]StrategyConfig{
+		"do_not_honor": {InitialCooldown: "1h"},
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	with := BuildRetryPlan("do_not_honor", "stripe_latam", base, PlanOptions{})
+
+	if len(with.ScheduledTimes) != len(without.ScheduledTimes) {
+		t.Fatalf("cooldown changed attempt count: %d vs %d", len(with.ScheduledTimes), len(without.ScheduledTimes))
+	}
+	for i := range with.ScheduledTimes {
+		if offset := with.ScheduledTimes[i].Sub(without.ScheduledTimes[i]); offset != time.Hour {
+			t.Errorf("attempt %d: expected 1h offset, got %v", i+1, offset)
+		}
+	}
+}
+
+func TestBuildRetryPlan_InitialCooldown_Exponential(t *testing.T) {
+	original := retryStrategies["issuer_timeout"]
+	defer func() { retryStrategies["issuer_timeout"] = original }()
+
+	retryStrategies["issuer_timeout"] = RetryStrategy{
+		DeclineCode:     "issuer_timeout",
+		Category:        SoftDecline,
+		MaxAttempts:     3,
+		BackoffType:     BackoffExponential,
+		BaseDelay:       10 * time.Minute,
+		InitialCooldown: 30 * time.Minute,
+	}
+
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	plan := BuildRetryPlan("issuer_timeout", "stripe_latam", base, PlanOptions{})
+
+	// Exponential 10m, 30m, 70m cumulative, each shifted by the 30m cooldown
+	expected := []time.Duration{40 * time.Minute, 60 * time.Minute, 100 * time.Minute}
+	for i, exp := range expected {
+		if actual := plan.ScheduledTimes[i].Sub(base); actual != exp {
+			t.Errorf("attempt %d: expected %v from base, got %v", i+1, exp, actual)
+		}
+	}
+}
+
+func TestApplyProcessorOverrides(t *testing.T) {
+	original := ListProcessors()
+	defer func() { availableProcessors = original }()
+
+	if err := ApplyProcessorOverrides([]string{"acquirer_a", "acquirer_b"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := ListProcessors(); len(got) != 2 || got[0] != "acquirer_a" || got[1] != "acquirer_b" {
+		t.Errorf("expected replaced list, got %v", got)
+	}
+	if alts := GetAvailableProcessors("acquirer_a"); len(alts) != 1 || alts[0] != "acquirer_b" {
+		t.Errorf("expected only acquirer_b as alternative, got %v", alts)
+	}
+
+	plan := BuildRetryPlan("processor_error", "acquirer_a", time.Now(), PlanOptions{})
+	for i, p := range plan.Processors {
+		if p != "acquirer_a" && p != "acquirer_b" {
+			t.Errorf("attempt %d uses processor %q outside the configured list", i+1, p)
+		}
+	}
+}
+
+func TestApplyProcessorOverrides_Validation(t *testing.T) {
+	original := ListProcessors()
+	defer func() { availableProcessors = original }()
+	saveProcessorStrategies(t)
+
+	if err := ApplyProcessorStrategyOverrides(map[string]map[string]StrategyConfig{
+		"adyen_apac": {"processor_error": {MaxAttempts: 2, Delays: []string{"1m", "5m"}, PerAttemptRates: []float64{0.3, 0.2}}},
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		processors []string
+		wantErr    string
+	}{
+		{"empty list", nil, "must not be empty"},
+		{"empty name", []string{"adyen_apac", ""}, "processors[1]"},
+		{"duplicate", []string{"adyen_apac", "dlocal_br", "adyen_apac"}, "duplicate"},
+		{"drops overridden processor", []string{"dlocal_br"}, "adyen_apac"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ApplyProcessorOverrides(tt.processors)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+			if got := ListProcessors(); len(got) != len(original) {
+				t.Errorf("failed override must leave the list unchanged, got %v", got)
+			}
+		})
+	}
+
+	err := ApplyProcessorStrategyOverrides(map[string]map[string]StrategyConfig{
+		"not_a_processor": {"processor_error": {MaxAttempts: 2}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "unknown processor") {
+		t.Errorf("expected unknown processor error, got %v", err)
+	}
+}
+
+func TestApplyStrategyOverrides_Enabled(t *testing.T) {
+	original := retryStrategies["do_not_honor"]
+	defer func() {
+		retryStrategies["do_not_honor"] = original
+		SetStrategyEnabled("do_not_honor", true)
+	}()
+
+	disabled := false
+	if err := ApplyStrategyOverrides(map[string]StrategyConfig{"do_not_honor": {Enabled: &disabled}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if IsStrategyEnabled("do_not_honor") {
+		t.Error("expected do_not_honor to be disabled")
+	}
+	if GetRetryStrategy("do_not_honor").MaxAttempts != original.MaxAttempts {
+		t.Error("disabling must not change other strategy fields")
+	}
+
+	if err := SetStrategyEnabled("stolen_card", false); !errors.Is(err, ErrUnknownStrategy) {
+		t.Errorf("expected ErrUnknownStrategy for a hard decline, got %v", err)
+	}
+
+	// An invalid override elsewhere in the config leaves the flag untouched.
+	enabled := true
+	if err := ApplyStrategyOverrides(map[string]StrategyConfig{
+		"do_not_honor":   {Enabled: &enabled},
+		"issuer_timeout": {MaxPending: -1},
+	}); err == nil {
+		t.Fatal("expected error for negative max_pending")
+	}
+	if IsStrategyEnabled("do_not_honor") {
+		t.Error("expected a rejected config not to re-enable do_not_honor")
+	}
+}
+
+func TestApplyStrategyOverrides_Tags(t *testing.T) {
+	original := retryStrategies["do_not_honor"]
+	defer func() { retryStrategies["do_not_honor"] = original }()
+
+	if err := ApplyStrategyOverrides(map[string]StrategyConfig{
+		"do_not_honor": {Tags: []string{"funding", "issuer"}},
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tags := GetRetryStrategy("do_not_honor").Tags; len(tags) != 2 || tags[0] != "funding" {
+		t.Errorf("expected overridden tags, got %v", tags)
+	}
+
+	err := ApplyStrategyOverrides(map[string]StrategyConfig{"do_not_honor": {Tags: []string{""}}})
+	if err == nil || !strings.Contains(err.Error(), "tags[0]") {
+		t.Errorf("expected empty tag error, got %v", err)
+	}
+}
+
+func TestPatchStrategy_ZeroValueAndOmittedFields(t *testing.T) {
+	orig := retryStrategies["do_not_honor"]
+	t.Cleanup(func() { retryStrategies["do_not_honor"] = orig })
+
+	start, end := 0, 17
+	backoff := string(BackoffBusinessHours)
+	patched, err := PatchStrategy("do_not_honor", StrategyPatch{
+		BackoffType:        &backoff,
+		BusinessHoursStart: &start,
+		BusinessHoursEnd:   &end,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if patched.BusinessHoursStart != 0 || patched.BusinessHoursEnd != 17 {
+		t.Errorf("expected business hours 0-17, got %d-%d", patched.BusinessHoursStart, patched.BusinessHoursEnd)
+	}
+
+	// A second patch touching only max_attempts (with matching lists) keeps
+	// the zero start hour and every other field.
+	attempts := 2
+	delays := []string{"24h", "48h"}
+	rates := []float64{0.12, 0.15}
+	patched, err = PatchStrategy("do_not_honor", StrategyPatch{MaxAttempts: &attempts, Delays: &delays, PerAttemptRates: &rates})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := GetRetryStrategy("do_not_honor")
+	if got.MaxAttempts != 2 || got.BusinessHoursStart != 0 || got.BusinessHoursEnd != 17 || got.BackoffType != BackoffBusinessHours {
+		t.Errorf("expected patched fields to persist, got %+v", got)
+	}
+	if got.Description != orig.Description || len(got.Tags) != len(orig.Tags) {
+		t.Errorf("expected omitted fields unchanged, got %+v", got)
+	}
+
+	// Clearing a list is explicit.
+	empty := []string{}
+	if _, err := PatchStrategy("do_not_honor", StrategyPatch{Tags: &empty}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := GetRetryStrategy("do_not_honor"); len(got.Tags) != 0 {
+		t.Errorf("expected tags cleared, got %v", got.Tags)
+	}
+}
+
+func TestStrategyValidation_ConfigAndPatchAgree(t *testing.T) {
+	orig := retryStrategies["insufficient_funds"]
+	t.Cleanup(func() { retryStrategies["insufficient_funds"] = orig })
+
+	// Both paths check the merged strategy, so fields left out of the
+	// override still count: max_attempts comes from the existing strategy.
+	guarantee, negative := 5, -1
+	for name, tt := range map[string]struct {
+		cfg   StrategyConfig
+		patch StrategyPatch
+	}{
+		"guarantee_attempts above existing max_attempts": {StrategyConfig{GuaranteeAttempts: guarantee}, StrategyPatch{GuaranteeAttempts: &guarantee}},
+		"negative max_pending":                           {StrategyConfig{MaxPending: negative}, StrategyPatch{MaxPending: &negative}},
+	} {
+		cfgErr := ApplyStrategyOverrides(map[string]StrategyConfig{"insufficient_funds": tt.cfg})
+		_, patchErr := PatchStrategy("insufficient_funds", tt.patch)
+		if cfgErr == nil || patchErr == nil || cfgErr.Error() != patchErr.Error() {
+			t.Errorf("%s: expected the same error from config and PATCH, got %v and %v", name, cfgErr, patchErr)
+		}
+	}
+}
+
+func TestFallbackCode_MustNameSoftStrategy(t *testing.T) {
+	orig := retryStrategies["do_not_honor"]
+	t.Cleanup(func() {
+		retryStrategies["do_not_honor"] = orig
+		delete(retryStrategies, "test_fallback_target")
+	})
+
+	typo := "insuficient_funds"
+	if err := ApplyStrategyOverrides(map[string]StrategyConfig{"do_not_honor": {FallbackCode: typo}}); err == nil {
+		t.Error("expected config error for unknown fallback_code")
+	}
+	if _, err := PatchStrategy("do_not_honor", StrategyPatch{FallbackCode: &typo}); err == nil {
+		t.Error("expected PATCH error for unknown fallback_code")
+	}
+
+	// A fallback may name a code defined in the same config.
+	if err := ApplyStrategyOverrides(map[string]StrategyConfig{
+		"do_not_honor":         {FallbackCode: "test_fallback_target"},
+		"test_fallback_target": {MaxAttempts: 1, Delays: []string{"1h"}},
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := retryStrategies["do_not_honor"].FallbackCode; got != "test_fallback_target" {
+		t.Errorf("expected fallback to new code, got %q", got)
+	}
+
+	// A rejected config applies none of its overrides.
+	if err := ApplyStrategyOverrides(map[string]StrategyConfig{
+		"do_not_honor":         {FallbackCode: ""},
+		"test_fallback_target": {FallbackCode: typo},
+	}); err == nil {
+		t.Fatal("expected config error for unknown fallback_code")
+	}
+	if got := retryStrategies["test_fallback_target"].FallbackCode; got != "" {
+		t.Errorf("expected rejected config not applied, got fallback %q", got)
+	}
+}
+
+func TestApplyStrategyOverrides_RejectsNaNAndInf(t *testing.T) {
+	orig := retryStrategies["issuer_timeout"]
+	t.Cleanup(func() { retryStrategies["issuer_timeout"] = orig })
+
+	tests := []struct {
+		name string
+		cfg  StrategyConfig
+	}{
+		{"NaN rate", StrategyConfig{MaxAttempts: 2, PerAttemptRates: []float64{0.5, math.NaN()}}},
+		{"+Inf rate", StrategyConfig{MaxAttempts: 1, PerAttemptRates: []float64{math.Inf(1)}}},
+		{"NaN multiplier", StrategyConfig{BackoffType: "exponential", BackoffMultiplier: math.NaN()}},
+		{"+Inf multiplier", StrategyConfig{BackoffType: "exponential", BackoffMultiplier: math.Inf(1)}},
+		{"-Inf multiplier", StrategyConfig{BackoffType: "exponential", BackoffMultiplier: math.Inf(-1)}},
+		{"NaN target", StrategyConfig{TargetRecoveryRate: math.NaN()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ApplyStrategyOverrides(map[string]StrategyConfig{"issuer_timeout": tt.cfg})
+			if err == nil || !strings.Contains(err.Error(), "finite") {
+				t.Errorf("expected finite-number error, got %v", err)
+			}
+		})
+	}
+
+	nan, inf := math.NaN(), math.Inf(1)
+	rates := append([]float64(nil), orig.PerAttemptRates...)
+	rates[len(rates)-1] = inf
+	for name, patch := range map[string]StrategyPatch{
+		"NaN multiplier": {BackoffMultiplier: &nan},
+		"+Inf rate":      {PerAttemptRates: &rates},
+		"NaN target":     {TargetRecoveryRate: &nan},
+	} {
+		if _, err := PatchStrategy("issuer_timeout", patch); err == nil || !strings.Contains(err.Error(), "finite") {
+			t.Errorf("patch %s: expected finite-number error, got %v", name, err)
+		}
+	}
+}
+
+func TestPatchStrategy_RemergesProcessorOverrides(t *testing.T) {
+	orig := retryStrategies["processor_error"]
+	t.Cleanup(func() { retryStrategies["processor_error"] = orig })
+	saveProcessorStrategies(t)
+
+	err := ApplyProcessorStrategyOverrides(map[string]map[string]StrategyConfig{
+		"adyen_apac": {"processor_error": {MaxPending: 7}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	description := "Patched at runtime"
+	maxPending := 2
+	if _, err := PatchStrategy("processor_error", StrategyPatch{Description: &description, MaxPending: &maxPending}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Fields the override does not set follow the patch; the ones it sets win.
+	s := GetRetryStrategyForProcessor("processor_error", "adyen_apac")
+	if s.Description != description {
+		t.Errorf("expected override to pick up patched description, got %q", s.Description)
+	}
+	if s.MaxPending != 7 {
+		t.Errorf("expected override max_pending 7 to survive the patch, got %d", s.MaxPending)
+	}
+}
+
+func TestPatchStrategy_Validation(t *testing.T) {
+	orig := retryStrategies["issuer_timeout"]
+	t.Cleanup(func() { retryStrategies["issuer_timeout"] = orig })
+
+	zero := 0
+	bad := 1.5
+	tests := []struct {
+		name  string
+		patch StrategyPatch
+	}{
+		{"zero max attempts", StrategyPatch{MaxAttempts: &zero}},
+		{"target above 1.0", StrategyPatch{TargetRecoveryRate: &bad}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := PatchStrategy("issuer_timeout", tt.patch); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+	if got := retryStrategies["issuer_timeout"]; got.MaxAttempts != orig.MaxAttempts {
+		t.Errorf("expected rejected patch to leave strategy unchanged, got %+v", got)
+	}
+	if _, err := PatchStrategy("stolen_card", StrategyPatch{}); !errors.Is(err, ErrUnknownStrategy) {
+		t.Errorf("expected ErrUnknownStrategy, got %v", err)
+	}
+}
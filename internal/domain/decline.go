@@ -1,26 +1,101 @@
 package domain
 
 import (
+	"fmt"
+	"slices"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
 // RetryStrategy defines how a specific decline code should be retried.
 type RetryStrategy struct {
-	DeclineCode        string
-	Category           DeclineCategory
-	MaxAttempts        int
-	Delays             []time.Duration
-	PerAttemptRates    []float64   // success probability per attempt (for simulation)
-	UseAltProcessor    bool        // bonus: try alternative processor on retry
-	Description        string
-	BackoffType        BackoffType // "fixed" (default), "exponential", "business_hours"
-	BaseDelay          time.Duration // for exponential backoff
-	BackoffMultiplier  float64     // for exponential (default 2.0)
-	BusinessHoursStart int         // hour (0-23) for business-hours mode
-	BusinessHoursEnd   int         // hour (0-23) for business-hours mode
+	DeclineCode            string
+	Category               DeclineCategory
+	MaxAttempts            int
+	Delays                 []time.Duration
+	PerAttemptRates        []float64 // success probability per attempt (for simulation)
+	UseAltProcessor        bool      // bonus: try alternative processor on retry
+	Description            string
+	BackoffType            BackoffType   // "fixed" (default), "exponential", "business_hours"
+	BaseDelay              time.Duration // for exponential backoff
+	BackoffMultiplier      float64       // for exponential (default 2.0)
+	MaxDelay               time.Duration // for exponential: caps each step's delay; 0 = uncapped
+	BusinessHoursStart     int           // hour (0-23) for business-hours mode
+	BusinessHoursEnd       int           // hour (0-23) for business-hours mode
+	RetryableResponseCodes []string      // if set, only these original response codes are retried
+	InitialCooldown        time.Duration // delays the whole schedule after submit, before the first delay applies
+	Tags                   []string      // business categories for reporting (e.g. "funding", "risk")
+	TargetRecoveryRate     float64       // SLA: expected recovery probability (0.0-1.0); 0 = no target
+	AttemptTimeout         time.Duration // simulated processor latency above this fails the attempt; 0 = never
+	MinGapAfterFailure     time.Duration // next attempt no sooner than this after a failed one, even if planned earlier
+	MaxPending             int           // cap on simultaneously pending transactions of this code; 0 = no cap
+	MaxBudgetResets        int           // partial approvals that restart the attempt budget for the remainder; 0 = none
+	GuaranteeAttempts      int           // business-hours mode: first N attempts packed onto consecutive business days; 0 = off
+
+	// CurrencyBusinessHours overrides the business-hours window per currency;
+	// currencies without an entry use BusinessHoursStart/End.
+	CurrencyBusinessHours map[string]BusinessHoursWindow
+
+	// FallbackCode names the strategy whose plan is appended when this one is
+	// exhausted, instead of failing the transaction. Empty means none.
+	FallbackCode string
+}
+
+// BusinessHoursWindow is a daily [Start, End) hour range (0-23) in the time
+// zone of the schedule's base time: the customer's time zone if the
+// transaction has one, UTC otherwise.
+type BusinessHoursWindow struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
 }
 
+// validate checks the window's hours for the given code and currency.
+func (w BusinessHoursWindow) validate(code, currency string) error {
+	if w.Start < 0 || w.End < 0 || w.Start > 23 || w.End > 23 {
+		return fmt.Errorf("business hours for %s/%s must be 0-23, got start=%d end=%d", code, currency, w.Start, w.End)
+	}
+	if w.Start >= w.End {
+		return fmt.Errorf("business hours start (%d) must be < end (%d) for %s/%s", w.Start, w.End, code, currency)
+	}
+	return nil
+}
+
+// validateCurrencyBusinessHours checks that every key is an upper-case
+// three-letter currency code with a valid window.
+func validateCurrencyBusinessHours(code string, windows map[string]BusinessHoursWindow) error {
+	for currency, w := range windows {
+		if len(currency) != 3 || strings.ToUpper(currency) != currency {
+			return fmt.Errorf("currency_business_hours for %s: %q is not an upper-case ISO 4217 code", code, currency)
+		}
+		if err := w.validate(code, currency); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AllowsResponseCode reports whether a transaction declined with the given
+// processor response code should be retried under this strategy. An empty
+// RetryableResponseCodes list allows every code. An empty code (the submit
+// carried none) is always allowed: the list only narrows retries when the
+// processor's code is known.
+func (s *RetryStrategy) AllowsResponseCode(code string) bool {
+	if code == "" || len(s.RetryableResponseCodes) == 0 {
+		return true
+	}
+	for _, c := range s.RetryableResponseCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// unknownDeclineReason is the reason given for unrecognized decline codes.
+const unknownDeclineReason = "Unknown decline code, treating as hard decline for safety"
+
 // hardDeclineCodes are decline codes that must never be retried.
 var hardDeclineCodes = map[string]string{
 	"stolen_card":      "Card has been reported as stolen",
@@ -29,8 +104,18 @@ var hardDeclineCodes = map[string]string{
 	"expired_card":     "Card is past its expiration date",
 }
 
+// defaultUnknownStrategy, when set, retries unrecognized decline codes instead
+// of treating them as hard declines. Nil (the default) keeps them hard.
+// Guarded by strategiesMu.
+var defaultUnknownStrategy *RetryStrategy
+
+// strategiesMu guards retryStrategies, which PatchStrategy can update while
+// requests are being served, and defaultUnknownStrategy.
+var strategiesMu sync.RWMutex
+
 // retryStrategies maps soft decline codes to their optimal retry strategy.
-// Delays and success rates are calibrated to match observed recovery data:
+// Delays and success rates are calibrated to match observed recovery data,
+// which also serves as each code's default SLA target:
 //   - insufficient_funds: 42% cumulative recovery
 //   - issuer_timeout: 68% cumulative recovery
 //   - do_not_honor: 31% cumulative recovery
@@ -38,53 +123,78 @@ var hardDeclineCodes = map[string]string{
 //   - authentication_failed: ~25% cumulative recovery
 var retryStrategies = map[string]RetryStrategy{
 	"insufficient_funds": {
-		DeclineCode:     "insufficient_funds",
-		Category:        SoftDecline,
-		MaxAttempts:     3,
-		Delays:          []time.Duration{2 * time.Hour, 24 * time.Hour, 48 * time.Hour},
-		PerAttemptRates: []float64{0.12, 0.17, 0.22},
-		UseAltProcessor: false,
-		Description:     "Customer may add funds; retry with increasing delays",
+		DeclineCode:        "insufficient_funds",
+		Category:           SoftDecline,
+		MaxAttempts:        3,
+		Delays:             []time.Duration{2 * time.Hour, 24 * time.Hour, 48 * time.Hour},
+		PerAttemptRates:    []float64{0.12, 0.17, 0.22},
+		UseAltProcessor:    false,
+		Description:        "Customer may add funds; retry with increasing delays",
+		Tags:               []string{"funding"},
+		TargetRecoveryRate: 0.42,
 	},
 	"issuer_timeout": {
-		DeclineCode:     "issuer_timeout",
-		Category:        SoftDecline,
-		MaxAttempts:     3,
-		Delays:          []time.Duration{0, 5 * time.Minute, 30 * time.Minute},
-		PerAttemptRates: []float64{0.40, 0.30, 0.25},
-		UseAltProcessor: true,
-		Description:     "Network issue; retry immediately via alternative processor",
+		DeclineCode:        "issuer_timeout",
+		Category:           SoftDecline,
+		MaxAttempts:        3,
+		Delays:             []time.Duration{0, 5 * time.Minute, 30 * time.Minute},
+		PerAttemptRates:    []float64{0.40, 0.30, 0.25},
+		UseAltProcessor:    true,
+		Description:        "Network issue; retry immediately via alternative processor",
+		Tags:               []string{"technical"},
+		TargetRecoveryRate: 0.68,
 	},
 	"do_not_honor": {
-		DeclineCode:     "do_not_honor",
-		Category:        SoftDecline,
-		MaxAttempts:     3,
-		Delays:          []time.Duration{24 * time.Hour, 48 * time.Hour, 72 * time.Hour},
-		PerAttemptRates: []float64{0.12, 0.15, 0.10},
-		UseAltProcessor: false,
-		Description:     "Generic decline with temporary risk flags; retry after cool-down",
+		DeclineCode:        "do_not_honor",
+		Category:           SoftDecline,
+		MaxAttempts:        3,
+		Delays:             []time.Duration{24 * time.Hour, 48 * time.Hour, 72 * time.Hour},
+		PerAttemptRates:    []float64{0.12, 0.15, 0.10},
+		UseAltProcessor:    false,
+		Description:        "Generic decline with temporary risk flags; retry after cool-down",
+		Tags:               []string{"risk"},
+		TargetRecoveryRate: 0.31,
 	},
 	"processor_error": {
-		DeclineCode:     "processor_error",
-		Category:        SoftDecline,
-		MaxAttempts:     3,
-		Delays:          []time.Duration{0, 5 * time.Minute, 1 * time.Hour},
-		PerAttemptRates: []float64{0.35, 0.25, 0.20},
-		UseAltProcessor: true,
-		Description:     "Technical failure on processor side; retry via alternative processor",
+		DeclineCode:        "processor_error",
+		Category:           SoftDecline,
+		MaxAttempts:        3,
+		Delays:             []time.Duration{0, 5 * time.Minute, 1 * time.Hour},
+		PerAttemptRates:    []float64{0.35, 0.25, 0.20},
+		UseAltProcessor:    true,
+		Description:        "Technical failure on processor side; retry via alternative processor",
+		Tags:               []string{"technical"},
+		TargetRecoveryRate: 0.60,
 	},
 	"authentication_failed": {
-		DeclineCode:     "authentication_failed",
-		Category:        SoftDecline,
-		MaxAttempts:     2,
-		Delays:          []time.Duration{1 * time.Hour, 6 * time.Hour},
-		PerAttemptRates: []float64{0.15, 0.12},
-		UseAltProcessor: false,
-		Description:     "3DS verification incomplete; retry with fresh auth window",
+		DeclineCode:        "authentication_failed",
+		Category:           SoftDecline,
+		MaxAttempts:        2,
+		Delays:             []time.Duration{1 * time.Hour, 6 * time.Hour},
+		PerAttemptRates:    []float64{0.15, 0.12},
+		UseAltProcessor:    false,
+		Description:        "3DS verification incomplete; retry with fresh auth window",
+		Tags:               []string{"authentication"},
+		TargetRecoveryRate: 0.25,
 	},
 }
 
+// processorOverride is a per-processor strategy override: the configs applied
+// to a (processor, code) pair, in order, and the strategy they produce on top
+// of the code-level one. The strategy is re-merged whenever the code-level
+// strategy changes, so overrides only ever pin the fields they set.
+type processorOverride struct {
+	configs  []StrategyConfig
+	strategy RetryStrategy
+}
+
+// processorStrategies holds per-processor strategy overrides, keyed by
+// processor and then decline code. Consulted before the code-level default.
+// Guarded by strategiesMu.
+var processorStrategies = map[string]map[string]processorOverride{}
+
 // availableProcessors lists the simulated payment processors for multi-processor failover.
+// Replaceable via the "processors" config section (see ApplyProcessorOverrides).
 var availableProcessors = []string{
 	"stripe_latam",
 	"adyen_apac",
@@ -93,26 +203,147 @@ var availableProcessors = []string{
 	"mercadopago_co",
 }
 
+// NormalizeDeclineCode returns code in the canonical form decline codes are
+// configured in: trimmed and lowercased, so " Insufficient_Funds" matches
+// insufficient_funds.
+func NormalizeDeclineCode(code string) string {
+	return strings.ToLower(strings.TrimSpace(code))
+}
+
 // ClassifyDecline determines whether a decline code is hard or soft.
+// The code is normalized first (see NormalizeDeclineCode).
 func ClassifyDecline(code string) (DeclineCategory, string) {
+	code = NormalizeDeclineCode(code)
 	if reason, ok := hardDeclineCodes[code]; ok {
 		return HardDecline, reason
 	}
-	if strategy, ok := retryStrategies[code]; ok {
+	if strategy, ok := softStrategy(code); ok {
 		return SoftDecline, strategy.Description
 	}
-	return HardDecline, "Unknown decline code, treating as hard decline for safety"
+	if s, ok := unknownStrategy(); ok {
+		return SoftDecline, s.Description
+	}
+	return HardDecline, unknownDeclineReason
+}
+
+// unknownStrategy returns the default strategy for unrecognized codes, if
+// one is configured.
+func unknownStrategy() (RetryStrategy, bool) {
+	strategiesMu.RLock()
+	defer strategiesMu.RUnlock()
+	if defaultUnknownStrategy == nil {
+		return RetryStrategy{}, false
+	}
+	return *defaultUnknownStrategy, true
+}
+
+// softStrategy returns the configured strategy for a soft decline code,
+// ignoring the default unknown-code strategy.
+func softStrategy(code string) (RetryStrategy, bool) {
+	strategiesMu.RLock()
+	defer strategiesMu.RUnlock()
+	s, ok := retryStrategies[code]
+	return s, ok
+}
+
+// RestoreRetryStrategy puts back a strategy previously returned by
+// GetRetryStrategy, or removes code's strategy when s is nil. It bypasses
+// validation and is meant for undoing a change exactly, e.g. in tests.
+func RestoreRetryStrategy(code string, s *RetryStrategy) {
+	strategiesMu.Lock()
+	defer strategiesMu.Unlock()
+	if s == nil {
+		delete(retryStrategies, code)
+		return
+	}
+	retryStrategies[code] = *s
+}
+
+// ClearProcessorStrategy removes the override of code on processor, if any,
+// e.g. to undo ApplyProcessorStrategyOverrides in tests.
+func ClearProcessorStrategy(processor, code string) {
+	strategiesMu.Lock()
+	defer strategiesMu.Unlock()
+	delete(processorStrategies[processor], code)
+}
+
+// isSoftDeclineCode reports whether code has a configured retry strategy.
+func isSoftDeclineCode(code string) bool {
+	_, ok := softStrategy(code)
+	return ok
+}
+
+// IsKnownDeclineCode reports whether code is a configured hard or soft decline.
+func IsKnownDeclineCode(code string) bool {
+	code = NormalizeDeclineCode(code)
+	if _, ok := hardDeclineCodes[code]; ok {
+		return true
+	}
+	return isSoftDeclineCode(code)
 }
 
-// GetRetryStrategy returns the retry strategy for a given decline code.
-// Returns nil for hard declines.
+// GetRetryStrategy returns the retry strategy for a given decline code,
+// normalized first. Unrecognized codes get the default unknown-code strategy,
+// if configured. Returns nil for hard declines.
 func GetRetryStrategy(code string) *RetryStrategy {
-	if s, ok := retryStrategies[code]; ok {
+	code = NormalizeDeclineCode(code)
+	if s, ok := softStrategy(code); ok {
+		return &s
+	}
+	if _, hard := hardDeclineCodes[code]; hard {
+		return nil
+	}
+	if s, ok := unknownStrategy(); ok {
+		s.DeclineCode = code
 		return &s
 	}
 	return nil
 }
 
+// GetRetryStrategyForProcessor returns the retry strategy for a decline code
+// from a specific processor, preferring a (code, processor) override over the
+// code-level default. Returns nil for hard declines.
+func GetRetryStrategyForProcessor(code, processor string) *RetryStrategy {
+	code = NormalizeDeclineCode(code)
+	strategiesMu.RLock()
+	o, ok := processorStrategies[processor][code]
+	strategiesMu.RUnlock()
+	if ok {
+		return &o.strategy
+	}
+	return GetRetryStrategy(code)
+}
+
+// ListProcessors returns a copy of the configured processor list, in failover order.
+func ListProcessors() []string {
+	processors := make([]string, len(availableProcessors))
+	copy(processors, availableProcessors)
+	return processors
+}
+
+// IsKnownProcessor reports whether processor is in the configured processor list.
+func IsKnownProcessor(processor string) bool {
+	for _, p := range availableProcessors {
+		if p == processor {
+			return true
+		}
+	}
+	return false
+}
+
+// GetProcessorOverrideCodes returns the decline codes that have a
+// processor-specific strategy override for the given processor, sorted.
+func GetProcessorOverrideCodes(processor string) []string {
+	strategiesMu.RLock()
+	defer strategiesMu.RUnlock()
+	codes := make([]string, 0, len(processorStrategies[processor]))
+	for code := range processorStrategies[processor] {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
 // GetAvailableProcessors returns processors available for retry, excluding the original.
 func GetAvailableProcessors(excludeProcessor string) []string {
 	var processors []string
@@ -124,45 +355,235 @@ func GetAvailableProcessors(excludeProcessor string) []string {
 	return processors
 }
 
-// BuildRetryPlan creates a RetryPlan for a soft-declined transaction.
+// AllowedProcessors returns the configured processors not in excluded, in
+// failover order.
+func AllowedProcessors(excluded []string) []string {
+	var processors []string
+	for _, p := range availableProcessors {
+		if !slices.Contains(excluded, p) {
+			processors = append(processors, p)
+		}
+	}
+	return processors
+}
+
+// PlanOptions are the transaction details that shape a retry plan beyond its
+// decline code and original processor. The zero value plans in baseTime's
+// zone with the strategy-level business-hours window and every processor.
+type PlanOptions struct {
+	// Currency selects the strategy's per-currency business-hours window,
+	// if it defines one.
+	Currency string
+	// Timezone is the customer's IANA time zone, in which business-hours
+	// windows are applied. Scheduled times are still returned in baseTime's
+	// zone. An empty or unloadable zone leaves the windows in baseTime's.
+	Timezone string
+	// Excluded processors are kept out of the routing: the first attempt
+	// moves off an excluded original processor to the first allowed one,
+	// and alternatives are chosen among the allowed processors only.
+	Excluded []string
+	// Strategy, if set, is used instead of the configured strategy for the
+	// decline code, e.g. to evaluate a candidate strategy.
+	Strategy *RetryStrategy
+}
+
+// PlanOptions returns the options a retry plan for t is built with.
+func (t *Transaction) PlanOptions() PlanOptions {
+	return PlanOptions{Currency: t.Currency, Timezone: t.CustomerTimezone, Excluded: t.ExcludeProcessors}
+}
+
+// BuildRetryPlan creates a RetryPlan for a soft-declined transaction, using any
+// processor-specific override for the original processor unless opts names a
+// strategy. Returns nil if the decline code has no strategy.
 // Supports three scheduling modes:
 //   - fixed: use static delays from the strategy
 //   - exponential: BaseDelay * Multiplier^(attempt-1)
 //   - business_hours: snap retry times to the next business-hours window
-func BuildRetryPlan(declineCode string, originalProcessor string, baseTime time.Time) *RetryPlan {
-	strategy := GetRetryStrategy(declineCode)
+func BuildRetryPlan(declineCode, originalProcessor string, baseTime time.Time, opts PlanOptions) *RetryPlan {
+	strategy := opts.Strategy
+	if strategy == nil {
+		strategy = GetRetryStrategyForProcessor(declineCode, originalProcessor)
+	}
 	if strategy == nil {
 		return nil
 	}
-
-	scheduledTimes := buildScheduledTimes(strategy, baseTime)
-
-	processors := make([]string, strategy.MaxAttempts)
-	altProcessors := GetAvailableProcessors(originalProcessor)
-	for i := 0; i < strategy.MaxAttempts; i++ {
-		if strategy.UseAltProcessor && i > 0 && len(altProcessors) > 0 {
-			processors[i] = altProcessors[(i-1)%len(altProcessors)]
-		} else {
-			processors[i] = originalProcessor
+	local := baseTime
+	if opts.Timezone != "" {
+		if loc, err := time.LoadLocation(opts.Timezone); err == nil {
+			local = baseTime.In(loc)
 		}
 	}
+	plan := buildRetryPlan(strategy, originalProcessor, opts.Currency, opts.Excluded, local)
+	for i, t := range plan.ScheduledTimes {
+		plan.ScheduledTimes[i] = t.In(baseTime.Location())
+	}
+	if opts.Strategy == nil {
+		plan.DeclineCode = declineCode
+	}
+	return plan
+}
+
+// buildRetryPlan builds a plan from strategy, routing around the excluded processors.
+func buildRetryPlan(strategy *RetryStrategy, originalProcessor, currency string, excluded []string, baseTime time.Time) *RetryPlan {
+	scheduledTimes := buildScheduledTimes(strategy, currency, baseTime)
+	enforceIncreasing(scheduledTimes, businessHoursSnap(strategy, currency))
+
+	processors := assignProcessors(strategy, originalProcessor, excluded)
 
 	return &RetryPlan{
 		MaxAttempts:    strategy.MaxAttempts,
 		Strategy:       strategy.Description,
-		DeclineCode:    declineCode,
+		DeclineCode:    strategy.DeclineCode,
 		ScheduledTimes: scheduledTimes,
 		Processors:     processors,
 	}
 }
 
+// validateMaxDelay checks that an exponential step cap leaves room to grow
+// past the first step.
+func validateMaxDelay(code string, s RetryStrategy) error {
+	if s.MaxDelay == 0 {
+		return nil
+	}
+	if base := s.exponentialBaseDelay(); s.MaxDelay <= base {
+		return fmt.Errorf("max_delay for %s must be greater than base_delay (%s), got %s", code, base, s.MaxDelay)
+	}
+	return nil
+}
+
+// assignProcessors picks the processor for each attempt. The first attempt always
+// uses the original processor. With UseAltProcessor, later attempts cycle through
+// every alternative before repeating one, and no processor is ever placed in two
+// consecutive slots (when only one alternative exists, it alternates with the original).
+// Excluded processors are never picked; an excluded original is replaced by the
+// first allowed processor, which then plays the original's part.
+func assignProcessors(strategy *RetryStrategy, originalProcessor string, excluded []string) []string {
+	primary := originalProcessor
+	if slices.Contains(excluded, primary) {
+		if allowed := AllowedProcessors(excluded); len(allowed) > 0 {
+			primary = allowed[0]
+		}
+	}
+	var altProcessors []string
+	for _, p := range GetAvailableProcessors(primary) {
+		if !slices.Contains(excluded, p) {
+			altProcessors = append(altProcessors, p)
+		}
+	}
+
+	processors := make([]string, strategy.MaxAttempts)
+	for i := 0; i < strategy.MaxAttempts; i++ {
+		if !strategy.UseAltProcessor || i == 0 || len(altProcessors) == 0 {
+			processors[i] = primary
+			continue
+		}
+		candidate := altProcessors[(i-1)%len(altProcessors)]
+		if candidate == processors[i-1] {
+			candidate = primary
+		}
+		processors[i] = candidate
+	}
+	return processors
+}
+
+// ResumePlan records the attempts already made in the first slots of a
+// freshly built plan. With UseAltProcessor, any later slot that would then
+// repeat the processor of the slot before it is re-routed, as
+// assignProcessors does, to the first allowed processor that differs from
+// both neighbours.
+func ResumePlan(plan *RetryPlan, strategy *RetryStrategy, attempts []RetryAttempt, excluded []string) {
+	if len(plan.Processors) == 0 {
+		return
+	}
+	primary := plan.Processors[0]
+	made := min(len(attempts), plan.MaxAttempts)
+	for i, a := range attempts[:made] {
+		plan.ScheduledTimes[i] = a.ScheduledAt
+		plan.Processors[i] = a.Processor
+	}
+	if !strategy.UseAltProcessor {
+		return
+	}
+
+	candidates := append([]string{primary}, AllowedProcessors(excluded)...)
+	for i := max(made, 1); i < len(plan.Processors); i++ {
+		prev := plan.Processors[i-1]
+		if plan.Processors[i] != prev {
+			continue
+		}
+		var next string
+		if i+1 < len(plan.Processors) {
+			next = plan.Processors[i+1]
+		}
+		for _, p := range candidates {
+			if p != prev && p != next {
+				plan.Processors[i] = p
+				break
+			}
+		}
+	}
+}
+
+// minAttemptSpacing is the least time enforceIncreasing keeps between two
+// consecutive attempts of a plan.
+const minAttemptSpacing = time.Minute
+
+// enforceIncreasing makes scheduled times strictly increasing, moving any time
+// that is not at least minAttemptSpacing after its predecessor forward to
+// exactly that, then through snap, if set, so a nudge past the end of a
+// business-hours window moves on to the next window. Out-of-order delays, a
+// repeated last delay, or business-hours snapping can otherwise give two
+// attempts the same slot, and the scheduler would fire them together.
+func enforceIncreasing(times []time.Time, snap func(time.Time) time.Time) {
+	for i := 1; i < len(times); i++ {
+		if earliest := times[i-1].Add(minAttemptSpacing); times[i].Before(earliest) {
+			times[i] = earliest
+			if snap != nil {
+				times[i] = snap(earliest)
+			}
+		}
+	}
+}
+
+// businessHoursSnap returns the function that snaps a time, in its own
+// location, into the strategy's business-hours window for currency, or nil if
+// the strategy does not schedule in business hours.
+func businessHoursSnap(strategy *RetryStrategy, currency string) func(time.Time) time.Time {
+	if strategy.BackoffType != BackoffBusinessHours {
+		return nil
+	}
+	startHour, endHour := businessHoursWindow(strategy, currency)
+	return func(t time.Time) time.Time {
+		return snapToBusinessHours(t, startHour, endHour)
+	}
+}
+
+// DelaysOutOfOrder reports whether the strategy's configured delays are not
+// strictly increasing. Fixed and business-hours schedules use the delays as
+// given, so such a strategy gets attempts nudged apart by enforceIncreasing;
+// exponential schedules compute their own increasing delays.
+func (s *RetryStrategy) DelaysOutOfOrder() bool {
+	if s.BackoffType == BackoffExponential {
+		return false
+	}
+	for i := 1; i < len(s.Delays); i++ {
+		if s.Delays[i] <= s.Delays[i-1] {
+			return true
+		}
+	}
+	return false
+}
+
 // buildScheduledTimes calculates retry times based on the strategy's backoff type.
-func buildScheduledTimes(strategy *RetryStrategy, baseTime time.Time) []time.Time {
+// The initial cooldown moves the base time, so every mode schedules from the end
+// of the cooldown (business-hours snapping still applies afterwards).
+func buildScheduledTimes(strategy *RetryStrategy, currency string, baseTime time.Time) []time.Time {
+	baseTime = baseTime.Add(strategy.InitialCooldown)
 	switch strategy.BackoffType {
 	case BackoffExponential:
 		return buildExponentialTimes(strategy, baseTime)
 	case BackoffBusinessHours:
-		return buildBusinessHoursTimes(strategy, baseTime)
+		return buildBusinessHoursTimes(strategy, currency, baseTime)
 	default:
 		return buildFixedTimes(strategy, baseTime)
 	}
@@ -182,22 +603,35 @@ func buildFixedTimes(strategy *RetryStrategy, baseTime time.Time) []time.Time {
 	return times
 }
 
+// defaultBaseDelay is the first exponential step when BaseDelay is unset.
+const defaultBaseDelay = 5 * time.Minute
+
+// exponentialBaseDelay returns the first exponential step of s.
+func (s *RetryStrategy) exponentialBaseDelay() time.Duration {
+	if s.BaseDelay <= 0 {
+		return defaultBaseDelay
+	}
+	return s.BaseDelay
+}
+
 // buildExponentialTimes computes delays using exponential backoff:
-// delay_i = BaseDelay * Multiplier^i
+// delay_i = min(BaseDelay * Multiplier^i, MaxDelay)
 func buildExponentialTimes(strategy *RetryStrategy, baseTime time.Time) []time.Time {
 	multiplier := strategy.BackoffMultiplier
 	if multiplier <= 0 {
 		multiplier = 2.0
 	}
-	base := strategy.BaseDelay
-	if base <= 0 {
-		base = 5 * time.Minute
-	}
+	base := strategy.exponentialBaseDelay()
 
 	times := make([]time.Time, strategy.MaxAttempts)
 	cumulativeDelay := time.Duration(0)
 	for i := 0; i < strategy.MaxAttempts; i++ {
 		delay := time.Duration(float64(base) * pow(multiplier, i))
+		if strategy.MaxDelay > 0 && (delay > strategy.MaxDelay || delay <= 0) {
+			// Once a step reaches the cap every later step does too; the
+			// delay <= 0 check catches float overflow on huge exponents.
+			delay = strategy.MaxDelay
+		}
 		cumulativeDelay += delay
 		times[i] = baseTime.Add(cumulativeDelay)
 	}
@@ -207,14 +641,10 @@ func buildExponentialTimes(strategy *RetryStrategy, baseTime time.Time) []time.T
 // buildBusinessHoursTimes schedules retries during business hours (e.g., 9am-17pm).
 // If a retry would fall outside business hours, it is pushed to the start of the
 // next business-hours window. This aligns with when customers are most likely to
-// have funds available (banking hours).
-func buildBusinessHoursTimes(strategy *RetryStrategy, baseTime time.Time) []time.Time {
-	startHour := strategy.BusinessHoursStart
-	endHour := strategy.BusinessHoursEnd
-	if startHour == 0 && endHour == 0 {
-		startHour = 9  // default: 9am
-		endHour = 17   // default: 5pm
-	}
+// have funds available (banking hours). The window is the currency's entry in
+// CurrencyBusinessHours if present, otherwise the strategy-level one.
+func buildBusinessHoursTimes(strategy *RetryStrategy, currency string, baseTime time.Time) []time.Time {
+	startHour, endHour := businessHoursWindow(strategy, currency)
 
 	times := make([]time.Time, strategy.MaxAttempts)
 	for i := 0; i < strategy.MaxAttempts; i++ {
@@ -226,15 +656,96 @@ func buildBusinessHoursTimes(strategy *RetryStrategy, baseTime time.Time) []time
 		}
 		times[i] = snapToBusinessHours(candidate, startHour, endHour)
 	}
+	guaranteeBusinessDays(times, strategy.GuaranteeAttempts, startHour)
 	return times
 }
 
-// snapToBusinessHours adjusts a time to fall within business hours.
-// If already within business hours, returns it unchanged.
-// Otherwise, advances to the start of the next business-hours window.
+// businessHoursWindow returns the start and end hour of the strategy's
+// business-hours window for currency.
+func businessHoursWindow(strategy *RetryStrategy, currency string) (startHour, endHour int) {
+	startHour = strategy.BusinessHoursStart
+	endHour = strategy.BusinessHoursEnd
+	if w, ok := strategy.CurrencyBusinessHours[strings.ToUpper(currency)]; ok {
+		startHour, endHour = w.Start, w.End
+	}
+	if startHour == 0 && endHour == 0 {
+		startHour = 9  // default: 9am
+		endHour = 17   // default: 5pm
+	}
+	return startHour, endHour
+}
+
+// ResnapPlan moves the plan's times from index from onward back into the
+// strategy's business-hours window (in the customer's timezone, as
+// BuildRetryPlanForCustomer does) after they were shifted, e.g. by the card
+// gap. Times only move later, guaranteed attempts stay on distinct business
+// days, and the times stay strictly increasing. Plans of other backoff types
+// are left alone.
+func ResnapPlan(plan *RetryPlan, from int, strategy *RetryStrategy, currency, timezone string) {
+	if strategy == nil || strategy.BackoffType != BackoffBusinessHours || from >= len(plan.ScheduledTimes) {
+		return
+	}
+	startHour, endHour := businessHoursWindow(strategy, currency)
+	loc := plan.ScheduledTimes[from].Location()
+	local := loc
+	if timezone != "" {
+		if l, err := time.LoadLocation(timezone); err == nil {
+			local = l
+		}
+	}
+
+	times := plan.ScheduledTimes[from:]
+	for i, t := range times {
+		times[i] = snapToBusinessHours(t.In(local), startHour, endHour)
+	}
+	guaranteeBusinessDays(times, strategy.GuaranteeAttempts-from, startHour)
+	enforceIncreasing(times, businessHoursSnap(strategy, currency))
+	for i, t := range times {
+		times[i] = t.In(loc)
+	}
+}
+
+// guaranteeBusinessDays spreads the first n snapped times over distinct
+// business days, so a submit just before a window closes does not leave
+// several attempts collapsed onto the next window start. Each of those
+// attempts that falls on or before the previous attempt's day moves to the
+// window start of the next business day; attempts are never moved earlier.
+// Later attempts are pushed back, if needed, to stay in order.
+func guaranteeBusinessDays(times []time.Time, n, startHour int) {
+	for i := 1; i < len(times); i++ {
+		prev := times[i-1]
+		if i < n {
+			next := nextBusinessDay(time.Date(prev.Year(), prev.Month(), prev.Day()+1, startHour, 0, 0, 0, prev.Location()))
+			if times[i].Before(next) {
+				times[i] = next
+			}
+			continue
+		}
+		if times[i].Before(prev) {
+			times[i] = prev
+		}
+	}
+}
+
+// nextBusinessDay moves a Saturday or Sunday to the same time on the
+// following Monday; weekdays are returned unchanged.
+func nextBusinessDay(t time.Time) time.Time {
+	switch t.Weekday() {
+	case time.Saturday:
+		return t.AddDate(0, 0, 2)
+	case time.Sunday:
+		return t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+// snapToBusinessHours adjusts a time to fall within business hours on a
+// weekday. If already within business hours, returns it unchanged.
+// Otherwise, advances to the start of the next business-hours window,
+// skipping Saturday and Sunday.
 func snapToBusinessHours(t time.Time, startHour, endHour int) time.Time {
 	hour := t.Hour()
-	if hour >= startHour && hour < endHour {
+	if hour >= startHour && hour < endHour && nextBusinessDay(t).Equal(t) {
 		return t
 	}
 	// Advance to next business day start
@@ -242,7 +753,7 @@ func snapToBusinessHours(t time.Time, startHour, endHour int) time.Time {
 	if hour >= endHour {
 		next = next.AddDate(0, 0, 1)
 	}
-	return next
+	return nextBusinessDay(next)
 }
 
 // pow computes base^exp for float64 (integer exponents only).
@@ -256,7 +767,7 @@ func pow(base float64, exp int) float64 {
 
 // IsHardDecline checks if a decline code is a hard decline.
 func IsHardDecline(code string) bool {
-	_, ok := hardDeclineCodes[code]
+	_, ok := hardDeclineCodes[NormalizeDeclineCode(code)]
 	return ok
 }
 
@@ -269,9 +780,11 @@ func GetAllDeclineCodes() map[DeclineCategory][]string {
 	for code := range hardDeclineCodes {
 		result[HardDecline] = append(result[HardDecline], code)
 	}
+	strategiesMu.RLock()
 	for code := range retryStrategies {
 		result[SoftDecline] = append(result[SoftDecline], code)
 	}
+	strategiesMu.RUnlock()
 	sort.Strings(result[HardDecline])
 	sort.Strings(result[SoftDecline])
 	return result
@@ -1,6 +1,7 @@
 package domain
 
 import (
+	"slices"
 	"testing"
 	"time"
 )
@@ -35,6 +36,25 @@ func TestClassifyDecline_SoftDeclines(t *testing.T) {
 	}
 }
 
+func TestClassifyDecline_NormalizesInput(t *testing.T) {
+	for _, code := range []string{"Insufficient_Funds", " insufficient_funds ", "INSUFFICIENT_FUNDS\t"} {
+		if category, _ := ClassifyDecline(code); category != SoftDecline {
+			t.Errorf("%q: expected SoftDecline, got %s", code, category)
+		}
+		strategy := GetRetryStrategy(code)
+		if strategy == nil || strategy.DeclineCode != "insufficient_funds" {
+			t.Errorf("%q: expected the insufficient_funds strategy, got %+v", code, strategy)
+		}
+	}
+	if !IsHardDecline(" Stolen_Card") {
+		t.Error("expected padded mixed-case hard decline to stay hard")
+	}
+
+	if err := ApplyStrategyOverrides(map[string]StrategyConfig{"Custom_Code": {MaxAttempts: 1, Delays: []string{"1h"}}}); err == nil {
+		t.Error("expected a non-normalized strategy code to be rejected")
+	}
+}
+
 func TestClassifyDecline_UnknownCode(t *testing.T) {
 	category, _ := ClassifyDecline("unknown_code")
 	if category != HardDecline {
@@ -42,6 +62,46 @@ func TestClassifyDecline_UnknownCode(t *testing.T) {
 	}
 }
 
+func TestClassifyDecline_UnknownCodeWithDefaultStrategy(t *testing.T) {
+	if err := SetDefaultUnknownStrategy(&StrategyConfig{MaxAttempts: 1, Delays: []string{"24h"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { SetDefaultUnknownStrategy(nil) })
+
+	category, _ := ClassifyDecline("insuficient_funds")
+	if category != SoftDecline {
+		t.Errorf("expected SoftDecline for unknown code with default strategy, got %s", category)
+	}
+	if category, _ := ClassifyDecline("stolen_card"); category != HardDecline {
+		t.Errorf("expected known hard decline to stay hard, got %s", category)
+	}
+
+	plan := BuildRetryPlan("insuficient_funds", "stripe_latam", time.Now(), PlanOptions{})
+	if plan == nil || plan.MaxAttempts != 1 {
+		t.Fatalf("expected 1-attempt default plan, got %+v", plan)
+	}
+	if plan.DeclineCode != "insuficient_funds" {
+		t.Errorf("expected plan to carry the submitted code, got %s", plan.DeclineCode)
+	}
+	if IsKnownDeclineCode("insuficient_funds") {
+		t.Error("expected code to still be reported as unrecognized")
+	}
+}
+
+func TestSetDefaultUnknownStrategy_Validation(t *testing.T) {
+	t.Cleanup(func() { SetDefaultUnknownStrategy(nil) })
+
+	if err := SetDefaultUnknownStrategy(&StrategyConfig{Delays: []string{"1h"}}); err == nil {
+		t.Error("expected error without max_attempts")
+	}
+	if err := SetDefaultUnknownStrategy(&StrategyConfig{MaxAttempts: 2}); err == nil {
+		t.Error("expected error for fixed backoff without delays")
+	}
+	if category, _ := ClassifyDecline("unknown_code"); category != HardDecline {
+		t.Errorf("expected rejected configs to leave unknown codes hard, got %s", category)
+	}
+}
+
 func TestGetRetryStrategy(t *testing.T) {
 	tests := []struct {
 		code        string
@@ -86,7 +146,7 @@ func TestBuildRetryPlan(t *testing.T) {
 	baseTime := time.Date(2026, 2, 10, 12, 0, 0, 0, time.UTC)
 
 	t.Run("soft decline produces plan", func(t *testing.T) {
-		plan := BuildRetryPlan("insufficient_funds", "stripe_latam", baseTime)
+		plan := BuildRetryPlan("insufficient_funds", "stripe_latam", baseTime, PlanOptions{})
 		if plan == nil {
 			t.Fatal("expected non-nil plan")
 		}
@@ -104,14 +164,14 @@ func TestBuildRetryPlan(t *testing.T) {
 	})
 
 	t.Run("hard decline produces no plan", func(t *testing.T) {
-		plan := BuildRetryPlan("stolen_card", "stripe_latam", baseTime)
+		plan := BuildRetryPlan("stolen_card", "stripe_latam", baseTime, PlanOptions{})
 		if plan != nil {
 			t.Error("expected nil plan for hard decline")
 		}
 	})
 
 	t.Run("alt processor used when configured", func(t *testing.T) {
-		plan := BuildRetryPlan("issuer_timeout", "stripe_latam", baseTime)
+		plan := BuildRetryPlan("issuer_timeout", "stripe_latam", baseTime, PlanOptions{})
 		if plan == nil {
 			t.Fatal("expected non-nil plan")
 		}
@@ -146,3 +206,180 @@ func TestGetAvailableProcessors(t *testing.T) {
 		t.Error("expected at least one alternative processor")
 	}
 }
+
+func TestBuildRetryPlan_ProcessorsDistinct(t *testing.T) {
+	original := retryStrategies["processor_error"]
+	defer func() { retryStrategies["processor_error"] = original }()
+
+	strategy := retryStrategies["processor_error"]
+	strategy.MaxAttempts = 5
+	strategy.Delays = []time.Duration{0, 5 * time.Minute, 10 * time.Minute, 20 * time.Minute, 40 * time.Minute}
+	retryStrategies["processor_error"] = strategy
+
+	// 5 processors configured, original excluded -> 4 alternatives
+	plan := BuildRetryPlan("processor_error", "stripe_latam", time.Now(), PlanOptions{})
+	if len(plan.Processors) != 5 {
+		t.Fatalf("expected 5 processors, got %d", len(plan.Processors))
+	}
+	for i := 1; i < len(plan.Processors); i++ {
+		if plan.Processors[i] == plan.Processors[i-1] {
+			t.Errorf("attempts %d and %d share processor %s", i, i+1, plan.Processors[i])
+		}
+	}
+	seen := map[string]bool{}
+	for _, p := range plan.Processors[1:] {
+		if seen[p] {
+			t.Errorf("alternative %s reused before all alternatives were tried", p)
+		}
+		seen[p] = true
+	}
+}
+
+func TestBuildRetryPlan_SingleAlternativeAlternates(t *testing.T) {
+	originalProcessors := availableProcessors
+	defer func() { availableProcessors = originalProcessors }()
+	availableProcessors = []string{"stripe_latam", "adyen_apac"}
+
+	plan := BuildRetryPlan("issuer_timeout", "stripe_latam", time.Now(), PlanOptions{})
+	expected := []string{"stripe_latam", "adyen_apac", "stripe_latam"}
+	for i, p := range expected {
+		if plan.Processors[i] != p {
+			t.Errorf("attempt %d: expected %s, got %s", i+1, p, plan.Processors[i])
+		}
+	}
+}
+
+func TestBuildRetryPlan_ExcludedShiftsRouting(t *testing.T) {
+	excluded := []string{"stripe_latam", "dlocal_br"}
+	plan := BuildRetryPlan("issuer_timeout", "stripe_latam", time.Now(), PlanOptions{Excluded: excluded})
+	if plan.Processors[0] != "adyen_apac" {
+		t.Errorf("expected excluded original replaced by first allowed processor, got %s", plan.Processors[0])
+	}
+	for i, p := range plan.Processors {
+		if slices.Contains(excluded, p) {
+			t.Errorf("attempt %d routed to excluded processor %s", i+1, p)
+		}
+	}
+
+	// Excluding only alternatives keeps the original first
+	plan = BuildRetryPlan("issuer_timeout", "stripe_latam", time.Now(), PlanOptions{Excluded: []string{"adyen_apac"}})
+	if plan.Processors[0] != "stripe_latam" || plan.Processors[1] != "dlocal_br" {
+		t.Errorf("expected [stripe_latam dlocal_br ...], got %v", plan.Processors)
+	}
+}
+
+func TestBuildRetryPlan_ExplicitStrategyKeepsTransactionOptions(t *testing.T) {
+	strategy := &RetryStrategy{
+		DeclineCode:        "candidate",
+		MaxAttempts:        2,
+		Delays:             []time.Duration{2 * time.Hour, 2 * time.Hour},
+		BackoffType:        BackoffBusinessHours,
+		BusinessHoursStart: 9,
+		BusinessHoursEnd:   17,
+	}
+	tx := &Transaction{
+		OriginalProcessor: "stripe_latam",
+		CustomerTimezone:  "Asia/Tokyo",
+		ExcludeProcessors: []string{"stripe_latam"},
+	}
+	opts := tx.PlanOptions()
+	opts.Strategy = strategy
+
+	// 4pm UTC + 2h is 3am in Tokyo: snapped to 9am Tokyo = midnight UTC.
+	base := time.Date(2025, 1, 1, 16, 0, 0, 0, time.UTC)
+	plan := BuildRetryPlan(strategy.DeclineCode, tx.OriginalProcessor, base, opts)
+	if want := time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC); !plan.ScheduledTimes[0].Equal(want) {
+		t.Errorf("expected the customer timezone window, got %s", plan.ScheduledTimes[0])
+	}
+	if slices.Contains(plan.Processors, "stripe_latam") {
+		t.Errorf("expected the excluded processor kept out, got %v", plan.Processors)
+	}
+	if plan.DeclineCode != "candidate" {
+		t.Errorf("expected decline code from the strategy, got %q", plan.DeclineCode)
+	}
+}
+
+func TestBuildRetryPlan_OutOfOrderDelaysStrictlyIncreasing(t *testing.T) {
+	original := retryStrategies["insufficient_funds"]
+	defer func() { retryStrategies["insufficient_funds"] = original }()
+
+	strategy := RetryStrategy{
+		DeclineCode: "insufficient_funds",
+		Category:    SoftDecline,
+		MaxAttempts: 4,
+		Delays:      []time.Duration{24 * time.Hour, 2 * time.Hour, 2 * time.Hour},
+		BackoffType: BackoffFixed,
+	}
+	retryStrategies["insufficient_funds"] = strategy
+	if !strategy.DelaysOutOfOrder() {
+		t.Error("expected DelaysOutOfOrder for [24h 2h 2h]")
+	}
+
+	base := time.Date(2025, 1, 6, 10, 0, 0, 0, time.UTC)
+	plan := BuildRetryPlan("insufficient_funds", "stripe_latam", base, PlanOptions{})
+	if !plan.ScheduledTimes[0].Equal(base.Add(24 * time.Hour)) {
+		t.Errorf("expected first attempt untouched, got %s", plan.ScheduledTimes[0])
+	}
+	for i := 1; i < len(plan.ScheduledTimes); i++ {
+		if gap := plan.ScheduledTimes[i].Sub(plan.ScheduledTimes[i-1]); gap < minAttemptSpacing {
+			t.Errorf("attempt %d only %s after attempt %d: %v", i+1, gap, i, plan.ScheduledTimes)
+		}
+	}
+
+	strategy.Delays = []time.Duration{time.Hour, 2 * time.Hour}
+	if strategy.DelaysOutOfOrder() {
+		t.Error("expected increasing delays to be in order")
+	}
+}
+
+func TestListProcessors_ReturnsCopy(t *testing.T) {
+	processors := ListProcessors()
+	if len(processors) != 5 {
+		t.Fatalf("expected 5 default processors, got %d", len(processors))
+	}
+	processors[0] = "mutated"
+	if ListProcessors()[0] != "stripe_latam" {
+		t.Error("mutating the returned slice should not affect the processor list")
+	}
+}
+
+func TestParseLanguage(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   Language
+	}{
+		{"", LangEnglish},
+		{"pt", LangPortuguese},
+		{"pt-BR,pt;q=0.9,en;q=0.8", LangPortuguese},
+		{"fr-FR, es;q=0.7, en;q=0.5", LangSpanish},
+		{"en;q=0.9, es", LangSpanish},
+		{"de, fr", LangEnglish},
+		{"es;q=0", LangEnglish},
+	}
+	for _, tt := range tests {
+		if got := ParseLanguage(tt.accept); got != tt.want {
+			t.Errorf("ParseLanguage(%q) = %s, want %s", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestMessageCatalog_CoversDefaultReasons(t *testing.T) {
+	reasons := []string{unknownDeclineReason}
+	for _, reason := range hardDeclineCodes {
+		reasons = append(reasons, reason)
+	}
+	for _, strategy := range retryStrategies {
+		reasons = append(reasons, strategy.Description)
+	}
+	for lang, catalog := range messageCatalog {
+		for _, reason := range reasons {
+			if _, ok := catalog[reason]; !ok {
+				t.Errorf("%s catalog is missing %q", lang, reason)
+			}
+		}
+	}
+
+	if got := Translate(LangPortuguese, "operator-written description"); got != "operator-written description" {
+		t.Errorf("expected untranslated strings to fall back to English, got %q", got)
+	}
+}
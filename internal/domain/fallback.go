@@ -0,0 +1,92 @@
+package domain
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxFallbackDepth bounds how many fallback strategies a transaction can chain
+// through once its primary plan is exhausted, so a cycle of fallback codes
+// cannot retry forever. Guarded by strategiesMu.
+var maxFallbackDepth = 1
+
+// GetMaxFallbackDepth returns the configured fallback chain depth.
+func GetMaxFallbackDepth() int {
+	strategiesMu.RLock()
+	defer strategiesMu.RUnlock()
+	return maxFallbackDepth
+}
+
+// SetMaxFallbackDepth sets the fallback chain depth; 0 disables fallbacks.
+// Returns an error if depth is negative.
+func SetMaxFallbackDepth(depth int) error {
+	if depth < 0 {
+		return fmt.Errorf("max_fallback_depth must not be negative, got %d", depth)
+	}
+	strategiesMu.Lock()
+	maxFallbackDepth = depth
+	strategiesMu.Unlock()
+	return nil
+}
+
+// validateFallbackCode rejects a strategy falling back to itself or to a code
+// without a soft-decline strategy, so a typo cannot silently disable the
+// fallback. Longer cycles are cut off by the chain depth.
+func validateFallbackCode(code, fallback string, strategies map[string]RetryStrategy) error {
+	if fallback == "" {
+		return nil
+	}
+	if fallback == code {
+		return fmt.Errorf("fallback_code for %s must differ from the code itself", code)
+	}
+	if IsHardDecline(fallback) {
+		return fmt.Errorf("fallback_code for %s must be a soft decline, got hard decline %q", code, fallback)
+	}
+	if _, ok := strategies[fallback]; !ok {
+		return fmt.Errorf("fallback_code for %s must name a soft decline strategy, got %q", code, fallback)
+	}
+	return nil
+}
+
+// ActiveStrategyCode returns the decline code whose strategy produced the
+// transaction's current plan: the last fallback taken, or the original code.
+func (t *Transaction) ActiveStrategyCode() string {
+	if n := len(t.FallbackCodes); n > 0 {
+		return t.FallbackCodes[n-1]
+	}
+	return t.DeclineCode
+}
+
+// StrategyAttempt returns attemptNum's position within the plan of the active
+// strategy: attempts made before the last fallback do not count.
+func (t *Transaction) StrategyAttempt(attemptNum int) int {
+	return attemptNum - t.FallbackOffset
+}
+
+// ApplyFallback extends an exhausted transaction's plan with the fallback
+// strategy of its active code, scheduled from now, and moves it back to
+// scheduled. It reports false, leaving the transaction untouched, when the
+// active strategy has no (enabled) fallback or the chain depth is reached.
+func (t *Transaction) ApplyFallback(now time.Time) bool {
+	if t.RetryPlan == nil || len(t.FallbackCodes) >= GetMaxFallbackDepth() {
+		return false
+	}
+	current := GetRetryStrategyForProcessor(t.ActiveStrategyCode(), t.OriginalProcessor)
+	if current == nil || current.FallbackCode == "" || !IsStrategyEnabled(current.FallbackCode) {
+		return false
+	}
+	next := BuildRetryPlan(current.FallbackCode, t.OriginalProcessor, now, t.PlanOptions())
+	if next == nil || len(next.ScheduledTimes) == 0 {
+		return false
+	}
+
+	t.FallbackOffset = len(t.RetryPlan.ScheduledTimes)
+	t.RetryPlan.MaxAttempts += next.MaxAttempts
+	t.RetryPlan.ScheduledTimes = append(t.RetryPlan.ScheduledTimes, next.ScheduledTimes...)
+	t.RetryPlan.Processors = append(t.RetryPlan.Processors, next.Processors...)
+	t.FallbackCodes = append(t.FallbackCodes, current.FallbackCode)
+	t.Status = StatusScheduled
+	first := next.ScheduledTimes[0]
+	t.NextRetryAt = &first
+	return true
+}
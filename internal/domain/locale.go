@@ -0,0 +1,101 @@
+package domain
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Language is a supported language for merchant-facing messages.
+type Language string
+
+const (
+	LangEnglish    Language = "en"
+	LangSpanish    Language = "es"
+	LangPortuguese Language = "pt"
+)
+
+// messageCatalog translates English source strings (decline reasons and
+// submit message formats) per language. English is the source language;
+// strings without an entry, such as operator-configured descriptions, are
+// returned untranslated.
+var messageCatalog = map[Language]map[string]string{
+	LangSpanish: {
+		"Card has been reported as stolen":                                     "La tarjeta fue reportada como robada",
+		"Issuer suspects fraudulent activity":                                  "El emisor sospecha actividad fraudulenta",
+		"Card number does not exist":                                           "El número de tarjeta no existe",
+		"Card is past its expiration date":                                     "La tarjeta está vencida",
+		"Customer may add funds; retry with increasing delays":                 "El cliente puede agregar fondos; reintentar con intervalos crecientes",
+		"Network issue; retry immediately via alternative processor":           "Problema de red; reintentar de inmediato con un procesador alternativo",
+		"Generic decline with temporary risk flags; retry after cool-down":     "Rechazo genérico con alertas de riesgo temporales; reintentar tras un período de espera",
+		"Technical failure on processor side; retry via alternative processor": "Falla técnica del procesador; reintentar con un procesador alternativo",
+		"3DS verification incomplete; retry with fresh auth window":            "Verificación 3DS incompleta; reintentar con una nueva ventana de autenticación",
+		unknownDeclineReason:                                                   "Código de rechazo desconocido; se trata como rechazo definitivo por seguridad",
+
+		"Hard decline: %s. Transaction will not be retried.":                                            "Rechazo definitivo: %s. La transacción no será reintentada.",
+		"Soft decline: %s. Retries temporarily disabled for %s; transaction will not be retried.":       "Rechazo temporal: %s. Reintentos desactivados temporalmente para %s; la transacción no será reintentada.",
+		"Soft decline: %s. Response code %q is not retryable; no retries scheduled.":                    "Rechazo temporal: %s. El código de respuesta %q no admite reintentos; no se programaron reintentos.",
+		"Soft decline: %s. Scheduled %d retry attempts.":                                                "Rechazo temporal: %s. Se programaron %d reintentos.",
+		"Soft decline: %s. %s already has %d pending retries (limit); transaction will not be retried.": "Rechazo temporal: %s. %s ya tiene %d reintentos pendientes (límite); la transacción no será reintentada.",
+	},
+	LangPortuguese: {
+		"Card has been reported as stolen":                                     "O cartão foi reportado como roubado",
+		"Issuer suspects fraudulent activity":                                  "O emissor suspeita de atividade fraudulenta",
+		"Card number does not exist":                                           "O número do cartão não existe",
+		"Card is past its expiration date":                                     "O cartão está vencido",
+		"Customer may add funds; retry with increasing delays":                 "O cliente pode adicionar fundos; nova tentativa com intervalos crescentes",
+		"Network issue; retry immediately via alternative processor":           "Problema de rede; nova tentativa imediata por um processador alternativo",
+		"Generic decline with temporary risk flags; retry after cool-down":     "Recusa genérica com alertas de risco temporários; nova tentativa após um período de espera",
+		"Technical failure on processor side; retry via alternative processor": "Falha técnica no processador; nova tentativa por um processador alternativo",
+		"3DS verification incomplete; retry with fresh auth window":            "Verificação 3DS incompleta; nova tentativa com uma nova janela de autenticação",
+		unknownDeclineReason:                                                   "Código de recusa desconhecido; tratado como recusa definitiva por segurança",
+
+		"Hard decline: %s. Transaction will not be retried.":                                            "Recusa definitiva: %s. A transação não será retentada.",
+		"Soft decline: %s. Retries temporarily disabled for %s; transaction will not be retried.":       "Recusa temporária: %s. Novas tentativas desativadas temporariamente para %s; a transação não será retentada.",
+		"Soft decline: %s. Response code %q is not retryable; no retries scheduled.":                    "Recusa temporária: %s. O código de resposta %q não permite novas tentativas; nenhuma tentativa agendada.",
+		"Soft decline: %s. Scheduled %d retry attempts.":                                                "Recusa temporária: %s. %d novas tentativas agendadas.",
+		"Soft decline: %s. %s already has %d pending retries (limit); transaction will not be retried.": "Recusa temporária: %s. %s já tem %d novas tentativas pendentes (limite); a transação não será retentada.",
+	},
+}
+
+// Translate returns msg in lang, falling back to the English msg when the
+// language or the string has no translation.
+func Translate(lang Language, msg string) string {
+	if translated, ok := messageCatalog[lang][msg]; ok {
+		return translated
+	}
+	return msg
+}
+
+// Localizef formats a catalog message in lang, like fmt.Sprintf.
+func Localizef(lang Language, format string, args ...any) string {
+	return fmt.Sprintf(Translate(lang, format), args...)
+}
+
+// ParseLanguage picks the supported language from an Accept-Language style
+// value (e.g. "pt-BR,pt;q=0.9,en;q=0.5" or just "es"), preferring the highest
+// q-value and then the earliest entry. Region subtags are ignored. Returns
+// English when nothing supported is listed.
+func ParseLanguage(accept string) Language {
+	best, bestQ := LangEnglish, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		base, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		lang := Language(base)
+		if lang != LangEnglish && messageCatalog[lang] == nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > bestQ {
+			best, bestQ = lang, q
+		}
+	}
+	return best
+}
@@ -1,6 +1,9 @@
 package domain
 
-import "time"
+import (
+	"slices"
+	"time"
+)
 
 // DeclineCategory classifies whether a decline is retryable.
 type DeclineCategory string
@@ -14,11 +17,13 @@ const (
 type TransactionStatus string
 
 const (
-	StatusScheduled  TransactionStatus = "scheduled"    // Retry plan created, waiting for first attempt
-	StatusRetrying   TransactionStatus = "retrying"     // At least one retry attempted, more pending
-	StatusRecovered  TransactionStatus = "recovered"    // A retry attempt succeeded
-	StatusFailedFinal TransactionStatus = "failed_final" // All retry attempts exhausted, none succeeded
-	StatusRejected   TransactionStatus = "rejected"     // Hard decline, will not retry
+	StatusScheduled           TransactionStatus = "scheduled"            // Retry plan created, waiting for first attempt
+	StatusRetrying            TransactionStatus = "retrying"             // At least one retry attempted, more pending
+	StatusRecovered           TransactionStatus = "recovered"            // A retry attempt succeeded
+	StatusPendingConfirmation TransactionStatus = "pending_confirmation" // A retry attempt succeeded, awaiting merchant confirmation
+	StatusFailedFinal         TransactionStatus = "failed_final"         // All retry attempts exhausted, none succeeded
+	StatusRejected            TransactionStatus = "rejected"             // Hard decline, will not retry
+	StatusResolvedExternally  TransactionStatus = "resolved_externally"  // Merchant resolved out-of-band, remaining retries cancelled
 )
 
 // Webhook event type constants.
@@ -27,8 +32,18 @@ const (
 	EventRetrySucceeded = "retry.succeeded"
 	EventRetryFailed    = "retry.failed"
 	EventRetryExhausted = "retry.exhausted"
+	EventRetryCancelled = "retry.cancelled"
 )
 
+// IsWebhookEventType reports whether eventType is one of the emitted webhook event types.
+func IsWebhookEventType(eventType string) bool {
+	switch eventType {
+	case EventRetryScheduled, EventRetrySucceeded, EventRetryFailed, EventRetryExhausted, EventRetryCancelled:
+		return true
+	}
+	return false
+}
+
 // Transaction represents a failed payment transaction submitted for retry evaluation.
 type Transaction struct {
 	ID                string            `json:"id"`
@@ -36,8 +51,11 @@ type Transaction struct {
 	Currency          string            `json:"currency"`
 	CustomerID        string            `json:"customer_id"`
 	MerchantID        string            `json:"merchant_id"`
+	CardToken         string            `json:"card_token,omitempty"`
 	OriginalProcessor string            `json:"original_processor"`
 	DeclineCode       string            `json:"decline_code"`
+	RawDeclineCode    string            `json:"raw_decline_code,omitempty"` // decline code as submitted, when normalization changed it
+	ResponseCode      string            `json:"response_code,omitempty"`
 	DeclineCategory   DeclineCategory   `json:"decline_category"`
 	Status            TransactionStatus `json:"status"`
 	RetryPlan         *RetryPlan        `json:"retry_plan,omitempty"`
@@ -46,15 +64,43 @@ type Transaction struct {
 	CreatedAt         time.Time         `json:"created_at"`
 	UpdatedAt         time.Time         `json:"updated_at"`
 	WebhookURL        string            `json:"webhook_url,omitempty"`
+	WebhookRoutes     map[string]string `json:"webhook_routes,omitempty"`     // event type -> URL, overrides WebhookURL
+	WebhookEvents     []string          `json:"webhook_events,omitempty"`     // event types delivered; empty = all
+	WebhookTimeoutMs  int               `json:"webhook_timeout_ms,omitempty"` // per-delivery timeout; 0 = notifier default
+	ResolutionReason  string            `json:"resolution_reason,omitempty"`  // merchant-supplied reason for external resolution
+	FallbackCodes     []string          `json:"fallback_codes,omitempty"`     // fallback strategies appended to the plan, in order
+	FallbackOffset    int               `json:"fallback_offset,omitempty"`    // plan attempts before the last fallback's
+	Priority          int               `json:"priority,omitempty"`           // scheduler ordering among due retries; higher first
+	CustomerTimezone  string            `json:"customer_timezone,omitempty"`  // IANA zone for business-hours windows; empty = UTC
+	RecoveredCents    int64             `json:"recovered_cents,omitempty"`    // collected so far by partial approvals
+	BudgetResets      int               `json:"budget_resets,omitempty"`      // attempt budgets restarted by partial approvals
+	ExcludeProcessors []string          `json:"exclude_processors,omitempty"` // processors kept out of retry routing
+	Version           int               `json:"version"`                      // incremented by the store on every write; see If-Match
+}
+
+// WebhookURLFor returns the delivery URL for an event type: the matching
+// entry in WebhookRoutes if present, otherwise WebhookURL.
+func (t *Transaction) WebhookURLFor(eventType string) string {
+	if url, ok := t.WebhookRoutes[eventType]; ok {
+		return url
+	}
+	return t.WebhookURL
+}
+
+// DeliversEvent reports whether events of eventType are delivered to the
+// merchant: every type when WebhookEvents is empty, otherwise only the listed
+// ones. Events are recorded either way.
+func (t *Transaction) DeliversEvent(eventType string) bool {
+	return len(t.WebhookEvents) == 0 || slices.Contains(t.WebhookEvents, eventType)
 }
 
 // RetryPlan describes the scheduled retry strategy for a soft-declined transaction.
 type RetryPlan struct {
-	MaxAttempts    int           `json:"max_attempts"`
-	Strategy       string        `json:"strategy"`
-	DeclineCode    string        `json:"decline_code"`
-	ScheduledTimes []time.Time   `json:"scheduled_times"`
-	Processors     []string      `json:"processors"`
+	MaxAttempts    int         `json:"max_attempts"`
+	Strategy       string      `json:"strategy"`
+	DeclineCode    string      `json:"decline_code"`
+	ScheduledTimes []time.Time `json:"scheduled_times"`
+	Processors     []string    `json:"processors"`
 }
 
 // RetryAttempt records the result of a single retry execution.
@@ -66,6 +112,8 @@ type RetryAttempt struct {
 	Success       bool      `json:"success"`
 	ResponseCode  string    `json:"response_code"`
 	ResponseMsg   string    `json:"response_message"`
+	TimedOut      bool      `json:"timed_out,omitempty"`      // processor call exceeded the strategy's attempt timeout
+	ApprovedCents int64     `json:"approved_cents,omitempty"` // partial approval: amount collected, less than the outstanding balance
 }
 
 // SubmitRequest is the API request body for submitting a failed transaction.
@@ -74,63 +122,325 @@ type SubmitRequest struct {
 	TransactionID     string `json:"transaction_id"`
 	AmountCents       int64  `json:"amount_cents"`
 	Currency          string `json:"currency"`
-	CustomerID        string  `json:"customer_id"`
-	MerchantID        string  `json:"merchant_id"`
-	OriginalProcessor string  `json:"original_processor"`
-	DeclineCode       string  `json:"decline_code"`
-	Timestamp         string  `json:"timestamp"`
-	WebhookURL        string  `json:"webhook_url,omitempty"`
+	CustomerID        string `json:"customer_id"`
+	MerchantID        string `json:"merchant_id"`
+	CardToken         string `json:"card_token,omitempty"` // tokenized card credential, for per-card retry spacing
+	OriginalProcessor string `json:"original_processor"`
+	DeclineCode       string `json:"decline_code"`
+	ResponseCode      string `json:"response_code,omitempty"` // original processor response code
+	Timestamp         string `json:"timestamp"`
+	WebhookURL        string `json:"webhook_url,omitempty"`
+	// WebhookRoutes maps event types to URLs; unlisted events go to WebhookURL.
+	WebhookRoutes map[string]string `json:"webhook_routes,omitempty"`
+	// WebhookEvents limits delivery to these event types, e.g. only
+	// retry.succeeded and retry.exhausted; other events are still recorded.
+	WebhookEvents []string `json:"webhook_events,omitempty"`
+	// WebhookTimeoutMs overrides the delivery timeout for this transaction's events.
+	WebhookTimeoutMs int `json:"webhook_timeout_ms,omitempty"`
+	// Priority orders due retries when the scheduler's per-tick cap applies;
+	// higher runs first. Default 0.
+	Priority int `json:"priority,omitempty"`
+	// CustomerTimezone is an IANA time zone (e.g. "America/Sao_Paulo") in
+	// which business-hours strategies place retries, so they land in the
+	// customer's daytime. Empty keeps the strategy's window in UTC.
+	CustomerTimezone string `json:"customer_timezone,omitempty"`
+	// ExcludeProcessors keeps these processors out of the retry routing, e.g.
+	// one known to be down for the merchant's account. At least one processor
+	// must remain.
+	ExcludeProcessors []string `json:"exclude_processors,omitempty"`
+	// Language selects the language of the response message; set by the
+	// handler from the request, English if empty.
+	Language Language `json:"-"`
 }
 
 // SubmitResponse is the API response after submitting a failed transaction.
 type SubmitResponse struct {
-	TransactionID   string          `json:"transaction_id"`
-	DeclineCategory DeclineCategory `json:"decline_category"`
+	TransactionID   string            `json:"transaction_id"`
+	DeclineCategory DeclineCategory   `json:"decline_category"`
 	Status          TransactionStatus `json:"status"`
-	RetryEligible   bool            `json:"retry_eligible"`
-	RetryPlan       *RetryPlan      `json:"retry_plan,omitempty"`
-	Message         string          `json:"message"`
+	RetryEligible   bool              `json:"retry_eligible"`
+	RetryPlan       *RetryPlan        `json:"retry_plan,omitempty"`
+	Message         string            `json:"message"`
+	// WebhookReachable is set on submits with ?validate_webhook=true: whether
+	// every webhook URL of the transaction answered a probe.
+	WebhookReachable *bool `json:"webhook_reachable,omitempty"`
+}
+
+// LastError summarizes the final retry attempt of a transaction when it failed.
+type LastError struct {
+	Attempt         int       `json:"attempt"`
+	Processor       string    `json:"processor"`
+	ResponseCode    string    `json:"response_code"`
+	ResponseMessage string    `json:"response_message"`
+	ExecutedAt      time.Time `json:"executed_at"`
+}
+
+// Schedule slot statuses, relative to the time of the request.
+const (
+	SlotExecuted = "executed" // the attempt for this slot has run
+	SlotDue      = "due"      // scheduled time has passed, attempt not yet run
+	SlotPending  = "pending"  // scheduled in the future
+	SlotSkipped  = "skipped"  // will never run: the transaction reached a terminal state first
+)
+
+// ScheduleSlot annotates one planned retry with its state. Result is set for
+// executed slots.
+type ScheduleSlot struct {
+	AttemptNumber int           `json:"attempt_number"`
+	ScheduledAt   time.Time     `json:"scheduled_at"`
+	Processor     string        `json:"processor"`
+	Status        string        `json:"status"`
+	Result        *RetryAttempt `json:"attempt_result,omitempty"`
+}
+
+// ReadOnlyRequest is the API request body for toggling maintenance mode.
+type ReadOnlyRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+// SimulatorLatencyRequest is the API request body for injecting processor
+// latency into the simulator.
+type SimulatorLatencyRequest struct {
+	Processor string `json:"processor"`
+	Ms        *int   `json:"ms"` // delay per attempt in milliseconds; 0 removes it
+}
+
+// RetryRequest is the optional API request body for a manual retry.
+type RetryRequest struct {
+	// Processor overrides the plan's processor for this attempt only.
+	Processor string `json:"processor,omitempty"`
+}
+
+// SuspendRetriesRequest is the API request body for the retry kill switch.
+type SuspendRetriesRequest struct {
+	Suspended *bool `json:"suspended"`
+}
+
+// PurgeRequest is the API request body for purging old terminal transactions.
+// OlderThan is a Go duration (e.g. "720h"); Status optionally limits the purge
+// to one terminal status.
+type PurgeRequest struct {
+	OlderThan string            `json:"older_than"`
+	Status    TransactionStatus `json:"status,omitempty"`
+}
+
+// AckRequest is the API request body for acknowledging an out-of-band resolution.
+type AckRequest struct {
+	Reason string `json:"reason"`
 }
 
 // AnalyticsOverview provides high-level recovery metrics.
 type AnalyticsOverview struct {
-	TotalTransactions   int     `json:"total_transactions"`
-	HardDeclines        int     `json:"hard_declines"`
-	SoftDeclines        int     `json:"soft_declines"`
-	Recovered           int     `json:"recovered"`
-	FailedFinal         int     `json:"failed_final"`
-	PendingRetry        int     `json:"pending_retry"`
+	TotalTransactions  int `json:"total_transactions"`
+	HardDeclines       int `json:"hard_declines"`
+	SoftDeclines       int `json:"soft_declines"`
+	Recovered          int `json:"recovered"`
+	FailedFinal        int `json:"failed_final"`
+	PendingRetry       int `json:"pending_retry"`
+	ResolvedExternally int `json:"resolved_externally"`
+	// PendingConfirmation counts successful retries awaiting merchant
+	// confirmation; they are not in Recovered or the recovery rate.
+	PendingConfirmation int     `json:"pending_confirmation"`
 	RecoveryRate        float64 `json:"recovery_rate_pct"`
 	TotalRetryAttempts  int     `json:"total_retry_attempts"`
 	SuccessfulAttempts  int     `json:"successful_attempts"`
+	TimedOutAttempts    int     `json:"timed_out_attempts"`
 	EfficiencyRate      float64 `json:"efficiency_rate_pct"`
 }
 
 // DeclineReasonStats provides recovery metrics for a specific decline code.
 type DeclineReasonStats struct {
-	DeclineCode    string  `json:"decline_code"`
-	Category       string  `json:"category"`
-	Total          int     `json:"total"`
+	DeclineCode  string  `json:"decline_code"`
+	Category     string  `json:"category"`
+	Total        int     `json:"total"`
+	Recovered    int     `json:"recovered"`
+	Failed       int     `json:"failed"`
+	Pending      int     `json:"pending"`
+	RecoveryRate float64 `json:"recovery_rate_pct"`
+	AvgAttempts  float64 `json:"avg_attempts_to_recover"`
+}
+
+// AttemptStats shows success rate by attempt number.
+type AttemptStats struct {
+	AttemptNumber int     `json:"attempt_number"`
+	TotalAttempts int     `json:"total_attempts"`
+	Successes     int     `json:"successes"`
+	Timeouts      int     `json:"timeouts"`
+	SuccessRate   float64 `json:"success_rate_pct"`
+}
+
+// SLAStats compares a decline code's actual recovery rate with its target.
+// Rates are percentages over completed (recovered or failed) transactions.
+// With none completed yet, NoData is set and MeetsTarget is meaningless.
+type SLAStats struct {
+	DeclineCode        string  `json:"decline_code"`
+	Completed          int     `json:"completed"`
+	ActualRecoveryRate float64 `json:"actual_recovery_rate_pct"`
+	TargetRecoveryRate float64 `json:"target_recovery_rate_pct"`
+	MeetsTarget        bool    `json:"meets_target"`
+	NoData             bool    `json:"no_data,omitempty"`
+}
+
+// TagStats aggregates recovery metrics across every decline code sharing a tag.
+type TagStats struct {
+	Tag          string   `json:"tag"`
+	DeclineCodes []string `json:"decline_codes"`
+	Total        int      `json:"total"`
+	Recovered    int      `json:"recovered"`
+	Failed       int      `json:"failed"`
+	Pending      int      `json:"pending"`
+	RecoveryRate float64  `json:"recovery_rate_pct"`
+}
+
+// ShadowRequest is the API request body for evaluating a candidate strategy
+// against stored transactions without changing live configuration.
+type ShadowRequest struct {
+	DeclineCode string         `json:"decline_code"`
+	Strategy    StrategyConfig `json:"strategy"`
+	Seed        int64          `json:"seed,omitempty"` // simulator seed; fixed default keeps runs comparable
+}
+
+// ShadowReport compares a shadow strategy's projected recovery with the
+// actual outcome of the same transactions. Actual rate is over completed
+// transactions; projected rate is over all evaluated ones, each simulated
+// to completion.
+type ShadowReport struct {
+	DeclineCode           string  `json:"decline_code"`
+	ShadowMaxAttempts     int     `json:"shadow_max_attempts"`
+	Evaluated             int     `json:"evaluated"`
+	ActualCompleted       int     `json:"actual_completed"`
+	ActualRecovered       int     `json:"actual_recovered"`
+	ActualRecoveryRate    float64 `json:"actual_recovery_rate_pct"`
+	ProjectedRecovered    int     `json:"projected_recovered"`
+	ProjectedRecoveryRate float64 `json:"projected_recovery_rate_pct"`
+	ProjectedByAttempt    []int   `json:"projected_recoveries_by_attempt"`
+}
+
+// AttemptCountStats counts terminal transactions that used a given total number
+// of retry attempts, split by outcome.
+type AttemptCountStats struct {
+	Attempts  int `json:"attempts"`
+	Recovered int `json:"recovered"`
+	Failed    int `json:"failed"`
+}
+
+// CustomerStats aggregates recovery metrics for one customer across every
+// merchant. RecoveredCents is in USD-equivalent cents and includes amounts
+// collected by partial approvals.
+type CustomerStats struct {
+	CustomerID     string  `json:"customer_id"`
+	Declines       int     `json:"declines"`
 	Recovered      int     `json:"recovered"`
 	Failed         int     `json:"failed"`
-	Pending        int     `json:"pending"`
+	RecoveredCents int64   `json:"recovered_cents"`
 	RecoveryRate   float64 `json:"recovery_rate_pct"`
-	AvgAttempts    float64 `json:"avg_attempts_to_recover"`
 }
 
-// AttemptStats shows success rate by attempt number.
-type AttemptStats struct {
-	AttemptNumber  int     `json:"attempt_number"`
-	TotalAttempts  int     `json:"total_attempts"`
-	Successes      int     `json:"successes"`
-	SuccessRate    float64 `json:"success_rate_pct"`
+// AmountBucketStats shows recovery metrics for one transaction size segment.
+// Bounds are in USD-equivalent cents after currency normalization.
+type AmountBucketStats struct {
+	Bucket       string  `json:"bucket"`
+	MinCents     int64   `json:"min_cents"`
+	MaxCents     int64   `json:"max_cents,omitempty"`
+	Total        int     `json:"total"`
+	SoftDeclines int     `json:"soft_declines"`
+	Recovered    int     `json:"recovered"`
+	RecoveryRate float64 `json:"recovery_rate_pct"`
+}
+
+// ProcessorStats shows retry performance for a single processor.
+type ProcessorStats struct {
+	Processor     string  `json:"processor"`
+	TotalAttempts int     `json:"total_attempts"`
+	Successes     int     `json:"successes"`
+	SuccessRate   float64 `json:"success_rate_pct"`
+}
+
+// RoutingStats shows per-processor retry performance for one decline code.
+type RoutingStats struct {
+	DeclineCode string           `json:"decline_code"`
+	Processors  []ProcessorStats `json:"processors"`
+}
+
+// AnalyticsReport combines the analytics sections into one downloadable document.
+type AnalyticsReport struct {
+	GeneratedAt time.Time         `json:"generated_at"`
+	MerchantID  string            `json:"merchant_id,omitempty"` // set when the report is scoped to one merchant
+	Overview    AnalyticsOverview `json:"overview"`
+	ByDecline   DeclineBreakdown  `json:"by_decline"`
+	ByAttempt   []AttemptStats    `json:"by_attempt"`
+	ByProcessor []ProcessorStats  `json:"by_processor"`
+}
+
+// DeclineBreakdown groups per-decline-code stats by category.
+type DeclineBreakdown struct {
+	SoftDeclines []DeclineReasonStats `json:"soft_declines"`
+	HardDeclines []DeclineReasonStats `json:"hard_declines"`
+}
+
+// SchedulerDriftStats summarizes how late retry attempts ran relative to their
+// scheduled time. Attempts executed early (manual or accelerated runs) are
+// counted separately and excluded from the drift figures.
+type SchedulerDriftStats struct {
+	Attempts      int     `json:"attempts"`
+	EarlyAttempts int     `json:"early_attempts"`
+	AvgSeconds    float64 `json:"avg_drift_seconds"`
+	P95Seconds    float64 `json:"p95_drift_seconds"`
+	MaxSeconds    float64 `json:"max_drift_seconds"`
+}
+
+// Timeline entry type constants.
+const (
+	TimelineRetryAttempt = "retry_attempt"
+	TimelineWebhookEvent = "webhook_event"
+)
+
+// TimelineEntry is one item in a transaction's chronological history: either a
+// retry attempt (timestamped by ExecutedAt) or a webhook event (by Timestamp).
+type TimelineEntry struct {
+	Type      string        `json:"type"`
+	Timestamp time.Time     `json:"timestamp"`
+	Attempt   *RetryAttempt `json:"attempt,omitempty"`
+	Event     *WebhookEvent `json:"event,omitempty"`
+}
+
+// Badge is a status badge in the shields.io endpoint schema.
+type Badge struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Label         string `json:"label"`
+	Message       string `json:"message"`
+	Color         string `json:"color"`
 }
 
 // WebhookEvent represents a notification sent to the merchant.
 type WebhookEvent struct {
+	ID            string            `json:"event_id"` // stable per (transaction, type, attempt); echoed back by ack-verifying merchants
 	EventType     string            `json:"event_type"`
 	TransactionID string            `json:"transaction_id"`
 	Status        TransactionStatus `json:"status"`
 	AttemptNumber int               `json:"attempt_number,omitempty"`
 	Timestamp     time.Time         `json:"timestamp"`
+	WebhookURL    string            `json:"-"`                    // delivery target at send time, kept for replays
+	Timeout       time.Duration     `json:"-"`                    // per-transaction delivery timeout; 0 = notifier default
+	ExpectAck     bool              `json:"-"`                    // response body must equal ID for the delivery to count
+	Duplicate     bool              `json:"duplicate,omitempty"`  // repeat of a recent event; recorded but not delivered
+	Filtered      bool              `json:"filtered,omitempty"`   // type not in the transaction's webhook_events; recorded but not delivered
+	Cumulative    *RecoveryTotals   `json:"cumulative,omitempty"` // retry.succeeded only, when enabled: the merchant's running totals
+}
+
+// RecoveryTotals is a merchant's running recovered amount in one currency,
+// including the transaction the event is about.
+type RecoveryTotals struct {
+	MerchantID     string `json:"merchant_id"`
+	Currency       string `json:"currency"`
+	RecoveredCents int64  `json:"recovered_cents"`
+	RecoveredCount int    `json:"recovered_count"`
+}
+
+// WebhookReplayRequest is the API request body for re-delivering recorded webhook events.
+// Events with From <= Timestamp <= To are replayed, optionally for one transaction.
+type WebhookReplayRequest struct {
+	From          time.Time `json:"from"`
+	To            time.Time `json:"to"`
+	TransactionID string    `json:"transaction_id,omitempty"`
 }
@@ -0,0 +1,41 @@
+package domain
+
+import "time"
+
+// OutstandingCents returns the part of the amount not yet collected by
+// partial approvals.
+func (t *Transaction) OutstandingCents() int64 {
+	return t.AmountCents - t.RecoveredCents
+}
+
+// ResetAttemptBudget gives a partially recovered transaction a fresh attempt
+// budget for its remaining balance: the unused attempts of the plan are
+// replaced by a new plan of the active strategy, scheduled from now, and the
+// transaction moves back to scheduled. Attempts already made stay in the
+// plan. It reports false, leaving the transaction untouched, once the active
+// strategy's MaxBudgetResets is reached; the count is kept on the transaction
+// across fallbacks, so resets are bounded over its whole life.
+func (t *Transaction) ResetAttemptBudget(now time.Time) bool {
+	if t.RetryPlan == nil {
+		return false
+	}
+	code := t.ActiveStrategyCode()
+	strategy := GetRetryStrategyForProcessor(code, t.OriginalProcessor)
+	if strategy == nil || t.BudgetResets >= strategy.MaxBudgetResets {
+		return false
+	}
+	next := BuildRetryPlan(code, t.OriginalProcessor, now, t.PlanOptions())
+	if next == nil || len(next.ScheduledTimes) == 0 {
+		return false
+	}
+
+	made := len(t.RetryAttempts)
+	t.RetryPlan.MaxAttempts = made + next.MaxAttempts
+	t.RetryPlan.ScheduledTimes = append(t.RetryPlan.ScheduledTimes[:made:made], next.ScheduledTimes...)
+	t.RetryPlan.Processors = append(t.RetryPlan.Processors[:made:made], next.Processors...)
+	t.BudgetResets++
+	t.Status = StatusScheduled
+	first := next.ScheduledTimes[0]
+	t.NextRetryAt = &first
+	return true
+}
@@ -0,0 +1,173 @@
+package domain
+
+import (
+	"fmt"
+	"maps"
+	"time"
+)
+
+// StrategyPatch is a partial update to a decline code's retry strategy.
+// Nil fields are left unchanged; non-nil fields are set as given, including
+// to zero values. An empty list clears RetryableResponseCodes (retry every
+// response code) or Tags.
+type StrategyPatch struct {
+	MaxAttempts            *int       `json:"max_attempts,omitempty"`
+	Delays                 *[]string  `json:"delays,omitempty"`
+	PerAttemptRates        *[]float64 `json:"per_attempt_rates,omitempty"`
+	UseAltProcessor        *bool      `json:"use_alt_processor,omitempty"`
+	BackoffType            *string    `json:"backoff_type,omitempty"`
+	BaseDelay              *string    `json:"base_delay,omitempty"`
+	BackoffMultiplier      *float64   `json:"backoff_multiplier,omitempty"`
+	MaxDelay               *string    `json:"max_delay,omitempty"`
+	BusinessHoursStart     *int       `json:"business_hours_start,omitempty"`
+	BusinessHoursEnd       *int       `json:"business_hours_end,omitempty"`
+	Description            *string    `json:"description,omitempty"`
+	RetryableResponseCodes *[]string  `json:"retryable_response_codes,omitempty"`
+	InitialCooldown        *string    `json:"initial_cooldown,omitempty"`
+	Tags                   *[]string  `json:"tags,omitempty"`
+	TargetRecoveryRate     *float64   `json:"target_recovery_rate,omitempty"`
+	AttemptTimeout         *string    `json:"attempt_timeout,omitempty"`
+	MinGapAfterFailure     *string    `json:"min_gap_after_failure,omitempty"`
+	MaxPending             *int       `json:"max_pending,omitempty"`
+	MaxBudgetResets        *int       `json:"max_budget_resets,omitempty"`
+	GuaranteeAttempts      *int       `json:"guarantee_attempts,omitempty"`
+
+	CurrencyBusinessHours *map[string]BusinessHoursWindow `json:"currency_business_hours,omitempty"`
+	FallbackCode          *string                         `json:"fallback_code,omitempty"`
+}
+
+// PatchStrategy applies a partial update to the strategy of an existing soft
+// decline code and returns the result. The patched strategy is validated as a
+// whole (e.g. per_attempt_rates must still match max_attempts), so related
+// fields may need to be patched together. Processor-specific overrides of the
+// code are re-merged on top of the patched strategy. Returns ErrUnknownStrategy if the code has no strategy.
+// Safe to call while serving requests.
+func PatchStrategy(code string, patch StrategyPatch) (*RetryStrategy, error) {
+	strategiesMu.Lock()
+	defer strategiesMu.Unlock()
+
+	existing, ok := retryStrategies[code]
+	if !ok {
+		return nil, fmt.Errorf("%q: %w", code, ErrUnknownStrategy)
+	}
+
+	patched, err := applyStrategyPatch(code, existing, patch)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateStrategy(code, patched, retryStrategies); err != nil {
+		return nil, err
+	}
+	if err := validatePlanShape(code, patched); err != nil {
+		return nil, err
+	}
+	remerged, err := remergeProcessorOverrides(code, patched, retryStrategies)
+	if err != nil {
+		return nil, err
+	}
+
+	retryStrategies[code] = patched
+	for processor, o := range remerged {
+		processorStrategies[processor][code] = o
+	}
+	return &patched, nil
+}
+
+// applyStrategyPatch sets every non-nil patch field on a copy of s.
+func applyStrategyPatch(code string, s RetryStrategy, patch StrategyPatch) (RetryStrategy, error) {
+	if patch.MaxAttempts != nil {
+		s.MaxAttempts = *patch.MaxAttempts
+	}
+	if patch.Delays != nil {
+		delays := make([]time.Duration, len(*patch.Delays))
+		for i, d := range *patch.Delays {
+			parsed, err := time.ParseDuration(d)
+			if err != nil {
+				return s, fmt.Errorf("invalid delay %q for %s: %w", d, code, err)
+			}
+			delays[i] = parsed
+		}
+		s.Delays = delays
+	}
+	if patch.PerAttemptRates != nil {
+		s.PerAttemptRates = append([]float64(nil), *patch.PerAttemptRates...)
+	}
+	if patch.UseAltProcessor != nil {
+		s.UseAltProcessor = *patch.UseAltProcessor
+	}
+	if patch.BackoffType != nil {
+		s.BackoffType = BackoffType(*patch.BackoffType)
+	}
+	if patch.BaseDelay != nil {
+		parsed, err := time.ParseDuration(*patch.BaseDelay)
+		if err != nil {
+			return s, fmt.Errorf("invalid base_delay %q for %s: %w", *patch.BaseDelay, code, err)
+		}
+		s.BaseDelay = parsed
+	}
+	if patch.BackoffMultiplier != nil {
+		s.BackoffMultiplier = *patch.BackoffMultiplier
+	}
+	if patch.MaxDelay != nil {
+		parsed, err := time.ParseDuration(*patch.MaxDelay)
+		if err != nil {
+			return s, fmt.Errorf("invalid max_delay %q for %s: %w", *patch.MaxDelay, code, err)
+		}
+		s.MaxDelay = parsed
+	}
+	if patch.BusinessHoursStart != nil {
+		s.BusinessHoursStart = *patch.BusinessHoursStart
+	}
+	if patch.BusinessHoursEnd != nil {
+		s.BusinessHoursEnd = *patch.BusinessHoursEnd
+	}
+	if patch.Description != nil {
+		s.Description = *patch.Description
+	}
+	if patch.RetryableResponseCodes != nil {
+		s.RetryableResponseCodes = append([]string(nil), *patch.RetryableResponseCodes...)
+	}
+	if patch.InitialCooldown != nil {
+		parsed, err := time.ParseDuration(*patch.InitialCooldown)
+		if err != nil {
+			return s, fmt.Errorf("invalid initial_cooldown %q for %s: %w", *patch.InitialCooldown, code, err)
+		}
+		s.InitialCooldown = parsed
+	}
+	if patch.AttemptTimeout != nil {
+		parsed, err := time.ParseDuration(*patch.AttemptTimeout)
+		if err != nil {
+			return s, fmt.Errorf("invalid attempt_timeout %q for %s: %w", *patch.AttemptTimeout, code, err)
+		}
+		s.AttemptTimeout = parsed
+	}
+	if patch.MinGapAfterFailure != nil {
+		parsed, err := time.ParseDuration(*patch.MinGapAfterFailure)
+		if err != nil {
+			return s, fmt.Errorf("invalid min_gap_after_failure %q for %s: %w", *patch.MinGapAfterFailure, code, err)
+		}
+		s.MinGapAfterFailure = parsed
+	}
+	if patch.MaxPending != nil {
+		s.MaxPending = *patch.MaxPending
+	}
+	if patch.MaxBudgetResets != nil {
+		s.MaxBudgetResets = *patch.MaxBudgetResets
+	}
+	if patch.GuaranteeAttempts != nil {
+		s.GuaranteeAttempts = *patch.GuaranteeAttempts
+	}
+	if patch.Tags != nil {
+		s.Tags = append([]string(nil), *patch.Tags...)
+	}
+	if patch.CurrencyBusinessHours != nil {
+		s.CurrencyBusinessHours = maps.Clone(*patch.CurrencyBusinessHours)
+	}
+	if patch.FallbackCode != nil {
+		s.FallbackCode = *patch.FallbackCode
+	}
+	if patch.TargetRecoveryRate != nil {
+		s.TargetRecoveryRate = *patch.TargetRecoveryRate
+	}
+	return s, nil
+}
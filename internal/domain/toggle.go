@@ -0,0 +1,48 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrUnknownStrategy is returned when a decline code has no soft-decline retry strategy.
+var ErrUnknownStrategy = errors.New("unknown soft decline code")
+
+// disabledStrategies holds soft decline codes whose retries are switched off.
+// It is toggled independently of the strategies themselves (see
+// strategiesMu), so it has its own lock.
+var (
+	disabledMu         sync.RWMutex
+	disabledStrategies = map[string]struct{}{}
+)
+
+// IsStrategyEnabled reports whether retries are enabled for a decline code.
+// Codes are enabled unless explicitly disabled.
+func IsStrategyEnabled(code string) bool {
+	disabledMu.RLock()
+	defer disabledMu.RUnlock()
+	_, disabled := disabledStrategies[code]
+	return !disabled
+}
+
+// SetStrategyEnabled enables or disables retries for a soft decline code.
+// Returns ErrUnknownStrategy if the code has no retry strategy.
+func SetStrategyEnabled(code string, enabled bool) error {
+	if !isSoftDeclineCode(code) {
+		return fmt.Errorf("%q: %w", code, ErrUnknownStrategy)
+	}
+	setStrategyEnabled(code, enabled)
+	return nil
+}
+
+// setStrategyEnabled is SetStrategyEnabled for a code known to have a strategy.
+func setStrategyEnabled(code string, enabled bool) {
+	disabledMu.Lock()
+	defer disabledMu.Unlock()
+	if enabled {
+		delete(disabledStrategies, code)
+	} else {
+		disabledStrategies[code] = struct{}{}
+	}
+}
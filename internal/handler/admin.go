@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/eabugauch/zenithpay-retry/internal/domain"
+	"github.com/eabugauch/zenithpay-retry/internal/retry"
+	"github.com/eabugauch/zenithpay-retry/internal/store"
+)
+
+// AdminHandler handles operational endpoints.
+type AdminHandler struct {
+	engine *retry.Engine
+	store  *store.Store
+}
+
+// NewAdminHandler creates a new admin handler.
+func NewAdminHandler(engine *retry.Engine, s *store.Store) *AdminHandler {
+	return &AdminHandler{engine: engine, store: s}
+}
+
+// SetReadOnly handles POST /api/admin/readonly - toggle maintenance mode.
+// While enabled, writes return 503 and the scheduler pauses; reads and
+// analytics keep serving.
+func (h *AdminHandler) SetReadOnly(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+
+	var req domain.ReadOnlyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if req.Enabled == nil {
+		writeError(w, http.StatusBadRequest, "enabled is required")
+		return
+	}
+
+	h.engine.SetReadOnly(*req.Enabled)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"readonly": h.engine.ReadOnly(),
+	})
+}
+
+// SuspendRetries handles POST /api/admin/suspend-retries - the retry kill
+// switch. While suspended the scheduler executes no attempts; unlike read-only
+// mode, submits keep being accepted and scheduled.
+func (h *AdminHandler) SuspendRetries(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+
+	var req domain.SuspendRetriesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if req.Suspended == nil {
+		writeError(w, http.StatusBadRequest, "suspended is required")
+		return
+	}
+
+	h.engine.SetRetriesSuspended(*req.Suspended)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"retries_suspended": h.engine.RetriesSuspended(),
+	})
+}
+
+// Purge handles POST /api/admin/purge - delete terminal transactions that
+// have not been updated within older_than. Pending transactions are never
+// purged.
+func (h *AdminHandler) Purge(w http.ResponseWriter, r *http.Request) {
+	if h.engine.ReadOnly() {
+		WriteReadOnly(w)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+
+	var req domain.PurgeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if req.OlderThan == "" {
+		writeError(w, http.StatusBadRequest, "older_than is required")
+		return
+	}
+	olderThan, err := time.ParseDuration(req.OlderThan)
+	if err != nil || olderThan <= 0 {
+		writeError(w, http.StatusBadRequest, "older_than must be a positive duration, e.g. \"720h\"")
+		return
+	}
+
+	var statuses []domain.TransactionStatus
+	if req.Status != "" {
+		switch req.Status {
+		case domain.StatusRecovered, domain.StatusFailedFinal, domain.StatusRejected, domain.StatusResolvedExternally:
+			statuses = append(statuses, req.Status)
+		default:
+			writeError(w, http.StatusBadRequest, "status must be a terminal status: recovered, failed_final, rejected, resolved_externally")
+			return
+		}
+	}
+
+	before := time.Now().UTC().Add(-olderThan)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"purged": h.store.PurgeTerminal(before, statuses...),
+		"before": before,
+	})
+}
+
+// pendingIndexEntry is one ID from the pending index with the status of the
+// transaction it points at. Status is empty if the transaction is missing.
+type pendingIndexEntry struct {
+	ID     string                   `json:"id"`
+	Status domain.TransactionStatus `json:"status,omitempty"`
+}
+
+// PendingIndex handles GET /api/admin/pending-index - dump the raw pending
+// index with each transaction's current status, plus the IDs where index
+// and status disagree. Entries are read one by one after the index snapshot,
+// so a transaction changing state meanwhile can show a non-pending status
+// without being reported as drift.
+func (h *AdminHandler) PendingIndex(w http.ResponseWriter, r *http.Request) {
+	ids := h.store.PendingIDsSnapshot()
+	entries := make([]pendingIndexEntry, 0, len(ids))
+	for _, id := range ids {
+		entry := pendingIndexEntry{ID: id}
+		if tx, err := h.store.Get(id); err == nil {
+			entry.Status = tx.Status
+		}
+		entries = append(entries, entry)
+	}
+
+	drift := h.store.VerifyIndex()
+	if drift == nil {
+		drift = []string{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"pending": entries,
+		"count":   len(entries),
+		"drift":   drift,
+	})
+}
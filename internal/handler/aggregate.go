@@ -0,0 +1,426 @@
+package handler
+
+import (
+	"sort"
+
+	"github.com/eabugauch/zenithpay-retry/internal/domain"
+)
+
+// Analytics accumulators. Each one consumes transactions one at a time via
+// add and produces its section with result, so a single pass over the store
+// can feed any combination of sections (see AnalyticsHandler.Report).
+
+// overviewAccumulator builds domain.AnalyticsOverview.
+type overviewAccumulator struct {
+	overview domain.AnalyticsOverview
+}
+
+func newOverviewAccumulator() *overviewAccumulator {
+	return &overviewAccumulator{}
+}
+
+func (a *overviewAccumulator) add(tx *domain.Transaction) {
+	a.overview.TotalTransactions++
+
+	switch tx.DeclineCategory {
+	case domain.HardDecline:
+		a.overview.HardDeclines++
+	case domain.SoftDecline:
+		a.overview.SoftDeclines++
+	}
+
+	switch tx.Status {
+	case domain.StatusRecovered:
+		a.overview.Recovered++
+	case domain.StatusFailedFinal:
+		a.overview.FailedFinal++
+	case domain.StatusScheduled, domain.StatusRetrying:
+		a.overview.PendingRetry++
+	case domain.StatusResolvedExternally:
+		a.overview.ResolvedExternally++
+	case domain.StatusPendingConfirmation:
+		a.overview.PendingConfirmation++
+	}
+
+	a.overview.TotalRetryAttempts += len(tx.RetryAttempts)
+	for _, attempt := range tx.RetryAttempts {
+		if attempt.Success {
+			a.overview.SuccessfulAttempts++
+		}
+		if attempt.TimedOut {
+			a.overview.TimedOutAttempts++
+		}
+	}
+}
+
+func (a *overviewAccumulator) result() domain.AnalyticsOverview {
+	overview := a.overview
+	if overview.SoftDeclines > 0 {
+		overview.RecoveryRate = float64(overview.Recovered) / float64(overview.SoftDeclines) * 100
+	}
+	if overview.TotalRetryAttempts > 0 {
+		overview.EfficiencyRate = float64(overview.SuccessfulAttempts) / float64(overview.TotalRetryAttempts) * 100
+	}
+	return overview
+}
+
+// declineAccumulator builds per-decline-code stats, split into soft and hard declines.
+type declineAccumulator struct {
+	stats map[string]*domain.DeclineReasonStats
+}
+
+func newDeclineAccumulator() *declineAccumulator {
+	return &declineAccumulator{stats: make(map[string]*domain.DeclineReasonStats)}
+}
+
+func (a *declineAccumulator) add(tx *domain.Transaction) {
+	stats, ok := a.stats[tx.DeclineCode]
+	if !ok {
+		stats = &domain.DeclineReasonStats{
+			DeclineCode: tx.DeclineCode,
+			Category:    string(tx.DeclineCategory),
+		}
+		a.stats[tx.DeclineCode] = stats
+	}
+
+	stats.Total++
+	switch tx.Status {
+	case domain.StatusRecovered:
+		stats.Recovered++
+		for _, attempt := range tx.RetryAttempts {
+			if attempt.Success {
+				stats.AvgAttempts += float64(attempt.AttemptNumber)
+				break
+			}
+		}
+	case domain.StatusFailedFinal:
+		stats.Failed++
+	case domain.StatusScheduled, domain.StatusRetrying:
+		stats.Pending++
+	case domain.StatusRejected:
+		stats.Failed++
+	}
+}
+
+// result returns soft declines sorted by recovery rate and hard declines sorted by volume.
+func (a *declineAccumulator) result() (soft, hard []domain.DeclineReasonStats) {
+	for _, s := range a.stats {
+		stats := *s
+		if stats.Recovered > 0 {
+			stats.AvgAttempts /= float64(stats.Recovered)
+		}
+		completed := stats.Recovered + stats.Failed
+		if completed > 0 && stats.Category == string(domain.SoftDecline) {
+			stats.RecoveryRate = float64(stats.Recovered) / float64(completed) * 100
+		}
+		if stats.Category == string(domain.SoftDecline) {
+			soft = append(soft, stats)
+		} else {
+			hard = append(hard, stats)
+		}
+	}
+
+	sort.Slice(soft, func(i, j int) bool {
+		return soft[i].RecoveryRate > soft[j].RecoveryRate
+	})
+	sort.Slice(hard, func(i, j int) bool {
+		return hard[i].Total > hard[j].Total
+	})
+	return soft, hard
+}
+
+// attemptAccumulator builds success rates by attempt number.
+type attemptAccumulator struct {
+	stats map[int]*domain.AttemptStats
+}
+
+func newAttemptAccumulator() *attemptAccumulator {
+	return &attemptAccumulator{stats: make(map[int]*domain.AttemptStats)}
+}
+
+func (a *attemptAccumulator) add(tx *domain.Transaction) {
+	for _, attempt := range tx.RetryAttempts {
+		stats, ok := a.stats[attempt.AttemptNumber]
+		if !ok {
+			stats = &domain.AttemptStats{AttemptNumber: attempt.AttemptNumber}
+			a.stats[attempt.AttemptNumber] = stats
+		}
+		stats.TotalAttempts++
+		if attempt.Success {
+			stats.Successes++
+		}
+		if attempt.TimedOut {
+			stats.Timeouts++
+		}
+	}
+}
+
+func (a *attemptAccumulator) result() []domain.AttemptStats {
+	result := make([]domain.AttemptStats, 0, len(a.stats))
+	for _, s := range a.stats {
+		stats := *s
+		if stats.TotalAttempts > 0 {
+			stats.SuccessRate = float64(stats.Successes) / float64(stats.TotalAttempts) * 100
+		}
+		result = append(result, stats)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].AttemptNumber < result[j].AttemptNumber
+	})
+	return result
+}
+
+// tagAccumulator builds recovery metrics per strategy tag. A transaction whose
+// decline code has several tags counts toward each of them; untagged codes are skipped.
+type tagAccumulator struct {
+	stats map[string]*domain.TagStats
+	codes map[string]map[string]struct{}
+}
+
+func newTagAccumulator() *tagAccumulator {
+	return &tagAccumulator{
+		stats: make(map[string]*domain.TagStats),
+		codes: make(map[string]map[string]struct{}),
+	}
+}
+
+func (a *tagAccumulator) add(tx *domain.Transaction) {
+	strategy := domain.GetRetryStrategy(tx.DeclineCode)
+	if strategy == nil {
+		return
+	}
+	for _, tag := range strategy.Tags {
+		stats, ok := a.stats[tag]
+		if !ok {
+			stats = &domain.TagStats{Tag: tag}
+			a.stats[tag] = stats
+			a.codes[tag] = make(map[string]struct{})
+		}
+		a.codes[tag][tx.DeclineCode] = struct{}{}
+
+		stats.Total++
+		switch tx.Status {
+		case domain.StatusRecovered:
+			stats.Recovered++
+		case domain.StatusFailedFinal, domain.StatusRejected:
+			stats.Failed++
+		case domain.StatusScheduled, domain.StatusRetrying:
+			stats.Pending++
+		}
+	}
+}
+
+// result returns tag stats sorted by tag, with recovery rate over completed transactions.
+func (a *tagAccumulator) result() []domain.TagStats {
+	result := make([]domain.TagStats, 0, len(a.stats))
+	for tag, s := range a.stats {
+		stats := *s
+		for code := range a.codes[tag] {
+			stats.DeclineCodes = append(stats.DeclineCodes, code)
+		}
+		sort.Strings(stats.DeclineCodes)
+		if completed := stats.Recovered + stats.Failed; completed > 0 {
+			stats.RecoveryRate = float64(stats.Recovered) / float64(completed) * 100
+		}
+		result = append(result, stats)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Tag < result[j].Tag
+	})
+	return result
+}
+
+// attemptCountAccumulator builds the distribution of total attempts per
+// transaction for recovered and failed_final outcomes. Other statuses are skipped.
+type attemptCountAccumulator struct {
+	counts map[int]*domain.AttemptCountStats
+}
+
+func newAttemptCountAccumulator() *attemptCountAccumulator {
+	return &attemptCountAccumulator{counts: make(map[int]*domain.AttemptCountStats)}
+}
+
+func (a *attemptCountAccumulator) add(tx *domain.Transaction) {
+	if tx.Status != domain.StatusRecovered && tx.Status != domain.StatusFailedFinal {
+		return
+	}
+	n := len(tx.RetryAttempts)
+	stats, ok := a.counts[n]
+	if !ok {
+		stats = &domain.AttemptCountStats{Attempts: n}
+		a.counts[n] = stats
+	}
+	if tx.Status == domain.StatusRecovered {
+		stats.Recovered++
+	} else {
+		stats.Failed++
+	}
+}
+
+// result returns one entry per observed attempt count, ascending.
+func (a *attemptCountAccumulator) result() []domain.AttemptCountStats {
+	result := make([]domain.AttemptCountStats, 0, len(a.counts))
+	for _, stats := range a.counts {
+		result = append(result, *stats)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Attempts < result[j].Attempts
+	})
+	return result
+}
+
+// routingAccumulator builds per-(decline code, processor) attempt stats.
+type routingAccumulator struct {
+	routing map[string]map[string]*domain.ProcessorStats
+}
+
+func newRoutingAccumulator() *routingAccumulator {
+	return &routingAccumulator{routing: make(map[string]map[string]*domain.ProcessorStats)}
+}
+
+func (a *routingAccumulator) add(tx *domain.Transaction) {
+	for _, attempt := range tx.RetryAttempts {
+		byProcessor, ok := a.routing[tx.DeclineCode]
+		if !ok {
+			byProcessor = make(map[string]*domain.ProcessorStats)
+			a.routing[tx.DeclineCode] = byProcessor
+		}
+		stats, ok := byProcessor[attempt.Processor]
+		if !ok {
+			stats = &domain.ProcessorStats{Processor: attempt.Processor}
+			byProcessor[attempt.Processor] = stats
+		}
+		stats.TotalAttempts++
+		if attempt.Success {
+			stats.Successes++
+		}
+	}
+}
+
+// result returns routing stats sorted by decline code, with each code's
+// processors ordered by success rate (then name).
+func (a *routingAccumulator) result() []domain.RoutingStats {
+	result := make([]domain.RoutingStats, 0, len(a.routing))
+	for code, byProcessor := range a.routing {
+		processors := make([]*domain.ProcessorStats, 0, len(byProcessor))
+		for _, stats := range byProcessor {
+			processors = append(processors, stats)
+		}
+		result = append(result, domain.RoutingStats{
+			DeclineCode: code,
+			Processors:  rankProcessors(processors),
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].DeclineCode < result[j].DeclineCode
+	})
+	return result
+}
+
+// processorTotals returns attempt stats per processor across all decline codes,
+// ordered by success rate (then name).
+func (a *routingAccumulator) processorTotals() []domain.ProcessorStats {
+	totals := make(map[string]*domain.ProcessorStats)
+	for _, byProcessor := range a.routing {
+		for name, s := range byProcessor {
+			stats, ok := totals[name]
+			if !ok {
+				stats = &domain.ProcessorStats{Processor: name}
+				totals[name] = stats
+			}
+			stats.TotalAttempts += s.TotalAttempts
+			stats.Successes += s.Successes
+		}
+	}
+
+	processors := make([]*domain.ProcessorStats, 0, len(totals))
+	for _, stats := range totals {
+		processors = append(processors, stats)
+	}
+	return rankProcessors(processors)
+}
+
+// rankProcessors fills in success rates and sorts by rate descending, then name.
+func rankProcessors(processors []*domain.ProcessorStats) []domain.ProcessorStats {
+	result := make([]domain.ProcessorStats, 0, len(processors))
+	for _, s := range processors {
+		stats := *s
+		stats.SuccessRate = float64(stats.Successes) / float64(stats.TotalAttempts) * 100
+		result = append(result, stats)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].SuccessRate != result[j].SuccessRate {
+			return result[i].SuccessRate > result[j].SuccessRate
+		}
+		return result[i].Processor < result[j].Processor
+	})
+	return result
+}
+
+// Customer ranking metrics for customerAccumulator.result.
+const (
+	customerSortRecoveredAmount = "recovered_amount"
+	customerSortDeclines        = "declines"
+)
+
+// customerAccumulator builds recovery metrics per customer. Transactions
+// without a customer ID are skipped.
+type customerAccumulator struct {
+	stats map[string]*domain.CustomerStats
+}
+
+func newCustomerAccumulator() *customerAccumulator {
+	return &customerAccumulator{stats: make(map[string]*domain.CustomerStats)}
+}
+
+func (a *customerAccumulator) add(tx *domain.Transaction) {
+	if tx.CustomerID == "" {
+		return
+	}
+	stats, ok := a.stats[tx.CustomerID]
+	if !ok {
+		stats = &domain.CustomerStats{CustomerID: tx.CustomerID}
+		a.stats[tx.CustomerID] = stats
+	}
+
+	stats.Declines++
+	recovered := tx.RecoveredCents
+	switch tx.Status {
+	case domain.StatusRecovered:
+		stats.Recovered++
+		recovered = tx.AmountCents
+	case domain.StatusFailedFinal, domain.StatusRejected:
+		stats.Failed++
+	}
+	stats.RecoveredCents += domain.NormalizeAmountCents(recovered, tx.Currency)
+}
+
+// result returns the top limit customers ranked by sortBy, descending, with
+// ties broken by customer ID. Recovery rate is over completed transactions.
+func (a *customerAccumulator) result(sortBy string, limit int) []domain.CustomerStats {
+	result := make([]domain.CustomerStats, 0, len(a.stats))
+	for _, s := range a.stats {
+		stats := *s
+		if completed := stats.Recovered + stats.Failed; completed > 0 {
+			stats.RecoveryRate = float64(stats.Recovered) / float64(completed) * 100
+		}
+		result = append(result, stats)
+	}
+
+	metric := func(s domain.CustomerStats) int64 { return s.RecoveredCents }
+	if sortBy == customerSortDeclines {
+		metric = func(s domain.CustomerStats) int64 { return int64(s.Declines) }
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if mi, mj := metric(result[i]), metric(result[j]); mi != mj {
+			return mi > mj
+		}
+		return result[i].CustomerID < result[j].CustomerID
+	})
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
@@ -1,16 +1,26 @@
 package handler
 
 import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
 	"net/http"
 	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/eabugauch/zenithpay-retry/internal/domain"
+	"github.com/eabugauch/zenithpay-retry/internal/retry"
 	"github.com/eabugauch/zenithpay-retry/internal/store"
 )
 
 // AnalyticsHandler handles HTTP requests for analytics and reporting.
 type AnalyticsHandler struct {
 	store *store.Store
+	cache *analyticsCache // nil unless EnableCache is called
 }
 
 // NewAnalyticsHandler creates a new analytics handler.
@@ -18,145 +28,374 @@ func NewAnalyticsHandler(s *store.Store) *AnalyticsHandler {
 	return &AnalyticsHandler{store: s}
 }
 
+// transactions returns the aggregation set for an analytics request: every
+// transaction, or only those of the merchant named by ?merchant_id=.
+func (h *AnalyticsHandler) transactions(r *http.Request) []*domain.Transaction {
+	if merchantID := r.URL.Query().Get("merchant_id"); merchantID != "" {
+		return h.store.GetByMerchant(merchantID)
+	}
+	return h.store.GetAll()
+}
+
 // Overview handles GET /api/analytics/overview - overall recovery metrics.
+// Clients sending Accept: text/plain get an aligned text table instead of JSON.
+// as_of is when the metrics were computed (see EnableCache).
 func (h *AnalyticsHandler) Overview(w http.ResponseWriter, r *http.Request) {
-	all := h.store.GetAll()
+	snap := h.snapshot(r, partOverview)
+	if wantsPlainText(r) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		writeOverviewTable(w, snap.overview)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		domain.AnalyticsOverview
+		AsOf time.Time `json:"as_of"`
+	}{snap.overview, snap.asOf})
+}
+
+// wantsPlainText reports whether the client asked for text/plain rather than
+// JSON. JSON stays the default for missing, wildcard, or mixed Accept headers.
+func wantsPlainText(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "text/plain") && !strings.Contains(accept, "application/json")
+}
 
-	var overview domain.AnalyticsOverview
-	overview.TotalTransactions = len(all)
+// writeOverviewTable renders overview metrics as a two-column aligned table.
+func writeOverviewTable(w http.ResponseWriter, o domain.AnalyticsOverview) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "METRIC\tVALUE")
+	fmt.Fprintf(tw, "total_transactions\t%d\n", o.TotalTransactions)
+	fmt.Fprintf(tw, "hard_declines\t%d\n", o.HardDeclines)
+	fmt.Fprintf(tw, "soft_declines\t%d\n", o.SoftDeclines)
+	fmt.Fprintf(tw, "recovered\t%d\n", o.Recovered)
+	fmt.Fprintf(tw, "failed_final\t%d\n", o.FailedFinal)
+	fmt.Fprintf(tw, "pending_retry\t%d\n", o.PendingRetry)
+	fmt.Fprintf(tw, "resolved_externally\t%d\n", o.ResolvedExternally)
+	fmt.Fprintf(tw, "recovery_rate_pct\t%.2f\n", o.RecoveryRate)
+	fmt.Fprintf(tw, "total_retry_attempts\t%d\n", o.TotalRetryAttempts)
+	fmt.Fprintf(tw, "successful_attempts\t%d\n", o.SuccessfulAttempts)
+	fmt.Fprintf(tw, "timed_out_attempts\t%d\n", o.TimedOutAttempts)
+	fmt.Fprintf(tw, "efficiency_rate_pct\t%.2f\n", o.EfficiencyRate)
+	if err := tw.Flush(); err != nil {
+		slog.Default().Warn("failed to write response", "error", err)
+	}
+}
 
+// Badge handles GET /api/analytics/badge - recovery rate in shields.io endpoint schema.
+func (h *AnalyticsHandler) Badge(w http.ResponseWriter, r *http.Request) {
+	overview := computeOverview(h.transactions(r))
+
+	color := "green"
+	switch {
+	case overview.RecoveryRate < 20:
+		color = "red"
+	case overview.RecoveryRate < 50:
+		color = "yellow"
+	}
+
+	writeJSON(w, http.StatusOK, domain.Badge{
+		SchemaVersion: 1,
+		Label:         "recovery",
+		Message:       fmt.Sprintf("%.0f%%", overview.RecoveryRate),
+		Color:         color,
+	})
+}
+
+// computeOverview aggregates high-level recovery metrics over a set of transactions.
+func computeOverview(all []*domain.Transaction) domain.AnalyticsOverview {
+	acc := newOverviewAccumulator()
 	for _, tx := range all {
-		switch tx.DeclineCategory {
-		case domain.HardDecline:
-			overview.HardDeclines++
-		case domain.SoftDecline:
-			overview.SoftDeclines++
+		acc.add(tx)
+	}
+	return acc.result()
+}
+
+// ByDeclineReason handles GET /api/analytics/by-decline - recovery rate by decline code.
+func (h *AnalyticsHandler) ByDeclineReason(w http.ResponseWriter, r *http.Request) {
+	snap := h.snapshot(r, partDecline)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"soft_declines": snap.soft,
+		"hard_declines": snap.hard,
+		"as_of":         snap.asOf,
+	})
+}
+
+// ByAttemptNumber handles GET /api/analytics/by-attempt - success rate by attempt number.
+func (h *AnalyticsHandler) ByAttemptNumber(w http.ResponseWriter, r *http.Request) {
+	snap := h.snapshot(r, partAttempt)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"by_attempt": snap.byAttempt,
+		"as_of":      snap.asOf,
+	})
+}
+
+// SLA handles GET /api/analytics/sla - actual vs target recovery rate per decline code.
+// Codes without a target are omitted; codes with nothing completed yet are
+// flagged no_data rather than reported as missing their target.
+func (h *AnalyticsHandler) SLA(w http.ResponseWriter, r *http.Request) {
+	acc := newDeclineAccumulator()
+	for _, tx := range h.transactions(r) {
+		acc.add(tx)
+	}
+	soft, _ := acc.result()
+
+	result := make([]domain.SLAStats, 0, len(soft))
+	for _, stats := range soft {
+		strategy := domain.GetRetryStrategy(stats.DeclineCode)
+		if strategy == nil || strategy.TargetRecoveryRate <= 0 {
+			continue
 		}
+		target := strategy.TargetRecoveryRate * 100
+		completed := stats.Recovered + stats.Failed
+		result = append(result, domain.SLAStats{
+			DeclineCode:        stats.DeclineCode,
+			Completed:          completed,
+			ActualRecoveryRate: stats.RecoveryRate,
+			TargetRecoveryRate: target,
+			MeetsTarget:        completed > 0 && stats.RecoveryRate >= target,
+			NoData:             completed == 0,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].DeclineCode < result[j].DeclineCode
+	})
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"by_decline": result,
+	})
+}
 
-		switch tx.Status {
-		case domain.StatusRecovered:
-			overview.Recovered++
-		case domain.StatusFailedFinal:
-			overview.FailedFinal++
-		case domain.StatusScheduled, domain.StatusRetrying:
-			overview.PendingRetry++
+// defaultShadowSeed seeds shadow simulations when the request gives none, so
+// repeated evaluations of the same candidate are comparable.
+const defaultShadowSeed = 1
+
+// Shadow handles POST /api/analytics/shadow - project what a candidate strategy
+// would have recovered on the stored transactions of one decline code. Uses a
+// fresh simulator and never modifies live state or configuration.
+func (h *AnalyticsHandler) Shadow(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+
+	var req domain.ShadowRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if req.DeclineCode == "" {
+		writeError(w, http.StatusBadRequest, "decline_code is required")
+		return
+	}
+
+	strategy, err := domain.ShadowStrategy(req.DeclineCode, req.Strategy)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var txs []*domain.Transaction
+	for _, tx := range h.transactions(r) {
+		if tx.DeclineCode == req.DeclineCode {
+			txs = append(txs, tx)
 		}
+	}
 
-		overview.TotalRetryAttempts += len(tx.RetryAttempts)
-		for _, a := range tx.RetryAttempts {
-			if a.Success {
-				overview.SuccessfulAttempts++
-			}
+	seed := req.Seed
+	if seed == 0 {
+		seed = defaultShadowSeed
+	}
+	writeJSON(w, http.StatusOK, retry.ProjectRecovery(strategy, txs, retry.NewSimulator(seed, 0, retry.SeedShared)))
+}
+
+// ByTag handles GET /api/analytics/by-tag - recovery metrics rolled up by strategy tag.
+func (h *AnalyticsHandler) ByTag(w http.ResponseWriter, r *http.Request) {
+	acc := newTagAccumulator()
+	for _, tx := range h.transactions(r) {
+		acc.add(tx)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"by_tag": acc.result(),
+	})
+}
+
+// AttemptDistribution handles GET /api/analytics/attempt-distribution - how many
+// recovered and failed transactions used 0, 1, 2, ... retry attempts in total.
+func (h *AnalyticsHandler) AttemptDistribution(w http.ResponseWriter, r *http.Request) {
+	acc := newAttemptCountAccumulator()
+	for _, tx := range h.transactions(r) {
+		acc.add(tx)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"distribution": acc.result(),
+	})
+}
+
+// defaultCustomerLimit and maxCustomerLimit bound ?limit= on ByCustomer.
+const (
+	defaultCustomerLimit = 20
+	maxCustomerLimit     = 500
+)
+
+// ByCustomer handles GET /api/analytics/by-customer - the top customers by
+// recovered amount (?sort=recovered_amount, the default) or by decline volume
+// (?sort=declines), computed in one pass over the transactions.
+func (h *AnalyticsHandler) ByCustomer(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := defaultCustomerLimit
+	if raw := query.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxCustomerLimit {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be an integer between 1 and %d", maxCustomerLimit))
+			return
 		}
+		limit = n
+	}
+	sortBy := query.Get("sort")
+	switch sortBy {
+	case "":
+		sortBy = customerSortRecoveredAmount
+	case customerSortRecoveredAmount, customerSortDeclines:
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("sort must be %q or %q", customerSortRecoveredAmount, customerSortDeclines))
+		return
 	}
 
-	if overview.SoftDeclines > 0 {
-		overview.RecoveryRate = float64(overview.Recovered) / float64(overview.SoftDeclines) * 100
+	acc := newCustomerAccumulator()
+	for _, tx := range h.transactions(r) {
+		acc.add(tx)
 	}
-	if overview.TotalRetryAttempts > 0 {
-		overview.EfficiencyRate = float64(overview.SuccessfulAttempts) / float64(overview.TotalRetryAttempts) * 100
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"normalized_currency": "USD",
+		"sort":                sortBy,
+		"by_customer":         acc.result(sortBy, limit),
+	})
+}
+
+// Routing handles GET /api/analytics/routing - success rate per (decline code, processor) pair.
+func (h *AnalyticsHandler) Routing(w http.ResponseWriter, r *http.Request) {
+	acc := newRoutingAccumulator()
+	for _, tx := range h.transactions(r) {
+		acc.add(tx)
 	}
 
-	writeJSON(w, http.StatusOK, overview)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"by_decline": acc.result(),
+	})
 }
 
-// ByDeclineReason handles GET /api/analytics/by-decline - recovery rate by decline code.
-func (h *AnalyticsHandler) ByDeclineReason(w http.ResponseWriter, r *http.Request) {
-	all := h.store.GetAll()
+// Report handles GET /api/analytics/report - a downloadable JSON document combining
+// the overview, by-decline, by-attempt and by-processor sections, all computed
+// in a single pass over the transactions.
+func (h *AnalyticsHandler) Report(w http.ResponseWriter, r *http.Request) {
+	overview := newOverviewAccumulator()
+	declines := newDeclineAccumulator()
+	attempts := newAttemptAccumulator()
+	routing := newRoutingAccumulator()
+	for _, tx := range h.transactions(r) {
+		overview.add(tx)
+		declines.add(tx)
+		attempts.add(tx)
+		routing.add(tx)
+	}
+
+	report := domain.AnalyticsReport{
+		GeneratedAt: time.Now().UTC(),
+		MerchantID:  r.URL.Query().Get("merchant_id"),
+		Overview:    overview.result(),
+		ByAttempt:   attempts.result(),
+		ByProcessor: routing.processorTotals(),
+	}
+	report.ByDecline.SoftDeclines, report.ByDecline.HardDeclines = declines.result()
+
+	filename := "recovery-report-" + report.GeneratedAt.Format("20060102T150405Z") + ".json"
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	writeJSON(w, http.StatusOK, report)
+}
+
+// ByAmount handles GET /api/analytics/by-amount - recovery rate by transaction size.
+// Amounts are normalized to USD-equivalent cents before bucketing.
+func (h *AnalyticsHandler) ByAmount(w http.ResponseWriter, r *http.Request) {
+	all := h.transactions(r)
+
+	buckets := domain.GetAmountBuckets()
+	result := make([]domain.AmountBucketStats, len(buckets))
+	index := make(map[string]int, len(buckets))
+	var lower int64
+	for i, b := range buckets {
+		result[i] = domain.AmountBucketStats{Bucket: b.Name, MinCents: lower, MaxCents: b.MaxCents}
+		index[b.Name] = i
+		lower = b.MaxCents
+	}
 
-	statsMap := make(map[string]*domain.DeclineReasonStats)
 	for _, tx := range all {
-		stats, ok := statsMap[tx.DeclineCode]
+		// Bucket against the same snapshot the rows were built from, so a
+		// concurrent SetAmountBuckets cannot put a transaction in the wrong row.
+		i, ok := index[domain.BucketFor(buckets, tx.AmountCents, tx.Currency)]
 		if !ok {
-			stats = &domain.DeclineReasonStats{
-				DeclineCode: tx.DeclineCode,
-				Category:    string(tx.DeclineCategory),
-			}
-			statsMap[tx.DeclineCode] = stats
+			continue
 		}
-
+		stats := &result[i]
 		stats.Total++
-		switch tx.Status {
-		case domain.StatusRecovered:
+		if tx.DeclineCategory == domain.SoftDecline {
+			stats.SoftDeclines++
+		}
+		if tx.Status == domain.StatusRecovered {
 			stats.Recovered++
-			for _, a := range tx.RetryAttempts {
-				if a.Success {
-					stats.AvgAttempts += float64(a.AttemptNumber)
-					break
-				}
-			}
-		case domain.StatusFailedFinal:
-			stats.Failed++
-		case domain.StatusScheduled, domain.StatusRetrying:
-			stats.Pending++
-		case domain.StatusRejected:
-			stats.Failed++
 		}
 	}
 
-	var softResults, hardResults []domain.DeclineReasonStats
-	for _, stats := range statsMap {
-		if stats.Recovered > 0 {
-			stats.AvgAttempts /= float64(stats.Recovered)
-		}
-		completed := stats.Recovered + stats.Failed
-		if completed > 0 && stats.Category == string(domain.SoftDecline) {
-			stats.RecoveryRate = float64(stats.Recovered) / float64(completed) * 100
-		}
-		if stats.Category == string(domain.SoftDecline) {
-			softResults = append(softResults, *stats)
-		} else {
-			hardResults = append(hardResults, *stats)
+	for i := range result {
+		if result[i].SoftDeclines > 0 {
+			result[i].RecoveryRate = float64(result[i].Recovered) / float64(result[i].SoftDeclines) * 100
 		}
 	}
 
-	sort.Slice(softResults, func(i, j int) bool {
-		return softResults[i].RecoveryRate > softResults[j].RecoveryRate
-	})
-	sort.Slice(hardResults, func(i, j int) bool {
-		return hardResults[i].Total > hardResults[j].Total
-	})
-
 	writeJSON(w, http.StatusOK, map[string]any{
-		"soft_declines": softResults,
-		"hard_declines": hardResults,
+		"normalized_currency": "USD",
+		"by_amount":           result,
 	})
 }
 
-// ByAttemptNumber handles GET /api/analytics/by-attempt - success rate by attempt number.
-func (h *AnalyticsHandler) ByAttemptNumber(w http.ResponseWriter, r *http.Request) {
-	all := h.store.GetAll()
+// SchedulerDrift handles GET /api/analytics/scheduler-drift - lateness of executed
+// attempts (ExecutedAt - ScheduledAt), for tuning the scheduler interval.
+func (h *AnalyticsHandler) SchedulerDrift(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, computeSchedulerDrift(h.transactions(r)))
+}
 
-	attemptMap := make(map[int]*domain.AttemptStats)
+// computeSchedulerDrift aggregates attempt drift over a set of transactions.
+// P95 uses the nearest-rank method.
+func computeSchedulerDrift(all []*domain.Transaction) domain.SchedulerDriftStats {
+	var stats domain.SchedulerDriftStats
+	var drifts []time.Duration
 	for _, tx := range all {
 		for _, a := range tx.RetryAttempts {
-			stats, ok := attemptMap[a.AttemptNumber]
-			if !ok {
-				stats = &domain.AttemptStats{
-					AttemptNumber: a.AttemptNumber,
-				}
-				attemptMap[a.AttemptNumber] = stats
+			if a.ScheduledAt.IsZero() || a.ExecutedAt.IsZero() {
+				continue
 			}
-			stats.TotalAttempts++
-			if a.Success {
-				stats.Successes++
+			drift := a.ExecutedAt.Sub(a.ScheduledAt)
+			if drift < 0 {
+				stats.EarlyAttempts++
+				continue
 			}
+			drifts = append(drifts, drift)
 		}
 	}
 
-	result := make([]domain.AttemptStats, 0, len(attemptMap))
-	for _, stats := range attemptMap {
-		if stats.TotalAttempts > 0 {
-			stats.SuccessRate = float64(stats.Successes) / float64(stats.TotalAttempts) * 100
-		}
-		result = append(result, *stats)
+	stats.Attempts = len(drifts)
+	if len(drifts) == 0 {
+		return stats
 	}
 
-	sort.Slice(result, func(i, j int) bool {
-		return result[i].AttemptNumber < result[j].AttemptNumber
-	})
-
-	writeJSON(w, http.StatusOK, map[string]any{
-		"by_attempt": result,
-	})
+	sort.Slice(drifts, func(i, j int) bool { return drifts[i] < drifts[j] })
+	var total time.Duration
+	for _, d := range drifts {
+		total += d
+	}
+	rank := int(math.Ceil(0.95*float64(len(drifts)))) - 1
+	stats.AvgSeconds = (total / time.Duration(len(drifts))).Seconds()
+	stats.P95Seconds = drifts[rank].Seconds()
+	stats.MaxSeconds = drifts[len(drifts)-1].Seconds()
+	return stats
 }
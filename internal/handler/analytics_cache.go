@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/eabugauch/zenithpay-retry/internal/domain"
+)
+
+// analyticsSnapshot is one computation of the store-wide overview,
+// by-decline and by-attempt analytics.
+type analyticsSnapshot struct {
+	asOf      time.Time
+	overview  domain.AnalyticsOverview
+	soft      []domain.DeclineReasonStats
+	hard      []domain.DeclineReasonStats
+	byAttempt []domain.AttemptStats
+}
+
+// snapshotPart selects which of the analytics computeSnapshot aggregates.
+type snapshotPart uint8
+
+const (
+	partOverview snapshotPart = 1 << iota
+	partDecline
+	partAttempt
+
+	allParts = partOverview | partDecline | partAttempt
+)
+
+// computeSnapshot aggregates the requested analytics in a single pass. Parts
+// not requested are left at their zero value.
+func computeSnapshot(all []*domain.Transaction, now time.Time, parts snapshotPart) *analyticsSnapshot {
+	overview := newOverviewAccumulator()
+	decline := newDeclineAccumulator()
+	attempt := newAttemptAccumulator()
+	for _, tx := range all {
+		if parts&partOverview != 0 {
+			overview.add(tx)
+		}
+		if parts&partDecline != 0 {
+			decline.add(tx)
+		}
+		if parts&partAttempt != 0 {
+			attempt.add(tx)
+		}
+	}
+
+	snap := &analyticsSnapshot{asOf: now}
+	if parts&partOverview != 0 {
+		snap.overview = overview.result()
+	}
+	if parts&partDecline != 0 {
+		snap.soft, snap.hard = decline.result()
+	}
+	if parts&partAttempt != 0 {
+		snap.byAttempt = attempt.result()
+	}
+	return snap
+}
+
+// analyticsCache holds the last snapshot. It is filled on first use and
+// replaced only by a refresh, so cached results stay stable between refreshes.
+type analyticsCache struct {
+	mu   sync.Mutex
+	snap *analyticsSnapshot
+}
+
+// EnableCache makes the overview, by-decline and by-attempt endpoints serve a
+// cached store-wide snapshot, recomputed only by Refresh (or RunCacheRefresh).
+// Requests with ?merchant_id= or ?fresh=true are always computed directly.
+// Must be called before serving requests.
+func (h *AnalyticsHandler) EnableCache() {
+	h.cache = &analyticsCache{}
+}
+
+// refresh recomputes and stores the snapshot.
+func (h *AnalyticsHandler) refresh() *analyticsSnapshot {
+	snap := computeSnapshot(h.store.GetAll(), time.Now().UTC(), allParts)
+	h.cache.mu.Lock()
+	h.cache.snap = snap
+	h.cache.mu.Unlock()
+	return snap
+}
+
+// RunCacheRefresh recomputes the cache every interval until ctx is cancelled.
+func (h *AnalyticsHandler) RunCacheRefresh(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.refresh()
+		}
+	}
+}
+
+// snapshot returns the analytics for r: the cached snapshot when caching is
+// enabled and r asks for store-wide results, otherwise a fresh computation of
+// just the part the endpoint needs.
+func (h *AnalyticsHandler) snapshot(r *http.Request, part snapshotPart) *analyticsSnapshot {
+	query := r.URL.Query()
+	if h.cache == nil || query.Get("merchant_id") != "" || query.Get("fresh") == "true" {
+		return computeSnapshot(h.transactions(r), time.Now().UTC(), part)
+	}
+
+	h.cache.mu.Lock()
+	snap := h.cache.snap
+	h.cache.mu.Unlock()
+	if snap == nil {
+		snap = h.refresh()
+	}
+	return snap
+}
+
+// Refresh handles POST /api/analytics/refresh - recompute the cached
+// analytics now. Returns 409 when caching is not enabled.
+func (h *AnalyticsHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	if h.cache == nil {
+		writeError(w, http.StatusConflict, "analytics cache is not enabled")
+		return
+	}
+	snap := h.refresh()
+	writeJSON(w, http.StatusOK, map[string]any{"as_of": snap.asOf})
+}
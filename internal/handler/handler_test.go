@@ -3,11 +3,19 @@ package handler
 import (
 	"bytes"
 	"encoding/json"
+	"encoding/xml"
+	"fmt"
 	"io"
 	"log/slog"
+	"maps"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/eabugauch/zenithpay-retry/internal/domain"
 	"github.com/eabugauch/zenithpay-retry/internal/retry"
@@ -15,27 +23,66 @@ import (
 	"github.com/eabugauch/zenithpay-retry/internal/webhook"
 )
 
-func setupTestServer() (*http.ServeMux, *store.Store) {
+func setupTestServer() (*Router, *store.Store) {
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
 	s := store.New()
 	notifier := webhook.NewNotifier(logger)
-	sim := retry.NewSimulator(42)
+	sim := retry.NewSimulator(42, 0, retry.SeedShared)
 	engine := retry.NewEngine(s, sim, notifier, logger)
 
 	txHandler := NewTransactionHandler(engine, s, notifier, logger)
+	txHandler.SetAllowInject(true)
 	analyticsHandler := NewAnalyticsHandler(s)
 
-	mux := http.NewServeMux()
+	mux := NewRouter()
 	mux.HandleFunc("POST /api/transactions", txHandler.Submit)
+	mux.HandleFunc("POST /api/transactions/bulk", txHandler.BulkSubmit)
+	mux.HandleFunc("GET /api/transactions/upcoming", txHandler.Upcoming)
+	mux.HandleFunc("GET /api/transactions/changed", txHandler.Changed)
+	mux.HandleFunc("GET /api/transactions/count", txHandler.Count)
 	mux.HandleFunc("GET /api/transactions/{id}", txHandler.Get)
 	mux.HandleFunc("GET /api/transactions", txHandler.List)
 	mux.HandleFunc("POST /api/transactions/{id}/retry", txHandler.Retry)
+	mux.HandleFunc("DELETE /api/idempotency-keys/{key}", txHandler.DeleteIdempotencyKey)
+	mux.HandleFunc("DELETE /api/idempotency-keys", txHandler.ClearIdempotencyKeys)
+	mux.HandleFunc("POST /api/transactions/{id}/ack", txHandler.Ack)
+	mux.HandleFunc("POST /api/transactions/{id}/confirm", txHandler.Confirm)
+	mux.HandleFunc("POST /api/transactions/{id}/inject-attempt", txHandler.InjectAttempt)
+	mux.HandleFunc("GET /api/transactions/{id}/timeline", txHandler.Timeline)
+	mux.HandleFunc("GET /api/transactions/{id}/report.html", txHandler.Report)
 	mux.HandleFunc("POST /api/retry/process-all", txHandler.ProcessAll)
+	mux.HandleFunc("POST /api/retry/resync", txHandler.Resync)
 	mux.HandleFunc("GET /api/analytics/overview", analyticsHandler.Overview)
 	mux.HandleFunc("GET /api/analytics/by-decline", analyticsHandler.ByDeclineReason)
 	mux.HandleFunc("GET /api/analytics/by-attempt", analyticsHandler.ByAttemptNumber)
+	mux.HandleFunc("POST /api/analytics/refresh", analyticsHandler.Refresh)
+	mux.HandleFunc("GET /api/analytics/attempt-distribution", analyticsHandler.AttemptDistribution)
+	mux.HandleFunc("GET /api/analytics/by-amount", analyticsHandler.ByAmount)
+	mux.HandleFunc("GET /api/analytics/by-tag", analyticsHandler.ByTag)
+	mux.HandleFunc("GET /api/analytics/by-customer", analyticsHandler.ByCustomer)
+	mux.HandleFunc("GET /api/analytics/sla", analyticsHandler.SLA)
+	mux.HandleFunc("POST /api/analytics/shadow", analyticsHandler.Shadow)
+	mux.HandleFunc("GET /api/analytics/routing", analyticsHandler.Routing)
+	mux.HandleFunc("GET /api/analytics/scheduler-drift", analyticsHandler.SchedulerDrift)
+	mux.HandleFunc("GET /api/analytics/report", analyticsHandler.Report)
+	mux.HandleFunc("GET /api/analytics/badge", analyticsHandler.Badge)
 	mux.HandleFunc("GET /api/decline-codes", txHandler.GetDeclineCodes)
+	mux.HandleFunc("GET /api/processors", txHandler.GetProcessors)
+	mux.HandleFunc("PATCH /api/config/strategies/{code}", txHandler.PatchStrategy)
+	mux.HandleFunc("POST /api/config/strategies/{code}/disable", txHandler.DisableStrategy)
+	mux.HandleFunc("POST /api/config/strategies/{code}/enable", txHandler.EnableStrategy)
 	mux.HandleFunc("GET /api/webhooks/events", txHandler.GetWebhookEvents)
+	mux.HandleFunc("GET /api/webhooks/events/export", txHandler.ExportWebhookEvents)
+	mux.HandleFunc("POST /api/webhooks/replay", txHandler.ReplayWebhooks)
+	adminHandler := NewAdminHandler(engine, s)
+	mux.HandleFunc("POST /api/admin/readonly", adminHandler.SetReadOnly)
+	mux.HandleFunc("POST /api/admin/suspend-retries", adminHandler.SuspendRetries)
+	mux.HandleFunc("POST /api/admin/purge", adminHandler.Purge)
+	mux.HandleFunc("GET /api/admin/pending-index", adminHandler.PendingIndex)
+	simulatorHandler := NewSimulatorHandler(sim)
+	mux.HandleFunc("GET /api/simulator/latency", simulatorHandler.Latency)
+	mux.HandleFunc("POST /api/simulator/latency", simulatorHandler.SetLatency)
+	mux.HandleFunc("DELETE /api/simulator/latency", simulatorHandler.ClearLatency)
 
 	return mux, s
 }
@@ -120,6 +167,11 @@ func TestSubmitHandler_MissingFields(t *testing.T) {
 		{"zero amount", domain.SubmitRequest{TransactionID: "txn_1", AmountCents: 0, Currency: "USD", DeclineCode: "stolen_card"}},
 		{"negative amount", domain.SubmitRequest{TransactionID: "txn_1", AmountCents: -100, Currency: "USD", DeclineCode: "stolen_card"}},
 		{"missing currency", domain.SubmitRequest{TransactionID: "txn_1", AmountCents: 10000, DeclineCode: "stolen_card"}},
+		{"malformed webhook_url", domain.SubmitRequest{TransactionID: "txn_1", AmountCents: 10000, Currency: "USD", DeclineCode: "stolen_card", WebhookURL: "not-a-url"}},
+		{"unknown webhook route event", domain.SubmitRequest{TransactionID: "txn_1", AmountCents: 10000, Currency: "USD", DeclineCode: "stolen_card",
+			WebhookRoutes: map[string]string{"retry.unknown": "https://example.com/hook"}}},
+		{"malformed webhook route URL", domain.SubmitRequest{TransactionID: "txn_1", AmountCents: 10000, Currency: "USD", DeclineCode: "stolen_card",
+			WebhookRoutes: map[string]string{domain.EventRetrySucceeded: "ftp://example.com/hook"}}},
 	}
 
 	for _, tt := range tests {
@@ -132,6 +184,109 @@ func TestSubmitHandler_MissingFields(t *testing.T) {
 	}
 }
 
+func TestSubmitHandler_ReportsAllValidationErrors(t *testing.T) {
+	mux, _ := setupTestServer()
+
+	w := postJSON(mux, "/api/transactions", domain.SubmitRequest{
+		AmountCents: -5, WebhookURL: "not-a-url", DeclineCode: "insufficient_funds",
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Error  string             `json:"error"`
+		Errors []retry.FieldError `json:"errors"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	var fields []string
+	for _, fe := range resp.Errors {
+		fields = append(fields, fe.Field)
+	}
+	want := []string{"transaction_id", "amount_cents", "currency", "webhook_url"}
+	if strings.Join(fields, ",") != strings.Join(want, ",") {
+		t.Errorf("expected fields %v, got %v", want, fields)
+	}
+	if !strings.Contains(resp.Error, "currency is required") {
+		t.Errorf("expected combined message to include every problem, got %q", resp.Error)
+	}
+}
+
+func TestSubmitHandler_LocalizedMessage(t *testing.T) {
+	mux, _ := setupTestServer()
+
+	submit := func(id, query, acceptLanguage string) (domain.SubmitResponse, string) {
+		data, _ := json.Marshal(domain.SubmitRequest{
+			TransactionID: id, AmountCents: 1000, Currency: "BRL", CustomerID: "c1",
+			OriginalProcessor: "dlocal_br", DeclineCode: "stolen_card",
+		})
+		req := httptest.NewRequest(http.MethodPost, "/api/transactions"+query, bytes.NewReader(data))
+		if acceptLanguage != "" {
+			req.Header.Set("Accept-Language", acceptLanguage)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp domain.SubmitResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp, w.Header().Get("Content-Language")
+	}
+
+	resp, lang := submit("txn_lang_pt", "", "pt-BR,pt;q=0.9,en;q=0.8")
+	want := "Recusa definitiva: O cartão foi reportado como roubado. A transação não será retentada."
+	if resp.Message != want || lang != "pt" {
+		t.Errorf("expected Portuguese message %q (pt), got %q (%s)", want, resp.Message, lang)
+	}
+
+	resp, _ = submit("txn_lang_es", "?lang=es", "pt")
+	if !strings.HasPrefix(resp.Message, "Rechazo definitivo: La tarjeta fue reportada como robada") {
+		t.Errorf("expected lang query to win with a Spanish message, got %q", resp.Message)
+	}
+
+	resp, lang = submit("txn_lang_default", "", "")
+	if resp.Message != "Hard decline: Card has been reported as stolen. Transaction will not be retried." || lang != "en" {
+		t.Errorf("expected English message by default, got %q (%s)", resp.Message, lang)
+	}
+}
+
+func TestSubmitHandler_Timestamp(t *testing.T) {
+	mux, s := setupTestServer()
+	now := time.Now().UTC()
+
+	tests := []struct {
+		name      string
+		timestamp time.Time
+		want      int
+	}{
+		{"near future within skew", now.Add(2 * time.Minute), http.StatusCreated},
+		{"far future", now.Add(time.Hour), http.StatusBadRequest},
+		{"past backfill", now.Add(-72 * time.Hour), http.StatusCreated},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := fmt.Sprintf("txn_ts_%d", i)
+			w := postJSON(mux, "/api/transactions", domain.SubmitRequest{
+				TransactionID: id, AmountCents: 1000, Currency: "USD", CustomerID: "c1",
+				OriginalProcessor: "stripe_latam", DeclineCode: "insufficient_funds",
+				Timestamp: tt.timestamp.Format(time.RFC3339),
+			})
+			if w.Code != tt.want {
+				t.Fatalf("expected %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+			if tt.want != http.StatusCreated {
+				return
+			}
+			tx, _ := s.Get(id)
+			if !tx.CreatedAt.Equal(tt.timestamp.Truncate(time.Second)) {
+				t.Errorf("expected created_at %v, got %v", tt.timestamp.Truncate(time.Second), tx.CreatedAt)
+			}
+		})
+	}
+}
+
 func TestSubmitHandler_Duplicate(t *testing.T) {
 	mux, _ := setupTestServer()
 
@@ -151,6 +306,230 @@ func TestSubmitHandler_Duplicate(t *testing.T) {
 	}
 }
 
+func TestSubmitHandler_ValidateWebhook(t *testing.T) {
+	mux, _ := setupTestServer()
+
+	reachable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed) // any response counts as reachable
+	}))
+	defer reachable.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	unreachableURL := closed.URL
+	closed.Close()
+
+	submit := func(id, webhookURL, query string) domain.SubmitResponse {
+		t.Helper()
+		w := postJSON(mux, "/api/transactions"+query, domain.SubmitRequest{
+			TransactionID: id, AmountCents: 10000, Currency: "USD", CustomerID: "c1",
+			OriginalProcessor: "stripe_latam", DeclineCode: "insufficient_funds", WebhookURL: webhookURL,
+		})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp domain.SubmitResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp
+	}
+
+	if resp := submit("txn_probe_ok", reachable.URL, "?validate_webhook=true"); resp.WebhookReachable == nil || !*resp.WebhookReachable {
+		t.Errorf("expected webhook_reachable=true, got %v", resp.WebhookReachable)
+	}
+	resp := submit("txn_probe_bad", unreachableURL, "?validate_webhook=true")
+	if resp.WebhookReachable == nil || *resp.WebhookReachable {
+		t.Errorf("expected webhook_reachable=false, got %v", resp.WebhookReachable)
+	}
+	if resp.Status != domain.StatusScheduled {
+		t.Errorf("expected an unreachable webhook not to block scheduling, got %s", resp.Status)
+	}
+	if resp := submit("txn_probe_off", unreachableURL, ""); resp.WebhookReachable != nil {
+		t.Errorf("expected no webhook_reachable without validate_webhook, got %v", *resp.WebhookReachable)
+	}
+}
+
+func TestSubmitHandler_AllowUpdateWebhookURL(t *testing.T) {
+	mux, _ := setupTestServer()
+
+	oldHits := make(chan domain.WebhookEvent, 10)
+	oldSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event domain.WebhookEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		oldHits <- event
+	}))
+	defer oldSrv.Close()
+	newHits := make(chan domain.WebhookEvent, 10)
+	newSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event domain.WebhookEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		newHits <- event
+	}))
+	defer newSrv.Close()
+
+	body := domain.SubmitRequest{
+		TransactionID: "txn_update_url", AmountCents: 10000, Currency: "USD",
+		CustomerID: "c1", OriginalProcessor: "stripe_latam", DeclineCode: "issuer_timeout",
+		WebhookURL: oldSrv.URL,
+	}
+	if w := postJSON(mux, "/api/transactions", body); w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	select {
+	case <-oldHits:
+	case <-time.After(2 * time.Second):
+		t.Fatal("scheduled event was not delivered to the original URL")
+	}
+
+	body.WebhookURL = newSrv.URL
+	if w := postJSON(mux, "/api/transactions", body); w.Code != http.StatusConflict {
+		t.Errorf("expected 409 without allow_update, got %d", w.Code)
+	}
+
+	w := postJSON(mux, "/api/transactions?allow_update=true", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var tx domain.Transaction
+	json.NewDecoder(w.Body).Decode(&tx)
+	if tx.WebhookURL != newSrv.URL {
+		t.Errorf("expected webhook_url %s, got %s", newSrv.URL, tx.WebhookURL)
+	}
+
+	mismatch := body
+	mismatch.AmountCents = 20000
+	if w := postJSON(mux, "/api/transactions?allow_update=true", mismatch); w.Code != http.StatusConflict {
+		t.Errorf("expected 409 for a changed amount, got %d", w.Code)
+	}
+
+	postJSON(mux, "/api/transactions/txn_update_url/retry", nil)
+	select {
+	case event := <-newHits:
+		if event.TransactionID != "txn_update_url" || event.AttemptNumber != 1 {
+			t.Errorf("unexpected event at new URL: %+v", event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("retry event was not delivered to the updated URL")
+	}
+	select {
+	case event := <-oldHits:
+		t.Errorf("original URL still received %s", event.EventType)
+	default:
+	}
+}
+
+func TestCountHandler(t *testing.T) {
+	mux, _ := setupTestServer()
+
+	for i, code := range []string{"insufficient_funds", "insufficient_funds", "stolen_card"} {
+		postJSON(mux, "/api/transactions", domain.SubmitRequest{
+			TransactionID: fmt.Sprintf("txn_count_%d", i), AmountCents: 1000, Currency: "USD",
+			CustomerID: "c1", MerchantID: "merchant_count", OriginalProcessor: "stripe_latam", DeclineCode: code,
+		})
+	}
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"", 3},
+		{"?status=rejected", 1},
+		{"?decline_code=insufficient_funds&merchant_id=merchant_count", 2},
+		{"?status=scheduled&processor=adyen_apac", 0},
+	}
+	for _, tt := range tests {
+		w := get(mux, "/api/transactions/count"+tt.query)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%q: expected 200, got %d", tt.query, w.Code)
+		}
+		var resp map[string]int
+		json.NewDecoder(w.Body).Decode(&resp)
+		if resp["count"] != tt.want {
+			t.Errorf("%q: expected count %d, got %d", tt.query, tt.want, resp["count"])
+		}
+	}
+}
+
+func TestChangedHandler(t *testing.T) {
+	mux, s := setupTestServer()
+
+	submit := func(id string) {
+		postJSON(mux, "/api/transactions", domain.SubmitRequest{
+			TransactionID: id, AmountCents: 10000, Currency: "USD",
+			CustomerID: "c1", OriginalProcessor: "stripe_latam", DeclineCode: "insufficient_funds",
+		})
+	}
+	type changedResponse struct {
+		NextSince    time.Time             `json:"next_since"`
+		Total        int                   `json:"total"`
+		Transactions []*domain.Transaction `json:"transactions"`
+	}
+	poll := func(since time.Time) changedResponse {
+		t.Helper()
+		w := get(mux, "/api/transactions/changed?since="+since.Format(time.RFC3339Nano))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp changedResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp
+	}
+
+	submit("txn_changed_old")
+	old, _ := s.Get("txn_changed_old")
+	time.Sleep(time.Millisecond)
+	submit("txn_changed_new")
+
+	resp := poll(old.UpdatedAt)
+	if resp.Total != 1 || resp.Transactions[0].ID != "txn_changed_new" {
+		t.Fatalf("expected only txn_changed_new after the first update, got %+v", resp.Transactions)
+	}
+	if !resp.NextSince.Equal(resp.Transactions[0].UpdatedAt) {
+		t.Errorf("expected next_since %v, got %v", resp.Transactions[0].UpdatedAt, resp.NextSince)
+	}
+
+	again := poll(resp.NextSince)
+	if again.Total != 0 {
+		t.Errorf("expected nothing new on the advanced cursor, got %d", again.Total)
+	}
+	if !again.NextSince.Equal(resp.NextSince) {
+		t.Errorf("expected next_since to stay at %v, got %v", resp.NextSince, again.NextSince)
+	}
+
+	for _, query := range []string{"", "?since=yesterday"} {
+		if w := get(mux, "/api/transactions/changed"+query); w.Code != http.StatusBadRequest {
+			t.Errorf("changed%s: expected 400, got %d", query, w.Code)
+		}
+	}
+}
+
+func TestUpcomingHandler(t *testing.T) {
+	mux, s := setupTestServer()
+
+	now := time.Now().UTC()
+	soon := now.Add(20 * time.Minute)
+	far := now.Add(6 * time.Hour)
+	s.Save(&domain.Transaction{ID: "txn_up_soon", Status: domain.StatusScheduled, NextRetryAt: &soon})
+	s.Save(&domain.Transaction{ID: "txn_up_far", Status: domain.StatusScheduled, NextRetryAt: &far})
+	s.Save(&domain.Transaction{ID: "txn_up_done", Status: domain.StatusRecovered, NextRetryAt: &soon})
+
+	w := get(mux, "/api/transactions/upcoming?within=1h")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Total        int                  `json:"total"`
+		Transactions []domain.Transaction `json:"transactions"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Total != 1 || resp.Transactions[0].ID != "txn_up_soon" {
+		t.Errorf("expected only txn_up_soon, got %+v", resp.Transactions)
+	}
+
+	for _, within := range []string{"soon", "-1h", "0s"} {
+		if w := get(mux, "/api/transactions/upcoming?within="+within); w.Code != http.StatusBadRequest {
+			t.Errorf("within=%s: expected 400, got %d", within, w.Code)
+		}
+	}
+}
+
 func TestGetHandler_Found(t *testing.T) {
 	mux, _ := setupTestServer()
 
@@ -177,6 +556,48 @@ func TestGetHandler_Found(t *testing.T) {
 	}
 }
 
+func TestGetHandler_LastError(t *testing.T) {
+	mux, s := setupTestServer()
+
+	executed := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	s.Save(&domain.Transaction{
+		ID: "txn_exhausted", DeclineCode: "insufficient_funds", DeclineCategory: domain.SoftDecline,
+		Status: domain.StatusFailedFinal,
+		RetryAttempts: []domain.RetryAttempt{
+			{AttemptNumber: 1, Processor: "stripe_latam", ResponseCode: "51", ResponseMsg: "Insufficient funds", ExecutedAt: executed.Add(-time.Hour)},
+			{AttemptNumber: 2, Processor: "adyen_apac", ResponseCode: "91", ResponseMsg: "Issuer unavailable", ExecutedAt: executed},
+		},
+	})
+	s.Save(&domain.Transaction{ID: "txn_fresh", DeclineCategory: domain.SoftDecline, Status: domain.StatusScheduled})
+	s.Save(&domain.Transaction{
+		ID: "txn_recovered", DeclineCode: "insufficient_funds", DeclineCategory: domain.SoftDecline,
+		Status: domain.StatusRecovered,
+		RetryAttempts: []domain.RetryAttempt{
+			{AttemptNumber: 1, Processor: "stripe_latam", ResponseCode: "51", ResponseMsg: "Insufficient funds", ExecutedAt: executed.Add(-time.Hour)},
+			{AttemptNumber: 2, Processor: "adyen_apac", Success: true, ResponseCode: "00", ExecutedAt: executed},
+		},
+	})
+
+	var resp struct {
+		LastError *domain.LastError `json:"last_error"`
+	}
+	json.NewDecoder(get(mux, "/api/transactions/txn_exhausted").Body).Decode(&resp)
+	want := domain.LastError{Attempt: 2, Processor: "adyen_apac", ResponseCode: "91", ResponseMessage: "Issuer unavailable", ExecutedAt: executed}
+	if resp.LastError == nil || *resp.LastError != want {
+		t.Errorf("expected last_error %+v, got %+v", want, resp.LastError)
+	}
+
+	w := get(mux, "/api/transactions/txn_fresh")
+	if !strings.Contains(w.Body.String(), `"last_error":null`) {
+		t.Errorf("expected null last_error without attempts, got %s", w.Body.String())
+	}
+
+	w = get(mux, "/api/transactions/txn_recovered")
+	if !strings.Contains(w.Body.String(), `"last_error":null`) {
+		t.Errorf("expected null last_error after a successful final attempt, got %s", w.Body.String())
+	}
+}
+
 func TestGetHandler_NotFound(t *testing.T) {
 	mux, _ := setupTestServer()
 	w := get(mux, "/api/transactions/nonexistent")
@@ -279,6 +700,63 @@ func TestAnalyticsOverview_WithData(t *testing.T) {
 	}
 }
 
+func TestConfirmHandler_PendingConfirmationNotRecovered(t *testing.T) {
+	mux, s := setupTestServer()
+
+	for _, id := range []string{"txn_pc_1", "txn_pc_2"} {
+		postJSON(mux, "/api/transactions", domain.SubmitRequest{
+			TransactionID: id, AmountCents: 10000, Currency: "USD",
+			CustomerID: "c1", OriginalProcessor: "stripe_latam", DeclineCode: "insufficient_funds",
+		})
+	}
+	setStatus := func(id string, status domain.TransactionStatus) {
+		t.Helper()
+		if err := s.UpdateFunc(id, func(tx *domain.Transaction) error {
+			tx.Status = status
+			tx.NextRetryAt = nil
+			return nil
+		}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	setStatus("txn_pc_1", domain.StatusRecovered)
+	setStatus("txn_pc_2", domain.StatusPendingConfirmation)
+
+	overview := func() domain.AnalyticsOverview {
+		t.Helper()
+		var o domain.AnalyticsOverview
+		json.NewDecoder(get(mux, "/api/analytics/overview").Body).Decode(&o)
+		return o
+	}
+	o := overview()
+	if o.Recovered != 1 || o.PendingConfirmation != 1 || o.RecoveryRate != 50 {
+		t.Errorf("expected 1 recovered, 1 pending confirmation, 50%% rate; got %d, %d, %.1f%%",
+			o.Recovered, o.PendingConfirmation, o.RecoveryRate)
+	}
+
+	if w := postJSON(mux, "/api/transactions/txn_pc_1/confirm", nil); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 confirming a recovered transaction, got %d", w.Code)
+	}
+	if w := postJSON(mux, "/api/transactions/ghost/confirm", nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown transaction, got %d", w.Code)
+	}
+	w := postJSON(mux, "/api/transactions/txn_pc_2/confirm", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var tx domain.Transaction
+	json.NewDecoder(w.Body).Decode(&tx)
+	if tx.Status != domain.StatusRecovered {
+		t.Errorf("expected recovered, got %s", tx.Status)
+	}
+
+	o = overview()
+	if o.Recovered != 2 || o.PendingConfirmation != 0 || o.RecoveryRate != 100 {
+		t.Errorf("expected 2 recovered, 0 pending confirmation, 100%% rate; got %d, %d, %.1f%%",
+			o.Recovered, o.PendingConfirmation, o.RecoveryRate)
+	}
+}
+
 func TestRetryHandler(t *testing.T) {
 	mux, _ := setupTestServer()
 
@@ -293,6 +771,89 @@ func TestRetryHandler(t *testing.T) {
 	}
 }
 
+func TestRetryHandler_ProcessorOverride(t *testing.T) {
+	mux, s := setupTestServer()
+
+	postJSON(mux, "/api/transactions", domain.SubmitRequest{
+		TransactionID: "txn_retry_via", AmountCents: 50000, Currency: "USD",
+		CustomerID: "c1", OriginalProcessor: "stripe_latam", DeclineCode: "insufficient_funds",
+	})
+
+	if w := postJSON(mux, "/api/transactions/txn_retry_via/retry", domain.RetryRequest{Processor: "unknown_pay"}); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for unknown processor, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w := postJSON(mux, "/api/transactions/txn_retry_via/retry", domain.RetryRequest{Processor: "adyen_apac"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	tx, _ := s.Get("txn_retry_via")
+	if len(tx.RetryAttempts) != 1 {
+		t.Fatalf("expected exactly 1 attempt, got %d", len(tx.RetryAttempts))
+	}
+	if a := tx.RetryAttempts[0]; a.Processor != "adyen_apac" || a.AttemptNumber != 1 {
+		t.Errorf("expected attempt 1 via adyen_apac, got attempt %d via %s", a.AttemptNumber, a.Processor)
+	}
+	if tx.RetryPlan.Processors[0] != "stripe_latam" {
+		t.Errorf("expected the plan's processors to be unchanged, got %v", tx.RetryPlan.Processors)
+	}
+}
+
+func TestIfMatch_VersionPreconditions(t *testing.T) {
+	mux, s := setupTestServer()
+
+	for _, id := range []string{"txn_ifmatch_retry", "txn_ifmatch_ack"} {
+		postJSON(mux, "/api/transactions", domain.SubmitRequest{
+			TransactionID: id, AmountCents: 50000, Currency: "USD",
+			CustomerID: "c1", OriginalProcessor: "stripe_latam", DeclineCode: "insufficient_funds",
+		})
+	}
+	send := func(path, ifMatch string, body any) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(data))
+		req.Header.Set("If-Match", ifMatch)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get(mux, "/api/transactions/txn_ifmatch_retry")
+	var detail struct {
+		Transaction domain.Transaction `json:"transaction"`
+	}
+	json.NewDecoder(w.Body).Decode(&detail)
+	if detail.Transaction.Version != 1 || w.Header().Get("ETag") != `"1"` {
+		t.Fatalf("expected version 1 and ETag \"1\", got %d and %s", detail.Transaction.Version, w.Header().Get("ETag"))
+	}
+	var tx domain.Transaction
+
+	if w := send("/api/transactions/txn_ifmatch_retry/retry", "7", nil); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("retry: expected 412 for stale If-Match, got %d: %s", w.Code, w.Body.String())
+	}
+	if got, _ := s.Get("txn_ifmatch_retry"); len(got.RetryAttempts) != 0 {
+		t.Error("retry: a failed precondition must not run an attempt")
+	}
+	w = send("/api/transactions/txn_ifmatch_retry/retry", `"1"`, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("retry: expected 200 for current If-Match, got %d: %s", w.Code, w.Body.String())
+	}
+	json.NewDecoder(w.Body).Decode(&tx)
+	if tx.Version != 2 {
+		t.Errorf("retry: expected version 2 after the attempt, got %d", tx.Version)
+	}
+
+	ack := domain.AckRequest{Reason: "paid by bank transfer"}
+	if w := send("/api/transactions/txn_ifmatch_ack/ack", "2", ack); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("ack: expected 412 for stale If-Match, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := send("/api/transactions/txn_ifmatch_ack/ack", "1", ack); w.Code != http.StatusOK {
+		t.Errorf("ack: expected 200 for current If-Match, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := send("/api/transactions/txn_ifmatch_ack/ack", "latest", ack); w.Code != http.StatusBadRequest {
+		t.Errorf("ack: expected 400 for a malformed If-Match, got %d", w.Code)
+	}
+}
+
 func TestRetryHandler_NotFound(t *testing.T) {
 	mux, _ := setupTestServer()
 	w := postJSON(mux, "/api/transactions/ghost/retry", nil)
@@ -360,6 +921,68 @@ func TestWebhookEventsHandler(t *testing.T) {
 	}
 }
 
+func TestExportWebhookEventsHandler(t *testing.T) {
+	mux, _ := setupTestServer()
+
+	for _, id := range []string{"txn_export_1", "txn_export_2"} {
+		postJSON(mux, "/api/transactions", domain.SubmitRequest{
+			TransactionID: id, AmountCents: 10000, Currency: "USD",
+			CustomerID: "c1", OriginalProcessor: "stripe_latam", DeclineCode: "issuer_timeout",
+		})
+	}
+	postJSON(mux, "/api/transactions/txn_export_1/retry", nil)
+
+	export := func(query string) []domain.WebhookEvent {
+		t.Helper()
+		w := get(mux, "/api/webhooks/events/export"+query)
+		if w.Code != http.StatusOK {
+			t.Fatalf("export%s: expected 200, got %d: %s", query, w.Code, w.Body.String())
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+			t.Errorf("expected application/x-ndjson, got %q", ct)
+		}
+		var events []domain.WebhookEvent
+		for _, line := range strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n") {
+			if line == "" {
+				continue
+			}
+			var e domain.WebhookEvent
+			if err := json.Unmarshal([]byte(line), &e); err != nil {
+				t.Fatalf("line %q is not a JSON event: %v", line, err)
+			}
+			events = append(events, e)
+		}
+		return events
+	}
+
+	if all := export(""); len(all) < 3 {
+		t.Fatalf("expected scheduled events for both transactions plus the retry result, got %d", len(all))
+	}
+	for _, e := range export("?transaction_id=txn_export_1") {
+		if e.TransactionID != "txn_export_1" {
+			t.Errorf("transaction filter leaked event for %s", e.TransactionID)
+		}
+	}
+	scheduled := export("?type=" + domain.EventRetryScheduled)
+	if len(scheduled) != 2 {
+		t.Errorf("expected 2 scheduled events, got %d", len(scheduled))
+	}
+	for _, e := range scheduled {
+		if e.EventType != domain.EventRetryScheduled {
+			t.Errorf("type filter leaked %s event", e.EventType)
+		}
+	}
+	if got := export("?from=2999-01-01T00:00:00Z"); len(got) != 0 {
+		t.Errorf("expected no events after a future from, got %d", len(got))
+	}
+
+	for _, query := range []string{"?from=yesterday", "?from=2026-01-02T00:00:00Z&to=2026-01-01T00:00:00Z"} {
+		if w := get(mux, "/api/webhooks/events/export"+query); w.Code != http.StatusBadRequest {
+			t.Errorf("export%s: expected 400, got %d", query, w.Code)
+		}
+	}
+}
+
 func TestByDeclineReasonHandler(t *testing.T) {
 	mux, _ := setupTestServer()
 
@@ -390,3 +1013,1832 @@ func TestByAttemptNumberHandler(t *testing.T) {
 		t.Errorf("expected 200, got %d", w.Code)
 	}
 }
+
+func TestAnalyticsCache_StableUntilRefresh(t *testing.T) {
+	s := store.New()
+	analyticsHandler := NewAnalyticsHandler(s)
+	analyticsHandler.EnableCache()
+
+	mux := NewRouter()
+	mux.HandleFunc("GET /api/analytics/overview", analyticsHandler.Overview)
+	mux.HandleFunc("GET /api/analytics/by-decline", analyticsHandler.ByDeclineReason)
+	mux.HandleFunc("POST /api/analytics/refresh", analyticsHandler.Refresh)
+
+	type overviewResponse struct {
+		TotalTransactions int       `json:"total_transactions"`
+		AsOf              time.Time `json:"as_of"`
+	}
+	overview := func(query string) overviewResponse {
+		t.Helper()
+		w := get(mux, "/api/analytics/overview"+query)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp overviewResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp
+	}
+	save := func(id string) {
+		s.Save(&domain.Transaction{
+			ID: id, DeclineCode: "insufficient_funds", DeclineCategory: domain.SoftDecline,
+			Status: domain.StatusScheduled, CreatedAt: time.Now().UTC(),
+		})
+	}
+
+	save("txn_cache_1")
+	first := overview("")
+	if first.TotalTransactions != 1 || first.AsOf.IsZero() {
+		t.Fatalf("expected 1 transaction with as_of, got %+v", first)
+	}
+
+	save("txn_cache_2")
+	if cached := overview(""); cached != first {
+		t.Errorf("expected cached overview %+v until refresh, got %+v", first, cached)
+	}
+	w := get(mux, "/api/analytics/by-decline")
+	var byDecline struct {
+		AsOf time.Time `json:"as_of"`
+	}
+	json.NewDecoder(w.Body).Decode(&byDecline)
+	if !byDecline.AsOf.Equal(first.AsOf) {
+		t.Errorf("expected by-decline from the same snapshot (%v), got %v", first.AsOf, byDecline.AsOf)
+	}
+	if fresh := overview("?fresh=true"); fresh.TotalTransactions != 2 {
+		t.Errorf("expected fresh=true to recompute 2 transactions, got %d", fresh.TotalTransactions)
+	}
+	if cached := overview(""); cached != first {
+		t.Errorf("expected fresh=true to leave the cache untouched, got %+v", cached)
+	}
+
+	if w := postJSON(mux, "/api/analytics/refresh", nil); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 from refresh, got %d", w.Code)
+	}
+	refreshed := overview("")
+	if refreshed.TotalTransactions != 2 || refreshed.AsOf.Before(first.AsOf) {
+		t.Errorf("expected refreshed overview with 2 transactions, got %+v", refreshed)
+	}
+}
+
+func TestAnalyticsRefresh_CacheDisabled(t *testing.T) {
+	mux, _ := setupTestServer()
+
+	if w := postJSON(mux, "/api/analytics/refresh", nil); w.Code != http.StatusConflict {
+		t.Errorf("expected 409 without a cache, got %d", w.Code)
+	}
+}
+
+func TestComputeSnapshot_OnlyRequestedParts(t *testing.T) {
+	all := []*domain.Transaction{{
+		ID: "txn_parts", DeclineCode: "insufficient_funds", DeclineCategory: domain.SoftDecline,
+		Status:        domain.StatusRecovered,
+		RetryAttempts: []domain.RetryAttempt{{AttemptNumber: 1, Success: true}},
+	}}
+
+	snap := computeSnapshot(all, time.Now().UTC(), partOverview)
+	if snap.overview.TotalTransactions != 1 {
+		t.Errorf("expected overview of 1 transaction, got %d", snap.overview.TotalTransactions)
+	}
+	if snap.soft != nil || snap.hard != nil || snap.byAttempt != nil {
+		t.Errorf("expected only the overview to be computed, got %+v", snap)
+	}
+
+	snap = computeSnapshot(all, time.Now().UTC(), allParts)
+	if len(snap.soft) == 0 || len(snap.byAttempt) == 0 {
+		t.Errorf("expected every part to be computed, got %+v", snap)
+	}
+}
+
+func TestRouter_MethodNotAllowed(t *testing.T) {
+	mux, _ := setupTestServer()
+
+	w := get(mux, "/api/transactions/txn_any/retry")
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d: %s", w.Code, w.Body.String())
+	}
+	if allow := w.Header().Get("Allow"); allow != "POST" {
+		t.Errorf("expected Allow: POST, got %q", allow)
+	}
+
+	var resp map[string]string
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp["error"] == "" {
+		t.Error("expected JSON error body")
+	}
+}
+
+func TestRouter_UnknownPathStillNotFound(t *testing.T) {
+	mux, _ := setupTestServer()
+	w := get(mux, "/api/does-not-exist")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", w.Code)
+	}
+}
+
+func TestRouter_CamelCaseNaming(t *testing.T) {
+	mux, _ := setupTestServer()
+	submit := domain.SubmitRequest{
+		TransactionID: "txn_camel", AmountCents: 123456789012, Currency: "USD", CustomerID: "c1",
+		OriginalProcessor: "stripe_latam", DeclineCode: "insufficient_funds",
+	}
+
+	w := postJSON(mux, "/api/transactions?naming=camel", submit)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp map[string]any
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp["transactionId"] != "txn_camel" || resp["transaction_id"] != nil {
+		t.Errorf("expected camelCase transactionId, got %v", resp)
+	}
+	if plan, _ := resp["retryPlan"].(map[string]any); plan["maxAttempts"] == nil {
+		t.Errorf("expected nested keys to be camelCase, got %v", resp["retryPlan"])
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/transactions/txn_camel", nil)
+	req.Header.Set("Accept", "application/json; profile=camel")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if body := w.Body.String(); !strings.Contains(body, `"amountCents":123456789012`) {
+		t.Errorf("expected camelCase keys with exact amounts via Accept profile, got %s", body)
+	}
+
+	w = get(mux, "/api/transactions/txn_camel")
+	if body := w.Body.String(); !strings.Contains(body, `"amount_cents"`) || strings.Contains(body, `"amountCents"`) {
+		t.Errorf("expected snake_case keys by default, got %s", body)
+	}
+
+	if w := get(mux, "/api/transactions/txn_camel?naming=kebab"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for unsupported naming, got %d", w.Code)
+	}
+}
+
+func TestRouter_CamelCaseNamingKeepsDataKeys(t *testing.T) {
+	mux, _ := setupTestServer()
+	postJSON(mux, "/api/transactions", domain.SubmitRequest{
+		TransactionID: "txn_camel_data", AmountCents: 1000, Currency: "USD", CustomerID: "c1",
+		OriginalProcessor: "stripe_latam", DeclineCode: "insufficient_funds",
+	})
+
+	w := get(mux, "/api/analytics/by-decline?naming=camel")
+	var byDecline struct {
+		SoftDeclines []map[string]any `json:"softDeclines"`
+	}
+	json.NewDecoder(w.Body).Decode(&byDecline)
+	if len(byDecline.SoftDeclines) != 1 || byDecline.SoftDeclines[0]["declineCode"] != "insufficient_funds" {
+		t.Errorf("expected camelCase fields with the decline code intact, got %+v", byDecline.SoftDeclines)
+	}
+
+	w = get(mux, "/api/decline-codes?naming=camel")
+	var codes struct {
+		RetryStrategies map[string]map[string]any `json:"retryStrategies"`
+	}
+	json.NewDecoder(w.Body).Decode(&codes)
+	strategy, ok := codes.RetryStrategies["insufficient_funds"]
+	if !ok {
+		t.Fatalf("expected decline code keys to stay snake_case, got %v", slices.Collect(maps.Keys(codes.RetryStrategies)))
+	}
+	if strategy["maxAttempts"] == nil {
+		t.Errorf("expected strategy fields to be camelCase, got %v", strategy)
+	}
+
+	w = get(mux, "/api/analytics/overview?naming=camel")
+	if body := w.Body.String(); !strings.Contains(body, `"totalTransactions":1`) || !strings.Contains(body, `"asOf"`) {
+		t.Errorf("expected embedded and outer fields to be camelCase, got %s", body)
+	}
+}
+
+func TestAnalyticsOverview_FilterByMerchant(t *testing.T) {
+	mux, _ := setupTestServer()
+
+	postJSON(mux, "/api/transactions", domain.SubmitRequest{
+		TransactionID: "txn_m1_1", AmountCents: 10000, Currency: "USD", MerchantID: "voltcommerce",
+		CustomerID: "c1", OriginalProcessor: "stripe_latam", DeclineCode: "insufficient_funds",
+	})
+	postJSON(mux, "/api/transactions", domain.SubmitRequest{
+		TransactionID: "txn_m1_2", AmountCents: 10000, Currency: "USD", MerchantID: "voltcommerce",
+		CustomerID: "c2", OriginalProcessor: "stripe_latam", DeclineCode: "stolen_card",
+	})
+	postJSON(mux, "/api/transactions", domain.SubmitRequest{
+		TransactionID: "txn_m2_1", AmountCents: 20000, Currency: "BRL", MerchantID: "megastore_br",
+		CustomerID: "c3", OriginalProcessor: "dlocal_br", DeclineCode: "do_not_honor",
+	})
+
+	tests := []struct {
+		merchant string
+		total    int
+		hard     int
+		soft     int
+	}{
+		{"voltcommerce", 2, 1, 1},
+		{"megastore_br", 1, 0, 1},
+		{"unknown_merchant", 0, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.merchant, func(t *testing.T) {
+			w := get(mux, "/api/analytics/overview?merchant_id="+tt.merchant)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d", w.Code)
+			}
+			var overview domain.AnalyticsOverview
+			json.NewDecoder(w.Body).Decode(&overview)
+			if overview.TotalTransactions != tt.total {
+				t.Errorf("expected %d transactions, got %d", tt.total, overview.TotalTransactions)
+			}
+			if overview.HardDeclines != tt.hard {
+				t.Errorf("expected %d hard declines, got %d", tt.hard, overview.HardDeclines)
+			}
+			if overview.SoftDeclines != tt.soft {
+				t.Errorf("expected %d soft declines, got %d", tt.soft, overview.SoftDeclines)
+			}
+		})
+	}
+}
+
+func TestRoutingHandler(t *testing.T) {
+	mux, s := setupTestServer()
+
+	attempt := func(processor string, success bool) domain.RetryAttempt {
+		return domain.RetryAttempt{Processor: processor, Success: success, ExecutedAt: time.Now().UTC()}
+	}
+	// adyen_apac: 1/2 succeed (50%), dlocal_br: 1/4 succeed (25%)
+	s.Save(&domain.Transaction{
+		ID: "txn_route_1", DeclineCode: "processor_error", DeclineCategory: domain.SoftDecline,
+		Status: domain.StatusRecovered,
+		RetryAttempts: []domain.RetryAttempt{
+			attempt("dlocal_br", false), attempt("adyen_apac", true),
+		},
+	})
+	s.Save(&domain.Transaction{
+		ID: "txn_route_2", DeclineCode: "processor_error", DeclineCategory: domain.SoftDecline,
+		Status: domain.StatusRecovered,
+		RetryAttempts: []domain.RetryAttempt{
+			attempt("dlocal_br", false), attempt("adyen_apac", false), attempt("dlocal_br", false), attempt("dlocal_br", true),
+		},
+	})
+
+	w := get(mux, "/api/analytics/routing")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	var resp struct {
+		ByDecline []domain.RoutingStats `json:"by_decline"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if len(resp.ByDecline) != 1 {
+		t.Fatalf("expected 1 decline code, got %d", len(resp.ByDecline))
+	}
+	procs := resp.ByDecline[0].Processors
+	if len(procs) != 2 {
+		t.Fatalf("expected 2 processors, got %d", len(procs))
+	}
+	if procs[0].Processor != "adyen_apac" || procs[0].TotalAttempts != 2 || procs[0].SuccessRate != 50 {
+		t.Errorf("unexpected first entry: %+v", procs[0])
+	}
+	if procs[1].Processor != "dlocal_br" || procs[1].TotalAttempts != 4 || procs[1].SuccessRate != 25 {
+		t.Errorf("unexpected second entry: %+v", procs[1])
+	}
+}
+
+func TestBadgeHandler(t *testing.T) {
+	mux, s := setupTestServer()
+
+	// 3 soft declines, 1 recovered -> 33% -> yellow
+	statuses := []domain.TransactionStatus{domain.StatusRecovered, domain.StatusFailedFinal, domain.StatusFailedFinal}
+	for i, status := range statuses {
+		s.Save(&domain.Transaction{
+			ID:              fmt.Sprintf("txn_badge_%d", i),
+			DeclineCode:     "insufficient_funds",
+			DeclineCategory: domain.SoftDecline,
+			Status:          status,
+		})
+	}
+
+	w := get(mux, "/api/analytics/badge")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	var badge domain.Badge
+	json.NewDecoder(w.Body).Decode(&badge)
+	if badge.SchemaVersion != 1 || badge.Label != "recovery" {
+		t.Errorf("unexpected badge header fields: %+v", badge)
+	}
+	if badge.Message != "33%" {
+		t.Errorf("expected message 33%%, got %s", badge.Message)
+	}
+	if badge.Color != "yellow" {
+		t.Errorf("expected yellow, got %s", badge.Color)
+	}
+}
+
+func TestResyncHandler(t *testing.T) {
+	mux, _ := setupTestServer()
+
+	postJSON(mux, "/api/transactions", domain.SubmitRequest{
+		TransactionID: "txn_resync_http", AmountCents: 10000, Currency: "USD",
+		CustomerID: "c1", OriginalProcessor: "stripe_latam", DeclineCode: "insufficient_funds",
+	})
+
+	w := postJSON(mux, "/api/retry/resync", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var resp map[string]any
+	json.NewDecoder(w.Body).Decode(&resp)
+	if int(resp["updated"].(float64)) != 1 {
+		t.Errorf("expected 1 updated, got %v", resp["updated"])
+	}
+}
+
+func TestByAmountHandler(t *testing.T) {
+	mux, _ := setupTestServer()
+
+	postJSON(mux, "/api/transactions", domain.SubmitRequest{
+		TransactionID: "txn_amount_micro", AmountCents: 500, Currency: "USD",
+		CustomerID: "c1", OriginalProcessor: "stripe_latam", DeclineCode: "insufficient_funds",
+	})
+	postJSON(mux, "/api/transactions", domain.SubmitRequest{
+		TransactionID: "txn_amount_large", AmountCents: 5000000, Currency: "BRL",
+		CustomerID: "c2", OriginalProcessor: "dlocal_br", DeclineCode: "stolen_card",
+	})
+
+	w := get(mux, "/api/analytics/by-amount")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	var resp struct {
+		ByAmount []domain.AmountBucketStats `json:"by_amount"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	totals := map[string]int{}
+	for _, b := range resp.ByAmount {
+		totals[b.Bucket] = b.Total
+	}
+	if totals["micro"] != 1 || totals["large"] != 1 || totals["small"] != 0 {
+		t.Errorf("unexpected bucket totals: %v", totals)
+	}
+}
+
+func TestByCustomerHandler(t *testing.T) {
+	mux, s := setupTestServer()
+
+	// cust_small: one recovery of $10 out of three declines
+	s.Save(&domain.Transaction{ID: "txn_cust_s1", CustomerID: "cust_small", AmountCents: 1000, Currency: "USD", Status: domain.StatusRecovered})
+	s.Save(&domain.Transaction{ID: "txn_cust_s2", CustomerID: "cust_small", AmountCents: 9000, Currency: "USD", Status: domain.StatusFailedFinal})
+	s.Save(&domain.Transaction{ID: "txn_cust_s3", CustomerID: "cust_small", AmountCents: 9000, Currency: "USD", Status: domain.StatusScheduled})
+	// cust_big: one recovery of $50 out of one decline
+	s.Save(&domain.Transaction{ID: "txn_cust_b1", CustomerID: "cust_big", AmountCents: 5000, Currency: "USD", Status: domain.StatusRecovered})
+
+	rank := func(path string) []domain.CustomerStats {
+		t.Helper()
+		w := get(mux, path)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", path, w.Code, w.Body.String())
+		}
+		var resp struct {
+			ByCustomer []domain.CustomerStats `json:"by_customer"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp.ByCustomer
+	}
+
+	got := rank("/api/analytics/by-customer")
+	if len(got) != 2 || got[0].CustomerID != "cust_big" || got[1].CustomerID != "cust_small" {
+		t.Fatalf("expected [cust_big cust_small] by recovered amount, got %+v", got)
+	}
+	if got[0].RecoveredCents != 5000 || got[1].RecoveredCents != 1000 || got[1].Declines != 3 || got[1].RecoveryRate != 50 {
+		t.Errorf("unexpected customer stats: %+v", got)
+	}
+
+	got = rank("/api/analytics/by-customer?sort=declines&limit=1")
+	if len(got) != 1 || got[0].CustomerID != "cust_small" {
+		t.Errorf("expected [cust_small] by declines with limit 1, got %+v", got)
+	}
+
+	for _, path := range []string{
+		"/api/analytics/by-customer?limit=0",
+		"/api/analytics/by-customer?limit=abc",
+		"/api/analytics/by-customer?sort=amount",
+	} {
+		if w := get(mux, path); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", path, w.Code)
+		}
+	}
+}
+
+func TestHealthHandler_ReadinessTransitions(t *testing.T) {
+	h := NewHealthHandler()
+	mux := NewRouter()
+	mux.HandleFunc("GET /health", h.Health)
+	mux.HandleFunc("GET /readyz", h.Ready)
+
+	steps := []struct {
+		ready     bool
+		wantReady int
+	}{
+		{false, http.StatusServiceUnavailable},
+		{true, http.StatusOK},
+		{false, http.StatusServiceUnavailable},
+	}
+
+	for _, step := range steps {
+		h.SetReady(step.ready)
+		if w := get(mux, "/readyz"); w.Code != step.wantReady {
+			t.Errorf("ready=%v: expected /readyz %d, got %d", step.ready, step.wantReady, w.Code)
+		}
+		if w := get(mux, "/health"); w.Code != http.StatusOK {
+			t.Errorf("ready=%v: expected /health 200, got %d", step.ready, w.Code)
+		}
+	}
+}
+
+func TestTransactionReportHandler(t *testing.T) {
+	mux, _ := setupTestServer()
+
+	postJSON(mux, "/api/transactions", domain.SubmitRequest{
+		TransactionID: "txn_report", AmountCents: 10000, Currency: "USD",
+		CustomerID: "<script>alert(1)</script>", OriginalProcessor: "stripe_latam", DeclineCode: "insufficient_funds",
+	})
+	postJSON(mux, "/api/transactions/txn_report/retry", nil)
+
+	w := get(mux, "/api/transactions/txn_report/report.html")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("expected text/html content type, got %q", ct)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "txn_report") {
+		t.Error("expected report to contain the transaction id")
+	}
+	if strings.Contains(body, "<script>") || !strings.Contains(body, "&lt;script&gt;") {
+		t.Error("expected merchant-supplied fields to be escaped")
+	}
+
+	// The markup is XHTML-compatible, so a strict XML pass checks that every
+	// element is closed and properly nested.
+	dec := xml.NewDecoder(strings.NewReader(body))
+	for {
+		if _, err := dec.Token(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("report is not well-formed: %v", err)
+		}
+	}
+
+	if w := get(mux, "/api/transactions/txn_missing/report.html"); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown transaction, got %d", w.Code)
+	}
+}
+
+func TestTimelineHandler(t *testing.T) {
+	mux, _ := setupTestServer()
+
+	postJSON(mux, "/api/transactions", domain.SubmitRequest{
+		TransactionID: "txn_timeline", AmountCents: 10000, Currency: "USD",
+		CustomerID: "c1", OriginalProcessor: "stripe_latam", DeclineCode: "authentication_failed",
+	})
+	postJSON(mux, "/api/transactions/txn_timeline/retry", nil)
+	postJSON(mux, "/api/transactions/txn_timeline/retry", nil)
+
+	w := get(mux, "/api/transactions/txn_timeline/timeline")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	var resp struct {
+		Timeline []domain.TimelineEntry `json:"timeline"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	var attempts, events int
+	for i, entry := range resp.Timeline {
+		if i > 0 && entry.Timestamp.Before(resp.Timeline[i-1].Timestamp) {
+			t.Errorf("entry %d (%s) is out of chronological order", i, entry.Type)
+		}
+		switch entry.Type {
+		case domain.TimelineRetryAttempt:
+			attempts++
+		case domain.TimelineWebhookEvent:
+			events++
+		}
+	}
+	if attempts == 0 || events < 2 {
+		t.Fatalf("expected attempts and events in timeline, got %d attempts and %d events", attempts, events)
+	}
+	first := resp.Timeline[0]
+	if first.Type != domain.TimelineWebhookEvent || first.Event.EventType != domain.EventRetryScheduled {
+		t.Errorf("expected retry.scheduled event first, got %+v", first)
+	}
+	if resp.Timeline[1].Type != domain.TimelineRetryAttempt {
+		t.Errorf("expected first attempt to follow scheduling, got %s", resp.Timeline[1].Type)
+	}
+}
+
+func TestTimelineHandler_NotFound(t *testing.T) {
+	mux, _ := setupTestServer()
+	w := get(mux, "/api/transactions/ghost/timeline")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", w.Code)
+	}
+}
+
+func TestAckHandler(t *testing.T) {
+	mux, _ := setupTestServer()
+
+	postJSON(mux, "/api/transactions", domain.SubmitRequest{
+		TransactionID: "txn_ack_http", AmountCents: 10000, Currency: "USD",
+		CustomerID: "c1", OriginalProcessor: "stripe_latam", DeclineCode: "insufficient_funds",
+	})
+
+	if w := postJSON(mux, "/api/transactions/txn_ack_http/ack", map[string]string{}); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without reason, got %d", w.Code)
+	}
+
+	w := postJSON(mux, "/api/transactions/txn_ack_http/ack", domain.AckRequest{Reason: "paid by bank transfer"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var tx domain.Transaction
+	json.NewDecoder(w.Body).Decode(&tx)
+	if tx.Status != domain.StatusResolvedExternally {
+		t.Errorf("expected resolved_externally, got %s", tx.Status)
+	}
+
+	if w := postJSON(mux, "/api/transactions/txn_ack_http/ack", domain.AckRequest{Reason: "again"}); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 on second ack, got %d", w.Code)
+	}
+	if w := postJSON(mux, "/api/transactions/ghost/ack", domain.AckRequest{Reason: "x"}); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown transaction, got %d", w.Code)
+	}
+}
+
+func TestReplayWebhooksHandler_Validation(t *testing.T) {
+	mux, _ := setupTestServer()
+	now := time.Now().UTC()
+
+	tests := []struct {
+		name string
+		body any
+		want int
+	}{
+		{"missing window", map[string]string{}, http.StatusBadRequest},
+		{"to before from", domain.WebhookReplayRequest{From: now, To: now.Add(-time.Hour)}, http.StatusBadRequest},
+		{"valid window", domain.WebhookReplayRequest{From: now.Add(-time.Hour), To: now}, http.StatusAccepted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := postJSON(mux, "/api/webhooks/replay", tt.body)
+			if w.Code != tt.want {
+				t.Errorf("expected %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestSchedulerDriftHandler(t *testing.T) {
+	mux, s := setupTestServer()
+
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	attempts := make([]domain.RetryAttempt, 0, 21)
+	// 20 late attempts drifting 1s..20s, plus one executed early
+	for i := 1; i <= 20; i++ {
+		attempts = append(attempts, domain.RetryAttempt{
+			AttemptNumber: i,
+			ScheduledAt:   base,
+			ExecutedAt:    base.Add(time.Duration(i) * time.Second),
+		})
+	}
+	attempts = append(attempts, domain.RetryAttempt{AttemptNumber: 21, ScheduledAt: base, ExecutedAt: base.Add(-time.Hour)})
+	s.Save(&domain.Transaction{ID: "txn_drift", DeclineCategory: domain.SoftDecline, Status: domain.StatusFailedFinal, RetryAttempts: attempts})
+
+	w := get(mux, "/api/analytics/scheduler-drift")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	var stats domain.SchedulerDriftStats
+	json.NewDecoder(w.Body).Decode(&stats)
+	if stats.Attempts != 20 || stats.EarlyAttempts != 1 {
+		t.Errorf("expected 20 attempts and 1 early, got %d and %d", stats.Attempts, stats.EarlyAttempts)
+	}
+	if stats.AvgSeconds != 10.5 {
+		t.Errorf("expected avg 10.5s, got %v", stats.AvgSeconds)
+	}
+	if stats.P95Seconds != 19 {
+		t.Errorf("expected p95 19s, got %v", stats.P95Seconds)
+	}
+	if stats.MaxSeconds != 20 {
+		t.Errorf("expected max 20s, got %v", stats.MaxSeconds)
+	}
+}
+
+func TestListHandler_CursorPagination(t *testing.T) {
+	mux, s := setupTestServer()
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		s.Save(&domain.Transaction{ID: fmt.Sprintf("txn_page_%d", i), Status: domain.StatusScheduled, CreatedAt: base.Add(time.Duration(i) * time.Minute)})
+	}
+
+	type page struct {
+		Total        int                   `json:"total"`
+		Transactions []*domain.Transaction `json:"transactions"`
+		NextCursor   string                `json:"next_cursor"`
+	}
+
+	seen := map[string]int{}
+	path := "/api/transactions?limit=2"
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("pagination did not terminate")
+		}
+		w := get(mux, path)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var p page
+		json.NewDecoder(w.Body).Decode(&p)
+		for _, tx := range p.Transactions {
+			seen[tx.ID]++
+		}
+		if p.NextCursor == "" {
+			break
+		}
+		s.Save(&domain.Transaction{ID: fmt.Sprintf("txn_new_%d", pages), Status: domain.StatusScheduled, CreatedAt: base.Add(time.Hour)})
+		path = "/api/transactions?limit=2&after=" + p.NextCursor
+	}
+
+	for i := 0; i < 5; i++ {
+		if id := fmt.Sprintf("txn_page_%d", i); seen[id] != 1 {
+			t.Errorf("%s seen %d times, want 1", id, seen[id])
+		}
+	}
+	if len(seen) != 5 {
+		t.Errorf("expected only the original 5 transactions, got %v", seen)
+	}
+}
+
+func TestListHandler_SummaryFields(t *testing.T) {
+	mux, _ := setupTestServer()
+
+	postJSON(mux, "/api/transactions", domain.SubmitRequest{
+		TransactionID: "txn_summary", AmountCents: 4200, Currency: "USD",
+		CustomerID: "c1", OriginalProcessor: "stripe_latam", DeclineCode: "insufficient_funds",
+	})
+
+	for _, path := range []string{"/api/transactions?fields=summary", "/api/transactions?fields=summary&limit=10", "/api/transactions?fields=summary&offset=0"} {
+		w := get(mux, path)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", path, w.Code)
+		}
+		var resp struct {
+			Transactions []map[string]any `json:"transactions"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if len(resp.Transactions) != 1 {
+			t.Fatalf("%s: expected 1 transaction, got %d", path, len(resp.Transactions))
+		}
+		item := resp.Transactions[0]
+		for _, field := range []string{"retry_plan", "retry_attempts", "customer_id"} {
+			if _, ok := item[field]; ok {
+				t.Errorf("%s: summary should omit %s", path, field)
+			}
+		}
+		if item["id"] != "txn_summary" || item["amount_cents"] != float64(4200) || item["attempt_count"] != float64(0) {
+			t.Errorf("%s: unexpected summary %v", path, item)
+		}
+		if item["status"] != "scheduled" || item["decline_code"] != "insufficient_funds" || item["next_retry_at"] == nil {
+			t.Errorf("%s: unexpected summary %v", path, item)
+		}
+	}
+
+	w := get(mux, "/api/transactions?fields=full")
+	var full struct {
+		Transactions []map[string]any `json:"transactions"`
+	}
+	json.NewDecoder(w.Body).Decode(&full)
+	if _, ok := full.Transactions[0]["retry_plan"]; !ok {
+		t.Error("fields=full should include retry_plan")
+	}
+
+	if w := get(mux, "/api/transactions?fields=everything"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for unknown fields mode, got %d", w.Code)
+	}
+}
+
+func TestListHandler_OffsetPagination(t *testing.T) {
+	mux, s := setupTestServer()
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		s.Save(&domain.Transaction{ID: fmt.Sprintf("txn_offset_%d", i), Status: domain.StatusScheduled, CreatedAt: base.Add(time.Duration(i) * time.Minute)})
+	}
+
+	type page struct {
+		Total        int                   `json:"total"`
+		Limit        int                   `json:"limit"`
+		Offset       int                   `json:"offset"`
+		HasMore      bool                  `json:"has_more"`
+		NextOffset   *int                  `json:"next_offset"`
+		Transactions []*domain.Transaction `json:"transactions"`
+	}
+	fetch := func(path string) (page, string) {
+		t.Helper()
+		w := get(mux, path)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", path, w.Code, w.Body.String())
+		}
+		var p page
+		json.NewDecoder(w.Body).Decode(&p)
+		return p, w.Header().Get("Link")
+	}
+
+	first, link := fetch("/api/transactions?offset=0&limit=2&status=scheduled")
+	if first.Total != 5 || !first.HasMore || first.NextOffset == nil || *first.NextOffset != 2 {
+		t.Errorf("first page: expected total 5, has_more and next_offset 2, got %+v", first)
+	}
+	if len(first.Transactions) != 2 || first.Transactions[0].ID != "txn_offset_4" {
+		t.Errorf("first page: expected the 2 newest transactions, got %d", len(first.Transactions))
+	}
+	if want := `</api/transactions?limit=2&offset=2&status=scheduled>; rel="next"`; link != want {
+		t.Errorf("first page: expected Link %q, got %q", want, link)
+	}
+
+	last, link := fetch("/api/transactions?offset=4&limit=2&status=scheduled")
+	if last.HasMore || last.NextOffset != nil || len(last.Transactions) != 1 {
+		t.Errorf("last page: expected 1 transaction and no more, got %+v", last)
+	}
+	if want := `</api/transactions?limit=2&offset=2&status=scheduled>; rel="prev"`; link != want {
+		t.Errorf("last page: expected Link %q, got %q", want, link)
+	}
+
+	if _, link := fetch("/api/transactions?offset=2&limit=2"); !strings.Contains(link, `offset=4>; rel="next"`) || !strings.Contains(link, `offset=0>; rel="prev"`) {
+		t.Errorf("middle page: expected next and prev links, got %q", link)
+	}
+
+	// An offset near MaxInt must not overflow offset+limit
+	past, link := fetch(fmt.Sprintf("/api/transactions?offset=%d&limit=50", math.MaxInt))
+	if past.Total != 5 || past.HasMore || past.NextOffset != nil || len(past.Transactions) != 0 {
+		t.Errorf("past the end: expected an empty last page, got %+v", past)
+	}
+	if want := `</api/transactions?limit=50&offset=0>; rel="prev"`; link != want {
+		t.Errorf("past the end: expected Link %q, got %q", want, link)
+	}
+}
+
+func TestListHandler_InvalidPagination(t *testing.T) {
+	mux, _ := setupTestServer()
+
+	for _, path := range []string{
+		"/api/transactions?limit=0",
+		"/api/transactions?limit=abc",
+		"/api/transactions?after=not-a-cursor",
+		"/api/transactions?offset=-1",
+		"/api/transactions?offset=0&after=abc",
+	} {
+		if w := get(mux, path); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", path, w.Code)
+		}
+	}
+}
+
+func TestReportHandler(t *testing.T) {
+	mux, _ := setupTestServer()
+	codes := []string{"insufficient_funds", "processor_error", "do_not_honor", "stolen_card", "issuer_timeout"}
+	for i := 0; i < 20; i++ {
+		postJSON(mux, "/api/transactions", domain.SubmitRequest{
+			TransactionID:     fmt.Sprintf("txn_report_%02d", i),
+			AmountCents:       5000,
+			Currency:          "USD",
+			OriginalProcessor: "stripe_latam",
+			DeclineCode:       codes[i%len(codes)],
+		})
+	}
+	if w := postJSON(mux, "/api/retry/process-all", nil); w.Code != http.StatusOK {
+		t.Fatalf("process-all failed: %d", w.Code)
+	}
+
+	w := get(mux, "/api/analytics/report")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if cd := w.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, `attachment; filename="recovery-report-`) {
+		t.Errorf("unexpected Content-Disposition: %q", cd)
+	}
+
+	var report domain.AnalyticsReport
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatalf("decoding report: %v", err)
+	}
+	if report.GeneratedAt.IsZero() {
+		t.Error("expected generated_at")
+	}
+
+	overview := report.Overview
+	if overview.TotalTransactions == 0 {
+		t.Fatal("expected seeded transactions in overview")
+	}
+
+	var declineTotal, declineRecovered int
+	for _, group := range [][]domain.DeclineReasonStats{report.ByDecline.SoftDeclines, report.ByDecline.HardDeclines} {
+		for _, d := range group {
+			declineTotal += d.Total
+			declineRecovered += d.Recovered
+		}
+	}
+	if declineTotal != overview.TotalTransactions || declineRecovered != overview.Recovered {
+		t.Errorf("by_decline totals (%d, %d recovered) disagree with overview (%d, %d recovered)",
+			declineTotal, declineRecovered, overview.TotalTransactions, overview.Recovered)
+	}
+
+	var byAttempt, byAttemptSuccess int
+	for _, a := range report.ByAttempt {
+		byAttempt += a.TotalAttempts
+		byAttemptSuccess += a.Successes
+	}
+	var byProcessor, byProcessorSuccess int
+	for _, p := range report.ByProcessor {
+		byProcessor += p.TotalAttempts
+		byProcessorSuccess += p.Successes
+	}
+	if byAttempt != overview.TotalRetryAttempts || byProcessor != overview.TotalRetryAttempts {
+		t.Errorf("attempt totals disagree: overview %d, by_attempt %d, by_processor %d",
+			overview.TotalRetryAttempts, byAttempt, byProcessor)
+	}
+	if byAttemptSuccess != overview.SuccessfulAttempts || byProcessorSuccess != overview.SuccessfulAttempts {
+		t.Errorf("success totals disagree: overview %d, by_attempt %d, by_processor %d",
+			overview.SuccessfulAttempts, byAttemptSuccess, byProcessorSuccess)
+	}
+}
+
+func TestGetProcessorsHandler(t *testing.T) {
+	mux, _ := setupTestServer()
+
+	w := get(mux, "/api/processors")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	var resp struct {
+		Total      int `json:"total"`
+		Processors []struct {
+			Name              string   `json:"name"`
+			StrategyOverrides []string `json:"strategy_overrides"`
+		} `json:"processors"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	want := []string{"stripe_latam", "adyen_apac", "dlocal_br", "payu_mx", "mercadopago_co"}
+	if resp.Total != len(want) || len(resp.Processors) != len(want) {
+		t.Fatalf("expected %d processors, got total=%d len=%d", len(want), resp.Total, len(resp.Processors))
+	}
+	for i, name := range want {
+		if resp.Processors[i].Name != name {
+			t.Errorf("processor %d: expected %s, got %s", i, name, resp.Processors[i].Name)
+		}
+	}
+}
+
+func TestStrategyToggleHandlers(t *testing.T) {
+	mux, _ := setupTestServer()
+	defer domain.SetStrategyEnabled("do_not_honor", true)
+
+	submit := func(id string) domain.SubmitResponse {
+		w := postJSON(mux, "/api/transactions", domain.SubmitRequest{
+			TransactionID:     id,
+			AmountCents:       5000,
+			Currency:          "USD",
+			OriginalProcessor: "stripe_latam",
+			DeclineCode:       "do_not_honor",
+		})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("submit %s: expected 201, got %d", id, w.Code)
+		}
+		var resp domain.SubmitResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp
+	}
+
+	if w := postJSON(mux, "/api/config/strategies/do_not_honor/disable", nil); w.Code != http.StatusOK {
+		t.Fatalf("disable: expected 200, got %d", w.Code)
+	}
+	disabled := submit("txn_toggle_1")
+	if disabled.Status != domain.StatusRejected || disabled.RetryEligible || disabled.RetryPlan != nil {
+		t.Errorf("expected rejected without plan while disabled, got %+v", disabled)
+	}
+	if !strings.Contains(disabled.Message, "temporarily disabled") {
+		t.Errorf("expected disabled message, got %q", disabled.Message)
+	}
+
+	if w := postJSON(mux, "/api/config/strategies/do_not_honor/enable", nil); w.Code != http.StatusOK {
+		t.Fatalf("enable: expected 200, got %d", w.Code)
+	}
+	enabled := submit("txn_toggle_2")
+	if enabled.Status != domain.StatusScheduled || enabled.RetryPlan == nil {
+		t.Errorf("expected scheduling restored after enable, got %+v", enabled)
+	}
+}
+
+func TestStrategyToggleHandlers_UnknownCode(t *testing.T) {
+	mux, _ := setupTestServer()
+
+	for _, code := range []string{"not_a_code", "stolen_card"} {
+		if w := postJSON(mux, "/api/config/strategies/"+code+"/disable", nil); w.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", code, w.Code)
+		}
+	}
+}
+
+func TestAttemptDistributionHandler(t *testing.T) {
+	mux, s := setupTestServer()
+
+	save := func(id string, status domain.TransactionStatus, attempts int) {
+		tx := &domain.Transaction{ID: id, DeclineCategory: domain.SoftDecline, Status: status}
+		for i := 1; i <= attempts; i++ {
+			tx.RetryAttempts = append(tx.RetryAttempts, domain.RetryAttempt{AttemptNumber: i, Success: status == domain.StatusRecovered && i == attempts})
+		}
+		s.Save(tx)
+	}
+	save("txn_r1a", domain.StatusRecovered, 1)
+	save("txn_r1b", domain.StatusRecovered, 1)
+	save("txn_r2", domain.StatusRecovered, 2)
+	save("txn_f3a", domain.StatusFailedFinal, 3)
+	save("txn_f3b", domain.StatusFailedFinal, 3)
+	save("txn_f0", domain.StatusFailedFinal, 0)    // response code not retryable
+	save("txn_pending", domain.StatusScheduled, 1) // not terminal: excluded
+
+	w := get(mux, "/api/analytics/attempt-distribution")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var resp struct {
+		Distribution []domain.AttemptCountStats `json:"distribution"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	want := []domain.AttemptCountStats{
+		{Attempts: 0, Failed: 1},
+		{Attempts: 1, Recovered: 2},
+		{Attempts: 2, Recovered: 1},
+		{Attempts: 3, Failed: 2},
+	}
+	if len(resp.Distribution) != len(want) {
+		t.Fatalf("expected %v, got %v", want, resp.Distribution)
+	}
+	for i := range want {
+		if resp.Distribution[i] != want[i] {
+			t.Errorf("entry %d: expected %+v, got %+v", i, want[i], resp.Distribution[i])
+		}
+	}
+}
+
+func TestSimulatorLatencyHandler(t *testing.T) {
+	mux, _ := setupTestServer()
+
+	for _, body := range []map[string]any{
+		{"processor": "unknown_psp", "ms": 100},
+		{"processor": "payu_mx"},
+		{"processor": "payu_mx", "ms": 60000},
+		{"processor": "payu_mx", "ms": -1},
+	} {
+		if w := postJSON(mux, "/api/simulator/latency", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%v: expected 400, got %d", body, w.Code)
+		}
+	}
+
+	const spike = 150 * time.Millisecond
+	if w := postJSON(mux, "/api/simulator/latency", map[string]any{"processor": "payu_mx", "ms": spike.Milliseconds()}); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	for _, id := range []string{"txn_slow", "txn_fast"} {
+		postJSON(mux, "/api/transactions", domain.SubmitRequest{
+			TransactionID: id, AmountCents: 10000, Currency: "USD",
+			CustomerID: "c1", OriginalProcessor: "stripe_latam", DeclineCode: "do_not_honor",
+		})
+	}
+	retryVia := func(id, processor string) time.Duration {
+		t.Helper()
+		start := time.Now()
+		if w := postJSON(mux, "/api/transactions/"+id+"/retry", domain.RetryRequest{Processor: processor}); w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", id, w.Code, w.Body.String())
+		}
+		return time.Since(start)
+	}
+	if d := retryVia("txn_slow", "payu_mx"); d < spike {
+		t.Errorf("expected a retry through payu_mx to take at least %s, took %s", spike, d)
+	}
+	if d := retryVia("txn_fast", "stripe_latam"); d >= spike {
+		t.Errorf("expected other processors to be unaffected, took %s", d)
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/simulator/latency", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	var resp struct {
+		LatencyMs map[string]int64 `json:"latency_ms"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || len(resp.LatencyMs) != 0 {
+		t.Errorf("expected clear to remove all latency, got %d %v", w.Code, resp.LatencyMs)
+	}
+}
+
+func TestSuspendRetriesHandler(t *testing.T) {
+	mux, s := setupTestServer()
+
+	w := postJSON(mux, "/api/admin/suspend-retries", map[string]bool{"suspended": true})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp map[string]bool
+	json.NewDecoder(w.Body).Decode(&resp)
+	if !resp["retries_suspended"] {
+		t.Errorf("expected retries_suspended true, got %v", resp)
+	}
+
+	if w := postJSON(mux, "/api/transactions", domain.SubmitRequest{
+		TransactionID: "txn_suspend_submit", AmountCents: 5000, Currency: "USD",
+		OriginalProcessor: "stripe_latam", DeclineCode: "insufficient_funds",
+	}); w.Code != http.StatusCreated {
+		t.Fatalf("expected submits to be accepted while suspended, got %d", w.Code)
+	}
+	if tx, _ := s.Get("txn_suspend_submit"); tx.Status != domain.StatusScheduled {
+		t.Errorf("expected scheduled status, got %s", tx.Status)
+	}
+
+	if w := postJSON(mux, "/api/admin/suspend-retries", map[string]string{}); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without suspended, got %d", w.Code)
+	}
+}
+
+func TestReadOnlyMode(t *testing.T) {
+	mux, s := setupTestServer()
+	submit := domain.SubmitRequest{
+		TransactionID:     "txn_ro_001",
+		AmountCents:       5000,
+		Currency:          "USD",
+		OriginalProcessor: "stripe_latam",
+		DeclineCode:       "insufficient_funds",
+	}
+	if w := postJSON(mux, "/api/transactions", submit); w.Code != http.StatusCreated {
+		t.Fatalf("setup submit: expected 201, got %d", w.Code)
+	}
+
+	if w := postJSON(mux, "/api/admin/readonly", map[string]bool{"enabled": true}); w.Code != http.StatusOK {
+		t.Fatalf("enable read-only: expected 200, got %d", w.Code)
+	}
+
+	submit.TransactionID = "txn_ro_002"
+	writes := []struct {
+		path string
+		body any
+	}{
+		{"/api/transactions", submit},
+		{"/api/transactions/txn_ro_001/retry", nil},
+		{"/api/transactions/txn_ro_001/ack", domain.AckRequest{Reason: "paid"}},
+		{"/api/retry/process-all", nil},
+		{"/api/retry/resync", nil},
+	}
+	for _, wr := range writes {
+		if w := postJSON(mux, wr.path, wr.body); w.Code != http.StatusServiceUnavailable {
+			t.Errorf("POST %s: expected 503, got %d", wr.path, w.Code)
+		}
+	}
+	if s.Exists("txn_ro_002") {
+		t.Error("submit must not store transactions in read-only mode")
+	}
+	if got, _ := s.Get("txn_ro_001"); len(got.RetryAttempts) != 0 {
+		t.Error("retry must not run in read-only mode")
+	}
+
+	for _, path := range []string{"/api/transactions/txn_ro_001", "/api/transactions", "/api/analytics/overview"} {
+		if w := get(mux, path); w.Code != http.StatusOK {
+			t.Errorf("GET %s: expected 200 in read-only mode, got %d", path, w.Code)
+		}
+	}
+
+	postJSON(mux, "/api/admin/readonly", map[string]bool{"enabled": false})
+	if w := postJSON(mux, "/api/transactions", submit); w.Code != http.StatusCreated {
+		t.Errorf("submit after leaving read-only: expected 201, got %d", w.Code)
+	}
+}
+
+func TestReadOnlyMode_GuardsMaintenanceRoutes(t *testing.T) {
+	mux, _ := setupTestServer()
+	postJSON(mux, "/api/admin/readonly", map[string]bool{"enabled": true})
+	defer postJSON(mux, "/api/admin/readonly", map[string]bool{"enabled": false})
+
+	now := time.Now().UTC()
+	writes := []struct {
+		method, path, body string
+	}{
+		{http.MethodDelete, "/api/idempotency-keys/click-1", ""},
+		{http.MethodDelete, "/api/idempotency-keys", ""},
+		{http.MethodPost, "/api/webhooks/replay", fmt.Sprintf(`{"from": %q, "to": %q}`, now.Add(-time.Hour).Format(time.RFC3339), now.Format(time.RFC3339))},
+		{http.MethodPost, "/api/config/strategies/do_not_honor/disable", ""},
+		{http.MethodPost, "/api/config/strategies/do_not_honor/enable", ""},
+	}
+	for _, wr := range writes {
+		req := httptest.NewRequest(wr.method, wr.path, strings.NewReader(wr.body))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s %s: expected 503, got %d", wr.method, wr.path, w.Code)
+		}
+	}
+	if !domain.IsStrategyEnabled("do_not_honor") {
+		t.Error("disable must not take effect in read-only mode")
+	}
+}
+
+func TestReadOnlyMode_MissingEnabled(t *testing.T) {
+	mux, _ := setupTestServer()
+	if w := postJSON(mux, "/api/admin/readonly", map[string]string{}); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", w.Code)
+	}
+}
+
+func TestByTagHandler(t *testing.T) {
+	mux, s := setupTestServer()
+
+	// issuer_timeout and processor_error are both tagged "technical" by default
+	s.Save(&domain.Transaction{ID: "txn_tag_1", DeclineCode: "issuer_timeout", DeclineCategory: domain.SoftDecline, Status: domain.StatusRecovered})
+	s.Save(&domain.Transaction{ID: "txn_tag_2", DeclineCode: "processor_error", DeclineCategory: domain.SoftDecline, Status: domain.StatusRecovered})
+	s.Save(&domain.Transaction{ID: "txn_tag_3", DeclineCode: "processor_error", DeclineCategory: domain.SoftDecline, Status: domain.StatusFailedFinal})
+	s.Save(&domain.Transaction{ID: "txn_tag_4", DeclineCode: "issuer_timeout", DeclineCategory: domain.SoftDecline, Status: domain.StatusScheduled})
+	s.Save(&domain.Transaction{ID: "txn_tag_5", DeclineCode: "insufficient_funds", DeclineCategory: domain.SoftDecline, Status: domain.StatusFailedFinal})
+	s.Save(&domain.Transaction{ID: "txn_tag_6", DeclineCode: "stolen_card", DeclineCategory: domain.HardDecline, Status: domain.StatusRejected})
+
+	w := get(mux, "/api/analytics/by-tag")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var resp struct {
+		ByTag []domain.TagStats `json:"by_tag"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	byTag := map[string]domain.TagStats{}
+	for _, ts := range resp.ByTag {
+		byTag[ts.Tag] = ts
+	}
+	if len(byTag) != 2 {
+		t.Fatalf("expected funding and technical tags, got %+v", resp.ByTag)
+	}
+
+	technical := byTag["technical"]
+	if technical.Total != 4 || technical.Recovered != 2 || technical.Failed != 1 || technical.Pending != 1 {
+		t.Errorf("unexpected technical counts: %+v", technical)
+	}
+	if math.Abs(technical.RecoveryRate-200.0/3) > 0.01 {
+		t.Errorf("expected technical recovery rate 66.67, got %.2f", technical.RecoveryRate)
+	}
+	if len(technical.DeclineCodes) != 2 || technical.DeclineCodes[0] != "issuer_timeout" || technical.DeclineCodes[1] != "processor_error" {
+		t.Errorf("expected both codes combined under technical, got %v", technical.DeclineCodes)
+	}
+	if funding := byTag["funding"]; funding.Total != 1 || funding.Failed != 1 {
+		t.Errorf("unexpected funding counts: %+v", funding)
+	}
+}
+
+func TestSLAHandler(t *testing.T) {
+	mux, s := setupTestServer()
+
+	// issuer_timeout (target 68%): 3 of 4 recovered = 75%, meets target.
+	// insufficient_funds (target 42%): 1 of 4 recovered = 25%, misses target.
+	for i, recovered := range []bool{true, true, true, false} {
+		status := domain.StatusFailedFinal
+		if recovered {
+			status = domain.StatusRecovered
+		}
+		s.Save(&domain.Transaction{ID: fmt.Sprintf("txn_sla_it_%d", i), DeclineCode: "issuer_timeout", DeclineCategory: domain.SoftDecline, Status: status})
+	}
+	for i, recovered := range []bool{true, false, false, false} {
+		status := domain.StatusFailedFinal
+		if recovered {
+			status = domain.StatusRecovered
+		}
+		s.Save(&domain.Transaction{ID: fmt.Sprintf("txn_sla_if_%d", i), DeclineCode: "insufficient_funds", DeclineCategory: domain.SoftDecline, Status: status})
+	}
+
+	w := get(mux, "/api/analytics/sla")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var resp struct {
+		ByDecline []domain.SLAStats `json:"by_decline"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	byCode := map[string]domain.SLAStats{}
+	for _, st := range resp.ByDecline {
+		byCode[st.DeclineCode] = st
+	}
+
+	above := byCode["issuer_timeout"]
+	if above.ActualRecoveryRate != 75 || above.TargetRecoveryRate != 68 || !above.MeetsTarget {
+		t.Errorf("expected issuer_timeout to meet target, got %+v", above)
+	}
+	below := byCode["insufficient_funds"]
+	if below.ActualRecoveryRate != 25 || below.TargetRecoveryRate != 42 || below.MeetsTarget {
+		t.Errorf("expected insufficient_funds to miss target, got %+v", below)
+	}
+	if below.Completed != 4 {
+		t.Errorf("expected 4 completed, got %d", below.Completed)
+	}
+	if above.NoData || below.NoData {
+		t.Error("expected codes with completed transactions to have data")
+	}
+
+	// do_not_honor only has a pending transaction: no data, not a miss.
+	s.Save(&domain.Transaction{ID: "txn_sla_pending", DeclineCode: "do_not_honor", DeclineCategory: domain.SoftDecline, Status: domain.StatusScheduled})
+	w = get(mux, "/api/analytics/sla")
+	resp.ByDecline = nil
+	json.NewDecoder(w.Body).Decode(&resp)
+	var pending *domain.SLAStats
+	for i, st := range resp.ByDecline {
+		if st.DeclineCode == "do_not_honor" {
+			pending = &resp.ByDecline[i]
+		}
+	}
+	if pending == nil || !pending.NoData || pending.MeetsTarget {
+		t.Errorf("expected do_not_honor to be flagged no_data, got %+v", pending)
+	}
+}
+
+func TestOverviewHandler_ContentNegotiation(t *testing.T) {
+	mux, _ := setupTestServer()
+	postJSON(mux, "/api/transactions", domain.SubmitRequest{
+		TransactionID: "txn_neg_001", MerchantID: "m1", CustomerID: "c1", AmountCents: 1000, Currency: "USD",
+		DeclineCode: "issuer_timeout", OriginalProcessor: "stripe_latam",
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/analytics/overview", nil)
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected application/json, got %q", ct)
+	}
+	var overview domain.AnalyticsOverview
+	if err := json.NewDecoder(w.Body).Decode(&overview); err != nil {
+		t.Fatalf("expected JSON body: %v", err)
+	}
+	if overview.TotalTransactions != 1 {
+		t.Errorf("expected 1 transaction, got %d", overview.TotalTransactions)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/analytics/overview", nil)
+	req.Header.Set("Accept", "text/plain")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("expected text/plain, got %q", ct)
+	}
+	body := w.Body.String()
+	for _, want := range []string{"total_transactions    1", "soft_declines", "recovery_rate_pct", "efficiency_rate_pct"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected plaintext to contain %q, got:\n%s", want, body)
+		}
+	}
+}
+
+func TestInjectAttemptHandler(t *testing.T) {
+	mux, _ := setupTestServer()
+	postJSON(mux, "/api/transactions", domain.SubmitRequest{
+		TransactionID: "txn_inject_001", AmountCents: 5000, Currency: "USD",
+		CustomerID: "c1", MerchantID: "m1", OriginalProcessor: "stripe_latam",
+		DeclineCode: "insufficient_funds",
+	})
+
+	w := postJSON(mux, "/api/transactions/txn_inject_001/inject-attempt", domain.RetryAttempt{
+		Success: false, ResponseCode: "51", ResponseMsg: "Insufficient funds",
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var tx domain.Transaction
+	json.NewDecoder(w.Body).Decode(&tx)
+	if tx.Status != domain.StatusRetrying {
+		t.Errorf("expected retrying after injected failure, got %s", tx.Status)
+	}
+	if len(tx.RetryAttempts) != 1 || tx.RetryAttempts[0].ResponseCode != "51" {
+		t.Errorf("expected injected attempt with code 51, got %+v", tx.RetryAttempts)
+	}
+
+	w = postJSON(mux, "/api/transactions/txn_inject_001/inject-attempt", domain.RetryAttempt{
+		Success: true, ResponseCode: "00", ResponseMsg: "Approved",
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	tx = domain.Transaction{}
+	json.NewDecoder(w.Body).Decode(&tx)
+	if tx.Status != domain.StatusRecovered {
+		t.Errorf("expected recovered after injected success, got %s", tx.Status)
+	}
+	if len(tx.RetryAttempts) != 2 || tx.RetryAttempts[1].AttemptNumber != 2 {
+		t.Errorf("expected second attempt to be recorded, got %+v", tx.RetryAttempts)
+	}
+
+	w = get(mux, "/api/webhooks/events")
+	var events struct {
+		Events []domain.WebhookEvent `json:"events"`
+	}
+	json.NewDecoder(w.Body).Decode(&events)
+	var succeeded bool
+	for _, ev := range events.Events {
+		if ev.EventType == domain.EventRetrySucceeded {
+			succeeded = true
+		}
+	}
+	if !succeeded {
+		t.Error("expected retry.succeeded webhook for injected success")
+	}
+}
+
+func TestInjectAttemptHandler_Disabled(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s := store.New()
+	notifier := webhook.NewNotifier(logger)
+	engine := retry.NewEngine(s, retry.NewSimulator(42, 0, retry.SeedShared), notifier, logger)
+	txHandler := NewTransactionHandler(engine, s, notifier, logger)
+
+	mux := NewRouter()
+	mux.HandleFunc("POST /api/transactions/{id}/inject-attempt", txHandler.InjectAttempt)
+
+	w := postJSON(mux, "/api/transactions/txn_any/inject-attempt", domain.RetryAttempt{Success: true})
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 when injection is disabled, got %d", w.Code)
+	}
+}
+
+func TestBulkSubmitHandler_ItemCap(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s := store.New()
+	notifier := webhook.NewNotifier(logger)
+	engine := retry.NewEngine(s, retry.NewSimulator(42, 0, retry.SeedShared), notifier, logger)
+	txHandler := NewTransactionHandler(engine, s, notifier, logger)
+	txHandler.SetMaxBulkItems(3)
+
+	mux := NewRouter()
+	mux.HandleFunc("POST /api/transactions/bulk", txHandler.BulkSubmit)
+
+	batch := func(n int) []domain.SubmitRequest {
+		reqs := make([]domain.SubmitRequest, n)
+		for i := range reqs {
+			reqs[i] = domain.SubmitRequest{
+				TransactionID: fmt.Sprintf("txn_bulk_%d_%d", n, i), AmountCents: 10000, Currency: "USD",
+				CustomerID: "c1", OriginalProcessor: "stripe_latam", DeclineCode: "insufficient_funds",
+			}
+		}
+		return reqs
+	}
+
+	w := postJSON(mux, "/api/transactions/bulk", batch(4))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 over the cap, got %d: %s", w.Code, w.Body.String())
+	}
+	if n := s.Count(); n != 0 {
+		t.Fatalf("expected no transactions from a rejected batch, got %d", n)
+	}
+
+	atCap := batch(3)
+	atCap[2].DeclineCode = "" // one invalid item does not fail the batch
+	w = postJSON(mux, "/api/transactions/bulk", atCap)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 at the cap, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Created int                `json:"created"`
+		Failed  int                `json:"failed"`
+		Results []bulkSubmitResult `json:"results"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Created != 2 || resp.Failed != 1 || len(resp.Results) != 3 {
+		t.Fatalf("expected 2 created and 1 failed, got %+v", resp)
+	}
+	if resp.Results[0].Status != http.StatusCreated || resp.Results[2].Status != http.StatusBadRequest {
+		t.Errorf("unexpected per-item statuses: %+v", resp.Results)
+	}
+	if !s.Exists(atCap[0].TransactionID) {
+		t.Error("expected the first item to be stored")
+	}
+
+	if w := postJSON(mux, "/api/transactions/bulk", []domain.SubmitRequest{}); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an empty batch, got %d", w.Code)
+	}
+}
+
+func TestPurgeHandler(t *testing.T) {
+	mux, s := setupTestServer()
+	now := time.Now().UTC()
+	s.Save(&domain.Transaction{ID: "txn_purge_old", Status: domain.StatusFailedFinal, UpdatedAt: now.Add(-1000 * time.Hour)})
+	s.Save(&domain.Transaction{ID: "txn_purge_pending", Status: domain.StatusScheduled, UpdatedAt: now.Add(-1000 * time.Hour)})
+	s.Save(&domain.Transaction{ID: "txn_purge_recent", Status: domain.StatusFailedFinal, UpdatedAt: now})
+	s.Save(&domain.Transaction{ID: "txn_purge_unconfirmed", Status: domain.StatusPendingConfirmation, UpdatedAt: now.Add(-1000 * time.Hour)})
+
+	w := postJSON(mux, "/api/admin/purge", domain.PurgeRequest{OlderThan: "720h", Status: domain.StatusFailedFinal})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp map[string]any
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp["purged"] != float64(1) {
+		t.Errorf("expected 1 purged, got %v", resp["purged"])
+	}
+	if s.Exists("txn_purge_old") {
+		t.Error("expected old terminal transaction to be purged")
+	}
+	if !s.Exists("txn_purge_pending") || !s.Exists("txn_purge_recent") {
+		t.Error("expected pending and recent transactions to survive")
+	}
+
+	// Without a status filter, recoveries awaiting /confirm are still kept
+	if w := postJSON(mux, "/api/admin/purge", domain.PurgeRequest{OlderThan: "720h"}); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if !s.Exists("txn_purge_unconfirmed") {
+		t.Error("expected pending_confirmation transaction to survive an unfiltered purge")
+	}
+
+	for _, req := range []domain.PurgeRequest{
+		{OlderThan: "soon"},
+		{OlderThan: "-1h"},
+		{OlderThan: "720h", Status: domain.StatusScheduled},
+		{OlderThan: "720h", Status: domain.StatusPendingConfirmation},
+	} {
+		if w := postJSON(mux, "/api/admin/purge", req); w.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for %+v, got %d", req, w.Code)
+		}
+	}
+}
+
+func TestPendingIndexHandler(t *testing.T) {
+	mux, s := setupTestServer()
+	s.Save(&domain.Transaction{ID: "txn_idx_b", Status: domain.StatusRetrying})
+	s.Save(&domain.Transaction{ID: "txn_idx_a", Status: domain.StatusScheduled})
+	s.Save(&domain.Transaction{ID: "txn_idx_done", Status: domain.StatusRecovered})
+
+	w := get(mux, "/api/admin/pending-index")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Pending []pendingIndexEntry `json:"pending"`
+		Count   int                 `json:"count"`
+		Drift   []string            `json:"drift"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	want := []pendingIndexEntry{
+		{ID: "txn_idx_a", Status: domain.StatusScheduled},
+		{ID: "txn_idx_b", Status: domain.StatusRetrying},
+	}
+	if !slices.Equal(resp.Pending, want) || resp.Count != 2 {
+		t.Errorf("expected %v, got %v (count %d)", want, resp.Pending, resp.Count)
+	}
+	if resp.Drift == nil || len(resp.Drift) != 0 {
+		t.Errorf("expected empty drift list, got %v", resp.Drift)
+	}
+}
+
+func TestShadowHandler_AggressiveStrategyProjection(t *testing.T) {
+	mux, s := setupTestServer()
+	for i := range 20 {
+		status := domain.StatusFailedFinal
+		if i < 4 {
+			status = domain.StatusRecovered
+		}
+		s.Save(&domain.Transaction{
+			ID: fmt.Sprintf("txn_shadow_%02d", i), DeclineCode: "do_not_honor", DeclineCategory: domain.SoftDecline,
+			OriginalProcessor: "stripe_latam", Status: status, CreatedAt: time.Now().UTC(),
+		})
+	}
+	before := *domain.GetRetryStrategy("do_not_honor")
+
+	w := postJSON(mux, "/api/analytics/shadow", domain.ShadowRequest{
+		DeclineCode: "do_not_honor",
+		Strategy: domain.StrategyConfig{
+			MaxAttempts:     5,
+			Delays:          []string{"1h", "2h", "4h", "8h", "16h"},
+			PerAttemptRates: []float64{0.5, 0.5, 0.5, 0.5, 0.5},
+		},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var report domain.ShadowReport
+	json.NewDecoder(w.Body).Decode(&report)
+
+	if report.Evaluated != 20 || report.ActualRecoveryRate != 20 {
+		t.Errorf("expected 20 evaluated at 20%% actual, got %+v", report)
+	}
+	if report.ProjectedRecoveryRate <= report.ActualRecoveryRate {
+		t.Errorf("expected aggressive shadow to project above actual, got %.1f%% vs %.1f%%",
+			report.ProjectedRecoveryRate, report.ActualRecoveryRate)
+	}
+	if len(report.ProjectedByAttempt) != 5 {
+		t.Errorf("expected per-attempt projection for 5 attempts, got %v", report.ProjectedByAttempt)
+	}
+
+	after := domain.GetRetryStrategy("do_not_honor")
+	if after.MaxAttempts != before.MaxAttempts || after.PerAttemptRates[0] != before.PerAttemptRates[0] {
+		t.Errorf("expected live strategy unchanged, got %+v", after)
+	}
+	if tx, _ := s.Get("txn_shadow_10"); tx.Status != domain.StatusFailedFinal || len(tx.RetryAttempts) != 0 {
+		t.Errorf("expected stored transaction untouched, got %+v", tx)
+	}
+
+	w = postJSON(mux, "/api/analytics/shadow", domain.ShadowRequest{DeclineCode: "stolen_card"})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for code without a strategy, got %d", w.Code)
+	}
+}
+
+func TestServiceUnavailable_RetryAfterHeader(t *testing.T) {
+	mux, _ := setupTestServer()
+	postJSON(mux, "/api/admin/readonly", map[string]bool{"enabled": true})
+
+	w := postJSON(mux, "/api/transactions", domain.SubmitRequest{TransactionID: "txn_ra"})
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", w.Code)
+	}
+	if secs, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || secs <= 0 {
+		t.Errorf("expected positive integer Retry-After, got %q", w.Header().Get("Retry-After"))
+	}
+
+	h := NewHealthHandler()
+	w = httptest.NewRecorder()
+	h.Ready(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("expected not-ready 503 with Retry-After, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
+
+func patchJSON(mux http.Handler, path string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPatch, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	return w
+}
+
+func TestPatchStrategyHandler(t *testing.T) {
+	mux, _ := setupTestServer()
+	before := *domain.GetRetryStrategy("processor_error")
+	t.Cleanup(func() {
+		target := before.TargetRecoveryRate
+		domain.PatchStrategy("processor_error", domain.StrategyPatch{TargetRecoveryRate: &target})
+	})
+
+	w := patchJSON(mux, "/api/config/strategies/processor_error", `{"target_recovery_rate": 0}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	after := domain.GetRetryStrategy("processor_error")
+	if after.TargetRecoveryRate != 0 {
+		t.Errorf("expected target_recovery_rate set to 0, got %v", after.TargetRecoveryRate)
+	}
+	if after.MaxAttempts != before.MaxAttempts || after.Description != before.Description {
+		t.Errorf("expected omitted fields unchanged, got %+v", after)
+	}
+
+	// Reducing attempts without matching delays/rates is rejected as a whole.
+	if w := patchJSON(mux, "/api/config/strategies/processor_error", `{"max_attempts": 1}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for inconsistent patch, got %d", w.Code)
+	}
+	if w := patchJSON(mux, "/api/config/strategies/stolen_card", `{"max_attempts": 1}`); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for code without a strategy, got %d", w.Code)
+	}
+}
+
+func TestAttemptTimeout_RecordedAndCounted(t *testing.T) {
+	mux, s := setupTestServer()
+	t.Cleanup(func() {
+		off := "0s"
+		domain.PatchStrategy("issuer_timeout", domain.StrategyPatch{AttemptTimeout: &off})
+	})
+
+	// A 1ns timeout makes every simulated processor call time out.
+	if w := patchJSON(mux, "/api/config/strategies/issuer_timeout", `{"attempt_timeout": "1ns"}`); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	postJSON(mux, "/api/transactions", domain.SubmitRequest{
+		TransactionID: "txn_timeout", AmountCents: 10000, Currency: "USD",
+		CustomerID: "c1", OriginalProcessor: "stripe_latam", DeclineCode: "issuer_timeout",
+	})
+	postJSON(mux, "/api/transactions/txn_timeout/retry", nil)
+
+	tx, _ := s.Get("txn_timeout")
+	if len(tx.RetryAttempts) != 1 {
+		t.Fatalf("expected 1 attempt, got %d", len(tx.RetryAttempts))
+	}
+	if a := tx.RetryAttempts[0]; !a.TimedOut || a.Success || a.ResponseCode != "TIMEOUT" {
+		t.Errorf("expected a failed timed-out attempt, got %+v", a)
+	}
+	if tx.Status != domain.StatusRetrying {
+		t.Errorf("expected timeout to count as a failure leaving retries pending, got %s", tx.Status)
+	}
+
+	var overview domain.AnalyticsOverview
+	json.NewDecoder(get(mux, "/api/analytics/overview").Body).Decode(&overview)
+	if overview.TimedOutAttempts != 1 || overview.SuccessfulAttempts != 0 {
+		t.Errorf("expected 1 timed-out and 0 successful attempts, got %+v", overview)
+	}
+
+	var byAttempt struct {
+		ByAttempt []domain.AttemptStats `json:"by_attempt"`
+	}
+	json.NewDecoder(get(mux, "/api/analytics/by-attempt").Body).Decode(&byAttempt)
+	if len(byAttempt.ByAttempt) != 1 || byAttempt.ByAttempt[0].Timeouts != 1 {
+		t.Errorf("expected 1 timeout on attempt 1, got %+v", byAttempt.ByAttempt)
+	}
+
+	if w := patchJSON(mux, "/api/config/strategies/issuer_timeout", `{"attempt_timeout": "-1s"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a negative attempt_timeout, got %d", w.Code)
+	}
+}
+
+func TestRetryHandler_IdempotencyKey(t *testing.T) {
+	mux, s := setupTestServer()
+
+	postJSON(mux, "/api/transactions", domain.SubmitRequest{
+		TransactionID: "txn_idem", AmountCents: 10000, Currency: "USD",
+		CustomerID: "c1", OriginalProcessor: "stripe_latam", DeclineCode: "authentication_failed",
+	})
+
+	retryWithKey := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/transactions/txn_idem/retry", nil)
+		req.Header.Set("Idempotency-Key", key)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	first := retryWithKey("click-1")
+	second := retryWithKey("click-1")
+	if first.Code != second.Code || first.Body.String() != second.Body.String() {
+		t.Errorf("expected the repeated key to return the first response, got %d %s vs %d %s",
+			first.Code, first.Body.String(), second.Code, second.Body.String())
+	}
+	if second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("expected Idempotent-Replayed header on the repeated request")
+	}
+	tx, _ := s.Get("txn_idem")
+	if len(tx.RetryAttempts) != 1 {
+		t.Fatalf("expected 1 attempt after a repeated key, got %d", len(tx.RetryAttempts))
+	}
+
+	if tx.Status != domain.StatusRetrying {
+		t.Fatalf("expected the seeded first attempt to fail, got status %s", tx.Status)
+	}
+	retryWithKey("click-2")
+	tx, _ = s.Get("txn_idem")
+	if len(tx.RetryAttempts) != 2 {
+		t.Errorf("expected a distinct key to run a new attempt, got %d attempts", len(tx.RetryAttempts))
+	}
+}
+
+func TestIdempotencyCache_ExpiryAndAbandonedRequests(t *testing.T) {
+	c := newIdempotencyCache(time.Minute)
+	now := time.Now()
+	first := idempotencyKey{txID: "txn_1", key: "k"}
+	second := idempotencyKey{txID: "txn_2", key: "k"}
+
+	e1, _ := c.begin(first, now)
+	c.finish(first, e1, http.StatusOK, "ok", now)
+	e2, _ := c.begin(second, now.Add(30*time.Second))
+	c.finish(second, e2, http.StatusOK, "ok", now.Add(30*time.Second))
+
+	// Only the first entry has expired by now
+	if _, owner := c.begin(first, now.Add(61*time.Second)); !owner {
+		t.Error("expected an expired key to run again")
+	}
+	if _, owner := c.begin(second, now.Add(61*time.Second)); owner {
+		t.Error("expected an unexpired key to replay")
+	}
+	if len(c.expiries) != 1 {
+		t.Errorf("expected the expired entry dequeued, got %d queued", len(c.expiries))
+	}
+
+	// A request that ends without a response releases waiters with a 500
+	// and frees the key
+	panicked := idempotencyKey{txID: "txn_3", key: "k"}
+	e3, _ := c.begin(panicked, now)
+	waiter, owner := c.begin(panicked, now)
+	if owner {
+		t.Fatal("expected the second request to wait")
+	}
+	func() {
+		defer func() { recover() }()
+		defer c.finish(panicked, e3, 0, nil, now)
+		panic("retry failed")
+	}()
+	select {
+	case <-waiter.done:
+		if waiter.status != http.StatusInternalServerError {
+			t.Errorf("expected waiters to get 500, got %d", waiter.status)
+		}
+	default:
+		t.Fatal("expected waiters to be released")
+	}
+	if _, owner := c.begin(panicked, now); !owner {
+		t.Error("expected the key of an abandoned request to run again")
+	}
+}
+
+func TestDeleteIdempotencyKeys_AllowsFreshRetry(t *testing.T) {
+	mux, s := setupTestServer()
+
+	for _, id := range []string{"txn_idem_del_1", "txn_idem_del_2"} {
+		postJSON(mux, "/api/transactions", domain.SubmitRequest{
+			TransactionID: id, AmountCents: 10000, Currency: "USD",
+			CustomerID: "c1", OriginalProcessor: "stripe_latam", DeclineCode: "authentication_failed",
+		})
+	}
+
+	do := func(method, path, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+	attempts := func(id string) int {
+		tx, _ := s.Get(id)
+		return len(tx.RetryAttempts)
+	}
+
+	do(http.MethodPost, "/api/transactions/txn_idem_del_1/retry", "click-1")
+	if w := do(http.MethodDelete, "/api/idempotency-keys/click-1", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	w := do(http.MethodPost, "/api/transactions/txn_idem_del_1/retry", "click-1")
+	if w.Header().Get("Idempotent-Replayed") != "" || attempts("txn_idem_del_1") != 2 {
+		t.Errorf("expected a cleared key to run a fresh attempt, got %d attempts", attempts("txn_idem_del_1"))
+	}
+	if w := do(http.MethodDelete, "/api/idempotency-keys/unknown", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown key, got %d", w.Code)
+	}
+
+	do(http.MethodPost, "/api/transactions/txn_idem_del_2/retry", "click-2")
+	w = do(http.MethodDelete, "/api/idempotency-keys", "")
+	var resp struct {
+		Deleted int `json:"deleted"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || resp.Deleted != 2 {
+		t.Fatalf("expected 2 keys cleared, got %d %s", w.Code, w.Body.String())
+	}
+	do(http.MethodPost, "/api/transactions/txn_idem_del_2/retry", "click-2")
+	if attempts("txn_idem_del_2") != 2 {
+		t.Errorf("expected a fresh attempt after clearing all keys, got %d attempts", attempts("txn_idem_del_2"))
+	}
+}
+
+func TestGetHandler_EffectiveSchedule(t *testing.T) {
+	mux, s := setupTestServer()
+	now := time.Now().UTC()
+	s.Save(&domain.Transaction{
+		ID: "txn_sched", DeclineCode: "issuer_timeout", DeclineCategory: domain.SoftDecline,
+		Status: domain.StatusRetrying,
+		RetryPlan: &domain.RetryPlan{
+			MaxAttempts:    3,
+			ScheduledTimes: []time.Time{now.Add(-2 * time.Hour), now.Add(-time.Hour), now.Add(time.Hour)},
+			Processors:     []string{"stripe_latam", "adyen_apac", "dlocal_br"},
+		},
+		RetryAttempts: []domain.RetryAttempt{
+			{AttemptNumber: 1, Processor: "stripe_latam", ResponseCode: "DECLINE_issuer_timeout", ExecutedAt: now.Add(-2 * time.Hour)},
+		},
+	})
+
+	w := get(mux, "/api/transactions/txn_sched")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var resp struct {
+		EffectiveSchedule []domain.ScheduleSlot `json:"effective_schedule"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	if len(resp.EffectiveSchedule) != 3 {
+		t.Fatalf("expected 3 slots, got %d", len(resp.EffectiveSchedule))
+	}
+	first := resp.EffectiveSchedule[0]
+	if first.Status != domain.SlotExecuted || first.Result == nil || first.Result.ResponseCode != "DECLINE_issuer_timeout" {
+		t.Errorf("expected slot 1 executed and linked to its attempt, got %+v", first)
+	}
+	if got := resp.EffectiveSchedule[1]; got.Status != domain.SlotDue || got.Result != nil {
+		t.Errorf("expected slot 2 due, got %+v", got)
+	}
+	if got := resp.EffectiveSchedule[2]; got.Status != domain.SlotPending || got.Processor != "dlocal_br" {
+		t.Errorf("expected slot 3 pending on dlocal_br, got %+v", got)
+	}
+}
@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// serviceName identifies this service in health responses.
+const serviceName = "zenithpay-retry-engine"
+
+// HealthHandler serves liveness and readiness probes.
+// Liveness is always ok while the process runs; readiness reports whether
+// startup has completed and the service should receive traffic.
+type HealthHandler struct {
+	ready atomic.Bool
+}
+
+// NewHealthHandler creates a health handler that starts out not ready.
+func NewHealthHandler() *HealthHandler {
+	return &HealthHandler{}
+}
+
+// SetReady marks the service as ready (or not ready) to receive traffic.
+func (h *HealthHandler) SetReady(ready bool) {
+	h.ready.Store(ready)
+}
+
+// Health handles GET /health - liveness probe.
+func (h *HealthHandler) Health(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok", "service": serviceName})
+}
+
+// Ready handles GET /readyz - readiness probe. Returns 503 until startup completes.
+func (h *HealthHandler) Ready(w http.ResponseWriter, r *http.Request) {
+	if !h.ready.Load() {
+		writeUnavailable(w, map[string]string{"status": "not_ready", "service": serviceName})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready", "service": serviceName})
+}
@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// idempotencyTTL is how long a retry response is replayed for a repeated
+// Idempotency-Key.
+const idempotencyTTL = 10 * time.Minute
+
+// idempotencyKey scopes a client key to one transaction, so the same key sent
+// for two transactions runs both.
+type idempotencyKey struct {
+	txID string
+	key  string
+}
+
+// idempotentResponse is the recorded response for one key. done is closed
+// once status and body are set; requests racing the first one wait on it.
+type idempotentResponse struct {
+	done    chan struct{}
+	status  int
+	body    any
+	expires time.Time
+}
+
+// idempotencyCache remembers responses by (transaction, Idempotency-Key).
+// Every entry lives for the same ttl, so finished entries expire in the order
+// they finished; expiries keeps them in that order and begin prunes from its
+// front, touching only the entries that actually expired.
+type idempotencyCache struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	entries  map[idempotencyKey]*idempotentResponse
+	expiries []idempotencyExpiry
+}
+
+// idempotencyExpiry queues a finished entry for pruning.
+type idempotencyExpiry struct {
+	key   idempotencyKey
+	entry *idempotentResponse
+}
+
+func newIdempotencyCache(ttl time.Duration) *idempotencyCache {
+	return &idempotencyCache{
+		ttl:     ttl,
+		entries: make(map[idempotencyKey]*idempotentResponse),
+	}
+}
+
+// begin returns the entry for key. owner is true when the caller created it
+// and must run the request and call finish, deferred so waiters are released
+// even if the request panics; otherwise the caller should wait on entry.done
+// and replay the recorded response.
+func (c *idempotencyCache) begin(key idempotencyKey, now time.Time) (entry *idempotentResponse, owner bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.prune(now)
+	if e, ok := c.entries[key]; ok {
+		return e, false
+	}
+	e := &idempotentResponse{done: make(chan struct{})}
+	c.entries[key] = e
+	return e, true
+}
+
+// prune drops expired entries from the front of the expiry queue. Entries
+// already removed or replaced under the same key are skipped. Callers hold c.mu.
+func (c *idempotencyCache) prune(now time.Time) {
+	n := 0
+	for _, exp := range c.expiries {
+		if !now.After(exp.entry.expires) {
+			break
+		}
+		if c.entries[exp.key] == exp.entry {
+			delete(c.entries, exp.key)
+		}
+		n++
+	}
+	c.expiries = c.expiries[n:]
+}
+
+// finish records the response for an entry returned by begin and releases
+// any waiters. A zero status means the request ended without a response
+// (it panicked): waiters get a 500 and the key is dropped, so the next
+// request with it runs again.
+func (c *idempotencyCache) finish(key idempotencyKey, entry *idempotentResponse, status int, body any, now time.Time) {
+	c.mu.Lock()
+	if status == 0 {
+		entry.status = http.StatusInternalServerError
+		entry.body = errorBody("retry failed")
+		if c.entries[key] == entry {
+			delete(c.entries, key)
+		}
+	} else {
+		entry.status = status
+		entry.body = body
+		entry.expires = now.Add(c.ttl)
+		c.expiries = append(c.expiries, idempotencyExpiry{key: key, entry: entry})
+	}
+	c.mu.Unlock()
+	close(entry.done)
+}
+
+// remove drops every entry recorded under key, for any transaction, and
+// returns how many were dropped. A request still running under a removed
+// entry finishes normally, but later requests with the key run again.
+func (c *idempotencyCache) remove(key string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	for k := range c.entries {
+		if k.key == key {
+			delete(c.entries, k)
+			removed++
+		}
+	}
+	return removed
+}
+
+// clear drops every entry and returns how many were dropped.
+func (c *idempotencyCache) clear() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := len(c.entries)
+	c.entries = make(map[idempotencyKey]*idempotentResponse)
+	c.expiries = nil
+	return removed
+}
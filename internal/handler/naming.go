@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// Response key naming styles, selected with ?naming= or an Accept profile
+// (e.g. "Accept: application/json; profile=camel"). Snake case is the native
+// style of every response type.
+const (
+	namingSnake = "snake"
+	namingCamel = "camel"
+)
+
+// camelWriter marks a response whose JSON keys writeJSON rewrites to
+// camelCase.
+type camelWriter struct {
+	http.ResponseWriter
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (w *camelWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// responseNaming returns the naming style requested by r, or "" if the
+// naming query parameter names an unsupported style.
+func responseNaming(r *http.Request) string {
+	if naming := r.URL.Query().Get("naming"); naming != "" {
+		if naming != namingSnake && naming != namingCamel {
+			return ""
+		}
+		return naming
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		if _, params, err := mime.ParseMediaType(accept); err == nil && params["profile"] == namingCamel {
+			return namingCamel
+		}
+	}
+	return namingSnake
+}
+
+// writeCamelJSON encodes data with its field names converted to camelCase.
+// Field names are the JSON names of struct fields and the keys of
+// map[string]any values, which handlers use as ad hoc objects. Keys of other
+// maps are data, such as decline codes or webhook_routes event types, and are
+// kept as is so clients can send them back to the API.
+func writeCamelJSON(w http.ResponseWriter, data any) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber() // keep int64 cents exact
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(camelizeFields(v, reflect.ValueOf(data)))
+}
+
+// anyMapType is the map type whose keys camelizeFields treats as field names.
+var anyMapType = reflect.TypeFor[map[string]any]()
+
+// camelizeFields rewrites the field names in v, the decoded JSON encoding of
+// src, walking both together so each object is matched with the Go value it
+// came from.
+func camelizeFields(v any, src reflect.Value) any {
+	for src.IsValid() && (src.Kind() == reflect.Pointer || src.Kind() == reflect.Interface) {
+		src = src.Elem()
+	}
+	if !src.IsValid() {
+		return v
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		switch src.Kind() {
+		case reflect.Struct:
+			fields := jsonFields(src)
+			for k, val := range v {
+				out[camelCase(k)] = camelizeFields(val, fields[k])
+			}
+		case reflect.Map:
+			rename := src.Type() == anyMapType
+			for k, val := range v {
+				elem := src.MapIndex(reflect.ValueOf(k).Convert(src.Type().Key()))
+				if rename {
+					k = camelCase(k)
+				}
+				out[k] = camelizeFields(val, elem)
+			}
+		default:
+			return v
+		}
+		return out
+	case []any:
+		if src.Kind() != reflect.Slice && src.Kind() != reflect.Array {
+			return v
+		}
+		for i := range v {
+			if i < src.Len() {
+				v[i] = camelizeFields(v[i], src.Index(i))
+			}
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// jsonFields maps the JSON names of a struct's fields to their values,
+// including the promoted fields of embedded structs.
+func jsonFields(src reflect.Value) map[string]reflect.Value {
+	fields := make(map[string]reflect.Value)
+	t := src.Type()
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" || (!f.IsExported() && !f.Anonymous) {
+			continue
+		}
+		if f.Anonymous && name == "" {
+			embedded := src.Field(i)
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.IsValid() && embedded.Kind() == reflect.Struct {
+				for k, v := range jsonFields(embedded) {
+					if _, ok := fields[k]; !ok {
+						fields[k] = v
+					}
+				}
+				continue
+			}
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = src.Field(i)
+	}
+	return fields
+}
+
+// camelCase converts a snake_case key, e.g. "transaction_id" to
+// "transactionId". Keys without underscores are returned unchanged.
+func camelCase(key string) string {
+	if !strings.Contains(key, "_") {
+		return key
+	}
+	var b strings.Builder
+	upper := false
+	for i, c := range key {
+		switch {
+		case c == '_' && i > 0:
+			upper = true
+		case upper && 'a' <= c && c <= 'z':
+			b.WriteRune(c - 'a' + 'A')
+			upper = false
+		default:
+			b.WriteRune(c)
+			upper = false
+		}
+	}
+	return b.String()
+}
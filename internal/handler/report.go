@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/eabugauch/zenithpay-retry/internal/domain"
+	"github.com/eabugauch/zenithpay-retry/internal/store"
+)
+
+// reportTemplate renders a single transaction for support staff. Every value
+// goes through html/template, so merchant-supplied fields are escaped. The
+// markup is kept XHTML-compatible (closed tags, quoted attributes).
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"ts": formatReportTime,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8" />
+<title>Transaction {{.Tx.ID}}</title>
+</head>
+<body>
+<h1>Transaction {{.Tx.ID}}</h1>
+<table>
+<tr><th>Status</th><td>{{.Tx.Status}}</td></tr>
+<tr><th>Amount</th><td>{{.Tx.AmountCents}} {{.Tx.Currency}}</td></tr>
+<tr><th>Merchant</th><td>{{.Tx.MerchantID}}</td></tr>
+<tr><th>Customer</th><td>{{.Tx.CustomerID}}</td></tr>
+<tr><th>Processor</th><td>{{.Tx.OriginalProcessor}}</td></tr>
+<tr><th>Decline code</th><td>{{.Tx.DeclineCode}} ({{.Tx.DeclineCategory}})</td></tr>
+<tr><th>Created</th><td>{{ts .Tx.CreatedAt}}</td></tr>
+<tr><th>Updated</th><td>{{ts .Tx.UpdatedAt}}</td></tr>
+{{- if .Tx.ResolutionReason}}
+<tr><th>Resolution reason</th><td>{{.Tx.ResolutionReason}}</td></tr>
+{{- end}}
+</table>
+<h2>Schedule</h2>
+{{- if .Schedule}}
+<table>
+<tr><th>Attempt</th><th>Scheduled at</th><th>Processor</th><th>Status</th></tr>
+{{- range .Schedule}}
+<tr><td>{{.AttemptNumber}}</td><td>{{ts .ScheduledAt}}</td><td>{{.Processor}}</td><td>{{.Status}}</td></tr>
+{{- end}}
+</table>
+{{- else}}
+<p>No retries scheduled.</p>
+{{- end}}
+<h2>Attempts</h2>
+{{- if .Tx.RetryAttempts}}
+<table>
+<tr><th>Attempt</th><th>Executed at</th><th>Processor</th><th>Result</th><th>Response</th></tr>
+{{- range .Tx.RetryAttempts}}
+<tr><td>{{.AttemptNumber}}</td><td>{{ts .ExecutedAt}}</td><td>{{.Processor}}</td><td>{{if .Success}}approved{{else if .TimedOut}}timed out{{else}}declined{{end}}</td><td>{{.ResponseCode}} {{.ResponseMsg}}</td></tr>
+{{- end}}
+</table>
+{{- else}}
+<p>No attempts executed.</p>
+{{- end}}
+<h2>Webhook events</h2>
+{{- if .Events}}
+<table>
+<tr><th>Event</th><th>Type</th><th>Attempt</th><th>Timestamp</th><th>Delivery</th></tr>
+{{- range .Events}}
+<tr><td>{{.ID}}</td><td>{{.EventType}}</td><td>{{.AttemptNumber}}</td><td>{{ts .Timestamp}}</td><td>{{if .Duplicate}}duplicate{{else if .Filtered}}filtered{{else}}sent{{end}}</td></tr>
+{{- end}}
+</table>
+{{- else}}
+<p>No webhook events.</p>
+{{- end}}
+<p>Generated {{ts .GeneratedAt}}</p>
+</body>
+</html>
+`))
+
+// reportData is the input to reportTemplate.
+type reportData struct {
+	Tx          *domain.Transaction
+	Schedule    []domain.ScheduleSlot
+	Events      []domain.WebhookEvent
+	GeneratedAt time.Time
+}
+
+// formatReportTime renders timestamps in the report as RFC 3339 UTC.
+func formatReportTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// Report handles GET /api/transactions/{id}/report.html - a printable HTML
+// page with the transaction, its effective schedule, attempts and webhook events.
+func (h *TransactionHandler) Report(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		writeError(w, http.StatusBadRequest, "transaction id is required")
+		return
+	}
+
+	tx, err := h.store.Get(id)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "transaction not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to retrieve transaction")
+		return
+	}
+
+	now := time.Now().UTC()
+	data := reportData{
+		Tx:          tx,
+		Schedule:    effectiveSchedule(tx, now),
+		Events:      h.notifier.GetEventsByTransaction(tx.ID),
+		GeneratedAt: now,
+	}
+	var buf bytes.Buffer
+	if err := reportTemplate.Execute(&buf, data); err != nil {
+		h.logger.Error("failed to render transaction report", "transaction_id", tx.ID, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to render report")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	buf.WriteTo(w)
+}
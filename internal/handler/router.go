@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Router wraps http.ServeMux and tracks which methods were registered, so a
+// request whose path matches a route under a different method gets a JSON 405
+// with an accurate Allow header instead of the mux's plain-text response.
+type Router struct {
+	mux     *http.ServeMux
+	methods map[string]struct{}
+}
+
+// NewRouter creates an empty router.
+func NewRouter() *Router {
+	return &Router{
+		mux:     http.NewServeMux(),
+		methods: make(map[string]struct{}),
+	}
+}
+
+// HandleFunc registers a handler for a "METHOD /path" pattern.
+func (rt *Router) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	if method, _, ok := strings.Cut(pattern, " "); ok {
+		rt.methods[method] = struct{}{}
+	}
+	rt.mux.HandleFunc(pattern, handler)
+}
+
+// ServeHTTP dispatches to the matching route, or returns 405 when the path is
+// known but the method is not. Requests asking for camelCase keys get a writer
+// that writeJSON recognizes.
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch responseNaming(r) {
+	case "":
+		writeError(w, http.StatusBadRequest, "naming must be \""+namingSnake+"\" or \""+namingCamel+"\"")
+		return
+	case namingCamel:
+		w = &camelWriter{ResponseWriter: w}
+	}
+
+	if _, pattern := rt.mux.Handler(r); pattern == "" {
+		if allowed := rt.allowedMethods(r); len(allowed) > 0 {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			writeError(w, http.StatusMethodNotAllowed, "method "+r.Method+" not allowed")
+			return
+		}
+	}
+	rt.mux.ServeHTTP(w, r)
+}
+
+// allowedMethods probes the mux with each registered method to find which
+// ones have a route for the request's path.
+func (rt *Router) allowedMethods(r *http.Request) []string {
+	var allowed []string
+	for method := range rt.methods {
+		if method == r.Method {
+			continue
+		}
+		probe := r.Clone(r.Context())
+		probe.Method = method
+		if _, pattern := rt.mux.Handler(probe); pattern != "" {
+			allowed = append(allowed, method)
+		}
+	}
+	sort.Strings(allowed)
+	return allowed
+}
@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/eabugauch/zenithpay-retry/internal/domain"
+	"github.com/eabugauch/zenithpay-retry/internal/retry"
+)
+
+// SimulatorHandler handles load-testing controls for the payment simulator.
+type SimulatorHandler struct {
+	sim *retry.Simulator
+}
+
+// NewSimulatorHandler creates a new simulator handler.
+func NewSimulatorHandler(sim *retry.Simulator) *SimulatorHandler {
+	return &SimulatorHandler{sim: sim}
+}
+
+// SetLatency handles POST /api/simulator/latency - make every simulated
+// attempt through a processor sleep for ms milliseconds (at most
+// retry.MaxInjectedLatency) before returning; ms 0 removes the delay.
+func (h *SimulatorHandler) SetLatency(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+
+	var req domain.SimulatorLatencyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if !slices.Contains(domain.ListProcessors(), req.Processor) {
+		writeError(w, http.StatusBadRequest, "processor must be one of the configured processors")
+		return
+	}
+	if req.Ms == nil {
+		writeError(w, http.StatusBadRequest, "ms is required")
+		return
+	}
+	if err := h.sim.SetProcessorLatency(req.Processor, time.Duration(*req.Ms)*time.Millisecond); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	h.Latency(w, r)
+}
+
+// ClearLatency handles DELETE /api/simulator/latency - remove the injected
+// latency of every processor.
+func (h *SimulatorHandler) ClearLatency(w http.ResponseWriter, r *http.Request) {
+	h.sim.ClearProcessorLatency()
+	h.Latency(w, r)
+}
+
+// Latency handles GET /api/simulator/latency - the injected latency per
+// processor, in milliseconds.
+func (h *SimulatorHandler) Latency(w http.ResponseWriter, r *http.Request) {
+	latency := h.sim.ProcessorLatency()
+	ms := make(map[string]int64, len(latency))
+	for processor, d := range latency {
+		ms[processor] = d.Milliseconds()
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"latency_ms": ms})
+}
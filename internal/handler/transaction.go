@@ -1,10 +1,19 @@
 package handler
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/eabugauch/zenithpay-retry/internal/domain"
 	"github.com/eabugauch/zenithpay-retry/internal/retry"
@@ -15,12 +24,28 @@ import (
 // maxRequestBody limits request body size to prevent memory exhaustion (1MB).
 const maxRequestBody = 1 << 20
 
+// Page sizes for paginated transaction listing.
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// maxReplayEvents caps how many webhook events a single replay request re-delivers.
+const maxReplayEvents = 1000
+
+// defaultMaxBulkItems caps how many transactions one bulk submit may carry.
+const defaultMaxBulkItems = 500
+
 // TransactionHandler handles HTTP requests for transaction operations.
 type TransactionHandler struct {
 	engine   *retry.Engine
 	store    *store.Store
 	notifier *webhook.Notifier
 	logger   *slog.Logger
+
+	allowInject  bool              // enables POST /api/transactions/{id}/inject-attempt
+	maxBulkItems int               // item cap for POST /api/transactions/bulk
+	idempotency  *idempotencyCache // Idempotency-Key responses for POST /api/transactions/{id}/retry
 }
 
 // NewTransactionHandler creates a new transaction handler.
@@ -30,11 +55,34 @@ func NewTransactionHandler(engine *retry.Engine, s *store.Store, n *webhook.Noti
 		store:    s,
 		notifier: n,
 		logger:   logger,
+
+		maxBulkItems: defaultMaxBulkItems,
+		idempotency:  newIdempotencyCache(idempotencyTTL),
 	}
 }
 
+// SetAllowInject enables the attempt-injection endpoint. It is meant for test
+// and integration environments only and must be set before serving requests.
+func (h *TransactionHandler) SetAllowInject(allow bool) {
+	h.allowInject = allow
+}
+
+// SetMaxBulkItems sets how many transactions a bulk submit may carry (default
+// 500). Must be set before serving requests.
+func (h *TransactionHandler) SetMaxBulkItems(n int) {
+	h.maxBulkItems = n
+}
+
 // Submit handles POST /api/transactions - submit a failed transaction for retry evaluation.
+// With ?allow_update=true, a duplicate submission that differs only in
+// webhook_url updates the stored URL and returns 200 instead of 409. With
+// ?validate_webhook=true, the webhook URLs are probed after the transaction is
+// scheduled and the result is reported as webhook_reachable.
 func (h *TransactionHandler) Submit(w http.ResponseWriter, r *http.Request) {
+	if h.rejectIfReadOnly(w) {
+		return
+	}
+
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
 
 	var req domain.SubmitRequest
@@ -42,31 +90,150 @@ func (h *TransactionHandler) Submit(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
 		return
 	}
+	req.Language = requestLanguage(r)
 
-	if req.TransactionID == "" {
-		writeError(w, http.StatusBadRequest, "transaction_id is required")
+	resp, err := h.engine.Submit(req)
+	if err != nil {
+		if errors.Is(err, retry.ErrInvalidRequest) {
+			writeJSON(w, http.StatusBadRequest, validationErrorBody{
+				Error:  err.Error(),
+				Errors: retry.FieldErrors(err),
+			})
+			return
+		}
+		if errors.Is(err, store.ErrAlreadyExists) && r.URL.Query().Get("allow_update") == "true" {
+			tx, err := h.engine.UpdateWebhookURL(req)
+			if err != nil {
+				writeError(w, http.StatusConflict, err.Error())
+				return
+			}
+			writeJSON(w, http.StatusOK, tx)
+			return
+		}
+		writeError(w, http.StatusConflict, err.Error())
 		return
 	}
-	if req.DeclineCode == "" {
-		writeError(w, http.StatusBadRequest, "decline_code is required")
+
+	if r.URL.Query().Get("validate_webhook") == "true" {
+		if urls := webhookURLs(req); len(urls) > 0 {
+			reachable := true
+			for _, u := range urls {
+				reachable = h.notifier.Probe(r.Context(), u) && reachable
+			}
+			resp.WebhookReachable = &reachable
+		}
+	}
+
+	w.Header().Set("Content-Language", string(req.Language))
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// webhookURLs returns the distinct webhook URLs of a submit request, sorted.
+func webhookURLs(req domain.SubmitRequest) []string {
+	var urls []string
+	if req.WebhookURL != "" {
+		urls = append(urls, req.WebhookURL)
+	}
+	for _, u := range req.WebhookRoutes {
+		urls = append(urls, u)
+	}
+	slices.Sort(urls)
+	return slices.Compact(urls)
+}
+
+// bulkSubmitResult is the outcome of one item of a bulk submit, with the status
+// code the item would have received from POST /api/transactions.
+type bulkSubmitResult struct {
+	TransactionID string                 `json:"transaction_id"`
+	Status        int                    `json:"status"`
+	Response      *domain.SubmitResponse `json:"response,omitempty"`
+	Error         string                 `json:"error,omitempty"`
+	Errors        []retry.FieldError     `json:"errors,omitempty"`
+}
+
+// BulkSubmit handles POST /api/transactions/bulk - submit a JSON array of
+// transactions. Batches over the item cap are rejected with 413 before any
+// item is processed; otherwise every item is submitted independently and its
+// outcome reported in order.
+func (h *TransactionHandler) BulkSubmit(w http.ResponseWriter, r *http.Request) {
+	if h.rejectIfReadOnly(w) {
 		return
 	}
-	if req.AmountCents <= 0 {
-		writeError(w, http.StatusBadRequest, "amount_cents must be positive")
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+
+	var reqs []domain.SubmitRequest
+	if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit))
+			return
+		}
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
 		return
 	}
-	if req.Currency == "" {
-		writeError(w, http.StatusBadRequest, "currency is required")
+	if len(reqs) == 0 {
+		writeError(w, http.StatusBadRequest, "at least one transaction is required")
 		return
 	}
-
-	resp, err := h.engine.Submit(req)
-	if err != nil {
-		writeError(w, http.StatusConflict, err.Error())
+	if len(reqs) > h.maxBulkItems {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("batch has %d transactions, limit is %d", len(reqs), h.maxBulkItems))
 		return
 	}
 
-	writeJSON(w, http.StatusCreated, resp)
+	lang := requestLanguage(r)
+	results := make([]bulkSubmitResult, len(reqs))
+	created := 0
+	for i, req := range reqs {
+		req.Language = lang
+		result := bulkSubmitResult{TransactionID: req.TransactionID}
+		resp, err := h.engine.Submit(req)
+		switch {
+		case err == nil:
+			result.Status = http.StatusCreated
+			result.Response = resp
+			created++
+		case errors.Is(err, retry.ErrInvalidRequest):
+			result.Status = http.StatusBadRequest
+			result.Error = err.Error()
+			result.Errors = retry.FieldErrors(err)
+		default:
+			result.Status = http.StatusConflict
+			result.Error = err.Error()
+		}
+		results[i] = result
+	}
+
+	w.Header().Set("Content-Language", string(lang))
+	writeJSON(w, http.StatusOK, map[string]any{
+		"total":   len(results),
+		"created": created,
+		"failed":  len(results) - created,
+		"results": results,
+	})
+}
+
+// ifMatchVersion parses an If-Match header holding a transaction version, bare
+// or quoted like an ETag ("3"). Returns 0 when the header is absent.
+func ifMatchVersion(r *http.Request) (int, error) {
+	v := r.Header.Get("If-Match")
+	if v == "" {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(strings.Trim(v, `"`))
+	if err != nil || version <= 0 {
+		return 0, fmt.Errorf("If-Match must be a positive transaction version, got %q", v)
+	}
+	return version, nil
+}
+
+// requestLanguage picks the language for merchant-facing messages from the
+// lang query parameter, then the Accept-Language header; English by default.
+func requestLanguage(r *http.Request) domain.Language {
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		return domain.ParseLanguage(lang)
+	}
+	return domain.ParseLanguage(r.Header.Get("Accept-Language"))
 }
 
 // Get handles GET /api/transactions/{id} - get transaction status and retry history.
@@ -88,33 +255,514 @@ func (h *TransactionHandler) Get(w http.ResponseWriter, r *http.Request) {
 	}
 
 	response := map[string]any{
-		"transaction":    tx,
-		"webhook_events": h.notifier.GetEventsByTransaction(tx.ID),
+		"transaction":        tx,
+		"last_error":         lastError(tx),
+		"effective_schedule": effectiveSchedule(tx, time.Now().UTC()),
+		"webhook_events":     h.notifier.GetEventsByTransaction(tx.ID),
+	}
+	w.Header().Set("ETag", strconv.Quote(strconv.Itoa(tx.Version)))
+	writeJSON(w, http.StatusOK, response)
+}
+
+// effectiveSchedule joins the retry plan's scheduled times with the attempts
+// made so far, marking each slot executed, due, pending, or skipped as of now.
+func effectiveSchedule(tx *domain.Transaction, now time.Time) []domain.ScheduleSlot {
+	if tx.RetryPlan == nil {
+		return []domain.ScheduleSlot{}
+	}
+
+	attempts := make(map[int]domain.RetryAttempt, len(tx.RetryAttempts))
+	for _, a := range tx.RetryAttempts {
+		attempts[a.AttemptNumber] = a
+	}
+	pending := tx.Status == domain.StatusScheduled || tx.Status == domain.StatusRetrying
+
+	slots := make([]domain.ScheduleSlot, len(tx.RetryPlan.ScheduledTimes))
+	for i, at := range tx.RetryPlan.ScheduledTimes {
+		slot := domain.ScheduleSlot{
+			AttemptNumber: i + 1,
+			ScheduledAt:   at,
+		}
+		if i < len(tx.RetryPlan.Processors) {
+			slot.Processor = tx.RetryPlan.Processors[i]
+		}
+		if a, ok := attempts[i+1]; ok {
+			slot.Status = domain.SlotExecuted
+			slot.Result = &a
+		} else {
+			switch {
+			case !pending:
+				slot.Status = domain.SlotSkipped
+			case at.After(now):
+				slot.Status = domain.SlotPending
+			default:
+				slot.Status = domain.SlotDue
+			}
+		}
+		slots[i] = slot
+	}
+	return slots
+}
+
+// lastError summarizes the final attempt if it failed, or returns nil if there
+// are no attempts or the final one succeeded. Earlier failures are not
+// reported once a later attempt has recovered the transaction.
+func lastError(tx *domain.Transaction) *domain.LastError {
+	if len(tx.RetryAttempts) == 0 {
+		return nil
+	}
+	a := tx.RetryAttempts[len(tx.RetryAttempts)-1]
+	if a.Success {
+		return nil
+	}
+	return &domain.LastError{
+		Attempt:         a.AttemptNumber,
+		Processor:       a.Processor,
+		ResponseCode:    a.ResponseCode,
+		ResponseMessage: a.ResponseMsg,
+		ExecutedAt:      a.ExecutedAt,
+	}
+}
+
+// Timeline handles GET /api/transactions/{id}/timeline - retry attempts and webhook
+// events merged into one chronological list.
+func (h *TransactionHandler) Timeline(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		writeError(w, http.StatusBadRequest, "transaction id is required")
+		return
+	}
+
+	tx, err := h.store.Get(id)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "transaction not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to retrieve transaction")
+		return
+	}
+
+	events := h.notifier.GetEventsByTransaction(tx.ID)
+	timeline := make([]domain.TimelineEntry, 0, len(tx.RetryAttempts)+len(events))
+	// Attempts go first so that, on equal timestamps, an attempt sorts before the
+	// webhook event it produced.
+	for i := range tx.RetryAttempts {
+		timeline = append(timeline, domain.TimelineEntry{
+			Type:      domain.TimelineRetryAttempt,
+			Timestamp: tx.RetryAttempts[i].ExecutedAt,
+			Attempt:   &tx.RetryAttempts[i],
+		})
+	}
+	for i := range events {
+		timeline = append(timeline, domain.TimelineEntry{
+			Type:      domain.TimelineWebhookEvent,
+			Timestamp: events[i].Timestamp,
+			Event:     &events[i],
+		})
+	}
+	sort.SliceStable(timeline, func(i, j int) bool {
+		return timeline[i].Timestamp.Before(timeline[j].Timestamp)
+	})
+
+	response := map[string]any{
+		"transaction_id": tx.ID,
+		"status":         tx.Status,
+		"timeline":       timeline,
 	}
 	writeJSON(w, http.StatusOK, response)
 }
 
 // List handles GET /api/transactions - list all transactions with optional status filter.
+// Passing limit or an opaque after cursor switches to cursor pagination; the
+// response then carries next_cursor while more results remain. Passing offset
+// switches to offset pagination instead; see listOffset.
+// fields=summary returns a trimmed projection of each transaction (see
+// transactionSummary) instead of the full transaction (fields=full, default).
 func (h *TransactionHandler) List(w http.ResponseWriter, r *http.Request) {
-	status := r.URL.Query().Get("status")
-	transactions := h.store.List(status)
+	query := r.URL.Query()
+	status := query.Get("status")
+
+	var summary bool
+	switch query.Get("fields") {
+	case "", "full":
+	case "summary":
+		summary = true
+	default:
+		writeError(w, http.StatusBadRequest, "fields must be \"summary\" or \"full\"")
+		return
+	}
 
+	if query.Has("offset") {
+		if query.Has("after") {
+			writeError(w, http.StatusBadRequest, "offset and after cannot be combined")
+			return
+		}
+		h.listOffset(w, r, status, summary)
+		return
+	}
+	if !query.Has("limit") && !query.Has("after") {
+		transactions := h.store.List(status)
+		response := map[string]any{
+			"total":        len(transactions),
+			"transactions": listItems(transactions, summary),
+		}
+		writeJSON(w, http.StatusOK, response)
+		return
+	}
+
+	limit := defaultPageSize
+	if raw := query.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxPageSize {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be an integer between 1 and %d", maxPageSize))
+			return
+		}
+		limit = n
+	}
+
+	var after *store.Cursor
+	if raw := query.Get("after"); raw != "" {
+		c, err := decodeCursor(raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid after cursor")
+			return
+		}
+		after = c
+	}
+
+	transactions, more := h.store.ListPage(status, after, limit)
 	response := map[string]any{
 		"total":        len(transactions),
-		"transactions": transactions,
+		"transactions": listItems(transactions, summary),
+	}
+	if more {
+		last := transactions[len(transactions)-1]
+		response["next_cursor"] = encodeCursor(store.Cursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// listOffset serves an offset page of List with {total, limit, offset,
+// has_more, next_offset} metadata, where total counts every matching
+// transaction, and an RFC 5988 Link header with rel="next" and rel="prev"
+// URLs for the neighbouring pages.
+func (h *TransactionHandler) listOffset(w http.ResponseWriter, r *http.Request, status string, summary bool) {
+	query := r.URL.Query()
+	limit := defaultPageSize
+	if raw := query.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxPageSize {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be an integer between 1 and %d", maxPageSize))
+			return
+		}
+		limit = n
+	}
+	offset, err := strconv.Atoi(query.Get("offset"))
+	if err != nil || offset < 0 {
+		writeError(w, http.StatusBadRequest, "offset must be a non-negative integer")
+		return
+	}
+
+	transactions, total := h.store.ListOffset(status, offset, limit)
+	hasMore := offset+len(transactions) < total
+	response := map[string]any{
+		"total":        total,
+		"limit":        limit,
+		"offset":       offset,
+		"has_more":     hasMore,
+		"transactions": listItems(transactions, summary),
+	}
+
+	// has_more implies a full page below total, so next cannot overflow; prev
+	// is measured from total for offsets past the end.
+	var links []string
+	if hasMore {
+		next := offset + len(transactions)
+		response["next_offset"] = next
+		links = append(links, pageLink(r, next, limit, "next"))
+	}
+	if offset > 0 {
+		links = append(links, pageLink(r, max(min(offset, total)-limit, 0), limit, "prev"))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
 	}
 	writeJSON(w, http.StatusOK, response)
 }
 
+// transactionSummary is the fields=summary projection of a transaction in
+// List: enough for list views, without the retry plan and attempt history.
+type transactionSummary struct {
+	ID           string                   `json:"id"`
+	Status       domain.TransactionStatus `json:"status"`
+	AmountCents  int64                    `json:"amount_cents"`
+	Currency     string                   `json:"currency"`
+	DeclineCode  string                   `json:"decline_code"`
+	NextRetryAt  *time.Time               `json:"next_retry_at,omitempty"`
+	AttemptCount int                      `json:"attempt_count"`
+}
+
+// listItems returns the transactions as List renders them: as they are, or
+// projected to transactionSummary when summary is set.
+func listItems(transactions []*domain.Transaction, summary bool) any {
+	if !summary {
+		return transactions
+	}
+	items := make([]transactionSummary, len(transactions))
+	for i, tx := range transactions {
+		items[i] = transactionSummary{
+			ID:           tx.ID,
+			Status:       tx.Status,
+			AmountCents:  tx.AmountCents,
+			Currency:     tx.Currency,
+			DeclineCode:  tx.DeclineCode,
+			NextRetryAt:  tx.NextRetryAt,
+			AttemptCount: len(tx.RetryAttempts),
+		}
+	}
+	return items
+}
+
+// pageLink formats a Link header entry for r's URL at another offset.
+func pageLink(r *http.Request, offset, limit int, rel string) string {
+	query := r.URL.Query()
+	query.Set("offset", strconv.Itoa(offset))
+	query.Set("limit", strconv.Itoa(limit))
+	u := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+	return fmt.Sprintf("<%s>; rel=%q", u.String(), rel)
+}
+
+// Count handles GET /api/transactions/count - the number of transactions
+// matching the optional status, decline_code, processor and merchant_id filters.
+func (h *TransactionHandler) Count(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	count := h.store.CountFiltered(store.Filter{
+		Status:      query.Get("status"),
+		DeclineCode: query.Get("decline_code"),
+		Processor:   query.Get("processor"),
+		MerchantID:  query.Get("merchant_id"),
+	})
+	writeJSON(w, http.StatusOK, map[string]int{"count": count})
+}
+
+// defaultUpcomingWindow is the look-ahead for Upcoming when within is omitted.
+const defaultUpcomingWindow = time.Hour
+
+// Upcoming handles GET /api/transactions/upcoming?within=1h - pending
+// transactions whose next retry is due between now and now+within, soonest first.
+func (h *TransactionHandler) Upcoming(w http.ResponseWriter, r *http.Request) {
+	within := defaultUpcomingWindow
+	if raw := r.URL.Query().Get("within"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "within must be a positive duration (e.g. 30m, 1h)")
+			return
+		}
+		within = d
+	}
+
+	now := time.Now().UTC()
+	transactions := h.store.GetDueBetween(now, now.Add(within))
+	writeJSON(w, http.StatusOK, map[string]any{
+		"within":       within.String(),
+		"total":        len(transactions),
+		"transactions": transactions,
+	})
+}
+
+// Changed handles GET /api/transactions/changed?since=<rfc3339> - a delta feed
+// of transactions updated after since, oldest change first. next_since is the
+// cursor for the following poll (since itself when nothing changed).
+func (h *TransactionHandler) Changed(w http.ResponseWriter, r *http.Request) {
+	since, err := time.Parse(time.RFC3339, r.URL.Query().Get("since"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "since must be an RFC3339 timestamp")
+		return
+	}
+
+	transactions := h.store.ChangedSince(since)
+	next := since
+	if n := len(transactions); n > 0 {
+		next = transactions[n-1].UpdatedAt
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"since":        since,
+		"next_since":   next,
+		"total":        len(transactions),
+		"transactions": transactions,
+	})
+}
+
+// encodeCursor serializes a page cursor as URL-safe base64 JSON.
+func encodeCursor(c store.Cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses a cursor produced by encodeCursor.
+func decodeCursor(raw string) (*store.Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, err
+	}
+	var c store.Cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	if c.ID == "" || c.CreatedAt.IsZero() {
+		return nil, errors.New("incomplete cursor")
+	}
+	return &c, nil
+}
+
 // Retry handles POST /api/transactions/{id}/retry - manually trigger next retry.
+// An optional {"processor": "..."} body sends this attempt through a known
+// processor instead of the plan's. With an Idempotency-Key header, repeating
+// the key for the same transaction within idempotencyTTL returns the first
+// response without another attempt.
 func (h *TransactionHandler) Retry(w http.ResponseWriter, r *http.Request) {
+	if h.rejectIfReadOnly(w) {
+		return
+	}
+
+	id := r.PathValue("id")
+	if id == "" {
+		writeError(w, http.StatusBadRequest, "transaction id is required")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+	var req domain.RetryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	opts := retry.RetryOptions{Processor: req.Processor, IfVersion: version}
+
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" {
+		status, body := h.retry(id, opts)
+		writeJSON(w, status, body)
+		return
+	}
+
+	ik := idempotencyKey{txID: id, key: key}
+	entry, owner := h.idempotency.begin(ik, time.Now())
+	if !owner {
+		<-entry.done
+		w.Header().Set("Idempotent-Replayed", "true")
+		writeJSON(w, entry.status, entry.body)
+		return
+	}
+	var status int
+	var body any
+	defer func() { h.idempotency.finish(ik, entry, status, body, time.Now()) }()
+	status, body = h.retry(id, opts)
+	writeJSON(w, status, body)
+}
+
+// DeleteIdempotencyKey handles DELETE /api/idempotency-keys/{key} - forget the
+// recorded retry responses for an Idempotency-Key, for every transaction, so
+// the key runs a new attempt next time. Returns 404 if nothing was cached.
+func (h *TransactionHandler) DeleteIdempotencyKey(w http.ResponseWriter, r *http.Request) {
+	if h.rejectIfReadOnly(w) {
+		return
+	}
+	key := r.PathValue("key")
+	removed := h.idempotency.remove(key)
+	if removed == 0 {
+		writeError(w, http.StatusNotFound, "idempotency key not found")
+		return
+	}
+	h.logger.Info("idempotency key cleared", "key", key, "entries", removed)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"key":     key,
+		"deleted": removed,
+	})
+}
+
+// ClearIdempotencyKeys handles DELETE /api/idempotency-keys - forget every
+// recorded retry response.
+func (h *TransactionHandler) ClearIdempotencyKeys(w http.ResponseWriter, r *http.Request) {
+	if h.rejectIfReadOnly(w) {
+		return
+	}
+	removed := h.idempotency.clear()
+	h.logger.Info("idempotency keys cleared", "entries", removed)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"deleted": removed,
+	})
+}
+
+// retry executes the next attempt for id and returns the response status and body.
+func (h *TransactionHandler) retry(id string, opts retry.RetryOptions) (int, any) {
+	if err := h.engine.ExecuteRetryWith(id, opts); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			return http.StatusNotFound, errorBody("transaction not found")
+		case errors.Is(err, store.ErrVersionMismatch):
+			return http.StatusPreconditionFailed, errorBody(err.Error())
+		case errors.Is(err, retry.ErrNotRetryable):
+			return http.StatusUnprocessableEntity, errorBody(err.Error())
+		case errors.Is(err, retry.ErrAttemptsExhausted):
+			return http.StatusConflict, errorBody(err.Error())
+		default:
+			return http.StatusBadRequest, errorBody(err.Error())
+		}
+	}
+
+	tx, err := h.store.Get(id)
+	if err != nil {
+		return http.StatusInternalServerError, errorBody("failed to retrieve transaction after retry")
+	}
+	return http.StatusOK, tx
+}
+
+// InjectAttempt handles POST /api/transactions/{id}/inject-attempt - append a
+// retry attempt with a caller-specified outcome, bypassing the simulator.
+// Only success, response_code, response_message and approved_cents (a partial
+// approval) are taken from the body; attempt number, processor and timestamps
+// come from the retry plan.
+// Returns 403 unless injection was enabled with ALLOW_INJECT.
+func (h *TransactionHandler) InjectAttempt(w http.ResponseWriter, r *http.Request) {
+	if !h.allowInject {
+		writeError(w, http.StatusForbidden, "attempt injection is disabled")
+		return
+	}
+	if h.rejectIfReadOnly(w) {
+		return
+	}
+
 	id := r.PathValue("id")
 	if id == "" {
 		writeError(w, http.StatusBadRequest, "transaction id is required")
 		return
 	}
 
-	if err := h.engine.ExecuteRetry(id); err != nil {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+	var attempt domain.RetryAttempt
+	if err := json.NewDecoder(r.Body).Decode(&attempt); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if attempt.ApprovedCents < 0 {
+		writeError(w, http.StatusBadRequest, "approved_cents must not be negative")
+		return
+	}
+
+	err := h.engine.InjectAttempt(id, retry.SimResult{
+		Success:         attempt.Success,
+		ResponseCode:    attempt.ResponseCode,
+		ResponseMessage: attempt.ResponseMsg,
+		ApprovedCents:   attempt.ApprovedCents,
+	})
+	if err != nil {
 		switch {
 		case errors.Is(err, store.ErrNotFound):
 			writeError(w, http.StatusNotFound, "transaction not found")
@@ -130,20 +778,133 @@ func (h *TransactionHandler) Retry(w http.ResponseWriter, r *http.Request) {
 
 	tx, err := h.store.Get(id)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "failed to retrieve transaction after retry")
+		writeError(w, http.StatusInternalServerError, "failed to retrieve transaction after injection")
+		return
+	}
+	writeJSON(w, http.StatusOK, tx)
+}
+
+// Ack handles POST /api/transactions/{id}/ack - merchant reports the decline was
+// resolved out-of-band; remaining retries are cancelled.
+func (h *TransactionHandler) Ack(w http.ResponseWriter, r *http.Request) {
+	if h.rejectIfReadOnly(w) {
+		return
+	}
+
+	id := r.PathValue("id")
+	if id == "" {
+		writeError(w, http.StatusBadRequest, "transaction id is required")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+	var req domain.AckRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if req.Reason == "" {
+		writeError(w, http.StatusBadRequest, "reason is required")
+		return
+	}
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tx, err := h.engine.Acknowledge(id, req.Reason, version)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			writeError(w, http.StatusNotFound, "transaction not found")
+		case errors.Is(err, store.ErrVersionMismatch):
+			writeError(w, http.StatusPreconditionFailed, err.Error())
+		case errors.Is(err, retry.ErrNotRetryable):
+			writeError(w, http.StatusUnprocessableEntity, err.Error())
+		default:
+			writeError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, tx)
+}
+
+// Confirm handles POST /api/transactions/{id}/confirm - merchant confirms a
+// recovery awaiting confirmation, moving it to recovered.
+func (h *TransactionHandler) Confirm(w http.ResponseWriter, r *http.Request) {
+	if h.rejectIfReadOnly(w) {
+		return
+	}
+
+	id := r.PathValue("id")
+	if id == "" {
+		writeError(w, http.StatusBadRequest, "transaction id is required")
+		return
+	}
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tx, err := h.engine.Confirm(id, version)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			writeError(w, http.StatusNotFound, "transaction not found")
+		case errors.Is(err, store.ErrVersionMismatch):
+			writeError(w, http.StatusPreconditionFailed, err.Error())
+		case errors.Is(err, retry.ErrNotRetryable):
+			writeError(w, http.StatusUnprocessableEntity, err.Error())
+		default:
+			writeError(w, http.StatusInternalServerError, err.Error())
+		}
 		return
 	}
 	writeJSON(w, http.StatusOK, tx)
 }
 
 // ProcessAll handles POST /api/retry/process-all - process all pending retries (demo mode).
+// The run stops at the engine's attempt bound or when the client goes away;
+// truncated then reports that pending retries remain. aborted reports a run
+// cut short because the store was cleared (e.g. by a concurrent seed).
 func (h *TransactionHandler) ProcessAll(w http.ResponseWriter, r *http.Request) {
-	processed, recovered := h.engine.ProcessAllPending()
+	if h.rejectIfReadOnly(w) {
+		return
+	}
+
+	summary := h.engine.ProcessPending(r.Context())
+
+	message := "All pending retries processed"
+	switch {
+	case summary.Aborted:
+		message = "Processing aborted: the store was cleared during the run"
+	case summary.Truncated:
+		message = "Pending retries partially processed; call again to continue"
+	}
+	response := map[string]any{
+		"message":                message,
+		"total_attempts_made":    summary.Processed,
+		"transactions_recovered": summary.Recovered,
+		"truncated":              summary.Truncated,
+		"aborted":                summary.Aborted,
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// Resync handles POST /api/retry/resync - rebuild pending retry plans against the current config.
+func (h *TransactionHandler) Resync(w http.ResponseWriter, r *http.Request) {
+	if h.rejectIfReadOnly(w) {
+		return
+	}
+
+	updated, skipped := h.engine.ResyncPending()
 
 	response := map[string]any{
-		"message":                "All pending retries processed",
-		"total_attempts_made":    processed,
-		"transactions_recovered": recovered,
+		"message": "Pending retry plans resynced with current config",
+		"updated": updated,
+		"skipped": skipped,
 	}
 	writeJSON(w, http.StatusOK, response)
 }
@@ -151,31 +912,102 @@ func (h *TransactionHandler) ProcessAll(w http.ResponseWriter, r *http.Request)
 // GetWebhookEvents handles GET /api/webhooks/events - list all webhook events.
 func (h *TransactionHandler) GetWebhookEvents(w http.ResponseWriter, r *http.Request) {
 	events := h.notifier.GetEvents()
+	delivered, failed := h.notifier.DeliveryStats()
 	response := map[string]any{
-		"total":  len(events),
-		"events": events,
+		"total":              len(events),
+		"delivered_webhooks": delivered,
+		"failed_webhooks":    failed,
+		"dropped_webhooks":   h.notifier.DroppedDeliveries(),
+		"events":             events,
 	}
 	writeJSON(w, http.StatusOK, response)
 }
 
+// parseEventFilter reads the export filters from the query string.
+func parseEventFilter(r *http.Request) (webhook.EventFilter, error) {
+	q := r.URL.Query()
+	f := webhook.EventFilter{
+		EventType:     q.Get("type"),
+		TransactionID: q.Get("transaction_id"),
+	}
+	var err error
+	if v := q.Get("from"); v != "" {
+		if f.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, errors.New("from must be an RFC3339 timestamp")
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if f.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, errors.New("to must be an RFC3339 timestamp")
+		}
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && f.To.Before(f.From) {
+		return f, errors.New("to must not be before from")
+	}
+	return f, nil
+}
+
+// ExportWebhookEvents handles GET /api/webhooks/events/export - stream recorded
+// events as newline-delimited JSON, one event per line, oldest first. Optional
+// type, transaction_id, from and to (RFC3339, inclusive) filter the events.
+func (h *TransactionHandler) ExportWebhookEvents(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	if err := h.notifier.ExportEvents(filter, func(e domain.WebhookEvent) error {
+		return enc.Encode(e)
+	}); err != nil {
+		// Headers are sent; the client sees a truncated stream.
+		h.logger.Warn("webhook event export aborted", "error", err)
+	}
+}
+
+// ReplayWebhooks handles POST /api/webhooks/replay - re-deliver recorded events from a time window.
+func (h *TransactionHandler) ReplayWebhooks(w http.ResponseWriter, r *http.Request) {
+	if h.rejectIfReadOnly(w) {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+
+	var req domain.WebhookReplayRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if req.From.IsZero() || req.To.IsZero() {
+		writeError(w, http.StatusBadRequest, "from and to are required (RFC3339)")
+		return
+	}
+	if req.To.Before(req.From) {
+		writeError(w, http.StatusBadRequest, "to must not be before from")
+		return
+	}
+
+	dispatched, matched := h.notifier.Replay(req.From, req.To, req.TransactionID, maxReplayEvents)
+
+	response := map[string]any{
+		"message":    "Webhook replay dispatched",
+		"dispatched": dispatched,
+		"matched":    matched,
+		"truncated":  matched > dispatched,
+	}
+	writeJSON(w, http.StatusAccepted, response)
+}
+
 // GetDeclineCodes handles GET /api/decline-codes - list all known decline codes.
 func (h *TransactionHandler) GetDeclineCodes(w http.ResponseWriter, r *http.Request) {
 	codes := domain.GetAllDeclineCodes()
-	strategies := map[string]any{}
+	strategies := map[string]map[string]any{}
 
 	for _, code := range codes[domain.SoftDecline] {
-		strategy := domain.GetRetryStrategy(code)
-		if strategy != nil {
-			delays := make([]string, len(strategy.Delays))
-			for i, d := range strategy.Delays {
-				delays[i] = d.String()
-			}
-			strategies[code] = map[string]any{
-				"max_attempts":      strategy.MaxAttempts,
-				"delays":            delays,
-				"use_alt_processor": strategy.UseAltProcessor,
-				"description":       strategy.Description,
-			}
+		if strategy := domain.GetRetryStrategy(code); strategy != nil {
+			strategies[code] = strategyView(code, strategy)
 		}
 	}
 
@@ -187,15 +1019,179 @@ func (h *TransactionHandler) GetDeclineCodes(w http.ResponseWriter, r *http.Requ
 	writeJSON(w, http.StatusOK, response)
 }
 
+// strategyView renders a strategy for the config endpoints.
+func strategyView(code string, strategy *domain.RetryStrategy) map[string]any {
+	delays := make([]string, len(strategy.Delays))
+	for i, d := range strategy.Delays {
+		delays[i] = d.String()
+	}
+	return map[string]any{
+		"max_attempts":          strategy.MaxAttempts,
+		"delays":                delays,
+		"use_alt_processor":     strategy.UseAltProcessor,
+		"description":           strategy.Description,
+		"enabled":               domain.IsStrategyEnabled(code),
+		"tags":                  strategy.Tags,
+		"target_recovery_rate":  strategy.TargetRecoveryRate,
+		"attempt_timeout":       strategy.AttemptTimeout.String(),
+		"min_gap_after_failure": strategy.MinGapAfterFailure.String(),
+		"max_delay":             strategy.MaxDelay.String(),
+		"max_pending":           strategy.MaxPending,
+		"max_budget_resets":     strategy.MaxBudgetResets,
+		"guarantee_attempts":    strategy.GuaranteeAttempts,
+		"fallback_code":         strategy.FallbackCode,
+	}
+}
+
+// PatchStrategy handles PATCH /api/config/strategies/{code} - partially update
+// a decline code's strategy. Omitted fields are unchanged; fields present in
+// the body are set, including to zero. New submissions use the patched
+// strategy; existing plans are untouched until resynced.
+func (h *TransactionHandler) PatchStrategy(w http.ResponseWriter, r *http.Request) {
+	if h.rejectIfReadOnly(w) {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+	var patch domain.StrategyPatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	code := r.PathValue("code")
+	strategy, err := domain.PatchStrategy(code, patch)
+	if err != nil {
+		if errors.Is(err, domain.ErrUnknownStrategy) {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	h.logger.Warn("retry strategy patched", "decline_code", code)
+	if strategy.DelaysOutOfOrder() {
+		h.logger.Warn("retry strategy delays are not increasing; scheduled attempts will be nudged apart",
+			"decline_code", code,
+			"delays", strategy.Delays,
+		)
+	}
+	response := strategyView(code, strategy)
+	response["decline_code"] = code
+	writeJSON(w, http.StatusOK, response)
+}
+
+// DisableStrategy handles POST /api/config/strategies/{code}/disable - stop
+// scheduling retries for a decline code until it is re-enabled.
+func (h *TransactionHandler) DisableStrategy(w http.ResponseWriter, r *http.Request) {
+	h.setStrategyEnabled(w, r, false)
+}
+
+// EnableStrategy handles POST /api/config/strategies/{code}/enable - resume
+// scheduling retries for a decline code.
+func (h *TransactionHandler) EnableStrategy(w http.ResponseWriter, r *http.Request) {
+	h.setStrategyEnabled(w, r, true)
+}
+
+func (h *TransactionHandler) setStrategyEnabled(w http.ResponseWriter, r *http.Request, enabled bool) {
+	if h.rejectIfReadOnly(w) {
+		return
+	}
+	code := r.PathValue("code")
+	if err := domain.SetStrategyEnabled(code, enabled); err != nil {
+		if errors.Is(err, domain.ErrUnknownStrategy) {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	h.logger.Warn("retry strategy toggled", "decline_code", code, "enabled", enabled)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"decline_code": code,
+		"enabled":      enabled,
+	})
+}
+
+// GetProcessors handles GET /api/processors - list the processors used for retries.
+func (h *TransactionHandler) GetProcessors(w http.ResponseWriter, r *http.Request) {
+	names := domain.ListProcessors()
+	processors := make([]map[string]any, len(names))
+	for i, name := range names {
+		processors[i] = map[string]any{
+			"name":               name,
+			"strategy_overrides": domain.GetProcessorOverrideCodes(name),
+		}
+	}
+
+	response := map[string]any{
+		"total":      len(processors),
+		"processors": processors,
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// rejectIfReadOnly writes a 503 and returns true when the engine is in
+// read-only mode.
+func (h *TransactionHandler) rejectIfReadOnly(w http.ResponseWriter) bool {
+	if !h.engine.ReadOnly() {
+		return false
+	}
+	WriteReadOnly(w)
+	return true
+}
+
+// retryAfterSeconds is the Retry-After hint sent with every 503. It is set
+// once at startup via SetRetryAfter.
+var retryAfterSeconds = 30
+
+// SetRetryAfter sets the Retry-After value, in seconds, sent with 503 responses.
+// Must be called before serving requests.
+func SetRetryAfter(seconds int) {
+	retryAfterSeconds = seconds
+}
+
+// WriteReadOnly responds 503 to a write attempted during read-only mode.
+func WriteReadOnly(w http.ResponseWriter) {
+	writeUnavailable(w, map[string]string{"error": "service is in read-only mode"})
+}
+
+// writeUnavailable writes a 503 with a Retry-After hint. All 503 responses
+// go through here so clients always get a back-off hint.
+func writeUnavailable(w http.ResponseWriter, data any) {
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds))
+	writeJSON(w, http.StatusServiceUnavailable, data)
+}
+
 func writeJSON(w http.ResponseWriter, status int, data any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
-	if err := json.NewEncoder(w).Encode(data); err != nil {
+	var err error
+	if cw, ok := w.(*camelWriter); ok {
+		err = writeCamelJSON(cw.ResponseWriter, data)
+	} else {
+		err = json.NewEncoder(w).Encode(data)
+	}
+	if err != nil {
 		// Connection likely dropped; log but can't recover since headers are sent
 		slog.Default().Warn("failed to write response", "error", err)
 	}
 }
 
+// validationErrorBody is the 400 response for a rejected submit: the combined
+// message plus one entry per problem found.
+type validationErrorBody struct {
+	Error  string             `json:"error"`
+	Errors []retry.FieldError `json:"errors"`
+}
+
 func writeError(w http.ResponseWriter, status int, message string) {
-	writeJSON(w, status, map[string]string{"error": message})
+	writeJSON(w, status, errorBody(message))
+}
+
+// errorBody is the JSON body written by writeError.
+func errorBody(message string) map[string]string {
+	return map[string]string{"error": message}
 }
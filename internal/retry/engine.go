@@ -1,9 +1,15 @@
 package retry
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/eabugauch/zenithpay-retry/internal/domain"
@@ -17,28 +23,136 @@ var ErrNotRetryable = errors.New("transaction is not retryable")
 // ErrAttemptsExhausted indicates all retry attempts have been used.
 var ErrAttemptsExhausted = errors.New("all retry attempts exhausted")
 
+// ErrSubmitMismatch indicates a resubmission differs from the stored
+// transaction in a field other than the webhook URL.
+var ErrSubmitMismatch = errors.New("resubmission differs from stored transaction")
+
 // Engine orchestrates the retry logic for failed transactions.
 type Engine struct {
-	store     *store.Store
-	simulator *Simulator
-	notifier  *webhook.Notifier
-	logger    *slog.Logger
+	store      *store.Store
+	simulator  *Simulator
+	notifier   *webhook.Notifier
+	logger     *slog.Logger
+	validators []SubmitValidator // built-in checks; all run and report together
+	custom     []SubmitValidator // added via AddValidator; run once the built-ins pass
+	readOnly   atomic.Bool       // maintenance mode: writes rejected, scheduler paused
+	suspended  atomic.Bool       // retry kill switch: scheduler paused, submits still accepted
+
+	maxProcessAttempts int // bound on attempts per ProcessPending call; 0 = unbounded
+
+	confirmMu        sync.RWMutex
+	confirmMerchants map[string]bool // merchants whose recoveries wait for Confirm
 }
 
-// NewEngine creates a new retry engine.
+// defaultMaxProcessAttempts bounds one ProcessPending call, so a large store
+// cannot keep a synchronous request running indefinitely.
+const defaultMaxProcessAttempts = 10000
+
+// NewEngine creates a new retry engine with the default submit validators.
 func NewEngine(s *store.Store, sim *Simulator, n *webhook.Notifier, logger *slog.Logger) *Engine {
 	return &Engine{
-		store:     s,
-		simulator: sim,
-		notifier:  n,
-		logger:    logger,
+		store:      s,
+		simulator:  sim,
+		notifier:   n,
+		logger:     logger,
+		validators: DefaultValidators(),
+
+		maxProcessAttempts: defaultMaxProcessAttempts,
+		confirmMerchants:   make(map[string]bool),
+	}
+}
+
+// RequireConfirmation turns two-phase recovery on or off for a merchant.
+// While on, a successful retry of that merchant's transactions moves them to
+// pending_confirmation instead of recovered, and Confirm completes the
+// recovery, e.g. once settlement has been reconciled.
+func (e *Engine) RequireConfirmation(merchantID string, required bool) {
+	e.confirmMu.Lock()
+	defer e.confirmMu.Unlock()
+	if required {
+		e.confirmMerchants[merchantID] = true
+	} else {
+		delete(e.confirmMerchants, merchantID)
+	}
+}
+
+// requiresConfirmation reports whether recoveries for merchantID wait for Confirm.
+func (e *Engine) requiresConfirmation(merchantID string) bool {
+	e.confirmMu.RLock()
+	defer e.confirmMu.RUnlock()
+	return e.confirmMerchants[merchantID]
+}
+
+// SetMaxProcessAttempts sets how many attempts one ProcessPending call may
+// make before it stops and reports truncation (default 10000); 0 removes the
+// bound. Must be set before the engine is used.
+func (e *Engine) SetMaxProcessAttempts(n int) {
+	e.maxProcessAttempts = n
+}
+
+// SetReadOnly switches maintenance (read-only) mode on or off. While on, write
+// endpoints return 503 and the scheduler skips its ticks; reads keep working.
+func (e *Engine) SetReadOnly(readOnly bool) {
+	e.readOnly.Store(readOnly)
+}
+
+// ReadOnly reports whether the engine is in maintenance (read-only) mode.
+func (e *Engine) ReadOnly() bool {
+	return e.readOnly.Load()
+}
+
+// SetRetriesSuspended switches the retry kill switch on or off. While on, the
+// scheduler executes no attempts, but unlike read-only mode submits are still
+// accepted and scheduled; their attempts run once retries resume. Attempts
+// already executing are not interrupted.
+func (e *Engine) SetRetriesSuspended(suspended bool) {
+	e.suspended.Store(suspended)
+}
+
+// RetriesSuspended reports whether the retry kill switch is on.
+func (e *Engine) RetriesSuspended() bool {
+	return e.suspended.Load()
+}
+
+// AddValidator appends a custom submit validator, run after the built-in ones
+// and only when they all pass. Validators must be registered before the engine
+// starts serving requests.
+func (e *Engine) AddValidator(v SubmitValidator) {
+	e.custom = append(e.custom, v)
+}
+
+// validate collects every problem with req: all built-in validators run, and
+// the custom ones follow if the built-ins found nothing.
+func (e *Engine) validate(req domain.SubmitRequest) []FieldError {
+	fields := runValidators(e.validators, req)
+	if len(fields) > 0 {
+		return fields
 	}
+	return runValidators(e.custom, req)
 }
 
 // Submit evaluates a failed transaction and creates a retry plan if eligible.
+// The request is first run through the engine's validators; every problem
+// found is returned in one error for which errors.Is(err, ErrInvalidRequest)
+// holds and FieldErrors lists the individual failures.
+// The decline code is normalized (trimmed and lowercased) before validation;
+// the transaction keeps the submitted form in RawDeclineCode when it differs.
 // Uses SaveIfNotExists for atomic idempotency — no TOCTOU race.
 func (e *Engine) Submit(req domain.SubmitRequest) (*domain.SubmitResponse, error) {
+	rawDeclineCode := req.DeclineCode
+	req.DeclineCode = domain.NormalizeDeclineCode(req.DeclineCode)
+	if fields := e.validate(req); len(fields) > 0 {
+		return nil, &validationError{fields: fields}
+	}
+
 	category, reason := domain.ClassifyDecline(req.DeclineCode)
+	localReason := domain.Translate(req.Language, reason)
+	if category == domain.SoftDecline && !domain.IsKnownDeclineCode(req.DeclineCode) {
+		e.logger.Warn("unrecognized decline code, applying default retry strategy",
+			"transaction_id", req.TransactionID,
+			"decline_code", req.DeclineCode,
+		)
+	}
 	now := time.Now().UTC()
 
 	var parsedTime time.Time
@@ -58,20 +172,31 @@ func (e *Engine) Submit(req domain.SubmitRequest) (*domain.SubmitResponse, error
 		Currency:          req.Currency,
 		CustomerID:        req.CustomerID,
 		MerchantID:        req.MerchantID,
+		CardToken:         req.CardToken,
 		OriginalProcessor: req.OriginalProcessor,
 		DeclineCode:       req.DeclineCode,
+		ResponseCode:      req.ResponseCode,
 		DeclineCategory:   category,
 		RetryAttempts:     []domain.RetryAttempt{},
 		CreatedAt:         parsedTime,
 		UpdatedAt:         now,
 		WebhookURL:        req.WebhookURL,
+		WebhookRoutes:     req.WebhookRoutes,
+		WebhookEvents:     req.WebhookEvents,
+		WebhookTimeoutMs:  req.WebhookTimeoutMs,
+		Priority:          req.Priority,
+		CustomerTimezone:  req.CustomerTimezone,
+		ExcludeProcessors: req.ExcludeProcessors,
+	}
+	if rawDeclineCode != req.DeclineCode {
+		tx.RawDeclineCode = rawDeclineCode
 	}
 
 	if category == domain.HardDecline {
 		tx.Status = domain.StatusRejected
 		if err := e.store.SaveIfNotExists(tx); err != nil {
 			if errors.Is(err, store.ErrAlreadyExists) {
-				return nil, fmt.Errorf("transaction %s already submitted", req.TransactionID)
+				return nil, fmt.Errorf("transaction %s already submitted: %w", req.TransactionID, store.ErrAlreadyExists)
 			}
 			return nil, fmt.Errorf("saving transaction %s: %w", req.TransactionID, err)
 		}
@@ -85,21 +210,73 @@ func (e *Engine) Submit(req domain.SubmitRequest) (*domain.SubmitResponse, error
 			DeclineCategory: category,
 			Status:          tx.Status,
 			RetryEligible:   false,
-			Message:         fmt.Sprintf("Hard decline: %s. Transaction will not be retried.", reason),
+			Message:         domain.Localizef(req.Language, "Hard decline: %s. Transaction will not be retried.", localReason),
 		}, nil
 	}
 
-	plan := domain.BuildRetryPlan(req.DeclineCode, req.OriginalProcessor, now)
-	tx.RetryPlan = plan
-	tx.Status = domain.StatusScheduled
-	if len(plan.ScheduledTimes) > 0 {
-		nextRetry := plan.ScheduledTimes[0]
-		tx.NextRetryAt = &nextRetry
+	if !domain.IsStrategyEnabled(req.DeclineCode) {
+		tx.Status = domain.StatusRejected
+		if err := e.store.SaveIfNotExists(tx); err != nil {
+			if errors.Is(err, store.ErrAlreadyExists) {
+				return nil, fmt.Errorf("transaction %s already submitted: %w", req.TransactionID, store.ErrAlreadyExists)
+			}
+			return nil, fmt.Errorf("saving transaction %s: %w", req.TransactionID, err)
+		}
+		e.logger.Info("retries disabled for decline code",
+			"transaction_id", tx.ID,
+			"decline_code", tx.DeclineCode,
+		)
+		return &domain.SubmitResponse{
+			TransactionID:   tx.ID,
+			DeclineCategory: category,
+			Status:          tx.Status,
+			RetryEligible:   false,
+			Message:         domain.Localizef(req.Language, "Soft decline: %s. Retries temporarily disabled for %s; transaction will not be retried.", localReason, req.DeclineCode),
+		}, nil
 	}
 
-	if err := e.store.SaveIfNotExists(tx); err != nil {
+	strategy := domain.GetRetryStrategyForProcessor(req.DeclineCode, req.OriginalProcessor)
+	if !strategy.AllowsResponseCode(req.ResponseCode) {
+		tx.Status = domain.StatusFailedFinal
+		if err := e.store.SaveIfNotExists(tx); err != nil {
+			if errors.Is(err, store.ErrAlreadyExists) {
+				return nil, fmt.Errorf("transaction %s already submitted: %w", req.TransactionID, store.ErrAlreadyExists)
+			}
+			return nil, fmt.Errorf("saving transaction %s: %w", req.TransactionID, err)
+		}
+		e.logger.Info("response code not retryable for decline",
+			"transaction_id", tx.ID,
+			"decline_code", tx.DeclineCode,
+			"response_code", tx.ResponseCode,
+		)
+		return &domain.SubmitResponse{
+			TransactionID:   tx.ID,
+			DeclineCategory: category,
+			Status:          tx.Status,
+			RetryEligible:   false,
+			Message:         domain.Localizef(req.Language, "Soft decline: %s. Response code %q is not retryable; no retries scheduled.", localReason, req.ResponseCode),
+		}, nil
+	}
+
+	plan := domain.BuildRetryPlan(req.DeclineCode, req.OriginalProcessor, now, domain.PlanOptions{Currency: req.Currency, Timezone: req.CustomerTimezone, Excluded: req.ExcludeProcessors})
+	tx.Status = domain.StatusScheduled
+
+	// The card gap is applied under the store lock, so two submits with the
+	// same card token cannot both miss each other's plans.
+	err := e.store.SaveIfNotExistsCappedFunc(tx, strategy.MaxPending, func(tx *domain.Transaction, sameCard []*domain.Transaction) {
+		e.spaceCardRetries(tx, strategy, plan, 0, sameCard)
+		tx.RetryPlan = plan
+		if len(plan.ScheduledTimes) > 0 {
+			nextRetry := plan.ScheduledTimes[0]
+			tx.NextRetryAt = &nextRetry
+		}
+	})
+	if err != nil {
+		if errors.Is(err, store.ErrPendingCapReached) {
+			return e.rejectOverflow(tx, req.Language, localReason, strategy.MaxPending)
+		}
 		if errors.Is(err, store.ErrAlreadyExists) {
-			return nil, fmt.Errorf("transaction %s already submitted", req.TransactionID)
+			return nil, fmt.Errorf("transaction %s already submitted: %w", req.TransactionID, store.ErrAlreadyExists)
 		}
 		return nil, fmt.Errorf("saving transaction %s: %w", req.TransactionID, err)
 	}
@@ -118,13 +295,243 @@ func (e *Engine) Submit(req domain.SubmitRequest) (*domain.SubmitResponse, error
 		Status:          tx.Status,
 		RetryEligible:   true,
 		RetryPlan:       plan,
-		Message:         fmt.Sprintf("Soft decline: %s. Scheduled %d retry attempts.", reason, plan.MaxAttempts),
+		Message:         domain.Localizef(req.Language, "Soft decline: %s. Scheduled %d retry attempts.", localReason, plan.MaxAttempts),
+	}, nil
+}
+
+// rejectOverflow stores a soft decline as rejected because its decline code
+// already has maxPending pending transactions. Later submits of the code are
+// scheduled again once pending transactions complete.
+func (e *Engine) rejectOverflow(tx *domain.Transaction, lang domain.Language, localReason string, maxPending int) (*domain.SubmitResponse, error) {
+	tx.Status = domain.StatusRejected
+	tx.RetryPlan = nil
+	tx.NextRetryAt = nil
+	if err := e.store.SaveIfNotExists(tx); err != nil {
+		if errors.Is(err, store.ErrAlreadyExists) {
+			return nil, fmt.Errorf("transaction %s already submitted: %w", tx.ID, store.ErrAlreadyExists)
+		}
+		return nil, fmt.Errorf("saving transaction %s: %w", tx.ID, err)
+	}
+	e.logger.Warn("pending cap reached, soft decline rejected",
+		"transaction_id", tx.ID,
+		"decline_code", tx.DeclineCode,
+		"max_pending", maxPending,
+	)
+	return &domain.SubmitResponse{
+		TransactionID:   tx.ID,
+		DeclineCategory: tx.DeclineCategory,
+		Status:          tx.Status,
+		RetryEligible:   false,
+		Message:         domain.Localizef(lang, "Soft decline: %s. %s already has %d pending retries (limit); transaction will not be retried.", localReason, tx.DeclineCode, maxPending),
 	}, nil
 }
 
+// UpdateWebhookURL applies a resubmission of an already-stored transaction whose
+// only change is the webhook URL. Every other submitted field must match the
+// stored transaction; otherwise ErrSubmitMismatch is returned and nothing changes.
+// Later events for the transaction are delivered to the new URL.
+func (e *Engine) UpdateWebhookURL(req domain.SubmitRequest) (*domain.Transaction, error) {
+	var updated *domain.Transaction
+	err := e.store.UpdateFunc(req.TransactionID, func(tx *domain.Transaction) error {
+		if !sameSubmission(tx, req) {
+			return fmt.Errorf("transaction %s: %w", req.TransactionID, ErrSubmitMismatch)
+		}
+		if tx.WebhookURL != req.WebhookURL {
+			tx.WebhookURL = req.WebhookURL
+			tx.UpdatedAt = time.Now().UTC()
+		}
+		updated = tx
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, fmt.Errorf("transaction %s not found: %w", req.TransactionID, store.ErrNotFound)
+		}
+		return nil, err
+	}
+
+	e.logger.Info("webhook url updated on resubmission",
+		"transaction_id", req.TransactionID,
+		"webhook_url", updated.WebhookURL,
+	)
+	return updated, nil
+}
+
+// sameSubmission reports whether req carries the same core fields as the stored
+// transaction, ignoring the webhook URL.
+func sameSubmission(tx *domain.Transaction, req domain.SubmitRequest) bool {
+	return tx.AmountCents == req.AmountCents &&
+		tx.Currency == req.Currency &&
+		tx.CustomerID == req.CustomerID &&
+		tx.MerchantID == req.MerchantID &&
+		tx.CardToken == req.CardToken &&
+		tx.OriginalProcessor == req.OriginalProcessor &&
+		tx.DeclineCode == domain.NormalizeDeclineCode(req.DeclineCode) &&
+		tx.ResponseCode == req.ResponseCode &&
+		tx.WebhookTimeoutMs == req.WebhookTimeoutMs &&
+		tx.Priority == req.Priority &&
+		tx.CustomerTimezone == req.CustomerTimezone &&
+		maps.Equal(tx.WebhookRoutes, req.WebhookRoutes) &&
+		slices.Equal(tx.WebhookEvents, req.WebhookEvents) &&
+		slices.Equal(tx.ExcludeProcessors, req.ExcludeProcessors)
+}
+
+// cardRetryEarliest returns the earliest time a retry of tx may be scheduled:
+// the configured card gap after the last retry of any of sameCard, the other
+// transactions using the same card token. When tx already has a plan,
+// transactions whose plans start after it are queued behind it and do not
+// count, so rescheduling a card's transactions keeps their order. Returns the
+// zero time if the gap does not apply.
+func cardRetryEarliest(tx *domain.Transaction, sameCard []*domain.Transaction) time.Time {
+	gap := domain.GetCardRetryMinGap()
+	if tx.CardToken == "" || gap <= 0 {
+		return time.Time{}
+	}
+
+	var last time.Time
+	for _, other := range sameCard {
+		if other.ID == tx.ID || planStartsAfter(other, tx) {
+			continue
+		}
+		if t := other.LastRetryTime(); t.After(last) {
+			last = t
+		}
+	}
+	if last.IsZero() {
+		return time.Time{}
+	}
+	return last.Add(gap)
+}
+
+// planStartsAfter reports whether both transactions have plans and a's first
+// scheduled retry is after b's.
+func planStartsAfter(a, b *domain.Transaction) bool {
+	if a.RetryPlan == nil || b.RetryPlan == nil || len(a.RetryPlan.ScheduledTimes) == 0 || len(b.RetryPlan.ScheduledTimes) == 0 {
+		return false
+	}
+	return a.RetryPlan.ScheduledTimes[0].After(b.RetryPlan.ScheduledTimes[0])
+}
+
+// spaceCardRetries pushes tx's new plan from index from onward later so the
+// first of those attempts falls no earlier than cardRetryEarliest allows.
+// Later attempts keep their spacing relative to the first, except that
+// business-hours plans are snapped back into their window afterwards.
+func (e *Engine) spaceCardRetries(tx *domain.Transaction, strategy *domain.RetryStrategy, plan *domain.RetryPlan, from int, sameCard []*domain.Transaction) {
+	earliest := cardRetryEarliest(tx, sameCard)
+	if earliest.IsZero() || from >= len(plan.ScheduledTimes) {
+		return
+	}
+	if plan.ScheduledTimes[from].Before(earliest) {
+		offset := earliest.Sub(plan.ScheduledTimes[from])
+		plan.ShiftFrom(from, offset)
+		domain.ResnapPlan(plan, from, strategy, tx.Currency, tx.CustomerTimezone)
+		e.logger.Info("retry plan offset for card gap",
+			"transaction_id", tx.ID,
+			"offset", offset,
+		)
+	}
+}
+
+// ReanchorOverdue shifts the remaining schedule of a pending transaction so that
+// its next attempt is due at now, keeping the spacing between the remaining
+// attempts. It only acts when every remaining scheduled attempt is already in
+// the past (e.g. after a clock jump or a restore from an old snapshot); otherwise
+// the plan is left alone. Returns whether the plan was changed.
+func (e *Engine) ReanchorOverdue(txID string, now time.Time) (bool, error) {
+	var shift time.Duration
+	err := e.store.UpdateFunc(txID, func(tx *domain.Transaction) error {
+		if tx.Status != domain.StatusScheduled && tx.Status != domain.StatusRetrying {
+			return nil
+		}
+		if tx.RetryPlan == nil {
+			return nil
+		}
+		made := len(tx.RetryAttempts)
+		remaining := tx.RetryPlan.ScheduledTimes
+		if made >= len(remaining) {
+			return nil
+		}
+		remaining = remaining[made:]
+		if !remaining[len(remaining)-1].Before(now) {
+			return nil
+		}
+
+		shift = now.Sub(remaining[0])
+		for i := range remaining {
+			remaining[i] = remaining[i].Add(shift)
+		}
+		next := remaining[0]
+		tx.NextRetryAt = &next
+		tx.UpdatedAt = now
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return false, fmt.Errorf("transaction %s not found: %w", txID, store.ErrNotFound)
+		}
+		return false, err
+	}
+	if shift > 0 {
+		e.logger.Warn("overdue retry schedule re-anchored",
+			"transaction_id", txID,
+			"shift", shift,
+		)
+	}
+	return shift > 0, nil
+}
+
 // ExecuteRetry performs the next retry attempt for a transaction.
 // Uses UpdateFunc for atomic read-modify-write — no lost-update race.
 func (e *Engine) ExecuteRetry(txID string) error {
+	return e.ExecuteRetryWith(txID, RetryOptions{})
+}
+
+// RetryOptions adjusts a single manual retry attempt.
+type RetryOptions struct {
+	// Processor sends the attempt through this processor instead of the one
+	// the plan assigns, e.g. when an operator knows it has recovered. The
+	// attempt counts against the plan as usual and later attempts keep their
+	// assigned processors. Empty uses the plan's.
+	Processor string
+	// IfVersion makes the attempt conditional on the transaction being at
+	// this version (store.ErrVersionMismatch otherwise). 0 skips the check.
+	IfVersion int
+	// IfGeneration makes the attempt conditional on the store still being at
+	// this generation when the attempt is recorded (store.ErrCleared
+	// otherwise). 0 skips the check.
+	IfGeneration uint64
+}
+
+// ExecuteRetryWith is ExecuteRetry with options. An unknown processor is
+// rejected with ErrInvalidRequest.
+func (e *Engine) ExecuteRetryWith(txID string, opts RetryOptions) error {
+	if opts.Processor != "" && !slices.Contains(domain.ListProcessors(), opts.Processor) {
+		return fmt.Errorf("unknown processor %q: %w", opts.Processor, ErrInvalidRequest)
+	}
+	return e.executeAttempt(txID, opts, func(tx *domain.Transaction, attemptNum int, processor string) SimResult {
+		prev := tx.OriginalProcessor
+		if n := len(tx.RetryAttempts); n > 0 {
+			prev = tx.RetryAttempts[n-1].Processor
+		}
+		// The strategy follows the transaction, not the processor this
+		// attempt is routed through; after a fallback it is the fallback's.
+		strategy := domain.GetRetryStrategyForProcessor(tx.ActiveStrategyCode(), tx.OriginalProcessor)
+		return e.simulator.ProcessStrategyAttempt(tx.ID, strategy, attemptNum, tx.StrategyAttempt(attemptNum), processor, prev)
+	})
+}
+
+// InjectAttempt records the next retry attempt with a caller-supplied outcome
+// instead of the simulator's, so integrations can produce deterministic data.
+// Status transitions and webhooks follow the same path as ExecuteRetry.
+func (e *Engine) InjectAttempt(txID string, result SimResult) error {
+	return e.executeAttempt(txID, RetryOptions{}, func(*domain.Transaction, int, string) SimResult {
+		return result
+	})
+}
+
+// executeAttempt runs the next retry attempt for a transaction, taking its
+// outcome from process.
+func (e *Engine) executeAttempt(txID string, opts RetryOptions, process func(tx *domain.Transaction, attemptNum int, processor string) SimResult) error {
 	// Simulate outside the lock to avoid holding the mutex during I/O.
 	// First, read the current state to determine what to simulate.
 	tx, err := e.store.Get(txID)
@@ -135,27 +542,39 @@ func (e *Engine) ExecuteRetry(txID string) error {
 		return fmt.Errorf("executing retry for %s: %w", txID, err)
 	}
 
+	if opts.IfVersion != 0 && tx.Version != opts.IfVersion {
+		return fmt.Errorf("transaction %s is at version %d, not %d: %w", txID, tx.Version, opts.IfVersion, store.ErrVersionMismatch)
+	}
 	if tx.Status != domain.StatusScheduled && tx.Status != domain.StatusRetrying {
 		return fmt.Errorf("transaction %s is not eligible for retry (status: %s): %w", txID, tx.Status, ErrNotRetryable)
 	}
 	if tx.RetryPlan == nil {
 		return fmt.Errorf("transaction %s has no retry plan: %w", txID, ErrNotRetryable)
 	}
+	if slices.Contains(tx.ExcludeProcessors, opts.Processor) {
+		return fmt.Errorf("transaction %s excludes processor %q: %w", txID, opts.Processor, ErrInvalidRequest)
+	}
 
 	attemptNum := len(tx.RetryAttempts) + 1
 	if attemptNum > tx.RetryPlan.MaxAttempts {
 		// Mark as exhausted atomically
-		e.store.UpdateFunc(txID, func(tx *domain.Transaction) error {
+		err := e.store.UpdateFuncIfCurrent(txID, 0, opts.IfGeneration, func(tx *domain.Transaction) error {
 			tx.Status = domain.StatusFailedFinal
 			tx.NextRetryAt = nil
 			tx.UpdatedAt = time.Now().UTC()
 			return nil
 		})
+		if errors.Is(err, store.ErrCleared) {
+			return fmt.Errorf("transaction %s: %w", txID, err)
+		}
 		e.notifier.Send(tx, domain.EventRetryExhausted, attemptNum-1)
 		return fmt.Errorf("transaction %s: %w", txID, ErrAttemptsExhausted)
 	}
 
-	processor := tx.RetryPlan.Processors[attemptNum-1]
+	processor := opts.Processor
+	if processor == "" {
+		processor = tx.RetryPlan.Processors[attemptNum-1]
+	}
 	scheduledAt := tx.RetryPlan.ScheduledTimes[attemptNum-1]
 
 	e.logger.Info("executing retry attempt",
@@ -165,7 +584,7 @@ func (e *Engine) ExecuteRetry(txID string) error {
 	)
 
 	// Simulate payment outside the store lock
-	result := e.simulator.ProcessPayment(tx.DeclineCode, attemptNum, processor)
+	result := process(tx, attemptNum, processor)
 
 	attempt := domain.RetryAttempt{
 		AttemptNumber: attemptNum,
@@ -175,11 +594,13 @@ func (e *Engine) ExecuteRetry(txID string) error {
 		Success:       result.Success,
 		ResponseCode:  result.ResponseCode,
 		ResponseMsg:   result.ResponseMessage,
+		TimedOut:      result.Outcome == OutcomeTimeout,
 	}
 
 	// Atomically update the transaction with the retry result
 	var finalStatus domain.TransactionStatus
-	err = e.store.UpdateFunc(txID, func(tx *domain.Transaction) error {
+	var budgetReset bool
+	err = e.store.UpdateFuncIfCurrent(txID, opts.IfVersion, opts.IfGeneration, func(tx *domain.Transaction) error {
 		// Re-check state inside the lock to handle concurrent retries
 		if tx.Status != domain.StatusScheduled && tx.Status != domain.StatusRetrying {
 			return fmt.Errorf("concurrent state change: %w", ErrNotRetryable)
@@ -189,18 +610,40 @@ func (e *Engine) ExecuteRetry(txID string) error {
 			return fmt.Errorf("concurrent retry detected: %w", ErrNotRetryable)
 		}
 
+		// A partial approval collects part of the balance; the attempt does not
+		// settle the transaction, so the remainder is retried.
+		partial := result.Success && result.ApprovedCents > 0 && result.ApprovedCents < tx.OutstandingCents()
+		if partial {
+			attempt.Success = false
+			attempt.ApprovedCents = result.ApprovedCents
+			tx.RecoveredCents += result.ApprovedCents
+		}
 		tx.RetryAttempts = append(tx.RetryAttempts, attempt)
 		tx.UpdatedAt = time.Now().UTC()
 
-		if result.Success {
+		if result.Success && !partial {
 			tx.Status = domain.StatusRecovered
+			if e.requiresConfirmation(tx.MerchantID) {
+				tx.Status = domain.StatusPendingConfirmation
+			}
 			tx.NextRetryAt = nil
+		} else if partial && tx.ResetAttemptBudget(time.Now().UTC()) {
+			budgetReset = true
 		} else if attemptNum >= tx.RetryPlan.MaxAttempts {
-			tx.Status = domain.StatusFailedFinal
-			tx.NextRetryAt = nil
+			if !tx.ApplyFallback(time.Now().UTC()) {
+				tx.Status = domain.StatusFailedFinal
+				tx.NextRetryAt = nil
+			}
 		} else {
 			tx.Status = domain.StatusRetrying
 			nextRetry := tx.RetryPlan.ScheduledTimes[attemptNum]
+			// The strategy may require a minimum gap after the failure, even when
+			// the plan scheduled the next attempt sooner.
+			if strategy := domain.GetRetryStrategyForProcessor(tx.ActiveStrategyCode(), tx.OriginalProcessor); strategy != nil {
+				if earliest := tx.UpdatedAt.Add(strategy.MinGapAfterFailure); earliest.After(nextRetry) {
+					nextRetry = earliest
+				}
+			}
 			tx.NextRetryAt = &nextRetry
 		}
 		finalStatus = tx.Status
@@ -219,12 +662,36 @@ func (e *Engine) ExecuteRetry(txID string) error {
 			"attempt", attemptNum,
 			"processor", processor,
 		)
+	case domain.StatusPendingConfirmation:
+		e.notifier.Send(tx, domain.EventRetrySucceeded, attemptNum)
+		e.logger.Info("transaction recovered, awaiting merchant confirmation",
+			"transaction_id", tx.ID,
+			"attempt", attemptNum,
+			"processor", processor,
+		)
 	case domain.StatusFailedFinal:
 		e.notifier.Send(tx, domain.EventRetryExhausted, attemptNum)
 		e.logger.Info("transaction failed after all retries",
 			"transaction_id", tx.ID,
 			"total_attempts", attemptNum,
 		)
+	case domain.StatusScheduled:
+		if budgetReset {
+			e.notifier.Send(tx, domain.EventRetryScheduled, attemptNum)
+			e.logger.Info("partial approval, attempt budget reset for the remainder",
+				"transaction_id", tx.ID,
+				"attempt", attemptNum,
+				"approved_cents", result.ApprovedCents,
+			)
+			break
+		}
+		// The plan was exhausted and a fallback strategy appended to it.
+		e.notifier.Send(tx, domain.EventRetryFailed, attemptNum)
+		e.notifier.Send(tx, domain.EventRetryScheduled, attemptNum)
+		e.logger.Info("retries exhausted, fallback strategy scheduled",
+			"transaction_id", tx.ID,
+			"attempt", attemptNum,
+		)
 	default:
 		e.notifier.Send(tx, domain.EventRetryFailed, attemptNum)
 		e.logger.Info("retry attempt failed, next scheduled",
@@ -237,21 +704,57 @@ func (e *Engine) ExecuteRetry(txID string) error {
 }
 
 // ProcessAllPending executes retries for all due transactions (demo/accelerated mode).
+// It is ProcessPending without a deadline; use ProcessPending to learn
+// whether the attempt bound cut the run short.
 func (e *Engine) ProcessAllPending() (processed int, recovered int) {
+	summary := e.ProcessPending(context.Background())
+	return summary.Processed, summary.Recovered
+}
+
+// ProcessSummary is the outcome of a ProcessPending run.
+type ProcessSummary struct {
+	Processed int  // attempts made
+	Recovered int  // transactions recovered, including those pending confirmation
+	Truncated bool // stopped early by the attempt bound or ctx; pending transactions remain
+	Aborted   bool // stopped because the store was cleared mid-run; counts cover the work before the clear
+}
+
+// ProcessPending drives every pending transaction to a terminal state
+// (demo/accelerated mode). It stops early, with Truncated set, once the
+// engine's attempt bound is reached or ctx is done; the work done so far is
+// kept and reported. If the store is cleared during the run (a reset or seed),
+// the transactions it started from are gone, so it stops with Aborted set.
+// Each attempt is recorded only if the store is still at the generation the
+// run started from, so a transaction that reuses an ID after the clear is
+// never touched.
+func (e *Engine) ProcessPending(ctx context.Context) ProcessSummary {
+	var summary ProcessSummary
+	generation := e.store.Generation()
 	pending := e.store.GetPendingRetries()
 	for _, tx := range pending {
 		for {
-			err := e.ExecuteRetry(tx.ID)
-			if err != nil {
+			if e.store.Generation() != generation {
+				summary.Aborted = true
+				return summary
+			}
+			if ctx.Err() != nil || (e.maxProcessAttempts > 0 && summary.Processed >= e.maxProcessAttempts) {
+				summary.Truncated = true
+				return summary
+			}
+			if err := e.ExecuteRetryWith(tx.ID, RetryOptions{IfGeneration: generation}); err != nil {
+				if errors.Is(err, store.ErrCleared) {
+					summary.Aborted = true
+					return summary
+				}
 				break
 			}
-			processed++
+			summary.Processed++
 			refreshed, err := e.store.Get(tx.ID)
 			if err != nil {
 				break
 			}
-			if refreshed.Status == domain.StatusRecovered {
-				recovered++
+			if refreshed.Status == domain.StatusRecovered || refreshed.Status == domain.StatusPendingConfirmation {
+				summary.Recovered++
 				break
 			}
 			if refreshed.Status == domain.StatusFailedFinal {
@@ -259,5 +762,149 @@ func (e *Engine) ProcessAllPending() (processed int, recovered int) {
 			}
 		}
 	}
-	return processed, recovered
+	return summary
+}
+
+// Reschedule rebuilds a pending transaction's retry plan against the current
+// strategy configuration. Attempts already made are preserved; the remaining
+// attempts are scheduled from now using the current delays, with the same
+// card gap and processor rotation as a new submission. If the new plan allows
+// no further attempts, the transaction is marked failed_final.
+func (e *Engine) Reschedule(txID string) error {
+	now := time.Now().UTC()
+
+	var updated *domain.Transaction
+	err := e.store.UpdateFuncWithCard(txID, func(tx *domain.Transaction, sameCard []*domain.Transaction) error {
+		if tx.Status != domain.StatusScheduled && tx.Status != domain.StatusRetrying {
+			return fmt.Errorf("transaction %s is not pending (status: %s): %w", txID, tx.Status, ErrNotRetryable)
+		}
+		if len(tx.FallbackCodes) > 0 {
+			return fmt.Errorf("transaction %s is on a fallback plan and is not rescheduled: %w", txID, ErrNotRetryable)
+		}
+		if tx.BudgetResets > 0 {
+			return fmt.Errorf("transaction %s has a reset attempt budget and is not rescheduled: %w", txID, ErrNotRetryable)
+		}
+		plan := domain.BuildRetryPlan(tx.DeclineCode, tx.OriginalProcessor, now, tx.PlanOptions())
+		if plan == nil {
+			return fmt.Errorf("transaction %s has no retry strategy: %w", txID, ErrNotRetryable)
+		}
+
+		// Keep the history of attempts already made in the plan
+		made := len(tx.RetryAttempts)
+		strategy := domain.GetRetryStrategyForProcessor(tx.DeclineCode, tx.OriginalProcessor)
+		domain.ResumePlan(plan, strategy, tx.RetryAttempts, tx.ExcludeProcessors)
+		e.spaceCardRetries(tx, strategy, plan, made, sameCard)
+
+		tx.RetryPlan = plan
+		tx.UpdatedAt = now
+		if made >= plan.MaxAttempts {
+			tx.Status = domain.StatusFailedFinal
+			tx.NextRetryAt = nil
+		} else {
+			nextRetry := plan.ScheduledTimes[made]
+			tx.NextRetryAt = &nextRetry
+		}
+		updated = tx
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return fmt.Errorf("transaction %s not found: %w", txID, store.ErrNotFound)
+		}
+		return err
+	}
+
+	if updated.Status == domain.StatusFailedFinal {
+		e.notifier.Send(updated, domain.EventRetryExhausted, len(updated.RetryAttempts))
+	}
+	e.logger.Info("transaction rescheduled",
+		"transaction_id", txID,
+		"status", updated.Status,
+		"next_retry_at", updated.NextRetryAt,
+	)
+	return nil
+}
+
+// ResyncPending reschedules every pending transaction against the current
+// strategy configuration. Transactions that reach a terminal state before
+// they can be rescheduled (e.g., the scheduler retried them concurrently) are skipped.
+// Transactions are rescheduled oldest first, so a card's later retries are
+// spaced against its earlier ones' new plans rather than their old ones.
+func (e *Engine) ResyncPending() (updated int, skipped int) {
+	pending := e.store.GetPendingRetries()
+	slices.SortFunc(pending, func(a, b *domain.Transaction) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	for _, tx := range pending {
+		if err := e.Reschedule(tx.ID); err != nil {
+			skipped++
+			continue
+		}
+		updated++
+	}
+	return updated, skipped
+}
+
+// Acknowledge records that the merchant resolved a pending transaction
+// out-of-band. Remaining retries are cancelled, the reason is stored, and a
+// retry.cancelled event is emitted. Only scheduled or retrying transactions
+// can be acknowledged. A non-zero ifVersion makes the update conditional on
+// the transaction's version (store.ErrVersionMismatch otherwise).
+func (e *Engine) Acknowledge(txID string, reason string, ifVersion int) (*domain.Transaction, error) {
+	var updated *domain.Transaction
+	err := e.store.UpdateFuncIfVersion(txID, ifVersion, func(tx *domain.Transaction) error {
+		if tx.Status != domain.StatusScheduled && tx.Status != domain.StatusRetrying {
+			return fmt.Errorf("transaction %s cannot be acknowledged (status: %s): %w", txID, tx.Status, ErrNotRetryable)
+		}
+		tx.Status = domain.StatusResolvedExternally
+		tx.ResolutionReason = reason
+		tx.NextRetryAt = nil
+		tx.UpdatedAt = time.Now().UTC()
+		updated = tx
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, fmt.Errorf("transaction %s not found: %w", txID, store.ErrNotFound)
+		}
+		return nil, err
+	}
+
+	e.notifier.Send(updated, domain.EventRetryCancelled, len(updated.RetryAttempts))
+	e.logger.Info("transaction resolved externally",
+		"transaction_id", txID,
+		"reason", reason,
+		"attempts_made", len(updated.RetryAttempts),
+	)
+	return updated, nil
+}
+
+// Confirm completes a two-phase recovery: a pending_confirmation transaction
+// moves to recovered and from then on counts as recovered in analytics. No
+// webhook is sent; retry.succeeded went out when the retry succeeded. A
+// non-zero ifVersion makes the update conditional on the transaction's
+// version (store.ErrVersionMismatch otherwise).
+func (e *Engine) Confirm(txID string, ifVersion int) (*domain.Transaction, error) {
+	var updated *domain.Transaction
+	err := e.store.UpdateFuncIfVersion(txID, ifVersion, func(tx *domain.Transaction) error {
+		if tx.Status != domain.StatusPendingConfirmation {
+			return fmt.Errorf("transaction %s cannot be confirmed (status: %s): %w", txID, tx.Status, ErrNotRetryable)
+		}
+		tx.Status = domain.StatusRecovered
+		tx.UpdatedAt = time.Now().UTC()
+		updated = tx
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, fmt.Errorf("transaction %s not found: %w", txID, store.ErrNotFound)
+		}
+		return nil, err
+	}
+
+	e.logger.Info("recovery confirmed", "transaction_id", txID)
+	return updated, nil
 }
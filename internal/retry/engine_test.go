@@ -1,11 +1,16 @@
 package retry
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/eabugauch/zenithpay-retry/internal/domain"
 	"github.com/eabugauch/zenithpay-retry/internal/store"
@@ -15,7 +20,7 @@ import (
 func setupEngine() (*Engine, *store.Store, *webhook.Notifier) {
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
 	s := store.New()
-	sim := NewSimulator(42) // Fixed seed for deterministic tests
+	sim := NewSimulator(42, 0, SeedShared) // Fixed seed for deterministic tests
 	notifier := webhook.NewNotifier(logger)
 	engine := NewEngine(s, sim, notifier, logger)
 	return engine, s, notifier
@@ -248,6 +253,169 @@ func TestExecuteRetry_ExhaustedReturnsSentinel(t *testing.T) {
 	}
 }
 
+func TestExecuteRetry_FallbackStrategyOnExhaustion(t *testing.T) {
+	engine, s, _ := setupEngine()
+
+	// authentication_failed -> do_not_honor -> insufficient_funds, with the
+	// default depth of 1 only the first fallback may be taken.
+	auth := *domain.GetRetryStrategy("authentication_failed")
+	dnh := *domain.GetRetryStrategy("do_not_honor")
+	defer domain.PatchStrategy("authentication_failed", domain.StrategyPatch{
+		PerAttemptRates: &auth.PerAttemptRates,
+		FallbackCode:    &auth.FallbackCode,
+	})
+	defer domain.PatchStrategy("do_not_honor", domain.StrategyPatch{
+		PerAttemptRates: &dnh.PerAttemptRates,
+		FallbackCode:    &dnh.FallbackCode,
+	})
+
+	// The fallback's attempts use its own rates from its first attempt on, not
+	// the exhausted strategy's last rate.
+	never := []float64{0, 0}
+	dnhFirst := make([]float64, dnh.MaxAttempts)
+	dnhFirst[0] = 1
+	toDNH, toFunds := "do_not_honor", "insufficient_funds"
+	if _, err := domain.PatchStrategy("authentication_failed", domain.StrategyPatch{
+		PerAttemptRates: &never,
+		FallbackCode:    &toDNH,
+	}); err != nil {
+		t.Fatalf("unexpected patch error: %v", err)
+	}
+	if _, err := domain.PatchStrategy("do_not_honor", domain.StrategyPatch{
+		PerAttemptRates: &dnhFirst,
+		FallbackCode:    &toFunds,
+	}); err != nil {
+		t.Fatalf("unexpected patch error: %v", err)
+	}
+
+	_, _ = engine.Submit(domain.SubmitRequest{
+		TransactionID:     "txn_fallback",
+		AmountCents:       10000,
+		Currency:          "USD",
+		OriginalProcessor: "stripe_latam",
+		DeclineCode:       "authentication_failed", // max 2 attempts, never succeeds
+	})
+	for i := 0; i < auth.MaxAttempts; i++ {
+		if err := engine.ExecuteRetry("txn_fallback"); err != nil {
+			t.Fatalf("attempt %d: unexpected error: %v", i+1, err)
+		}
+	}
+
+	tx, _ := s.Get("txn_fallback")
+	if tx.Status != domain.StatusScheduled {
+		t.Fatalf("expected fallback to reschedule, got %s", tx.Status)
+	}
+	if len(tx.FallbackCodes) != 1 || tx.FallbackCodes[0] != "do_not_honor" {
+		t.Fatalf("expected one fallback to do_not_honor, got %v", tx.FallbackCodes)
+	}
+	want := auth.MaxAttempts + dnh.MaxAttempts
+	if tx.RetryPlan.MaxAttempts != want || len(tx.RetryPlan.ScheduledTimes) != want {
+		t.Errorf("expected plan extended to %d attempts, got %d (%d times)",
+			want, tx.RetryPlan.MaxAttempts, len(tx.RetryPlan.ScheduledTimes))
+	}
+	if err := engine.Reschedule("txn_fallback"); !errors.Is(err, ErrNotRetryable) {
+		t.Errorf("expected fallback plan to refuse reschedule, got %v", err)
+	}
+
+	if err := engine.ExecuteRetry("txn_fallback"); err != nil {
+		t.Fatalf("first fallback attempt: unexpected error: %v", err)
+	}
+	tx, _ = s.Get("txn_fallback")
+	if tx.Status != domain.StatusRecovered {
+		t.Errorf("expected the fallback's first-attempt rate to recover, got %s", tx.Status)
+	}
+
+	// With the fallback never succeeding either, the chain stops at depth 1.
+	dnhNever := make([]float64, dnh.MaxAttempts)
+	if _, err := domain.PatchStrategy("do_not_honor", domain.StrategyPatch{PerAttemptRates: &dnhNever}); err != nil {
+		t.Fatalf("unexpected patch error: %v", err)
+	}
+	_, _ = engine.Submit(domain.SubmitRequest{
+		TransactionID:     "txn_fallback_depth",
+		AmountCents:       10000,
+		Currency:          "USD",
+		OriginalProcessor: "stripe_latam",
+		DeclineCode:       "authentication_failed",
+	})
+	for i := 0; i < want+1; i++ {
+		engine.ExecuteRetry("txn_fallback_depth")
+	}
+	tx, _ = s.Get("txn_fallback_depth")
+	if tx.Status != domain.StatusFailedFinal {
+		t.Fatalf("expected failed_final after the fallback, got %s", tx.Status)
+	}
+	if len(tx.FallbackCodes) != 1 {
+		t.Errorf("expected chain depth to stop at 1 fallback, got %v", tx.FallbackCodes)
+	}
+}
+
+func TestExecuteRetryWith_StrategyFollowsOriginalProcessor(t *testing.T) {
+	engine, s, _ := setupEngine()
+	t.Cleanup(func() {
+		domain.ClearProcessorStrategy("stripe_latam", "do_not_honor")
+		domain.ClearProcessorStrategy("adyen_apac", "do_not_honor")
+	})
+
+	n := domain.GetRetryStrategy("do_not_honor").MaxAttempts
+	never, always := make([]float64, n), make([]float64, n)
+	for i := range always {
+		always[i] = 1
+	}
+	if err := domain.ApplyProcessorStrategyOverrides(map[string]map[string]domain.StrategyConfig{
+		"stripe_latam": {"do_not_honor": {PerAttemptRates: never}},
+		"adyen_apac":   {"do_not_honor": {PerAttemptRates: always}},
+	}); err != nil {
+		t.Fatalf("unexpected config error: %v", err)
+	}
+
+	_, _ = engine.Submit(domain.SubmitRequest{
+		TransactionID:     "txn_alt_rates",
+		AmountCents:       10000,
+		Currency:          "USD",
+		OriginalProcessor: "stripe_latam",
+		DeclineCode:       "do_not_honor",
+	})
+	// Routing through adyen_apac must not pick up adyen_apac's rates.
+	if err := engine.ExecuteRetryWith("txn_alt_rates", RetryOptions{Processor: "adyen_apac"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tx, _ := s.Get("txn_alt_rates")
+	if got := tx.RetryAttempts[0]; got.Processor != "adyen_apac" || got.Success {
+		t.Errorf("expected a failed attempt via adyen_apac under stripe_latam's rates, got %+v", got)
+	}
+}
+
+func TestExecuteRetry_ConfiguredResponseCode(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s := store.New()
+	sim := NewSimulator(42, 0, SeedShared)
+	if err := sim.SetResponseCode("authentication_failed", OutcomeDeclined, ResponseCode{Code: "N7", Message: "Decline for CVV2 failure"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	engine := NewEngine(s, sim, webhook.NewNotifier(logger), logger)
+
+	_, _ = engine.Submit(domain.SubmitRequest{
+		TransactionID:     "txn_response_code",
+		AmountCents:       10000,
+		Currency:          "USD",
+		OriginalProcessor: "stripe_latam",
+		DeclineCode:       "authentication_failed",
+	})
+	_ = engine.ExecuteRetry("txn_response_code")
+
+	tx, _ := s.Get("txn_response_code")
+	if len(tx.RetryAttempts) != 1 {
+		t.Fatalf("expected 1 attempt, got %d", len(tx.RetryAttempts))
+	}
+	a := tx.RetryAttempts[0]
+	if a.Success {
+		t.Fatal("expected the seeded first attempt to be declined")
+	}
+	if a.ResponseCode != "N7" || !strings.HasSuffix(a.ResponseMsg, "(Decline for CVV2 failure)") {
+		t.Errorf("expected configured response N7, got %s %q", a.ResponseCode, a.ResponseMsg)
+	}
+}
+
 func TestExecuteRetry_WebhookEvents(t *testing.T) {
 	engine, _, notifier := setupEngine()
 
@@ -304,3 +472,891 @@ func TestProcessAllPending(t *testing.T) {
 		}
 	}
 }
+
+func TestProcessPending_TruncatesAtAttemptBound(t *testing.T) {
+	engine, s, _ := setupEngine()
+	engine.SetMaxProcessAttempts(3)
+
+	for i := 0; i < 5; i++ {
+		_, _ = engine.Submit(domain.SubmitRequest{
+			TransactionID:     fmt.Sprintf("txn_bounded_%d", i),
+			AmountCents:       10000,
+			Currency:          "USD",
+			OriginalProcessor: "stripe_latam",
+			DeclineCode:       "do_not_honor",
+		})
+	}
+
+	summary := engine.ProcessPending(context.Background())
+	if !summary.Truncated || summary.Processed != 3 {
+		t.Fatalf("expected truncation after 3 attempts, got %+v", summary)
+	}
+	if pending := len(s.GetPendingRetries()); pending == 0 {
+		t.Error("expected pending transactions to remain after truncation")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if summary := engine.ProcessPending(ctx); !summary.Truncated || summary.Processed != 0 {
+		t.Errorf("expected a cancelled context to stop before any attempt, got %+v", summary)
+	}
+
+	engine.SetMaxProcessAttempts(0)
+	if summary := engine.ProcessPending(context.Background()); summary.Truncated {
+		t.Errorf("expected an unbounded run to finish, got %+v", summary)
+	}
+	if pending := len(s.GetPendingRetries()); pending != 0 {
+		t.Errorf("expected no pending transactions after an unbounded run, got %d", pending)
+	}
+}
+
+func TestProcessPending_AbortsWhenStoreCleared(t *testing.T) {
+	engine, s, _ := setupEngine()
+	if err := engine.simulator.SetProcessorLatency("stripe_latam", 20*time.Millisecond); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		_, _ = engine.Submit(domain.SubmitRequest{
+			TransactionID:     fmt.Sprintf("txn_clear_%d", i),
+			AmountCents:       10000,
+			Currency:          "USD",
+			OriginalProcessor: "stripe_latam",
+			DeclineCode:       "insufficient_funds",
+		})
+	}
+
+	done := make(chan ProcessSummary)
+	go func() { done <- engine.ProcessPending(context.Background()) }()
+	time.Sleep(50 * time.Millisecond)
+	s.Clear()
+
+	select {
+	case summary := <-done:
+		if !summary.Aborted {
+			t.Errorf("expected the run to abort after Clear, got %+v", summary)
+		}
+		if summary.Truncated {
+			t.Errorf("an aborted run is not truncated, got %+v", summary)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ProcessPending did not stop after Clear")
+	}
+	if n := s.Count(); n != 0 {
+		t.Errorf("expected the cleared store to stay empty, got %d transactions", n)
+	}
+
+	// A run started after the clear is unaffected
+	if summary := engine.ProcessPending(context.Background()); summary.Aborted {
+		t.Errorf("expected a fresh run not to abort, got %+v", summary)
+	}
+}
+
+func TestProcessPending_ClearDuringAttemptSparesReusedID(t *testing.T) {
+	engine, s, _ := setupEngine()
+	if err := engine.simulator.SetProcessorLatency("stripe_latam", 100*time.Millisecond); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	req := domain.SubmitRequest{
+		TransactionID:     "txn_reused",
+		AmountCents:       10000,
+		Currency:          "USD",
+		OriginalProcessor: "stripe_latam",
+		DeclineCode:       "insufficient_funds",
+	}
+	_, _ = engine.Submit(req)
+
+	done := make(chan ProcessSummary)
+	go func() { done <- engine.ProcessPending(context.Background()) }()
+	// Clear and reuse the ID while the first attempt is being simulated.
+	time.Sleep(30 * time.Millisecond)
+	s.Clear()
+	_, _ = engine.Submit(req)
+
+	summary := <-done
+	if !summary.Aborted || summary.Processed != 0 {
+		t.Errorf("expected the run to abort without recording the attempt, got %+v", summary)
+	}
+	if tx, _ := s.Get("txn_reused"); len(tx.RetryAttempts) != 0 || tx.Status != domain.StatusScheduled {
+		t.Errorf("expected the new transaction untouched, got status %s with %d attempts", tx.Status, len(tx.RetryAttempts))
+	}
+}
+
+func TestSubmit_RetryableResponseCodes(t *testing.T) {
+	engine, s, _ := setupEngine()
+	t.Cleanup(func() { domain.RestoreRetryStrategy("test_response_codes", nil) })
+
+	if err := domain.ApplyStrategyOverrides(map[string]domain.StrategyConfig{
+		"test_response_codes": {
+			MaxAttempts:            2,
+			Delays:                 []string{"1h", "2h"},
+			PerAttemptRates:        []float64{0.5, 0.5},
+			RetryableResponseCodes: []string{"05", "91"},
+		},
+	}); err != nil {
+		t.Fatalf("unexpected config error: %v", err)
+	}
+
+	resp, err := engine.Submit(domain.SubmitRequest{
+		TransactionID:     "txn_rc_blocked",
+		AmountCents:       10000,
+		Currency:          "USD",
+		OriginalProcessor: "stripe_latam",
+		DeclineCode:       "test_response_codes",
+		ResponseCode:      "14",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.RetryEligible || resp.RetryPlan != nil {
+		t.Error("out-of-list response code should not be retried")
+	}
+	tx, _ := s.Get("txn_rc_blocked")
+	if tx.Status != domain.StatusFailedFinal {
+		t.Errorf("expected failed_final, got %s", tx.Status)
+	}
+	if tx.NextRetryAt != nil {
+		t.Error("expected no next retry time")
+	}
+
+	resp, err = engine.Submit(domain.SubmitRequest{
+		TransactionID:     "txn_rc_allowed",
+		AmountCents:       10000,
+		Currency:          "USD",
+		OriginalProcessor: "stripe_latam",
+		DeclineCode:       "test_response_codes",
+		ResponseCode:      "91",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !resp.RetryEligible || resp.Status != domain.StatusScheduled {
+		t.Errorf("in-list response code should be scheduled, got %s", resp.Status)
+	}
+
+	resp, err = engine.Submit(domain.SubmitRequest{
+		TransactionID:     "txn_rc_missing",
+		AmountCents:       10000,
+		Currency:          "USD",
+		OriginalProcessor: "stripe_latam",
+		DeclineCode:       "test_response_codes",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !resp.RetryEligible || resp.Status != domain.StatusScheduled {
+		t.Errorf("missing response code should be scheduled, got %s", resp.Status)
+	}
+}
+
+func TestResyncPending_AppliesNewDelays(t *testing.T) {
+	engine, s, _ := setupEngine()
+
+	_, _ = engine.Submit(domain.SubmitRequest{
+		TransactionID:     "txn_resync",
+		AmountCents:       10000,
+		Currency:          "USD",
+		OriginalProcessor: "stripe_latam",
+		DeclineCode:       "insufficient_funds", // first delay 2h
+	})
+	_, _ = engine.Submit(domain.SubmitRequest{
+		TransactionID:     "txn_resync_hard",
+		AmountCents:       10000,
+		Currency:          "USD",
+		OriginalProcessor: "stripe_latam",
+		DeclineCode:       "stolen_card",
+	})
+
+	original := domain.GetRetryStrategy("insufficient_funds")
+	t.Cleanup(func() { domain.RestoreRetryStrategy("insufficient_funds", original) })
+	if err := domain.ApplyStrategyOverrides(map[string]domain.StrategyConfig{
+		"insufficient_funds": {Delays: []string{"10m", "20m", "30m"}},
+	}); err != nil {
+		t.Fatalf("unexpected config error: %v", err)
+	}
+
+	before := time.Now().UTC()
+	updated, skipped := engine.ResyncPending()
+	if updated != 1 || skipped != 0 {
+		t.Errorf("expected 1 updated and 0 skipped, got %d and %d", updated, skipped)
+	}
+
+	tx, _ := s.Get("txn_resync")
+	if tx.NextRetryAt == nil {
+		t.Fatal("expected next retry time")
+	}
+	delay := tx.NextRetryAt.Sub(before)
+	if delay < 10*time.Minute || delay > 11*time.Minute {
+		t.Errorf("expected next retry ~10m from now, got %v", delay)
+	}
+	if tx.RetryPlan.ScheduledTimes[1].Sub(*tx.NextRetryAt) != 10*time.Minute {
+		t.Errorf("expected second attempt 10m after first, got %v", tx.RetryPlan.ScheduledTimes[1].Sub(*tx.NextRetryAt))
+	}
+
+	hard, _ := s.Get("txn_resync_hard")
+	if hard.RetryPlan != nil {
+		t.Error("hard decline should not gain a retry plan")
+	}
+}
+
+func TestReschedule_TerminalNotRetryable(t *testing.T) {
+	engine, _, _ := setupEngine()
+
+	_, _ = engine.Submit(domain.SubmitRequest{
+		TransactionID:     "txn_resched_hard",
+		AmountCents:       10000,
+		Currency:          "USD",
+		OriginalProcessor: "stripe_latam",
+		DeclineCode:       "stolen_card",
+	})
+
+	if err := engine.Reschedule("txn_resched_hard"); !errors.Is(err, ErrNotRetryable) {
+		t.Errorf("expected ErrNotRetryable, got %v", err)
+	}
+	if err := engine.Reschedule("ghost"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestReschedule_KeepsProcessorRotation(t *testing.T) {
+	engine, s, _ := setupEngine()
+
+	_, _ = engine.Submit(domain.SubmitRequest{
+		TransactionID:     "txn_resched_rotate",
+		AmountCents:       10000,
+		Currency:          "USD",
+		OriginalProcessor: "stripe_latam",
+		DeclineCode:       "issuer_timeout", // rotates processors
+	})
+	// A manual first attempt through the plan's second processor.
+	_ = s.UpdateFunc("txn_resched_rotate", func(tx *domain.Transaction) error {
+		tx.RetryAttempts = append(tx.RetryAttempts, domain.RetryAttempt{
+			AttemptNumber: 1,
+			Processor:     tx.RetryPlan.Processors[1],
+			ScheduledAt:   tx.RetryPlan.ScheduledTimes[0],
+			ExecutedAt:    time.Now().UTC(),
+		})
+		tx.Status = domain.StatusRetrying
+		return nil
+	})
+
+	if err := engine.Reschedule("txn_resched_rotate"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tx, _ := s.Get("txn_resched_rotate")
+	procs := tx.RetryPlan.Processors
+	if procs[0] != tx.RetryAttempts[0].Processor {
+		t.Errorf("expected first slot to keep the attempt's processor %s, got %v", tx.RetryAttempts[0].Processor, procs)
+	}
+	for i := 1; i < len(procs); i++ {
+		if procs[i] == procs[i-1] {
+			t.Errorf("processor %s repeated in slots %d and %d: %v", procs[i], i-1, i, procs)
+		}
+	}
+}
+
+func TestResyncPending_KeepsCardRetryMinGap(t *testing.T) {
+	engine, s, _ := setupEngine()
+	domain.SetCardRetryMinGap(24 * time.Hour)
+	defer domain.SetCardRetryMinGap(0)
+
+	for _, id := range []string{"txn_resync_card_1", "txn_resync_card_2"} {
+		_, _ = engine.Submit(domain.SubmitRequest{
+			TransactionID:     id,
+			AmountCents:       5000,
+			Currency:          "USD",
+			OriginalProcessor: "stripe_latam",
+			DeclineCode:       "insufficient_funds",
+			CardToken:         "tok_resync",
+		})
+	}
+	before, _ := s.Get("txn_resync_card_2")
+
+	// Resyncing twice must neither drop the gap nor keep pushing plans out.
+	for range 2 {
+		if updated, _ := engine.ResyncPending(); updated != 2 {
+			t.Fatalf("expected 2 updated, got %d", updated)
+		}
+	}
+
+	first, _ := s.Get("txn_resync_card_1")
+	second, _ := s.Get("txn_resync_card_2")
+	want := first.LastRetryTime().Add(24 * time.Hour)
+	if got := second.RetryPlan.ScheduledTimes[0]; got.Before(want) {
+		t.Errorf("second card retry at %s, want at or after %s", got, want)
+	}
+	if drift := second.RetryPlan.ScheduledTimes[0].Sub(before.RetryPlan.ScheduledTimes[0]); drift > time.Minute {
+		t.Errorf("expected resync to keep the second plan in place, moved by %s", drift)
+	}
+}
+
+func TestAcknowledge_CancelsRemainingRetries(t *testing.T) {
+	engine, s, notifier := setupEngine()
+
+	_, _ = engine.Submit(domain.SubmitRequest{
+		TransactionID:     "txn_ack",
+		AmountCents:       10000,
+		Currency:          "USD",
+		OriginalProcessor: "stripe_latam",
+		DeclineCode:       "insufficient_funds",
+	})
+
+	tx, err := engine.Acknowledge("txn_ack", "customer paid by bank transfer", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tx.Status != domain.StatusResolvedExternally {
+		t.Errorf("expected resolved_externally, got %s", tx.Status)
+	}
+	if tx.ResolutionReason != "customer paid by bank transfer" {
+		t.Errorf("expected reason to be recorded, got %q", tx.ResolutionReason)
+	}
+	if tx.NextRetryAt != nil {
+		t.Error("expected no next retry time")
+	}
+
+	// Far-future cutoff: nothing should be due for the scheduler
+	if due := s.GetDueRetries(time.Now().Add(365 * 24 * time.Hour)); len(due) != 0 {
+		t.Errorf("scheduler should not pick up acknowledged transaction, got %d due", len(due))
+	}
+	if err := engine.ExecuteRetry("txn_ack"); !errors.Is(err, ErrNotRetryable) {
+		t.Errorf("expected ErrNotRetryable after ack, got %v", err)
+	}
+
+	events := notifier.GetEventsByTransaction("txn_ack")
+	if last := events[len(events)-1]; last.EventType != domain.EventRetryCancelled {
+		t.Errorf("expected retry.cancelled event, got %s", last.EventType)
+	}
+}
+
+func TestSubmit_CustomValidatorRejectsMerchant(t *testing.T) {
+	engine, s, _ := setupEngine()
+	engine.AddValidator(func(req domain.SubmitRequest) error {
+		if req.MerchantID == "merchant_blocked" {
+			return errors.New("merchant_blocked is not enrolled in retries")
+		}
+		return nil
+	})
+
+	req := domain.SubmitRequest{
+		TransactionID: "txn_blocked",
+		AmountCents:   5000,
+		Currency:      "USD",
+		MerchantID:    "merchant_blocked",
+		DeclineCode:   "insufficient_funds",
+	}
+	_, err := engine.Submit(req)
+	if !errors.Is(err, ErrInvalidRequest) {
+		t.Fatalf("expected ErrInvalidRequest, got %v", err)
+	}
+	if err.Error() != "merchant_blocked is not enrolled in retries" {
+		t.Errorf("expected validator message, got %q", err.Error())
+	}
+	if s.Exists("txn_blocked") {
+		t.Error("rejected request should not be stored")
+	}
+
+	req.TransactionID = "txn_allowed"
+	req.MerchantID = "merchant_ok"
+	if _, err := engine.Submit(req); err != nil {
+		t.Fatalf("expected other merchants to pass, got %v", err)
+	}
+}
+
+func TestSubmit_DefaultValidatorsRunFirst(t *testing.T) {
+	engine, _, _ := setupEngine()
+	called := false
+	engine.AddValidator(func(domain.SubmitRequest) error {
+		called = true
+		return nil
+	})
+
+	_, err := engine.Submit(domain.SubmitRequest{TransactionID: "txn_bad", DeclineCode: "insufficient_funds", Currency: "USD"})
+	if !errors.Is(err, ErrInvalidRequest) || err.Error() != "amount_cents must be positive" {
+		t.Fatalf("expected amount validation error, got %v", err)
+	}
+	if called {
+		t.Error("custom validator should not run after a built-in validator fails")
+	}
+}
+
+func TestSubmit_CardRetryMinGapOffsetsSharedToken(t *testing.T) {
+	engine, _, _ := setupEngine()
+	domain.SetCardRetryMinGap(24 * time.Hour)
+	defer domain.SetCardRetryMinGap(0)
+
+	submit := func(id, token string) *domain.SubmitResponse {
+		resp, err := engine.Submit(domain.SubmitRequest{
+			TransactionID:     id,
+			AmountCents:       5000,
+			Currency:          "USD",
+			OriginalProcessor: "stripe_latam",
+			DeclineCode:       "insufficient_funds",
+			CardToken:         token,
+		})
+		if err != nil {
+			t.Fatalf("submit %s: %v", id, err)
+		}
+		return resp
+	}
+
+	first := submit("txn_card_1", "tok_shared")
+	second := submit("txn_card_2", "tok_shared")
+	other := submit("txn_card_3", "tok_other")
+
+	lastOfFirst := first.RetryPlan.ScheduledTimes[len(first.RetryPlan.ScheduledTimes)-1]
+	want := lastOfFirst.Add(24 * time.Hour)
+	if got := second.RetryPlan.ScheduledTimes[0]; got.Before(want) {
+		t.Errorf("second card retry at %s, want at or after %s", got, want)
+	}
+
+	// Relative spacing within the shifted plan is preserved
+	firstGap := first.RetryPlan.ScheduledTimes[1].Sub(first.RetryPlan.ScheduledTimes[0])
+	secondGap := second.RetryPlan.ScheduledTimes[1].Sub(second.RetryPlan.ScheduledTimes[0])
+	if firstGap != secondGap {
+		t.Errorf("expected spacing %s preserved, got %s", firstGap, secondGap)
+	}
+
+	// A different token is not affected
+	if other.RetryPlan.ScheduledTimes[0].After(first.RetryPlan.ScheduledTimes[0].Add(time.Minute)) {
+		t.Errorf("unrelated token should not be offset: %s", other.RetryPlan.ScheduledTimes[0])
+	}
+}
+
+func TestSubmit_CardRetryMinGapConcurrent(t *testing.T) {
+	engine, s, _ := setupEngine()
+	domain.SetCardRetryMinGap(24 * time.Hour)
+	defer domain.SetCardRetryMinGap(0)
+
+	const n = 8
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = engine.Submit(domain.SubmitRequest{
+				TransactionID:     fmt.Sprintf("txn_card_race_%d", i),
+				AmountCents:       5000,
+				Currency:          "USD",
+				OriginalProcessor: "stripe_latam",
+				DeclineCode:       "insufficient_funds",
+				CardToken:         "tok_race",
+			})
+		}()
+	}
+	wg.Wait()
+
+	txs := s.GetByCardToken("tok_race")
+	if len(txs) != n {
+		t.Fatalf("expected %d transactions, got %d", n, len(txs))
+	}
+	slices.SortFunc(txs, func(a, b *domain.Transaction) int {
+		return a.RetryPlan.ScheduledTimes[0].Compare(b.RetryPlan.ScheduledTimes[0])
+	})
+	for i := 1; i < n; i++ {
+		want := txs[i-1].LastRetryTime().Add(24 * time.Hour)
+		if got := txs[i].RetryPlan.ScheduledTimes[0]; got.Before(want) {
+			t.Errorf("%s first retry at %s, want at or after %s (after %s)", txs[i].ID, got, want, txs[i-1].ID)
+		}
+	}
+}
+
+func TestReanchorOverdue_OnlyWhenWholeScheduleIsPast(t *testing.T) {
+	engine, s, _ := setupEngine()
+	now := time.Now().UTC()
+
+	partial := []time.Time{now.Add(-2 * time.Hour), now.Add(time.Hour)}
+	s.Save(&domain.Transaction{
+		ID: "txn_partial", Status: domain.StatusScheduled, DeclineCode: "issuer_timeout",
+		RetryPlan: &domain.RetryPlan{MaxAttempts: 2, ScheduledTimes: partial, Processors: []string{"a", "b"}},
+	})
+	changed, err := engine.ReanchorOverdue("txn_partial", now)
+	if err != nil || changed {
+		t.Errorf("expected no change while a remaining attempt is in the future, got changed=%v err=%v", changed, err)
+	}
+
+	if _, err := engine.ReanchorOverdue("txn_missing", now); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestSubmit_UnknownCodeDefaultStrategy(t *testing.T) {
+	engine, _, _ := setupEngine()
+	req := domain.SubmitRequest{
+		TransactionID: "txn_unknown_hard", AmountCents: 1000, Currency: "USD",
+		CustomerID: "c1", MerchantID: "m1", OriginalProcessor: "stripe_latam",
+		DeclineCode: "issuer_timout",
+	}
+
+	resp, err := engine.Submit(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Status != domain.StatusRejected {
+		t.Errorf("expected unknown code rejected by default, got %s", resp.Status)
+	}
+
+	if err := domain.SetDefaultUnknownStrategy(&domain.StrategyConfig{MaxAttempts: 1, Delays: []string{"1h"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer domain.SetDefaultUnknownStrategy(nil)
+
+	req.TransactionID = "txn_unknown_soft"
+	resp, err = engine.Submit(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Status != domain.StatusScheduled || resp.RetryPlan == nil || resp.RetryPlan.MaxAttempts != 1 {
+		t.Errorf("expected default strategy to schedule 1 attempt, got %+v", resp)
+	}
+}
+
+func TestSubmit_WebhookTimeoutBounds(t *testing.T) {
+	engine, s, _ := setupEngine()
+	base := domain.SubmitRequest{
+		AmountCents: 1000, Currency: "USD", CustomerID: "c1", MerchantID: "m1",
+		OriginalProcessor: "stripe_latam", DeclineCode: "issuer_timeout",
+	}
+
+	for i, ms := range []int{100, 60000} {
+		req := base
+		req.TransactionID = fmt.Sprintf("txn_wt_bad_%d", i)
+		req.WebhookTimeoutMs = ms
+		if _, err := engine.Submit(req); !errors.Is(err, ErrInvalidRequest) {
+			t.Errorf("expected ErrInvalidRequest for %dms, got %v", ms, err)
+		}
+	}
+
+	req := base
+	req.TransactionID = "txn_wt_ok"
+	req.WebhookTimeoutMs = 2000
+	if _, err := engine.Submit(req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tx, _ := s.Get("txn_wt_ok"); tx.WebhookTimeoutMs != 2000 {
+		t.Errorf("expected timeout stored on transaction, got %d", tx.WebhookTimeoutMs)
+	}
+}
+
+func TestSubmit_CustomerTimezone(t *testing.T) {
+	engine, s, _ := setupEngine()
+	base := domain.SubmitRequest{
+		AmountCents: 1000, Currency: "USD", CustomerID: "c1", MerchantID: "m1",
+		OriginalProcessor: "stripe_latam", DeclineCode: "insufficient_funds",
+	}
+
+	for i, tz := range []string{"Mars/Olympus_Mons", "Local"} {
+		req := base
+		req.TransactionID = fmt.Sprintf("txn_tz_bad_%d", i)
+		req.CustomerTimezone = tz
+		_, err := engine.Submit(req)
+		if fields := FieldErrors(err); len(fields) != 1 || fields[0].Field != "customer_timezone" {
+			t.Errorf("expected customer_timezone field error for %q, got %v", tz, err)
+		}
+	}
+
+	req := base
+	req.TransactionID = "txn_tz_ok"
+	req.CustomerTimezone = "America/Sao_Paulo"
+	if _, err := engine.Submit(req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tx, _ := s.Get("txn_tz_ok"); tx.CustomerTimezone != "America/Sao_Paulo" {
+		t.Errorf("expected timezone stored on transaction, got %q", tx.CustomerTimezone)
+	}
+}
+
+func TestSubmit_ExcludeProcessors(t *testing.T) {
+	engine, s, _ := setupEngine()
+	base := domain.SubmitRequest{
+		AmountCents: 1000, Currency: "USD", CustomerID: "c1", MerchantID: "m1",
+		OriginalProcessor: "stripe_latam", DeclineCode: "issuer_timeout",
+	}
+
+	req := base
+	req.TransactionID = "txn_excl_unknown"
+	req.ExcludeProcessors = []string{"acme_pay"}
+	_, err := engine.Submit(req)
+	if fields := FieldErrors(err); len(fields) != 1 || fields[0].Field != "exclude_processors[0]" {
+		t.Errorf("expected exclude_processors[0] field error, got %v", err)
+	}
+
+	req = base
+	req.TransactionID = "txn_excl_all"
+	req.ExcludeProcessors = domain.ListProcessors()
+	_, err = engine.Submit(req)
+	if fields := FieldErrors(err); len(fields) != 1 || fields[0].Field != "exclude_processors" {
+		t.Errorf("expected exclude_processors field error when nothing remains, got %v", err)
+	}
+	if _, err := s.Get("txn_excl_all"); !errors.Is(err, store.ErrNotFound) {
+		t.Error("rejected submit should not be stored")
+	}
+
+	req = base
+	req.TransactionID = "txn_excl_ok"
+	req.ExcludeProcessors = []string{"stripe_latam", "adyen_apac"}
+	resp, err := engine.Submit(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.RetryPlan.Processors[0] != "dlocal_br" {
+		t.Errorf("expected first attempt moved to dlocal_br, got %v", resp.RetryPlan.Processors)
+	}
+	for _, p := range resp.RetryPlan.Processors {
+		if p == "stripe_latam" || p == "adyen_apac" {
+			t.Errorf("plan routes to excluded processor: %v", resp.RetryPlan.Processors)
+		}
+	}
+
+	// A manual retry cannot route around the exclusion either.
+	err = engine.ExecuteRetryWith("txn_excl_ok", RetryOptions{Processor: "adyen_apac"})
+	if !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("expected ErrInvalidRequest for excluded processor, got %v", err)
+	}
+	if tx, _ := s.Get("txn_excl_ok"); len(tx.RetryAttempts) != 0 {
+		t.Errorf("expected no attempt recorded, got %d", len(tx.RetryAttempts))
+	}
+}
+
+func TestConfirm_TwoPhaseRecovery(t *testing.T) {
+	engine, s, notifier := setupEngine()
+	engine.RequireConfirmation("m_settle", true)
+
+	for _, id := range []string{"txn_confirm", "txn_confirm_other"} {
+		merchant := "m_settle"
+		if id == "txn_confirm_other" {
+			merchant = "m_plain"
+		}
+		if _, err := engine.Submit(domain.SubmitRequest{
+			TransactionID: id, AmountCents: 10000, Currency: "USD", MerchantID: merchant,
+			OriginalProcessor: "stripe_latam", DeclineCode: "insufficient_funds",
+		}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := engine.InjectAttempt(id, SimResult{Success: true, Outcome: OutcomeApproved, ResponseCode: "00"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if tx, _ := s.Get("txn_confirm_other"); tx.Status != domain.StatusRecovered {
+		t.Errorf("merchant without confirmation should recover directly, got %s", tx.Status)
+	}
+	tx, _ := s.Get("txn_confirm")
+	if tx.Status != domain.StatusPendingConfirmation {
+		t.Fatalf("expected pending_confirmation, got %s", tx.Status)
+	}
+	if tx.NextRetryAt != nil {
+		t.Error("expected no next retry while awaiting confirmation")
+	}
+	if err := engine.ExecuteRetry("txn_confirm"); !errors.Is(err, ErrNotRetryable) {
+		t.Errorf("expected ErrNotRetryable while awaiting confirmation, got %v", err)
+	}
+	if _, count := s.RecoveredTotal("m_settle", "USD"); count != 0 {
+		t.Errorf("unconfirmed recovery should not count toward totals, got %d", count)
+	}
+	events := notifier.GetEventsByTransaction("txn_confirm")
+	if last := events[len(events)-1]; last.EventType != domain.EventRetrySucceeded {
+		t.Errorf("expected retry.succeeded on success, got %s", last.EventType)
+	}
+
+	confirmed, err := engine.Confirm("txn_confirm", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if confirmed.Status != domain.StatusRecovered {
+		t.Errorf("expected recovered after confirm, got %s", confirmed.Status)
+	}
+	if _, err := engine.Confirm("txn_confirm", 0); !errors.Is(err, ErrNotRetryable) {
+		t.Errorf("expected ErrNotRetryable on second confirm, got %v", err)
+	}
+	if _, err := engine.Confirm("ghost", 0); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestInjectAttempt_PartialApprovalResetsBudget(t *testing.T) {
+	engine, s, _ := setupEngine()
+
+	dnh := *domain.GetRetryStrategy("do_not_honor")
+	defer domain.PatchStrategy("do_not_honor", domain.StrategyPatch{MaxBudgetResets: &dnh.MaxBudgetResets})
+	one := 1
+	if _, err := domain.PatchStrategy("do_not_honor", domain.StrategyPatch{MaxBudgetResets: &one}); err != nil {
+		t.Fatalf("unexpected patch error: %v", err)
+	}
+
+	_, _ = engine.Submit(domain.SubmitRequest{
+		TransactionID:     "txn_partial_reset",
+		AmountCents:       10000,
+		Currency:          "USD",
+		OriginalProcessor: "stripe_latam",
+		DeclineCode:       "do_not_honor",
+	})
+	partial := SimResult{Success: true, Outcome: OutcomeApproved, ResponseCode: "10", ApprovedCents: 4000}
+	if err := engine.InjectAttempt("txn_partial_reset", partial); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tx, _ := s.Get("txn_partial_reset")
+	if tx.Status != domain.StatusScheduled || tx.BudgetResets != 1 {
+		t.Fatalf("expected a fresh budget after the partial approval, got status %s with %d resets", tx.Status, tx.BudgetResets)
+	}
+	if tx.RecoveredCents != 4000 || tx.OutstandingCents() != 6000 {
+		t.Errorf("expected 4000 recovered and 6000 outstanding, got %d and %d", tx.RecoveredCents, tx.OutstandingCents())
+	}
+	if want := 1 + dnh.MaxAttempts; tx.RetryPlan.MaxAttempts != want || len(tx.RetryPlan.ScheduledTimes) != want {
+		t.Errorf("expected %d attempts after the reset, got %d (%d times)", want, tx.RetryPlan.MaxAttempts, len(tx.RetryPlan.ScheduledTimes))
+	}
+	if a := tx.RetryAttempts[0]; a.Success || a.ApprovedCents != 4000 {
+		t.Errorf("expected the partial attempt recorded as unsettled with 4000 approved, got %+v", a)
+	}
+
+	// The reset cap is reached: a second partial approval only continues the
+	// current budget.
+	partial.ApprovedCents = 1000
+	if err := engine.InjectAttempt("txn_partial_reset", partial); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tx, _ = s.Get("txn_partial_reset")
+	if tx.BudgetResets != 1 || tx.RetryPlan.MaxAttempts != 1+dnh.MaxAttempts {
+		t.Errorf("expected no second reset, got %d resets and %d attempts", tx.BudgetResets, tx.RetryPlan.MaxAttempts)
+	}
+	if tx.RecoveredCents != 5000 {
+		t.Errorf("expected 5000 recovered, got %d", tx.RecoveredCents)
+	}
+	if err := engine.Reschedule("txn_partial_reset"); !errors.Is(err, ErrNotRetryable) {
+		t.Errorf("expected a reset plan to refuse reschedule, got %v", err)
+	}
+
+	if err := engine.InjectAttempt("txn_partial_reset", SimResult{Success: true, Outcome: OutcomeApproved, ResponseCode: "00"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tx, _ = s.Get("txn_partial_reset"); tx.Status != domain.StatusRecovered {
+		t.Errorf("expected the remainder to recover, got %s", tx.Status)
+	}
+}
+
+func TestSubmit_NormalizesDeclineCode(t *testing.T) {
+	engine, s, _ := setupEngine()
+
+	resp, err := engine.Submit(domain.SubmitRequest{
+		TransactionID:     "txn_normalized",
+		AmountCents:       5000,
+		Currency:          "USD",
+		OriginalProcessor: "stripe_latam",
+		DeclineCode:       " Insufficient_Funds ",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Status != domain.StatusScheduled || resp.RetryPlan == nil || resp.RetryPlan.DeclineCode != "insufficient_funds" {
+		t.Fatalf("expected the insufficient_funds plan to be scheduled, got %+v", resp)
+	}
+
+	tx, _ := s.Get("txn_normalized")
+	if tx.DeclineCode != "insufficient_funds" || tx.RawDeclineCode != " Insufficient_Funds " {
+		t.Errorf("expected normalized code with raw input kept, got %q and %q", tx.DeclineCode, tx.RawDeclineCode)
+	}
+
+	if _, err := engine.Submit(domain.SubmitRequest{
+		TransactionID: "txn_blank_code", AmountCents: 5000, Currency: "USD",
+		OriginalProcessor: "stripe_latam", DeclineCode: "   ",
+	}); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("expected a blank decline code to fail validation, got %v", err)
+	}
+}
+
+func TestExecuteRetry_MinGapAfterFailure(t *testing.T) {
+	engine, s, _ := setupEngine()
+
+	dnh := *domain.GetRetryStrategy("do_not_honor")
+	origGap := dnh.MinGapAfterFailure.String()
+	defer domain.PatchStrategy("do_not_honor", domain.StrategyPatch{
+		PerAttemptRates:    &dnh.PerAttemptRates,
+		MinGapAfterFailure: &origGap,
+	})
+	never := make([]float64, len(dnh.PerAttemptRates))
+	gap := "720h"
+	if _, err := domain.PatchStrategy("do_not_honor", domain.StrategyPatch{
+		PerAttemptRates:    &never,
+		MinGapAfterFailure: &gap,
+	}); err != nil {
+		t.Fatalf("unexpected patch error: %v", err)
+	}
+
+	_, _ = engine.Submit(domain.SubmitRequest{
+		TransactionID:     "txn_min_gap",
+		AmountCents:       10000,
+		Currency:          "USD",
+		OriginalProcessor: "stripe_latam",
+		DeclineCode:       "do_not_honor",
+	})
+	before := time.Now().UTC()
+	if err := engine.ExecuteRetry("txn_min_gap"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tx, _ := s.Get("txn_min_gap")
+	planned := tx.RetryPlan.ScheduledTimes[1]
+	if tx.NextRetryAt == nil || !tx.NextRetryAt.After(planned) {
+		t.Fatalf("expected the gap to push the next retry past the planned %s, got %v", planned, tx.NextRetryAt)
+	}
+	if tx.NextRetryAt.Before(before.Add(720 * time.Hour)) {
+		t.Errorf("expected the next retry at least 720h after the failure, got %s", tx.NextRetryAt)
+	}
+
+	negative := "-1m"
+	if _, err := domain.PatchStrategy("do_not_honor", domain.StrategyPatch{MinGapAfterFailure: &negative}); err == nil {
+		t.Error("expected a negative min_gap_after_failure to be rejected")
+	}
+}
+
+func TestSubmit_PendingCapOverflow(t *testing.T) {
+	engine, s, _ := setupEngine()
+	orig := domain.GetRetryStrategy("issuer_timeout").MaxPending
+	defer domain.PatchStrategy("issuer_timeout", domain.StrategyPatch{MaxPending: &orig})
+	limit := 2
+	if _, err := domain.PatchStrategy("issuer_timeout", domain.StrategyPatch{MaxPending: &limit}); err != nil {
+		t.Fatalf("patch: %v", err)
+	}
+
+	submit := func(id string) *domain.SubmitResponse {
+		t.Helper()
+		resp, err := engine.Submit(domain.SubmitRequest{
+			TransactionID: id, AmountCents: 1000, Currency: "USD", CustomerID: "c1",
+			OriginalProcessor: "stripe_latam", DeclineCode: "issuer_timeout",
+		})
+		if err != nil {
+			t.Fatalf("submit %s: %v", id, err)
+		}
+		return resp
+	}
+
+	submit("txn_cap_1")
+	submit("txn_cap_2")
+	overflow := submit("txn_cap_3")
+	if overflow.Status != domain.StatusRejected || overflow.RetryEligible {
+		t.Fatalf("expected overflow submit rejected, got %+v", overflow)
+	}
+	if !strings.Contains(overflow.Message, "pending retries") {
+		t.Errorf("expected a pending-cap message, got %q", overflow.Message)
+	}
+	if n := s.PendingCount("issuer_timeout"); n != 2 {
+		t.Errorf("expected 2 pending issuer_timeout transactions, got %d", n)
+	}
+
+	// Other codes are not affected by the cap.
+	if resp, _ := engine.Submit(domain.SubmitRequest{
+		TransactionID: "txn_cap_other", AmountCents: 1000, Currency: "USD", CustomerID: "c1",
+		OriginalProcessor: "stripe_latam", DeclineCode: "insufficient_funds",
+	}); resp.Status != domain.StatusScheduled {
+		t.Errorf("expected other decline codes to schedule, got %s", resp.Status)
+	}
+
+	// Once a pending transaction completes, the code accepts submits again.
+	if _, err := engine.Acknowledge("txn_cap_1", "paid out of band", 0); err != nil {
+		t.Fatalf("acknowledge: %v", err)
+	}
+	if resp := submit("txn_cap_4"); resp.Status != domain.StatusScheduled {
+		t.Errorf("expected submit to schedule after pending drained, got %s", resp.Status)
+	}
+}
@@ -0,0 +1,90 @@
+package retry
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+)
+
+// AnyDeclineCode keys a response code that applies to every decline code
+// without its own entry for the outcome.
+const AnyDeclineCode = "*"
+
+// ResponseCode is the code and message a simulated processor returns.
+type ResponseCode struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// defaultResponseCodes maps decline code -> outcome -> response, using
+// ISO 8583 response codes. Timeouts keep the distinct TIMEOUT code unless one
+// is configured.
+var defaultResponseCodes = map[string]map[Outcome]ResponseCode{
+	AnyDeclineCode:          {OutcomeApproved: {Code: "00", Message: "Approved"}},
+	"insufficient_funds":    {OutcomeDeclined: {Code: "51", Message: "Not sufficient funds"}},
+	"issuer_timeout":        {OutcomeDeclined: {Code: "91", Message: "Issuer or switch inoperative"}},
+	"do_not_honor":          {OutcomeDeclined: {Code: "05", Message: "Do not honor"}},
+	"processor_error":       {OutcomeDeclined: {Code: "96", Message: "System malfunction"}},
+	"authentication_failed": {OutcomeDeclined: {Code: "55", Message: "Incorrect PIN or authentication failed"}},
+}
+
+// newResponseCodes returns a copy of the default taxonomy.
+func newResponseCodes() map[string]map[Outcome]ResponseCode {
+	codes := make(map[string]map[Outcome]ResponseCode, len(defaultResponseCodes))
+	for declineCode, byOutcome := range defaultResponseCodes {
+		codes[declineCode] = maps.Clone(byOutcome)
+	}
+	return codes
+}
+
+// SetResponseCode sets the response returned for an outcome of declineCode
+// (AnyDeclineCode for all codes without their own entry), replacing the
+// default. Must be set before the simulator is used.
+func (s *Simulator) SetResponseCode(declineCode string, outcome Outcome, rc ResponseCode) error {
+	switch outcome {
+	case OutcomeApproved, OutcomeDeclined, OutcomeTimeout:
+	default:
+		return fmt.Errorf("unknown outcome %q for %s", outcome, declineCode)
+	}
+	if declineCode == "" || rc.Code == "" {
+		return fmt.Errorf("response code for %s/%s needs a decline code and a code", declineCode, outcome)
+	}
+	if s.responses[declineCode] == nil {
+		s.responses[declineCode] = make(map[Outcome]ResponseCode)
+	}
+	s.responses[declineCode][outcome] = rc
+	return nil
+}
+
+// LoadResponseCodes reads a JSON file of decline code -> outcome -> response
+// code, e.g. {"do_not_honor": {"declined": {"code": "05", "message": "Do not
+// honor"}}}, and applies each entry over the defaults.
+func (s *Simulator) LoadResponseCodes(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading response codes %s: %w", path, err)
+	}
+	var codes map[string]map[Outcome]ResponseCode
+	if err := json.Unmarshal(data, &codes); err != nil {
+		return fmt.Errorf("parsing response codes %s: %w", path, err)
+	}
+	for declineCode, byOutcome := range codes {
+		for outcome, rc := range byOutcome {
+			if err := s.SetResponseCode(declineCode, outcome, rc); err != nil {
+				return fmt.Errorf("invalid response codes %s: %w", path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// responseCode looks up the response for an outcome of declineCode, falling
+// back to the AnyDeclineCode entry. ok is false when neither is configured.
+func (s *Simulator) responseCode(declineCode string, outcome Outcome) (rc ResponseCode, ok bool) {
+	if rc, ok := s.responses[declineCode][outcome]; ok {
+		return rc, true
+	}
+	rc, ok = s.responses[AnyDeclineCode][outcome]
+	return rc, ok
+}
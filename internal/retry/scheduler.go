@@ -2,33 +2,71 @@ package retry
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"time"
 
 	"github.com/eabugauch/zenithpay-retry/internal/store"
 )
 
+// OverduePolicy controls how the scheduler treats a transaction whose entire
+// remaining schedule is already in the past.
+type OverduePolicy string
+
+const (
+	// OverdueCatchUp runs the overdue attempts as-is, one per tick (default).
+	OverdueCatchUp OverduePolicy = "catch_up"
+	// OverdueReanchor shifts the remaining attempts so the next one runs now and
+	// the rest keep their original spacing after it.
+	OverdueReanchor OverduePolicy = "reanchor"
+)
+
+// ParseOverduePolicy converts a config value to an OverduePolicy.
+func ParseOverduePolicy(v string) (OverduePolicy, error) {
+	switch p := OverduePolicy(v); p {
+	case OverdueCatchUp, OverdueReanchor:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid overdue policy %q: must be %q or %q", v, OverdueCatchUp, OverdueReanchor)
+}
+
 // Scheduler runs a background loop that checks for due retry attempts and executes them.
 type Scheduler struct {
-	engine   *Engine
-	store    *store.Store
-	interval time.Duration
-	logger   *slog.Logger
+	engine        *Engine
+	store         *store.Store
+	interval      time.Duration
+	maxPerTick    int // 0 = unlimited
+	overduePolicy OverduePolicy
+	logger        *slog.Logger
 }
 
-// NewScheduler creates a background retry scheduler.
-func NewScheduler(engine *Engine, s *store.Store, interval time.Duration, logger *slog.Logger) *Scheduler {
+// NewScheduler creates a background retry scheduler. maxPerTick caps how many
+// due transactions a single tick processes (highest priority first, then
+// oldest NextRetryAt); the rest wait for the next tick. A value <= 0 means no limit.
+func NewScheduler(engine *Engine, s *store.Store, interval time.Duration, maxPerTick int, logger *slog.Logger) *Scheduler {
 	return &Scheduler{
-		engine:   engine,
-		store:    s,
-		interval: interval,
-		logger:   logger,
+		engine:        engine,
+		store:         s,
+		interval:      interval,
+		maxPerTick:    maxPerTick,
+		overduePolicy: OverdueCatchUp,
+		logger:        logger,
 	}
 }
 
+// SetOverduePolicy chooses how fully overdue schedules are handled. Must be
+// called before Start.
+func (s *Scheduler) SetOverduePolicy(p OverduePolicy) {
+	s.overduePolicy = p
+}
+
 // Start begins the background scheduling loop. It checks for due retries at the configured interval.
 func (s *Scheduler) Start(ctx context.Context) {
-	s.logger.Info("retry scheduler started", "interval", s.interval)
+	s.logger.Info("retry scheduler started",
+		"interval", s.interval,
+		"max_per_tick", s.maxPerTick,
+		"overdue_policy", s.overduePolicy,
+	)
 	ticker := time.NewTicker(s.interval)
 	defer ticker.Stop()
 
@@ -44,9 +82,35 @@ func (s *Scheduler) Start(ctx context.Context) {
 }
 
 func (s *Scheduler) processDueRetries() {
-	due := s.store.GetDueRetries(time.Now().UTC())
+	if s.engine.ReadOnly() {
+		s.logger.Debug("scheduler tick skipped: read-only mode")
+		return
+	}
+	if s.engine.RetriesSuspended() {
+		s.logger.Debug("scheduler tick skipped: retries suspended")
+		return
+	}
+
+	now := time.Now().UTC()
+	due := s.store.GetDueRetries(now)
+	if s.maxPerTick > 0 && len(due) > s.maxPerTick {
+		s.logger.Info("scheduler tick limit reached, deferring remaining due retries",
+			"due", len(due),
+			"max_per_tick", s.maxPerTick,
+		)
+		due = due[:s.maxPerTick]
+	}
 
 	for _, tx := range due {
+		if s.overduePolicy == OverdueReanchor {
+			if _, err := s.engine.ReanchorOverdue(tx.ID, now); err != nil {
+				s.logger.Error("scheduler re-anchor failed",
+					"transaction_id", tx.ID,
+					"error", err,
+				)
+			}
+		}
+
 		s.logger.Info("scheduler executing due retry",
 			"transaction_id", tx.ID,
 			"scheduled_for", tx.NextRetryAt,
@@ -2,6 +2,7 @@ package retry
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"log/slog"
 	"testing"
@@ -15,10 +16,10 @@ import (
 func setupSchedulerTest() (*Scheduler, *store.Store) {
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
 	s := store.New()
-	sim := NewSimulator(42)
+	sim := NewSimulator(42, 0, SeedShared)
 	notifier := webhook.NewNotifier(logger)
 	engine := NewEngine(s, sim, notifier, logger)
-	scheduler := NewScheduler(engine, s, 50*time.Millisecond, logger)
+	scheduler := NewScheduler(engine, s, 50*time.Millisecond, 0, logger)
 	return scheduler, s
 }
 
@@ -175,3 +176,251 @@ func TestScheduler_SkipsTerminalStatus(t *testing.T) {
 		t.Error("scheduler should skip transactions in terminal status")
 	}
 }
+
+func TestScheduler_MaxPerTick(t *testing.T) {
+	scheduler, s := setupSchedulerTest()
+	scheduler.maxPerTick = 10
+
+	base := time.Now().UTC().Add(-2 * time.Hour)
+	for i := 0; i < 100; i++ {
+		due := base.Add(time.Duration(i) * time.Minute)
+		s.Save(&domain.Transaction{
+			ID:              fmt.Sprintf("txn_tick_%03d", i),
+			DeclineCode:     "issuer_timeout",
+			DeclineCategory: domain.SoftDecline,
+			Status:          domain.StatusScheduled,
+			NextRetryAt:     &due,
+			RetryAttempts:   []domain.RetryAttempt{},
+			RetryPlan: &domain.RetryPlan{
+				MaxAttempts:    1,
+				DeclineCode:    "issuer_timeout",
+				ScheduledTimes: []time.Time{due},
+				Processors:     []string{"stripe_latam"},
+			},
+		})
+	}
+
+	advanced := func() []string {
+		var ids []string
+		for _, tx := range s.GetAll() {
+			if len(tx.RetryAttempts) > 0 {
+				ids = append(ids, tx.ID)
+			}
+		}
+		return ids
+	}
+
+	scheduler.processDueRetries()
+	ids := advanced()
+	if len(ids) != 10 {
+		t.Fatalf("expected 10 transactions advanced after one tick, got %d", len(ids))
+	}
+	for _, id := range ids {
+		if id > "txn_tick_009" {
+			t.Errorf("expected oldest due transactions first, got %s", id)
+		}
+	}
+
+	scheduler.processDueRetries()
+	if got := len(advanced()); got != 20 {
+		t.Errorf("expected 20 transactions advanced after two ticks, got %d", got)
+	}
+}
+
+func TestScheduler_MaxPerTick_HigherPriorityFirst(t *testing.T) {
+	scheduler, s := setupSchedulerTest()
+	scheduler.maxPerTick = 1
+
+	base := time.Now().UTC().Add(-2 * time.Hour)
+	for i, id := range []string{"txn_prio_oldest", "txn_prio_older"} {
+		due := base.Add(time.Duration(i) * time.Minute)
+		s.Save(&domain.Transaction{
+			ID:              id,
+			DeclineCode:     "issuer_timeout",
+			DeclineCategory: domain.SoftDecline,
+			Status:          domain.StatusScheduled,
+			NextRetryAt:     &due,
+			RetryAttempts:   []domain.RetryAttempt{},
+			RetryPlan: &domain.RetryPlan{
+				MaxAttempts:    1,
+				DeclineCode:    "issuer_timeout",
+				ScheduledTimes: []time.Time{due},
+				Processors:     []string{"stripe_latam"},
+			},
+		})
+	}
+
+	// The VIP transaction is submitted last and becomes due last.
+	if _, err := scheduler.engine.Submit(domain.SubmitRequest{
+		TransactionID:     "txn_prio_vip",
+		AmountCents:       500000,
+		Currency:          "USD",
+		OriginalProcessor: "stripe_latam",
+		DeclineCode:       "issuer_timeout",
+		Priority:          10,
+	}); err != nil {
+		t.Fatalf("unexpected submit error: %v", err)
+	}
+	s.UpdateFunc("txn_prio_vip", func(tx *domain.Transaction) error {
+		due := base.Add(time.Hour)
+		tx.NextRetryAt = &due
+		return nil
+	})
+
+	attempted := func(id string) bool {
+		tx, _ := s.Get(id)
+		return len(tx.RetryAttempts) > 0
+	}
+
+	scheduler.processDueRetries()
+	if !attempted("txn_prio_vip") || attempted("txn_prio_oldest") || attempted("txn_prio_older") {
+		t.Fatal("expected only the high-priority transaction to run on the first tick")
+	}
+	scheduler.processDueRetries()
+	if !attempted("txn_prio_oldest") || attempted("txn_prio_older") {
+		t.Error("expected equal-priority transactions to run oldest due first")
+	}
+}
+
+func TestScheduler_OverduePolicy(t *testing.T) {
+	tests := []struct {
+		name         string
+		policy       OverduePolicy
+		wantReanchor bool
+	}{
+		{"catch up runs attempts as scheduled", OverdueCatchUp, false},
+		{"reanchor shifts remaining schedule to now", OverdueReanchor, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheduler, s := setupSchedulerTest()
+			scheduler.SetOverduePolicy(tt.policy)
+
+			// Entire schedule is days in the past
+			past := time.Now().UTC().Add(-72 * time.Hour)
+			original := []time.Time{past, past.Add(time.Hour), past.Add(3 * time.Hour)}
+			s.Save(&domain.Transaction{
+				ID:              "txn_overdue",
+				AmountCents:     10000,
+				Currency:        "USD",
+				DeclineCode:     "issuer_timeout",
+				DeclineCategory: domain.SoftDecline,
+				Status:          domain.StatusScheduled,
+				NextRetryAt:     &past,
+				RetryAttempts:   []domain.RetryAttempt{},
+				RetryPlan: &domain.RetryPlan{
+					MaxAttempts:    3,
+					DeclineCode:    "issuer_timeout",
+					ScheduledTimes: append([]time.Time(nil), original...),
+					Processors:     []string{"stripe_latam", "adyen_apac", "dlocal_br"},
+				},
+			})
+
+			before := time.Now().UTC()
+			scheduler.processDueRetries()
+
+			got, _ := s.Get("txn_overdue")
+			if len(got.RetryAttempts) != 1 {
+				t.Fatalf("expected exactly 1 attempt after one tick, got %d", len(got.RetryAttempts))
+			}
+			first := got.RetryAttempts[0].ScheduledAt
+			times := got.RetryPlan.ScheduledTimes
+
+			if !tt.wantReanchor {
+				for i := range original {
+					if !times[i].Equal(original[i]) {
+						t.Errorf("catch_up must not move attempt %d: %s -> %s", i+1, original[i], times[i])
+					}
+				}
+				if !first.Equal(past) {
+					t.Errorf("expected attempt scheduled at original %s, got %s", past, first)
+				}
+				return
+			}
+
+			if first.Before(before) {
+				t.Errorf("expected re-anchored attempt at or after %s, got %s", before, first)
+			}
+			for i := 1; i < len(times); i++ {
+				if gap, want := times[i].Sub(times[i-1]), original[i].Sub(original[i-1]); gap != want {
+					t.Errorf("gap before attempt %d: expected %s, got %s", i+1, want, gap)
+				}
+			}
+			if got.Status == domain.StatusScheduled || got.Status == domain.StatusRetrying {
+				if got.NextRetryAt == nil || got.NextRetryAt.Before(before) {
+					t.Errorf("expected next retry in the future, got %v", got.NextRetryAt)
+				}
+			}
+		})
+	}
+}
+
+func TestParseOverduePolicy(t *testing.T) {
+	for _, v := range []string{"catch_up", "reanchor"} {
+		if _, err := ParseOverduePolicy(v); err != nil {
+			t.Errorf("%q: unexpected error %v", v, err)
+		}
+	}
+	if _, err := ParseOverduePolicy("skip"); err == nil {
+		t.Error("expected error for unknown policy")
+	}
+}
+
+func TestScheduler_SkipsTicksInReadOnlyMode(t *testing.T) {
+	scheduler, s := setupSchedulerTest()
+	scheduler.engine.SetReadOnly(true)
+
+	past := time.Now().UTC().Add(-time.Minute)
+	s.Save(&domain.Transaction{
+		ID:              "txn_ro_due",
+		DeclineCode:     "issuer_timeout",
+		DeclineCategory: domain.SoftDecline,
+		Status:          domain.StatusScheduled,
+		NextRetryAt:     &past,
+		RetryPlan: &domain.RetryPlan{
+			MaxAttempts:    1,
+			ScheduledTimes: []time.Time{past},
+			Processors:     []string{"stripe_latam"},
+		},
+	})
+
+	scheduler.processDueRetries()
+	if got, _ := s.Get("txn_ro_due"); len(got.RetryAttempts) != 0 {
+		t.Error("scheduler must not execute retries in read-only mode")
+	}
+
+	scheduler.engine.SetReadOnly(false)
+	scheduler.processDueRetries()
+	if got, _ := s.Get("txn_ro_due"); len(got.RetryAttempts) != 1 {
+		t.Error("scheduler should resume after read-only mode ends")
+	}
+}
+
+func TestScheduler_SkipsTicksWhileRetriesSuspended(t *testing.T) {
+	scheduler, s := setupSchedulerTest()
+	scheduler.engine.SetRetriesSuspended(true)
+
+	// Submits are still accepted while suspended, unlike read-only mode.
+	if _, err := scheduler.engine.Submit(domain.SubmitRequest{
+		TransactionID:     "txn_suspended",
+		AmountCents:       10000,
+		Currency:          "USD",
+		OriginalProcessor: "stripe_latam",
+		DeclineCode:       "issuer_timeout", // first attempt due immediately
+	}); err != nil {
+		t.Fatalf("expected submit to succeed while suspended, got %v", err)
+	}
+
+	scheduler.processDueRetries()
+	tx, _ := s.Get("txn_suspended")
+	if tx.Status != domain.StatusScheduled || len(tx.RetryAttempts) != 0 {
+		t.Fatalf("expected no attempts while suspended, got status %s with %d attempts", tx.Status, len(tx.RetryAttempts))
+	}
+
+	scheduler.engine.SetRetriesSuspended(false)
+	scheduler.processDueRetries()
+	if tx, _ := s.Get("txn_suspended"); len(tx.RetryAttempts) != 1 {
+		t.Error("scheduler should resume once retries are no longer suspended")
+	}
+}
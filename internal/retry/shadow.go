@@ -0,0 +1,46 @@
+package retry
+
+import (
+	"github.com/eabugauch/zenithpay-retry/internal/domain"
+)
+
+// ProjectRecovery replays each transaction's retry sequence through sim using
+// strategy instead of the live configuration, and reports projected versus
+// actual recovery. Nothing is written back; the transactions are only read.
+func ProjectRecovery(strategy *domain.RetryStrategy, txs []*domain.Transaction, sim *Simulator) domain.ShadowReport {
+	report := domain.ShadowReport{
+		DeclineCode:        strategy.DeclineCode,
+		ShadowMaxAttempts:  strategy.MaxAttempts,
+		Evaluated:          len(txs),
+		ProjectedByAttempt: make([]int, strategy.MaxAttempts),
+	}
+
+	for _, tx := range txs {
+		switch tx.Status {
+		case domain.StatusRecovered:
+			report.ActualRecovered++
+			report.ActualCompleted++
+		case domain.StatusFailedFinal:
+			report.ActualCompleted++
+		}
+
+		opts := tx.PlanOptions()
+		opts.Strategy = strategy
+		plan := domain.BuildRetryPlan(strategy.DeclineCode, tx.OriginalProcessor, tx.CreatedAt, opts)
+		for attempt := 1; attempt <= plan.MaxAttempts; attempt++ {
+			if sim.ProcessWithStrategy(strategy, attempt, plan.Processors[attempt-1]).Success {
+				report.ProjectedRecovered++
+				report.ProjectedByAttempt[attempt-1]++
+				break
+			}
+		}
+	}
+
+	if report.ActualCompleted > 0 {
+		report.ActualRecoveryRate = float64(report.ActualRecovered) / float64(report.ActualCompleted) * 100
+	}
+	if report.Evaluated > 0 {
+		report.ProjectedRecoveryRate = float64(report.ProjectedRecovered) / float64(report.Evaluated) * 100
+	}
+	return report
+}
@@ -2,69 +2,275 @@ package retry
 
 import (
 	"fmt"
+	"hash/fnv"
+	"maps"
 	"math/rand"
 	"sync"
+	"time"
 
 	"github.com/eabugauch/zenithpay-retry/internal/domain"
 )
 
+// Outcome classifies a simulated processor call.
+type Outcome string
+
+const (
+	OutcomeApproved Outcome = "approved"
+	OutcomeDeclined Outcome = "declined"
+	OutcomeTimeout  Outcome = "timeout" // no answer within the strategy's attempt timeout; a failure
+)
+
+// SeedMode selects how the simulator's random draws are seeded.
+type SeedMode string
+
+const (
+	// SeedShared draws every attempt from one RNG, so an attempt's outcome
+	// depends on how many attempts were simulated before it.
+	SeedShared SeedMode = "shared"
+	// SeedPerTransaction derives each attempt's draws from the seed, the
+	// transaction ID and the attempt number, so a transaction's outcomes do
+	// not depend on the order in which transactions are processed.
+	SeedPerTransaction SeedMode = "transaction"
+)
+
+// ParseSeedMode parses a mode name as used in SIMULATOR_SEED_MODE.
+func ParseSeedMode(s string) (SeedMode, error) {
+	switch m := SeedMode(s); m {
+	case SeedShared, SeedPerTransaction:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown seed mode %q (want shared or transaction)", s)
+}
+
 // SimResult represents the outcome of a simulated payment processor call.
 type SimResult struct {
 	Success         bool
+	Outcome         Outcome
 	ResponseCode    string
 	ResponseMessage string
+	// ApprovedCents, on a successful result, approves only this much of the
+	// outstanding balance (a partial approval). 0 or the full balance or more
+	// approves it all. The simulator itself always approves in full.
+	ApprovedCents int64
 }
 
+// meanProcessorLatency is the mean of the simulated (exponential) processor
+// latency that is compared against a strategy's AttemptTimeout.
+const meanProcessorLatency = time.Second
+
 // Simulator simulates payment processor API calls with configurable success rates.
 // It is safe for concurrent use.
 type Simulator struct {
-	mu  sync.Mutex
-	rng *rand.Rand
+	mu          sync.Mutex
+	rng         *rand.Rand
+	seed        int64
+	seedMode    SeedMode
+	noiseStdDev float64 // std dev of Gaussian noise added to success rates (0 = none)
+	switchBonus float64 // added to the success rate when an attempt changes processor
+	responses   map[string]map[Outcome]ResponseCode
+	latency     map[string]time.Duration // injected per-processor delay, for load testing
 }
 
-// NewSimulator creates a new payment processor simulator.
-func NewSimulator(seed int64) *Simulator {
+// NewSimulator creates a new payment processor simulator. noiseStdDev perturbs
+// each configured success rate by a seeded normal draw (clamped to [0, 1])
+// before the outcome is decided; 0 keeps the rates exact. Results remain
+// reproducible for a fixed seed either way. mode chooses between one shared
+// RNG and independent per-transaction draws; see SeedMode.
+func NewSimulator(seed int64, noiseStdDev float64, mode SeedMode) *Simulator {
 	return &Simulator{
-		rng: rand.New(rand.NewSource(seed)),
+		rng:         rand.New(rand.NewSource(seed)),
+		seed:        seed,
+		seedMode:    mode,
+		noiseStdDev: noiseStdDev,
+		responses:   newResponseCodes(),
+		latency:     make(map[string]time.Duration),
+	}
+}
+
+// SetProcessorSwitchBonus sets how much an attempt's success rate rises when it
+// goes through a different processor than the previous attempt (clamped to
+// [0, 1]; the boosted rate is clamped too). 0, the default, disables it. Must
+// be set before the simulator is used.
+func (s *Simulator) SetProcessorSwitchBonus(bonus float64) {
+	s.switchBonus = clampRate(bonus)
+}
+
+// MaxInjectedLatency bounds the delay SetProcessorLatency accepts.
+const MaxInjectedLatency = 10 * time.Second
+
+// SetProcessorLatency makes every simulated attempt through processor sleep
+// for d before deciding its outcome, to load-test callers against a slow
+// processor; 0 removes it. d must be at most MaxInjectedLatency. The sleep
+// happens outside the simulator's lock, so other attempts are unaffected.
+// Safe to call while the simulator is in use.
+func (s *Simulator) SetProcessorLatency(processor string, d time.Duration) error {
+	if d < 0 || d > MaxInjectedLatency {
+		return fmt.Errorf("latency for %s must be between 0 and %s, got %s", processor, MaxInjectedLatency, d)
 	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d == 0 {
+		delete(s.latency, processor)
+	} else {
+		s.latency[processor] = d
+	}
+	return nil
+}
+
+// ClearProcessorLatency removes the injected latency of every processor.
+func (s *Simulator) ClearProcessorLatency() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.latency)
+}
+
+// ProcessorLatency returns a copy of the injected latency per processor.
+func (s *Simulator) ProcessorLatency() map[string]time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.latency)
 }
 
 // ProcessPayment simulates a retry attempt through a payment processor.
 // Success probability is based on the decline code and attempt number,
-// using calibrated per-attempt rates from observed recovery data.
+// using calibrated per-attempt rates from observed recovery data
+// (or the processor's own rates, when a processor override defines them).
 func (s *Simulator) ProcessPayment(declineCode string, attemptNum int, processor string) SimResult {
-	strategy := domain.GetRetryStrategy(declineCode)
+	return s.ProcessAttempt(declineCode, attemptNum, processor, processor)
+}
+
+// ProcessAttempt is ProcessPayment for an attempt whose previous attempt went
+// through prevProcessor: when the two differ, the processor switch bonus is
+// added to the success rate.
+func (s *Simulator) ProcessAttempt(declineCode string, attemptNum int, processor, prevProcessor string) SimResult {
+	return s.ProcessTransactionAttempt("", declineCode, attemptNum, processor, prevProcessor)
+}
+
+// ProcessTransactionAttempt is ProcessAttempt for an attempt of transaction
+// txID. In SeedPerTransaction mode the outcome depends only on the seed, txID
+// and attemptNum; an empty txID uses the shared RNG.
+func (s *Simulator) ProcessTransactionAttempt(txID, declineCode string, attemptNum int, processor, prevProcessor string) SimResult {
+	return s.ProcessStrategyAttempt(txID, domain.GetRetryStrategyForProcessor(declineCode, processor), attemptNum, attemptNum, processor, prevProcessor)
+}
+
+// ProcessStrategyAttempt is ProcessTransactionAttempt with the strategy
+// resolved by the caller, e.g. from the transaction's original processor when
+// the attempt is routed through another one. processor only selects the
+// route; a nil strategy is not retryable. strategyAttempt is the attempt's
+// position within the strategy's own plan and picks its rate, which differs
+// from attemptNum once a fallback strategy has taken over.
+func (s *Simulator) ProcessStrategyAttempt(txID string, strategy *domain.RetryStrategy, attemptNum, strategyAttempt int, processor, prevProcessor string) SimResult {
 	if strategy == nil {
 		return SimResult{
 			Success:         false,
+			Outcome:         OutcomeDeclined,
 			ResponseCode:    "HARD_DECLINE",
 			ResponseMessage: "Transaction not retryable",
 		}
 	}
+	var bonus float64
+	if prevProcessor != "" && prevProcessor != processor {
+		bonus = s.switchBonus
+	}
+	return s.process(strategy, txID, attemptNum, strategyAttempt, processor, bonus)
+}
 
-	idx := attemptNum - 1
-	if idx >= len(strategy.PerAttemptRates) {
-		idx = len(strategy.PerAttemptRates) - 1
+// ProcessWithStrategy simulates a retry attempt using the given strategy's
+// per-attempt rates instead of the configured ones for its decline code.
+func (s *Simulator) ProcessWithStrategy(strategy *domain.RetryStrategy, attemptNum int, processor string) SimResult {
+	return s.process(strategy, "", attemptNum, attemptNum, processor, 0)
+}
+
+// attemptRNG returns the source for one attempt's draws and a function to call
+// once drawing is done: a fresh RNG keyed by transaction and attempt in
+// SeedPerTransaction mode, otherwise the shared RNG, locked until released.
+func (s *Simulator) attemptRNG(txID string, attemptNum int) (*rand.Rand, func()) {
+	if s.seedMode == SeedPerTransaction && txID != "" {
+		h := fnv.New64a()
+		fmt.Fprintf(h, "%d/%s/%d", s.seed, txID, attemptNum)
+		return rand.New(rand.NewSource(int64(h.Sum64()))), func() {}
+	}
+	s.mu.Lock()
+	return s.rng, s.mu.Unlock
+}
+
+// process decides one attempt from the strategy's rate for strategyAttempt
+// plus bonus.
+func (s *Simulator) process(strategy *domain.RetryStrategy, txID string, attemptNum, strategyAttempt int, processor string, bonus float64) SimResult {
+	declineCode := strategy.DeclineCode
+	// Strategies without per_attempt_rates (e.g. default_unknown_strategy)
+	// only succeed through the bonus.
+	var rate float64
+	if n := len(strategy.PerAttemptRates); n > 0 {
+		rate = strategy.PerAttemptRates[min(strategyAttempt-1, n-1)]
 	}
-	successRate := strategy.PerAttemptRates[idx]
+	successRate := clampRate(rate + bonus)
 
 	s.mu.Lock()
-	roll := s.rng.Float64()
+	delay := s.latency[processor]
 	s.mu.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+
+	rng, release := s.attemptRNG(txID, attemptNum)
+	if strategy.AttemptTimeout > 0 {
+		latency := time.Duration(rng.ExpFloat64() * float64(meanProcessorLatency))
+		if latency > strategy.AttemptTimeout {
+			release()
+			return s.withResponseCode(declineCode, SimResult{
+				Success:         false,
+				Outcome:         OutcomeTimeout,
+				ResponseCode:    "TIMEOUT",
+				ResponseMessage: fmt.Sprintf("Retry attempt %d via %s timed out after %s", attemptNum, processor, strategy.AttemptTimeout),
+			})
+		}
+	}
+	if s.noiseStdDev > 0 {
+		successRate = clampRate(successRate + rng.NormFloat64()*s.noiseStdDev)
+	}
+	roll := rng.Float64()
+	release()
 
 	success := roll < successRate
 
 	if success {
-		return SimResult{
+		return s.withResponseCode(declineCode, SimResult{
 			Success:         true,
+			Outcome:         OutcomeApproved,
 			ResponseCode:    "APPROVED",
 			ResponseMessage: fmt.Sprintf("Transaction approved by %s on attempt %d", processor, attemptNum),
-		}
+		})
 	}
 
-	return SimResult{
+	return s.withResponseCode(declineCode, SimResult{
 		Success:         false,
+		Outcome:         OutcomeDeclined,
 		ResponseCode:    fmt.Sprintf("DECLINE_%s", declineCode),
 		ResponseMessage: fmt.Sprintf("Retry attempt %d failed via %s: %s persists", attemptNum, processor, declineCode),
+	})
+}
+
+// withResponseCode replaces the result's generic code with the configured
+// response for its outcome, if there is one. The configured message is
+// appended to the result's own, which names the attempt and processor.
+func (s *Simulator) withResponseCode(declineCode string, result SimResult) SimResult {
+	if rc, ok := s.responseCode(declineCode, result.Outcome); ok {
+		result.ResponseCode = rc.Code
+		if rc.Message != "" {
+			result.ResponseMessage = fmt.Sprintf("%s (%s)", result.ResponseMessage, rc.Message)
+		}
+	}
+	return result
+}
+
+// clampRate bounds a probability to [0, 1].
+func clampRate(rate float64) float64 {
+	if rate < 0 {
+		return 0
+	}
+	if rate > 1 {
+		return 1
 	}
+	return rate
 }
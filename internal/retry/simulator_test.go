@@ -1,12 +1,20 @@
 package retry
 
 import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
 	"sync"
 	"testing"
+	"time"
+
+	"github.com/eabugauch/zenithpay-retry/internal/domain"
 )
 
 func TestSimulator_HardDecline(t *testing.T) {
-	sim := NewSimulator(42)
+	sim := NewSimulator(42, 0, SeedShared)
 	result := sim.ProcessPayment("stolen_card", 1, "stripe_latam")
 
 	if result.Success {
@@ -21,7 +29,7 @@ func TestSimulator_HardDecline(t *testing.T) {
 }
 
 func TestSimulator_UnknownDecline(t *testing.T) {
-	sim := NewSimulator(42)
+	sim := NewSimulator(42, 0, SeedShared)
 	result := sim.ProcessPayment("unknown_code", 1, "stripe_latam")
 
 	if result.Success {
@@ -34,8 +42,8 @@ func TestSimulator_UnknownDecline(t *testing.T) {
 
 func TestSimulator_Deterministic(t *testing.T) {
 	// Two simulators with the same seed should produce identical results
-	sim1 := NewSimulator(99)
-	sim2 := NewSimulator(99)
+	sim1 := NewSimulator(99, 0, SeedShared)
+	sim2 := NewSimulator(99, 0, SeedShared)
 
 	codes := []string{"insufficient_funds", "issuer_timeout", "processor_error", "do_not_honor"}
 	for _, code := range codes {
@@ -56,11 +64,11 @@ func TestSimulator_SuccessResponse(t *testing.T) {
 	// Use a seed that produces a success for issuer_timeout attempt 1 (40% rate)
 	// Try multiple seeds to find one that succeeds
 	for seed := int64(0); seed < 100; seed++ {
-		sim := NewSimulator(seed)
+		sim := NewSimulator(seed, 0, SeedShared)
 		result := sim.ProcessPayment("issuer_timeout", 1, "adyen_apac")
 		if result.Success {
-			if result.ResponseCode != "APPROVED" {
-				t.Errorf("expected APPROVED, got %s", result.ResponseCode)
+			if result.ResponseCode != "00" {
+				t.Errorf("expected approval code 00, got %s", result.ResponseCode)
 			}
 			if result.ResponseMessage == "" {
 				t.Error("expected non-empty success message")
@@ -74,10 +82,10 @@ func TestSimulator_SuccessResponse(t *testing.T) {
 func TestSimulator_FailureResponse(t *testing.T) {
 	// Use a seed that produces a failure for authentication_failed (15% rate)
 	for seed := int64(0); seed < 100; seed++ {
-		sim := NewSimulator(seed)
+		sim := NewSimulator(seed, 0, SeedShared)
 		result := sim.ProcessPayment("authentication_failed", 1, "dlocal_br")
 		if !result.Success {
-			expected := "DECLINE_authentication_failed"
+			expected := "55"
 			if result.ResponseCode != expected {
 				t.Errorf("expected %s, got %s", expected, result.ResponseCode)
 			}
@@ -93,8 +101,8 @@ func TestSimulator_FailureResponse(t *testing.T) {
 func TestSimulator_AttemptBeyondMaxClamps(t *testing.T) {
 	// authentication_failed has 2 PerAttemptRates: [0.15, 0.12]
 	// Attempt 5 should clamp to index 1 (last rate)
-	sim1 := NewSimulator(42)
-	sim2 := NewSimulator(42)
+	sim1 := NewSimulator(42, 0, SeedShared)
+	sim2 := NewSimulator(42, 0, SeedShared)
 
 	// Consume the same random values as attempt 5 would
 	// by using attempt 2 (index 1) which is the clamped value
@@ -108,7 +116,7 @@ func TestSimulator_AttemptBeyondMaxClamps(t *testing.T) {
 }
 
 func TestSimulator_ConcurrentSafe(t *testing.T) {
-	sim := NewSimulator(42)
+	sim := NewSimulator(42, 0, SeedShared)
 	var wg sync.WaitGroup
 
 	for i := 0; i < 100; i++ {
@@ -124,3 +132,203 @@ func TestSimulator_ConcurrentSafe(t *testing.T) {
 	wg.Wait()
 	// If we get here without panic or race detector complaint, concurrent access is safe
 }
+
+func TestSimulator_ZeroNoiseMatchesThreshold(t *testing.T) {
+	// With no noise, each outcome is exactly roll < configured rate
+	sim := NewSimulator(7, 0, SeedShared)
+	rng := rand.New(rand.NewSource(7))
+	rates := []float64{0.40, 0.30, 0.25} // issuer_timeout
+
+	for i := 0; i < 30; i++ {
+		attempt := i%3 + 1
+		want := rng.Float64() < rates[attempt-1]
+		if got := sim.ProcessPayment("issuer_timeout", attempt, "stripe_latam").Success; got != want {
+			t.Fatalf("call %d: expected success=%v, got %v", i, want, got)
+		}
+	}
+}
+
+func TestSimulator_NoiseDeterministicForSeed(t *testing.T) {
+	sim1 := NewSimulator(99, 0.1, SeedShared)
+	sim2 := NewSimulator(99, 0.1, SeedShared)
+	baseline := NewSimulator(99, 0, SeedShared)
+
+	differs := false
+	for i := 0; i < 200; i++ {
+		r1 := sim1.ProcessPayment("do_not_honor", 1, "stripe_latam")
+		r2 := sim2.ProcessPayment("do_not_honor", 1, "stripe_latam")
+		if r1 != r2 {
+			t.Fatalf("call %d: noisy simulators with the same seed diverged", i)
+		}
+		if r1.Success != baseline.ProcessPayment("do_not_honor", 1, "stripe_latam").Success {
+			differs = true
+		}
+	}
+	if !differs {
+		t.Error("expected noise to change at least one outcome versus the noiseless simulator")
+	}
+}
+
+func TestSimulator_ProcessorSwitchBonus(t *testing.T) {
+	same := NewSimulator(11, 0, SeedShared)
+	switched := NewSimulator(11, 0, SeedShared)
+	same.SetProcessorSwitchBonus(0.3)
+	switched.SetProcessorSwitchBonus(0.3)
+
+	var sameWins, switchWins int
+	for i := 0; i < 500; i++ {
+		s := same.ProcessAttempt("do_not_honor", 1, "adyen_apac", "adyen_apac").Success
+		w := switched.ProcessAttempt("do_not_honor", 1, "adyen_apac", "stripe_latam").Success
+		if s && !w {
+			t.Fatalf("call %d: same-processor attempt succeeded where the switched one failed on the same roll", i)
+		}
+		if s {
+			sameWins++
+		}
+		if w {
+			switchWins++
+		}
+	}
+	if switchWins <= sameWins {
+		t.Errorf("expected switched attempts to recover more often: switched=%d same=%d", switchWins, sameWins)
+	}
+
+	plain := NewSimulator(11, 0, SeedShared)
+	baseline := NewSimulator(11, 0, SeedShared)
+	for i := 0; i < 100; i++ {
+		if plain.ProcessAttempt("do_not_honor", 1, "adyen_apac", "stripe_latam") != baseline.ProcessPayment("do_not_honor", 1, "adyen_apac") {
+			t.Fatalf("call %d: zero bonus should match ProcessPayment", i)
+		}
+	}
+}
+
+func TestSimulator_AttemptTimeout(t *testing.T) {
+	strategy := *domain.GetRetryStrategy("issuer_timeout")
+	strategy.AttemptTimeout = 500 * time.Millisecond
+
+	sim1 := NewSimulator(5, 0, SeedShared)
+	sim2 := NewSimulator(5, 0, SeedShared)
+	var timeouts int
+	for i := 0; i < 200; i++ {
+		r := sim1.ProcessWithStrategy(&strategy, 1, "stripe_latam")
+		if r != sim2.ProcessWithStrategy(&strategy, 1, "stripe_latam") {
+			t.Fatalf("call %d: simulators with the same seed diverged", i)
+		}
+		if r.Outcome == OutcomeTimeout {
+			timeouts++
+			if r.Success || r.ResponseCode != "TIMEOUT" {
+				t.Fatalf("call %d: timeout should be a failure with TIMEOUT code, got %+v", i, r)
+			}
+		}
+	}
+	// Exponential latency with a 1s mean exceeds 500ms about 61% of the time.
+	if timeouts < 80 || timeouts > 160 {
+		t.Errorf("expected roughly 120 timeouts out of 200, got %d", timeouts)
+	}
+
+	strategy.AttemptTimeout = 0
+	for i := 0; i < 50; i++ {
+		if r := sim1.ProcessWithStrategy(&strategy, 1, "stripe_latam"); r.Outcome == OutcomeTimeout {
+			t.Fatal("expected no timeouts without an attempt timeout")
+		}
+	}
+}
+
+func TestClampRate(t *testing.T) {
+	tests := []struct{ in, want float64 }{{-0.2, 0}, {0.5, 0.5}, {1.3, 1}}
+	for _, tt := range tests {
+		if got := clampRate(tt.in); got != tt.want {
+			t.Errorf("clampRate(%v) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSimulator_LoadResponseCodes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "codes.json")
+	os.WriteFile(path, []byte(`{"*": {"approved": {"code": "A0", "message": "OK"}}}`), 0o644)
+
+	sim := NewSimulator(1, 0, SeedShared)
+	if err := sim.LoadResponseCodes(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rc, _ := sim.responseCode("do_not_honor", OutcomeApproved); rc.Code != "A0" {
+		t.Errorf("expected wildcard approval code A0, got %q", rc.Code)
+	}
+	if rc, _ := sim.responseCode("do_not_honor", OutcomeDeclined); rc.Code != "05" {
+		t.Errorf("expected default do_not_honor code 05 to be kept, got %q", rc.Code)
+	}
+	if _, ok := sim.responseCode("custom_code", OutcomeDeclined); ok {
+		t.Error("expected no response code for an unmapped decline")
+	}
+
+	os.WriteFile(path, []byte(`{"do_not_honor": {"refunded": {"code": "99"}}}`), 0o644)
+	if err := sim.LoadResponseCodes(path); err == nil {
+		t.Error("expected error for unknown outcome")
+	}
+}
+
+func TestSimulator_ResponseCodeKeepsAttemptDetails(t *testing.T) {
+	strategy := *domain.GetRetryStrategy("do_not_honor")
+	strategy.PerAttemptRates = []float64{0}
+
+	sim := NewSimulator(1, 0, SeedShared)
+	r := sim.ProcessWithStrategy(&strategy, 2, "adyen_apac")
+	if r.ResponseCode != "05" {
+		t.Errorf("expected default do_not_honor code 05, got %s", r.ResponseCode)
+	}
+	if want := "Retry attempt 2 failed via adyen_apac: do_not_honor persists (Do not honor)"; r.ResponseMessage != want {
+		t.Errorf("expected message %q, got %q", want, r.ResponseMessage)
+	}
+}
+
+func TestSimulator_ConfiguredTimeoutCode(t *testing.T) {
+	strategy := *domain.GetRetryStrategy("issuer_timeout")
+	strategy.AttemptTimeout = time.Nanosecond
+
+	sim := NewSimulator(1, 0, SeedShared)
+	if err := sim.SetResponseCode(AnyDeclineCode, OutcomeTimeout, ResponseCode{Code: "68", Message: "Response received too late"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r := sim.ProcessWithStrategy(&strategy, 1, "stripe_latam")
+	if r.Outcome != OutcomeTimeout || r.ResponseCode != "68" {
+		t.Fatalf("expected configured timeout code 68, got %+v", r)
+	}
+	if want := "Retry attempt 1 via stripe_latam timed out after 1ns (Response received too late)"; r.ResponseMessage != want {
+		t.Errorf("expected message %q, got %q", want, r.ResponseMessage)
+	}
+}
+
+func TestSimulator_PerTransactionSeedIgnoresOrder(t *testing.T) {
+	ids := make([]string, 20)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("txn_seed_%02d", i)
+	}
+	outcomes := func(order []string) map[string][]SimResult {
+		sim := NewSimulator(5, 0.05, SeedPerTransaction)
+		got := make(map[string][]SimResult)
+		for attempt := 1; attempt <= 3; attempt++ {
+			for _, id := range order {
+				got[id] = append(got[id], sim.ProcessTransactionAttempt(id, "do_not_honor", attempt, "stripe_latam", "stripe_latam"))
+			}
+		}
+		return got
+	}
+
+	forward := outcomes(ids)
+	reversed := slices.Clone(ids)
+	slices.Reverse(reversed)
+	backward := outcomes(reversed)
+
+	recovered := 0
+	for _, id := range ids {
+		if !slices.Equal(forward[id], backward[id]) {
+			t.Errorf("%s: outcomes depend on processing order: %v vs %v", id, forward[id], backward[id])
+		}
+		if forward[id][0].Success {
+			recovered++
+		}
+	}
+	if recovered == 0 || recovered == len(ids) {
+		t.Errorf("expected first attempts to vary across transactions, got %d of %d approved", recovered, len(ids))
+	}
+}
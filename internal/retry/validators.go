@@ -0,0 +1,249 @@
+package retry
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/eabugauch/zenithpay-retry/internal/domain"
+)
+
+// ErrInvalidRequest indicates a submit request was rejected by a validator.
+var ErrInvalidRequest = errors.New("invalid submit request")
+
+// SubmitValidator checks a submit request before it is classified. A non-nil
+// error rejects the request; its message is returned to the caller as-is. A
+// validator may report several problems at once with errors.Join, and may
+// name the offending field by returning a *FieldError.
+type SubmitValidator func(domain.SubmitRequest) error
+
+// FieldError is a single validation problem, optionally tied to a request field.
+type FieldError struct {
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e *FieldError) Error() string { return e.Message }
+
+// validationError carries every problem found in a request while matching
+// ErrInvalidRequest.
+type validationError struct {
+	fields []FieldError
+}
+
+func (e *validationError) Error() string {
+	msgs := make([]string, len(e.fields))
+	for i, f := range e.fields {
+		msgs[i] = f.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e *validationError) Is(target error) bool { return target == ErrInvalidRequest }
+
+// FieldErrors returns the individual problems behind a validation error from
+// Submit, or nil if err is not one.
+func FieldErrors(err error) []FieldError {
+	var ve *validationError
+	if !errors.As(err, &ve) {
+		return nil
+	}
+	return ve.fields
+}
+
+// runValidators runs every validator against req and collects all problems
+// they report, in order.
+func runValidators(validators []SubmitValidator, req domain.SubmitRequest) []FieldError {
+	var fields []FieldError
+	for _, validate := range validators {
+		fields = appendFieldErrors(fields, validate(req))
+	}
+	return fields
+}
+
+// appendFieldErrors flattens err (which may be joined) into fields.
+func appendFieldErrors(fields []FieldError, err error) []FieldError {
+	if err == nil {
+		return fields
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range joined.Unwrap() {
+			fields = appendFieldErrors(fields, e)
+		}
+		return fields
+	}
+	var fe *FieldError
+	if errors.As(err, &fe) {
+		return append(fields, *fe)
+	}
+	return append(fields, FieldError{Message: err.Error()})
+}
+
+// DefaultValidators returns the built-in request checks, in the order they run.
+func DefaultValidators() []SubmitValidator {
+	return []SubmitValidator{
+		ValidateRequiredFields,
+		ValidateAmount,
+		ValidateCurrency,
+		ValidateWebhookURLs,
+		ValidateWebhookTimeout,
+		ValidateTimestamp,
+		ValidateCustomerTimezone,
+		ValidateExcludeProcessors,
+	}
+}
+
+// Bounds for a per-transaction webhook_timeout_ms.
+const (
+	minWebhookTimeoutMs = 500
+	maxWebhookTimeoutMs = 30000
+)
+
+// ValidateRequiredFields rejects requests without a transaction ID or decline code.
+func ValidateRequiredFields(req domain.SubmitRequest) error {
+	var errs []error
+	if req.TransactionID == "" {
+		errs = append(errs, &FieldError{Field: "transaction_id", Message: "transaction_id is required"})
+	}
+	if req.DeclineCode == "" {
+		errs = append(errs, &FieldError{Field: "decline_code", Message: "decline_code is required"})
+	}
+	return errors.Join(errs...)
+}
+
+// ValidateAmount rejects non-positive amounts.
+func ValidateAmount(req domain.SubmitRequest) error {
+	if req.AmountCents <= 0 {
+		return &FieldError{Field: "amount_cents", Message: "amount_cents must be positive"}
+	}
+	return nil
+}
+
+// ValidateCurrency rejects requests without a currency.
+func ValidateCurrency(req domain.SubmitRequest) error {
+	if req.Currency == "" {
+		return &FieldError{Field: "currency", Message: "currency is required"}
+	}
+	return nil
+}
+
+// ValidateWebhookURLs checks that webhook_url and every webhook_routes entry
+// are absolute http(s) URLs, and that route keys and webhook_events entries
+// are known event types.
+func ValidateWebhookURLs(req domain.SubmitRequest) error {
+	var errs []error
+	if req.WebhookURL != "" && !validWebhookURL(req.WebhookURL) {
+		errs = append(errs, &FieldError{Field: "webhook_url", Message: "webhook_url must be an absolute http(s) URL"})
+	}
+	for _, eventType := range slices.Sorted(maps.Keys(req.WebhookRoutes)) {
+		field := "webhook_routes[" + eventType + "]"
+		if !domain.IsWebhookEventType(eventType) {
+			errs = append(errs, &FieldError{Field: field, Message: fmt.Sprintf("webhook_routes: unknown event type %q", eventType)})
+			continue
+		}
+		if !validWebhookURL(req.WebhookRoutes[eventType]) {
+			errs = append(errs, &FieldError{Field: field, Message: field + " must be an absolute http(s) URL"})
+		}
+	}
+	for i, eventType := range req.WebhookEvents {
+		if !domain.IsWebhookEventType(eventType) {
+			field := fmt.Sprintf("webhook_events[%d]", i)
+			errs = append(errs, &FieldError{Field: field, Message: fmt.Sprintf("webhook_events: unknown event type %q", eventType)})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ValidateWebhookTimeout checks that webhook_timeout_ms, if set, is within
+// 500ms-30s.
+func ValidateWebhookTimeout(req domain.SubmitRequest) error {
+	if req.WebhookTimeoutMs == 0 {
+		return nil
+	}
+	if req.WebhookTimeoutMs < minWebhookTimeoutMs || req.WebhookTimeoutMs > maxWebhookTimeoutMs {
+		return &FieldError{
+			Field:   "webhook_timeout_ms",
+			Message: fmt.Sprintf("webhook_timeout_ms must be between %d and %d", minWebhookTimeoutMs, maxWebhookTimeoutMs),
+		}
+	}
+	return nil
+}
+
+// maxTimestampSkew is how far in the future a submitted timestamp may be,
+// allowing for clock drift between the merchant and this service.
+const maxTimestampSkew = 5 * time.Minute
+
+// ValidateTimestamp rejects a timestamp more than maxTimestampSkew in the
+// future. Past timestamps are accepted so old declines can be backfilled.
+func ValidateTimestamp(req domain.SubmitRequest) error {
+	if req.Timestamp == "" {
+		return nil
+	}
+	ts, err := time.Parse(time.RFC3339, req.Timestamp)
+	if err != nil {
+		return nil // unparseable timestamps fall back to the submit time
+	}
+	if ts.After(time.Now().Add(maxTimestampSkew)) {
+		return &FieldError{
+			Field:   "timestamp",
+			Message: fmt.Sprintf("timestamp must not be more than %s in the future", maxTimestampSkew),
+		}
+	}
+	return nil
+}
+
+// ValidateCustomerTimezone checks that customer_timezone, if set, names a
+// loadable IANA time zone. "Local" is rejected since it depends on the host.
+func ValidateCustomerTimezone(req domain.SubmitRequest) error {
+	if req.CustomerTimezone == "" {
+		return nil
+	}
+	if _, err := time.LoadLocation(req.CustomerTimezone); err != nil || req.CustomerTimezone == "Local" {
+		return &FieldError{
+			Field:   "customer_timezone",
+			Message: fmt.Sprintf("customer_timezone %q is not a known IANA time zone", req.CustomerTimezone),
+		}
+	}
+	return nil
+}
+
+// ValidateExcludeProcessors checks that every exclude_processors entry is a
+// configured processor and that the exclusions leave at least one processor
+// to retry on.
+func ValidateExcludeProcessors(req domain.SubmitRequest) error {
+	if len(req.ExcludeProcessors) == 0 {
+		return nil
+	}
+	var errs []error
+	for i, p := range req.ExcludeProcessors {
+		if !domain.IsKnownProcessor(p) {
+			field := fmt.Sprintf("exclude_processors[%d]", i)
+			errs = append(errs, &FieldError{Field: field, Message: fmt.Sprintf("exclude_processors: unknown processor %q", p)})
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	// An original processor outside the configured list cannot be excluded,
+	// so it always remains available.
+	if len(domain.AllowedProcessors(req.ExcludeProcessors)) == 0 && domain.IsKnownProcessor(req.OriginalProcessor) {
+		return &FieldError{
+			Field:   "exclude_processors",
+			Message: "exclude_processors excludes every processor; at least one must remain for retries",
+		}
+	}
+	return nil
+}
+
+// validWebhookURL reports whether raw is an absolute http or https URL with a host.
+func validWebhookURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -1,8 +1,12 @@
 package seed
 
 import (
+	"errors"
 	"fmt"
+	"maps"
+	"math"
 	"math/rand"
+	"slices"
 	"time"
 
 	"github.com/eabugauch/zenithpay-retry/internal/domain"
@@ -32,25 +36,55 @@ var (
 
 // GenerateTransactions creates a realistic dataset of failed transactions.
 func GenerateTransactions(count int, seed int64) []domain.SubmitRequest {
+	return GenerateTransactionsFrom(count, seed, 0)
+}
+
+// GenerateTransactionsFrom is GenerateTransactions with transaction IDs
+// numbered after offset (txn_<offset+1> onward), so a dataset can be added
+// to an existing one without ID collisions.
+func GenerateTransactionsFrom(count int, seed int64, offset int) []domain.SubmitRequest {
+	return GenerateTransactionsWeighted(count, seed, offset, nil)
+}
+
+// GenerateTransactionsWeighted is GenerateTransactionsFrom with the decline
+// code mix drawn from weights (decline code -> relative weight) instead of the
+// built-in distribution, e.g. to match a merchant's actual decline mix. The
+// weights cover soft and hard codes alike; codes without a weight are not
+// generated. A nil or empty map keeps the defaults. weights must pass
+// ValidateWeights.
+func GenerateTransactionsWeighted(count int, seed int64, offset int, weights map[string]float64) []domain.SubmitRequest {
 	rng := rand.New(rand.NewSource(seed))
 	transactions := make([]domain.SubmitRequest, 0, count)
 
 	now := time.Now().UTC()
 	sevenDaysAgo := now.Add(-7 * 24 * time.Hour)
 
+	if len(weights) > 0 {
+		codes := slices.Sorted(maps.Keys(weights))
+		codeWeights := make([]float64, len(codes))
+		for i, code := range codes {
+			codeWeights[i] = weights[code]
+		}
+		for i := 0; i < count; i++ {
+			code := weightedChoice(rng, codes, codeWeights)
+			transactions = append(transactions, generateTransaction(rng, offset+i+1, code, sevenDaysAgo, now))
+		}
+		return transactions
+	}
+
 	softCount := int(float64(count) * 0.70)
 	hardCount := count - softCount
 
 	for i := 0; i < softCount; i++ {
 		code := weightedChoice(rng, softDeclineCodes, softWeights)
-		tx := generateTransaction(rng, i+1, code, sevenDaysAgo, now)
+		tx := generateTransaction(rng, offset+i+1, code, sevenDaysAgo, now)
 		transactions = append(transactions, tx)
 	}
 
 	hardWeights := []float64{0.25, 0.25, 0.25, 0.25}
 	for i := 0; i < hardCount; i++ {
 		code := weightedChoice(rng, hardDeclineCodes, hardWeights)
-		tx := generateTransaction(rng, softCount+i+1, code, sevenDaysAgo, now)
+		tx := generateTransaction(rng, offset+softCount+i+1, code, sevenDaysAgo, now)
 		transactions = append(transactions, tx)
 	}
 
@@ -61,6 +95,30 @@ func GenerateTransactions(count int, seed int64) []domain.SubmitRequest {
 	return transactions
 }
 
+// ValidateWeights checks a decline code weights map: every code must be a
+// known decline code, weights must be finite and non-negative, and at least
+// one must be positive.
+func ValidateWeights(weights map[string]float64) error {
+	var errs []error
+	positive := false
+	for _, code := range slices.Sorted(maps.Keys(weights)) {
+		w := weights[code]
+		if !domain.IsKnownDeclineCode(code) {
+			errs = append(errs, fmt.Errorf("weights: unknown decline code %q", code))
+		}
+		if w < 0 || math.IsNaN(w) || math.IsInf(w, 0) {
+			errs = append(errs, fmt.Errorf("weights: %s must be a non-negative number, got %v", code, w))
+		}
+		if w > 0 {
+			positive = true
+		}
+	}
+	if len(errs) == 0 && !positive {
+		errs = append(errs, errors.New("weights: at least one weight must be positive"))
+	}
+	return errors.Join(errs...)
+}
+
 func generateTransaction(rng *rand.Rand, idx int, declineCode string, start, end time.Time) domain.SubmitRequest {
 	duration := end.Sub(start)
 	randomOffset := time.Duration(rng.Int63n(int64(duration)))
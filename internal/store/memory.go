@@ -2,6 +2,7 @@ package store
 
 import (
 	"errors"
+	"slices"
 	"sort"
 	"sync"
 	"time"
@@ -15,23 +16,47 @@ var ErrNotFound = errors.New("transaction not found")
 // ErrAlreadyExists is returned when a transaction with the same ID already exists.
 var ErrAlreadyExists = errors.New("transaction already exists")
 
+// ErrVersionMismatch is returned when a conditional update expected a
+// different version than the stored one.
+var ErrVersionMismatch = errors.New("transaction version mismatch")
+
+// ErrCleared is returned when a conditional update expected a generation
+// the store has since left through Clear.
+var ErrCleared = errors.New("store was cleared")
+
+// ErrPendingCapReached is returned when a decline code already has the
+// maximum number of pending transactions.
+var ErrPendingCapReached = errors.New("pending cap reached for decline code")
+
 // Store provides thread-safe in-memory storage for transactions.
 // All read methods return deep copies to prevent data races from
 // external mutation of shared pointers.
 //
 // A secondary index (pendingIDs) tracks transactions in retryable states,
-// enabling O(pending) scheduler lookups instead of O(total) full scans.
+// enabling O(pending) scheduler lookups instead of O(total) full scans;
+// pendingByCode counts those pending transactions per decline code.
+// A second index (merchantIDs) groups transaction IDs by merchant so
+// per-merchant queries only touch that merchant's records; a third
+// (cardTokenIDs) does the same for card tokens.
 type Store struct {
-	mu           sync.RWMutex
-	transactions map[string]*domain.Transaction
-	pendingIDs   map[string]struct{} // secondary index: scheduled/retrying transactions
+	mu            sync.RWMutex
+	transactions  map[string]*domain.Transaction
+	pendingIDs    map[string]struct{}            // secondary index: scheduled/retrying transactions
+	pendingByCode map[string]int                 // pending transactions per decline code
+	merchantIDs   map[string]map[string]struct{} // secondary index: merchant ID -> transaction IDs
+	cardTokenIDs  map[string]map[string]struct{} // secondary index: card token -> transaction IDs
+	generation    uint64                         // starts at 1, incremented by Clear; see Generation
 }
 
 // New creates a new in-memory store.
 func New() *Store {
 	return &Store{
-		transactions: make(map[string]*domain.Transaction),
-		pendingIDs:   make(map[string]struct{}),
+		transactions:  make(map[string]*domain.Transaction),
+		pendingIDs:    make(map[string]struct{}),
+		pendingByCode: make(map[string]int),
+		merchantIDs:   make(map[string]map[string]struct{}),
+		cardTokenIDs:  make(map[string]map[string]struct{}),
+		generation:    1,
 	}
 }
 
@@ -40,55 +65,198 @@ func isPendingStatus(status domain.TransactionStatus) bool {
 	return status == domain.StatusScheduled || status == domain.StatusRetrying
 }
 
-// updatePendingIndex maintains the secondary index after a mutation.
-// Must be called with write lock held.
-func (s *Store) updatePendingIndex(id string, status domain.TransactionStatus) {
-	if isPendingStatus(status) {
+// updatePendingIndex maintains the pending index and per-code counts after a
+// mutation. old is nil for newly created transactions. Must be called with
+// write lock held.
+func (s *Store) updatePendingIndex(id string, old, updated *domain.Transaction) {
+	if old != nil && isPendingStatus(old.Status) {
+		if s.pendingByCode[old.DeclineCode]--; s.pendingByCode[old.DeclineCode] <= 0 {
+			delete(s.pendingByCode, old.DeclineCode)
+		}
+	}
+	if isPendingStatus(updated.Status) {
 		s.pendingIDs[id] = struct{}{}
+		s.pendingByCode[updated.DeclineCode]++
 	} else {
 		delete(s.pendingIDs, id)
 	}
 }
 
-// Save stores or updates a transaction (deep copy on write).
-func (s *Store) Save(tx *domain.Transaction) {
+// updateKeyIndex moves a transaction between buckets of a key -> IDs index
+// when its key changes. Empty keys are not indexed. Must be called with write
+// lock held.
+func updateKeyIndex(index map[string]map[string]struct{}, id, oldKey, newKey string) {
+	if oldKey != newKey {
+		if ids, ok := index[oldKey]; ok {
+			delete(ids, id)
+			if len(ids) == 0 {
+				delete(index, oldKey)
+			}
+		}
+	}
+	if newKey == "" {
+		return
+	}
+	ids, ok := index[newKey]
+	if !ok {
+		ids = make(map[string]struct{})
+		index[newKey] = ids
+	}
+	ids[id] = struct{}{}
+}
+
+// updateKeyIndexes maintains the merchant and card token indexes after a
+// mutation. old is nil for newly created transactions. Must be called with
+// write lock held.
+func (s *Store) updateKeyIndexes(id string, old, updated *domain.Transaction) {
+	var oldMerchant, oldToken string
+	if old != nil {
+		oldMerchant, oldToken = old.MerchantID, old.CardToken
+	}
+	updateKeyIndex(s.merchantIDs, id, oldMerchant, updated.MerchantID)
+	updateKeyIndex(s.cardTokenIDs, id, oldToken, updated.CardToken)
+}
+
+// Save stores or updates a transaction (deep copy on write); see Upsert for
+// when it refuses stale data. Prefer SaveIfNotExists for creation and
+// UpdateFunc for read-modify-write. The secondary indexes always follow the
+// saved record, so overwriting a terminal record with a pending one (or vice
+// versa) keeps the pending index consistent.
+func (s *Store) Save(tx *domain.Transaction) error {
+	_, err := s.Upsert(tx)
+	return err
+}
+
+// Upsert stores a transaction, replacing any existing record with the same ID.
+// Returns true if the transaction was created, false if an existing one was updated.
+// A transaction carrying a version (one read from the store) only replaces
+// the record at that version; if the record has been written since, Upsert
+// returns ErrVersionMismatch and leaves it, and its indexes, untouched. A zero
+// version overwrites unconditionally.
+func (s *Store) Upsert(tx *domain.Transaction) (created bool, err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.transactions[tx.ID] = copyTransaction(tx)
-	s.updatePendingIndex(tx.ID, tx.Status)
+	existing, ok := s.transactions[tx.ID]
+	if ok && tx.Version != 0 && tx.Version != existing.Version {
+		return false, ErrVersionMismatch
+	}
+	stored := copyTransaction(tx)
+	stored.Version = 1
+	if ok {
+		stored.Version = existing.Version + 1
+	}
+	s.transactions[tx.ID] = stored
+	s.updatePendingIndex(tx.ID, existing, tx)
+	s.updateKeyIndexes(tx.ID, existing, tx)
+	return !ok, nil
 }
 
 // SaveIfNotExists atomically stores a transaction only if no transaction with
 // the same ID exists. Returns ErrAlreadyExists if the ID is taken.
 // This prevents the TOCTOU race condition of Exists() + Save().
 func (s *Store) SaveIfNotExists(tx *domain.Transaction) error {
+	return s.SaveIfNotExistsCapped(tx, 0)
+}
+
+// SaveIfNotExistsCapped is SaveIfNotExists that also refuses a pending
+// transaction, with ErrPendingCapReached, when its decline code already has
+// maxPending pending transactions. A maxPending of 0 means no cap.
+func (s *Store) SaveIfNotExistsCapped(tx *domain.Transaction, maxPending int) error {
+	return s.SaveIfNotExistsCappedFunc(tx, maxPending, nil)
+}
+
+// SaveIfNotExistsCappedFunc is SaveIfNotExistsCapped that, once the checks
+// pass, calls prepare (if non-nil) under the store lock with tx and deep
+// copies of the other transactions sharing its card token, then saves tx as
+// prepare left it. This keeps decisions that depend on the card's other
+// transactions (e.g. retry spacing) atomic with the save.
+func (s *Store) SaveIfNotExistsCappedFunc(tx *domain.Transaction, maxPending int, prepare func(tx *domain.Transaction, sameCard []*domain.Transaction)) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if _, ok := s.transactions[tx.ID]; ok {
 		return ErrAlreadyExists
 	}
-	s.transactions[tx.ID] = copyTransaction(tx)
-	s.updatePendingIndex(tx.ID, tx.Status)
+	if maxPending > 0 && isPendingStatus(tx.Status) && s.pendingByCode[tx.DeclineCode] >= maxPending {
+		return ErrPendingCapReached
+	}
+	if prepare != nil {
+		prepare(tx, s.sameCard(tx))
+	}
+	stored := copyTransaction(tx)
+	stored.Version = 1
+	s.transactions[tx.ID] = stored
+	s.updatePendingIndex(tx.ID, nil, tx)
+	s.updateKeyIndexes(tx.ID, nil, tx)
 	return nil
 }
 
+// PendingCount returns how many transactions with the given decline code are
+// pending (scheduled or retrying).
+func (s *Store) PendingCount(declineCode string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.pendingByCode[declineCode]
+}
+
 // UpdateFunc atomically reads a transaction, passes it to the callback for mutation,
 // and saves the result back. This prevents lost-update race conditions on
 // read-modify-write sequences (e.g., concurrent ExecuteRetry calls).
 // The callback receives a deep copy; its mutations are saved atomically.
+// The copy already carries the incremented Version it will be saved with.
 func (s *Store) UpdateFunc(id string, fn func(tx *domain.Transaction) error) error {
+	return s.UpdateFuncIfVersion(id, 0, fn)
+}
+
+// UpdateFuncIfVersion is UpdateFunc that only runs fn if the stored
+// transaction is at the given version, returning ErrVersionMismatch otherwise.
+// A version of 0 matches any.
+func (s *Store) UpdateFuncIfVersion(id string, version int, fn func(tx *domain.Transaction) error) error {
+	return s.UpdateFuncIfCurrent(id, version, 0, fn)
+}
+
+// UpdateFuncIfCurrent is UpdateFuncIfVersion that also returns ErrCleared,
+// without running fn, if the store is no longer at generation, so a batch
+// working from transactions read before a Clear cannot update a new
+// transaction that reuses an ID. A generation of 0 matches any.
+func (s *Store) UpdateFuncIfCurrent(id string, version int, generation uint64, fn func(tx *domain.Transaction) error) error {
+	return s.update(id, version, generation, func(tx *domain.Transaction, _ []*domain.Transaction) error {
+		return fn(tx)
+	}, false)
+}
+
+// UpdateFuncWithCard is UpdateFunc whose callback also receives deep copies of
+// the other transactions sharing the card token, read under the same lock.
+func (s *Store) UpdateFuncWithCard(id string, fn func(tx *domain.Transaction, sameCard []*domain.Transaction) error) error {
+	return s.update(id, 0, 0, fn, true)
+}
+
+// update implements UpdateFuncIfCurrent and UpdateFuncWithCard; sameCard is
+// only collected when withCard is set.
+func (s *Store) update(id string, version int, generation uint64, fn func(tx *domain.Transaction, sameCard []*domain.Transaction) error, withCard bool) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if generation != 0 && s.generation != generation {
+		return ErrCleared
+	}
 	tx, ok := s.transactions[id]
 	if !ok {
 		return ErrNotFound
 	}
+	if version != 0 && tx.Version != version {
+		return ErrVersionMismatch
+	}
 	cp := copyTransaction(tx)
-	if err := fn(cp); err != nil {
+	cp.Version = tx.Version + 1
+	var sameCard []*domain.Transaction
+	if withCard {
+		sameCard = s.sameCard(tx)
+	}
+	if err := fn(cp, sameCard); err != nil {
 		return err
 	}
 	s.transactions[id] = copyTransaction(cp)
-	s.updatePendingIndex(id, cp.Status)
+	s.updatePendingIndex(id, tx, cp)
+	s.updateKeyIndexes(id, tx, cp)
 	return nil
 }
 
@@ -129,6 +297,122 @@ func (s *Store) List(status string) []*domain.Transaction {
 	return result
 }
 
+// Filter selects transactions by exact match on each non-empty field.
+type Filter struct {
+	Status      string
+	DeclineCode string
+	Processor   string // original processor
+	MerchantID  string
+}
+
+// matches reports whether tx satisfies every set field of f.
+func (f Filter) matches(tx *domain.Transaction) bool {
+	return (f.Status == "" || string(tx.Status) == f.Status) &&
+		(f.DeclineCode == "" || tx.DeclineCode == f.DeclineCode) &&
+		(f.Processor == "" || tx.OriginalProcessor == f.Processor) &&
+		(f.MerchantID == "" || tx.MerchantID == f.MerchantID)
+}
+
+// CountFiltered returns how many transactions match f without copying them.
+// A merchant filter narrows the scan to that merchant's index entry.
+func (s *Store) CountFiltered(f Filter) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := 0
+	if f.MerchantID != "" {
+		for id := range s.merchantIDs[f.MerchantID] {
+			if tx, ok := s.transactions[id]; ok && f.matches(tx) {
+				count++
+			}
+		}
+		return count
+	}
+	for _, tx := range s.transactions {
+		if f.matches(tx) {
+			count++
+		}
+	}
+	return count
+}
+
+// Cursor marks a position in the (CreatedAt, ID) descending order used by
+// ListPage. It identifies the last transaction of the previous page.
+type Cursor struct {
+	CreatedAt time.Time `json:"created_at"`
+	ID        string    `json:"id"`
+}
+
+// ListPage returns up to limit deep copies of transactions, optionally filtered by
+// status, ordered by CreatedAt descending with ID descending as a tiebreaker.
+// If after is non-nil, only transactions strictly after that cursor in this
+// order are returned, so inserts between page fetches never cause duplicates
+// or skips. more reports whether another page follows.
+func (s *Store) ListPage(status string, after *Cursor, limit int) (page []*domain.Transaction, more bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var matched []*domain.Transaction
+	for _, tx := range s.transactions {
+		if status != "" && string(tx.Status) != status {
+			continue
+		}
+		if after != nil && !listedAfter(tx, after) {
+			continue
+		}
+		matched = append(matched, tx)
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		return listedAfter(matched[j], &Cursor{CreatedAt: matched[i].CreatedAt, ID: matched[i].ID})
+	})
+
+	if len(matched) > limit {
+		matched, more = matched[:limit], true
+	}
+	page = make([]*domain.Transaction, len(matched))
+	for i, tx := range matched {
+		page[i] = copyTransaction(tx)
+	}
+	return page, more
+}
+
+// ListOffset returns up to limit deep copies of transactions starting at
+// offset, in the same order as ListPage, together with the total number
+// matching status. Unlike cursor pages, offset pages shift when transactions
+// are inserted between fetches.
+func (s *Store) ListOffset(status string, offset, limit int) (page []*domain.Transaction, total int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var matched []*domain.Transaction
+	for _, tx := range s.transactions {
+		if status == "" || string(tx.Status) == status {
+			matched = append(matched, tx)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return listedAfter(matched[j], &Cursor{CreatedAt: matched[i].CreatedAt, ID: matched[i].ID})
+	})
+
+	total = len(matched)
+	offset = min(offset, total)
+	matched = matched[offset : offset+min(limit, total-offset)]
+	page = make([]*domain.Transaction, len(matched))
+	for i, tx := range matched {
+		page[i] = copyTransaction(tx)
+	}
+	return page, total
+}
+
+// listedAfter reports whether tx comes after c in descending (CreatedAt, ID) order.
+func listedAfter(tx *domain.Transaction, c *Cursor) bool {
+	if !tx.CreatedAt.Equal(c.CreatedAt) {
+		return tx.CreatedAt.Before(c.CreatedAt)
+	}
+	return tx.ID < c.ID
+}
+
 // GetPendingRetries returns deep copies of transactions that are scheduled or retrying.
 // Uses the secondary index for O(pending) lookup instead of O(total) full scan.
 func (s *Store) GetPendingRetries() []*domain.Transaction {
@@ -144,7 +428,8 @@ func (s *Store) GetPendingRetries() []*domain.Transaction {
 	return result
 }
 
-// GetDueRetries returns pending transactions whose NextRetryAt is at or before the given time.
+// GetDueRetries returns pending transactions whose NextRetryAt is at or before the given time,
+// ordered by Priority descending, then NextRetryAt ascending (most overdue first).
 // Combines the pending index with a time filter, pushing all filtering into the store layer.
 func (s *Store) GetDueRetries(before time.Time) []*domain.Transaction {
 	s.mu.RLock()
@@ -160,6 +445,60 @@ func (s *Store) GetDueRetries(before time.Time) []*domain.Transaction {
 			result = append(result, copyTransaction(tx))
 		}
 	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Priority != result[j].Priority {
+			return result[i].Priority > result[j].Priority
+		}
+		return result[i].NextRetryAt.Before(*result[j].NextRetryAt)
+	})
+	return result
+}
+
+// GetDueBetween returns pending transactions whose NextRetryAt falls within
+// [start, end], ordered by NextRetryAt ascending. Like GetDueRetries it only
+// walks the pending index.
+func (s *Store) GetDueBetween(start, end time.Time) []*domain.Transaction {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var result []*domain.Transaction
+	for id := range s.pendingIDs {
+		tx, ok := s.transactions[id]
+		if !ok || tx.NextRetryAt == nil {
+			continue
+		}
+		if !tx.NextRetryAt.Before(start) && !tx.NextRetryAt.After(end) {
+			result = append(result, copyTransaction(tx))
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].NextRetryAt.Before(*result[j].NextRetryAt)
+	})
+	return result
+}
+
+// ChangedSince returns deep copies of transactions whose UpdatedAt is after
+// since, ordered by UpdatedAt ascending (ties by ID) so callers can poll
+// forward from the last UpdatedAt they saw.
+func (s *Store) ChangedSince(since time.Time) []*domain.Transaction {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var result []*domain.Transaction
+	for _, tx := range s.transactions {
+		if tx.UpdatedAt.After(since) {
+			result = append(result, copyTransaction(tx))
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].UpdatedAt.Equal(result[j].UpdatedAt) {
+			return result[i].UpdatedAt.Before(result[j].UpdatedAt)
+		}
+		return result[i].ID < result[j].ID
+	})
 	return result
 }
 
@@ -193,6 +532,75 @@ func (s *Store) GetAll() []*domain.Transaction {
 	return result
 }
 
+// GetByMerchant returns deep copies of a merchant's transactions sorted by
+// creation time descending. Uses the merchant index, so only that merchant's
+// records are scanned. Unknown merchants yield an empty slice.
+func (s *Store) GetByMerchant(merchantID string) []*domain.Transaction {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := s.merchantIDs[merchantID]
+	result := make([]*domain.Transaction, 0, len(ids))
+	for id := range ids {
+		if tx, ok := s.transactions[id]; ok {
+			result = append(result, copyTransaction(tx))
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+	return result
+}
+
+// RecoveredTotal returns the summed amount and count of a merchant's
+// recovered transactions in currency. Uses the merchant index.
+func (s *Store) RecoveredTotal(merchantID, currency string) (cents int64, count int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for id := range s.merchantIDs[merchantID] {
+		if tx, ok := s.transactions[id]; ok && tx.Status == domain.StatusRecovered && tx.Currency == currency {
+			cents += tx.AmountCents
+			count++
+		}
+	}
+	return cents, count
+}
+
+// GetByCardToken returns deep copies of every transaction made with a card
+// token, in no particular order. Uses the card token index.
+func (s *Store) GetByCardToken(token string) []*domain.Transaction {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.byCardToken(token, "")
+}
+
+// sameCard returns deep copies of the transactions other than tx that share
+// its card token. Must be called with s.mu held.
+func (s *Store) sameCard(tx *domain.Transaction) []*domain.Transaction {
+	if tx.CardToken == "" {
+		return nil
+	}
+	return s.byCardToken(tx.CardToken, tx.ID)
+}
+
+// byCardToken returns deep copies of the transactions with the card token,
+// skipping exceptID. Must be called with s.mu held.
+func (s *Store) byCardToken(token, exceptID string) []*domain.Transaction {
+	ids := s.cardTokenIDs[token]
+	result := make([]*domain.Transaction, 0, len(ids))
+	for id := range ids {
+		if id == exceptID {
+			continue
+		}
+		if tx, ok := s.transactions[id]; ok {
+			result = append(result, copyTransaction(tx))
+		}
+	}
+	return result
+}
+
 // Count returns the total number of transactions.
 func (s *Store) Count() int {
 	s.mu.RLock()
@@ -200,34 +608,148 @@ func (s *Store) Count() int {
 	return len(s.transactions)
 }
 
-// Clear removes all transactions (used for testing/reset).
+// PendingIDsSnapshot returns the IDs currently in the pending index, sorted.
+// It reads the index itself rather than transaction statuses, so it reflects
+// any drift between the two; see VerifyIndex.
+func (s *Store) PendingIDsSnapshot() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := make([]string, 0, len(s.pendingIDs))
+	for id := range s.pendingIDs {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// VerifyIndex checks the pending index against transaction statuses and
+// returns the sorted IDs where they disagree: indexed IDs that are missing or
+// not pending, and pending transactions absent from the index. An empty
+// result means the index is consistent.
+func (s *Store) VerifyIndex() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var drift []string
+	for id := range s.pendingIDs {
+		if tx, ok := s.transactions[id]; !ok || !isPendingStatus(tx.Status) {
+			drift = append(drift, id)
+		}
+	}
+	for id, tx := range s.transactions {
+		if _, indexed := s.pendingIDs[id]; !indexed && isPendingStatus(tx.Status) {
+			drift = append(drift, id)
+		}
+	}
+	slices.Sort(drift)
+	return drift
+}
+
+// PurgeTerminal deletes terminal transactions last updated before the given
+// time and returns how many were removed. If statuses are given, only those
+// are purged. Pending (scheduled/retrying) transactions and recoveries still
+// awaiting confirmation are never removed, even if their status is listed.
+func (s *Store) PurgeTerminal(before time.Time, statuses ...domain.TransactionStatus) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	purged := 0
+	for id, tx := range s.transactions {
+		if isPendingStatus(tx.Status) || tx.Status == domain.StatusPendingConfirmation || !tx.UpdatedAt.Before(before) {
+			continue
+		}
+		if len(statuses) > 0 && !slices.Contains(statuses, tx.Status) {
+			continue
+		}
+		delete(s.transactions, id)
+		delete(s.pendingIDs, id)
+		updateKeyIndex(s.merchantIDs, id, tx.MerchantID, "")
+		updateKeyIndex(s.cardTokenIDs, id, tx.CardToken, "")
+		purged++
+	}
+	return purged
+}
+
+// Generation identifies the store's current contents across resets: it
+// changes on every Clear and is never 0. Batch operations working from transactions read
+// earlier compare it to detect that the store was cleared under them.
+func (s *Store) Generation() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.generation
+}
+
+// Clear removes all transactions (used for testing/reset) and advances the
+// generation.
 func (s *Store) Clear() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.generation++
 	s.transactions = make(map[string]*domain.Transaction)
 	s.pendingIDs = make(map[string]struct{})
+	s.pendingByCode = make(map[string]int)
+	s.merchantIDs = make(map[string]map[string]struct{})
+	s.cardTokenIDs = make(map[string]map[string]struct{})
 }
 
+// emptyAttempts is shared by copies of transactions that have no retry
+// attempts. It has zero capacity, so any append reallocates and never
+// writes through to another copy.
+var emptyAttempts = []domain.RetryAttempt{}
+
 // copyTransaction creates a deep copy of a transaction to prevent shared pointer mutations.
+// Every non-nil slice gets its own backing array, even when empty, so an append
+// on a copy can never write into spare capacity shared with the stored
+// transaction. Copying an empty slice does not allocate, and copies without
+// attempts share emptyAttempts, which keeps copies of hard declines (no plan,
+// no attempts) down to a single allocation.
 func copyTransaction(tx *domain.Transaction) *domain.Transaction {
 	cp := *tx
 
 	if tx.RetryPlan != nil {
 		plan := *tx.RetryPlan
-		plan.ScheduledTimes = make([]time.Time, len(tx.RetryPlan.ScheduledTimes))
-		copy(plan.ScheduledTimes, tx.RetryPlan.ScheduledTimes)
-		plan.Processors = make([]string, len(tx.RetryPlan.Processors))
-		copy(plan.Processors, tx.RetryPlan.Processors)
+		if tx.RetryPlan.ScheduledTimes != nil {
+			plan.ScheduledTimes = make([]time.Time, len(tx.RetryPlan.ScheduledTimes))
+			copy(plan.ScheduledTimes, tx.RetryPlan.ScheduledTimes)
+		}
+		if tx.RetryPlan.Processors != nil {
+			plan.Processors = make([]string, len(tx.RetryPlan.Processors))
+			copy(plan.Processors, tx.RetryPlan.Processors)
+		}
 		cp.RetryPlan = &plan
 	}
 
-	cp.RetryAttempts = make([]domain.RetryAttempt, len(tx.RetryAttempts))
-	copy(cp.RetryAttempts, tx.RetryAttempts)
+	if tx.RetryAttempts != nil {
+		cp.RetryAttempts = make([]domain.RetryAttempt, len(tx.RetryAttempts))
+		copy(cp.RetryAttempts, tx.RetryAttempts)
+	} else {
+		cp.RetryAttempts = emptyAttempts
+	}
 
 	if tx.NextRetryAt != nil {
 		t := *tx.NextRetryAt
 		cp.NextRetryAt = &t
 	}
 
+	if tx.WebhookRoutes != nil {
+		cp.WebhookRoutes = make(map[string]string, len(tx.WebhookRoutes))
+		for eventType, url := range tx.WebhookRoutes {
+			cp.WebhookRoutes[eventType] = url
+		}
+	}
+
+	if tx.FallbackCodes != nil {
+		cp.FallbackCodes = append([]string(nil), tx.FallbackCodes...)
+	}
+
+	if tx.WebhookEvents != nil {
+		cp.WebhookEvents = append([]string(nil), tx.WebhookEvents...)
+	}
+
+	if tx.ExcludeProcessors != nil {
+		cp.ExcludeProcessors = append([]string(nil), tx.ExcludeProcessors...)
+	}
+
 	return &cp
 }
@@ -2,6 +2,8 @@ package store
 
 import (
 	"errors"
+	"fmt"
+	"slices"
 	"sync"
 	"testing"
 	"time"
@@ -137,11 +139,13 @@ func TestStore_DeepCopy(t *testing.T) {
 		ScheduledTimes: []time.Time{next},
 		Processors:     []string{"stripe"},
 	}
+	tx.ExcludeProcessors = []string{"adyen_apac"}
 	s.Save(tx)
 
 	got, _ := s.Get("txn_copy")
 	got.RetryAttempts = append(got.RetryAttempts, domain.RetryAttempt{AttemptNumber: 2, Success: true})
 	got.Status = domain.StatusRecovered
+	got.ExcludeProcessors[0] = "dlocal_br"
 
 	original, _ := s.Get("txn_copy")
 	if len(original.RetryAttempts) != 1 {
@@ -150,6 +154,9 @@ func TestStore_DeepCopy(t *testing.T) {
 	if original.Status != domain.StatusScheduled {
 		t.Errorf("store mutation leaked: expected scheduled, got %s", original.Status)
 	}
+	if original.ExcludeProcessors[0] != "adyen_apac" {
+		t.Errorf("store mutation leaked: expected adyen_apac excluded, got %v", original.ExcludeProcessors)
+	}
 }
 
 func TestStore_Exists(t *testing.T) {
@@ -285,6 +292,79 @@ func TestStore_GetDueRetries(t *testing.T) {
 	}
 }
 
+func TestStore_CountFiltered(t *testing.T) {
+	s := New()
+	for _, c := range []struct {
+		id, merchant, code, processor string
+		status                        domain.TransactionStatus
+	}{
+		{"txn_c1", "m1", "insufficient_funds", "stripe_latam", domain.StatusRecovered},
+		{"txn_c2", "m1", "issuer_timeout", "adyen_apac", domain.StatusScheduled},
+		{"txn_c3", "m2", "insufficient_funds", "stripe_latam", domain.StatusRecovered},
+		{"txn_c4", "m2", "insufficient_funds", "adyen_apac", domain.StatusFailedFinal},
+	} {
+		tx := newTestTransaction(c.id, c.status, domain.SoftDecline)
+		tx.MerchantID = c.merchant
+		tx.DeclineCode = c.code
+		tx.OriginalProcessor = c.processor
+		s.Save(tx)
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   int
+	}{
+		{"no filter", Filter{}, 4},
+		{"status", Filter{Status: string(domain.StatusRecovered)}, 2},
+		{"decline code", Filter{DeclineCode: "insufficient_funds"}, 3},
+		{"processor", Filter{Processor: "adyen_apac"}, 2},
+		{"merchant", Filter{MerchantID: "m2"}, 2},
+		{"merchant and status", Filter{MerchantID: "m1", Status: string(domain.StatusRecovered)}, 1},
+		{"code and processor", Filter{DeclineCode: "insufficient_funds", Processor: "stripe_latam"}, 2},
+		{"unknown merchant", Filter{MerchantID: "m9"}, 0},
+	}
+	for _, tt := range tests {
+		if got := s.CountFiltered(tt.filter); got != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestStore_GetDueBetween(t *testing.T) {
+	s := New()
+	now := time.Now().UTC()
+	soon := now.Add(10 * time.Minute)
+	sooner := now.Add(5 * time.Minute)
+	far := now.Add(3 * time.Hour)
+	past := now.Add(-time.Minute)
+
+	for _, c := range []struct {
+		id     string
+		status domain.TransactionStatus
+		next   *time.Time
+	}{
+		{"txn_soon", domain.StatusScheduled, &soon},
+		{"txn_sooner", domain.StatusRetrying, &sooner},
+		{"txn_far", domain.StatusScheduled, &far},
+		{"txn_overdue", domain.StatusScheduled, &past},
+		{"txn_terminal", domain.StatusRecovered, &soon},
+		{"txn_nil", domain.StatusScheduled, nil},
+	} {
+		tx := newTestTransaction(c.id, c.status, domain.SoftDecline)
+		tx.NextRetryAt = c.next
+		s.Save(tx)
+	}
+
+	due := s.GetDueBetween(now, now.Add(time.Hour))
+	if len(due) != 2 {
+		t.Fatalf("expected 2 transactions in window, got %d", len(due))
+	}
+	if due[0].ID != "txn_sooner" || due[1].ID != "txn_soon" {
+		t.Errorf("expected [txn_sooner txn_soon], got [%s %s]", due[0].ID, due[1].ID)
+	}
+}
+
 func TestStore_PendingIndex_SaveIfNotExists(t *testing.T) {
 	s := New()
 	tx := newTestTransaction("txn_sne", domain.StatusScheduled, domain.SoftDecline)
@@ -344,3 +424,530 @@ func TestStore_ConcurrentAccess(t *testing.T) {
 		t.Error("store should have entries after concurrent writes")
 	}
 }
+
+func TestStore_GetByMerchant(t *testing.T) {
+	s := New()
+	a := newTestTransaction("txn_a", domain.StatusScheduled, domain.SoftDecline)
+	a.MerchantID = "merchant_a"
+	b := newTestTransaction("txn_b", domain.StatusRejected, domain.HardDecline)
+	b.MerchantID = "merchant_b"
+	s.Save(a)
+	s.SaveIfNotExists(b)
+
+	if got := s.GetByMerchant("merchant_a"); len(got) != 1 || got[0].ID != "txn_a" {
+		t.Errorf("expected only txn_a for merchant_a, got %v", got)
+	}
+	if got := s.GetByMerchant("unknown"); len(got) != 0 {
+		t.Errorf("expected no transactions for unknown merchant, got %d", len(got))
+	}
+
+	// Moving a transaction to another merchant updates the index
+	s.UpdateFunc("txn_a", func(tx *domain.Transaction) error {
+		tx.MerchantID = "merchant_b"
+		return nil
+	})
+	if got := s.GetByMerchant("merchant_a"); len(got) != 0 {
+		t.Errorf("expected merchant_a to be empty after move, got %d", len(got))
+	}
+	if got := s.GetByMerchant("merchant_b"); len(got) != 2 {
+		t.Errorf("expected 2 transactions for merchant_b, got %d", len(got))
+	}
+
+	s.Clear()
+	if got := s.GetByMerchant("merchant_b"); len(got) != 0 {
+		t.Errorf("expected merchant index cleared, got %d", len(got))
+	}
+}
+
+func TestStore_Save_PendingIndexFollowsStatus(t *testing.T) {
+	s := New()
+	tx := newTestTransaction("txn_flip", domain.StatusScheduled, domain.SoftDecline)
+
+	steps := []struct {
+		status      domain.TransactionStatus
+		wantPending bool
+	}{
+		{domain.StatusScheduled, true},
+		{domain.StatusFailedFinal, false},
+		{domain.StatusRetrying, true},
+		{domain.StatusRecovered, false},
+	}
+
+	for _, step := range steps {
+		tx.Status = step.status
+		s.Save(tx)
+
+		_, indexed := s.pendingIDs["txn_flip"]
+		if indexed != step.wantPending {
+			t.Errorf("status %s: expected pending index membership %v, got %v", step.status, step.wantPending, indexed)
+		}
+		if got := len(s.GetPendingRetries()); (got == 1) != step.wantPending {
+			t.Errorf("status %s: GetPendingRetries returned %d transactions", step.status, got)
+		}
+	}
+}
+
+func TestStore_PendingCountAndCappedSave(t *testing.T) {
+	s := New()
+	pending := func(id string) *domain.Transaction {
+		return &domain.Transaction{ID: id, DeclineCode: "issuer_timeout", Status: domain.StatusScheduled}
+	}
+
+	if err := s.SaveIfNotExistsCapped(pending("txn_pc_1"), 1); err != nil {
+		t.Fatalf("first save: %v", err)
+	}
+	if err := s.SaveIfNotExistsCapped(pending("txn_pc_2"), 1); !errors.Is(err, ErrPendingCapReached) {
+		t.Fatalf("expected ErrPendingCapReached, got %v", err)
+	}
+	if s.Exists("txn_pc_2") {
+		t.Error("expected capped transaction not to be stored")
+	}
+	// Terminal records are never capped.
+	rejected := pending("txn_pc_rejected")
+	rejected.Status = domain.StatusRejected
+	if err := s.SaveIfNotExistsCapped(rejected, 1); err != nil {
+		t.Errorf("expected terminal save under the cap, got %v", err)
+	}
+
+	s.UpdateFunc("txn_pc_1", func(tx *domain.Transaction) error {
+		tx.Status = domain.StatusRecovered
+		return nil
+	})
+	if n := s.PendingCount("issuer_timeout"); n != 0 {
+		t.Errorf("expected count to drop when the transaction completes, got %d", n)
+	}
+	if err := s.SaveIfNotExistsCapped(pending("txn_pc_2"), 1); err != nil {
+		t.Errorf("expected save once pending drained, got %v", err)
+	}
+
+	s.Save(pending("txn_pc_2")) // overwrite keeps one pending record
+	if n := s.PendingCount("issuer_timeout"); n != 1 {
+		t.Errorf("expected 1 pending after overwrite, got %d", n)
+	}
+}
+
+func TestStore_VerifyIndex_DetectsDrift(t *testing.T) {
+	s := New()
+	s.Save(newTestTransaction("txn_pending", domain.StatusScheduled, domain.SoftDecline))
+	s.Save(newTestTransaction("txn_done", domain.StatusRecovered, domain.SoftDecline))
+	s.Save(newTestTransaction("txn_lost", domain.StatusRetrying, domain.SoftDecline))
+
+	if drift := s.VerifyIndex(); len(drift) != 0 {
+		t.Fatalf("expected consistent index, got drift %v", drift)
+	}
+
+	// Corrupt the index: a terminal transaction, a missing one, and a
+	// pending transaction dropped from it.
+	s.mu.Lock()
+	s.pendingIDs["txn_done"] = struct{}{}
+	s.pendingIDs["txn_ghost"] = struct{}{}
+	delete(s.pendingIDs, "txn_lost")
+	s.mu.Unlock()
+
+	want := []string{"txn_done", "txn_ghost", "txn_lost"}
+	if got := s.VerifyIndex(); !slices.Equal(got, want) {
+		t.Errorf("expected drift %v, got %v", want, got)
+	}
+	if got := s.PendingIDsSnapshot(); !slices.Equal(got, []string{"txn_done", "txn_ghost", "txn_pending"}) {
+		t.Errorf("expected raw index snapshot, got %v", got)
+	}
+}
+
+func TestStore_VersionIncrementsOnWrite(t *testing.T) {
+	s := New()
+	tx := newTestTransaction("txn_version", domain.StatusScheduled, domain.SoftDecline)
+	if err := s.SaveIfNotExists(tx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, _ := s.Get("txn_version"); got.Version != 1 {
+		t.Fatalf("expected version 1 after create, got %d", got.Version)
+	}
+
+	s.Save(tx)
+	var seen int
+	s.UpdateFunc("txn_version", func(tx *domain.Transaction) error {
+		seen = tx.Version
+		return nil
+	})
+	if got, _ := s.Get("txn_version"); got.Version != 3 || seen != 3 {
+		t.Errorf("expected version 3 after save and update (callback saw %d), got %d", seen, got.Version)
+	}
+
+	err := s.UpdateFuncIfVersion("txn_version", 2, func(tx *domain.Transaction) error {
+		t.Error("callback must not run on a version mismatch")
+		return nil
+	})
+	if !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("expected ErrVersionMismatch for a stale version, got %v", err)
+	}
+	if err := s.UpdateFuncIfVersion("txn_version", 3, func(*domain.Transaction) error { return nil }); err != nil {
+		t.Errorf("expected the current version to match, got %v", err)
+	}
+}
+
+func TestStore_UpdateFuncIfCurrent(t *testing.T) {
+	s := New()
+	generation := s.Generation()
+	if generation == 0 {
+		t.Fatal("expected a non-zero generation, 0 means any")
+	}
+	s.Save(newTestTransaction("txn_gen", domain.StatusScheduled, domain.SoftDecline))
+	if err := s.UpdateFuncIfCurrent("txn_gen", 0, generation, func(*domain.Transaction) error { return nil }); err != nil {
+		t.Errorf("expected the current generation to match, got %v", err)
+	}
+
+	// The same ID saved after a clear belongs to the new generation.
+	s.Clear()
+	s.Save(newTestTransaction("txn_gen", domain.StatusScheduled, domain.SoftDecline))
+	err := s.UpdateFuncIfCurrent("txn_gen", 0, generation, func(*domain.Transaction) error {
+		t.Error("callback must not run after a clear")
+		return nil
+	})
+	if !errors.Is(err, ErrCleared) {
+		t.Errorf("expected ErrCleared for a stale generation, got %v", err)
+	}
+	if err := s.UpdateFuncIfCurrent("txn_gen", 0, 0, func(*domain.Transaction) error { return nil }); err != nil {
+		t.Errorf("expected generation 0 to match any, got %v", err)
+	}
+}
+
+func TestStore_Upsert(t *testing.T) {
+	s := New()
+	tx := newTestTransaction("txn_upsert", domain.StatusScheduled, domain.SoftDecline)
+
+	if created, err := s.Upsert(tx); err != nil || !created {
+		t.Errorf("first upsert should report created, got created=%v err=%v", created, err)
+	}
+
+	tx.Status = domain.StatusRecovered
+	if created, err := s.Upsert(tx); err != nil || created {
+		t.Errorf("second upsert should report updated, got created=%v err=%v", created, err)
+	}
+
+	got, _ := s.Get("txn_upsert")
+	if got.Status != domain.StatusRecovered {
+		t.Errorf("expected recovered after upsert, got %s", got.Status)
+	}
+	if len(s.GetPendingRetries()) != 0 {
+		t.Error("terminal upsert should remove transaction from pending index")
+	}
+}
+
+func TestStore_Upsert_RejectsStaleVersion(t *testing.T) {
+	s := New()
+	s.Save(newTestTransaction("txn_stale", domain.StatusScheduled, domain.SoftDecline))
+
+	stale, _ := s.Get("txn_stale")
+	fresh, _ := s.Get("txn_stale")
+	fresh.Status = domain.StatusRecovered
+	if err := s.Save(fresh); err != nil {
+		t.Fatalf("save at the current version: %v", err)
+	}
+
+	// The stale copy would put the recovered transaction back in the pending index
+	stale.Status = domain.StatusRetrying
+	if created, err := s.Upsert(stale); !errors.Is(err, ErrVersionMismatch) || created {
+		t.Fatalf("expected ErrVersionMismatch for a stale copy, got created=%v err=%v", created, err)
+	}
+	got, _ := s.Get("txn_stale")
+	if got.Status != domain.StatusRecovered || got.Version != 2 {
+		t.Errorf("stale save overwrote the record: status %s, version %d", got.Status, got.Version)
+	}
+	if len(s.GetPendingRetries()) != 0 {
+		t.Error("stale save should leave the pending index untouched")
+	}
+
+	// A zero version is still an unconditional overwrite
+	stale.Version = 0
+	if err := s.Save(stale); err != nil {
+		t.Errorf("expected unconditional save with zero version, got %v", err)
+	}
+}
+
+func TestStore_DeepCopy_EmptyAttemptsNotShared(t *testing.T) {
+	s := New()
+	s.Save(newTestTransaction("txn_a", domain.StatusFailedFinal, domain.HardDecline))
+	s.Save(newTestTransaction("txn_b", domain.StatusFailedFinal, domain.HardDecline))
+
+	a, _ := s.Get("txn_a")
+	a.RetryAttempts = append(a.RetryAttempts, domain.RetryAttempt{AttemptNumber: 1})
+
+	b, _ := s.Get("txn_b")
+	if b.RetryAttempts == nil {
+		t.Error("expected empty (non-nil) attempts slice")
+	}
+	if len(b.RetryAttempts) != 0 {
+		t.Errorf("append on one copy leaked into another: got %d attempts", len(b.RetryAttempts))
+	}
+}
+
+func TestStore_DeepCopy_EmptySlicesWithCapacityNotShared(t *testing.T) {
+	s := New()
+	tx := newTestTransaction("txn_cap", domain.StatusScheduled, domain.SoftDecline)
+	tx.RetryAttempts = make([]domain.RetryAttempt, 0, 4)
+	tx.RetryPlan = &domain.RetryPlan{
+		ScheduledTimes: make([]time.Time, 0, 4),
+		Processors:     make([]string, 0, 4),
+	}
+	s.Save(tx)
+
+	// Append to two copies in turn; if they shared the stored backing array,
+	// the second append would overwrite the first copy's element
+	a, _ := s.Get("txn_cap")
+	a.RetryAttempts = append(a.RetryAttempts, domain.RetryAttempt{AttemptNumber: 1})
+	a.RetryPlan.Processors = append(a.RetryPlan.Processors, "stripe")
+	a.RetryPlan.ScheduledTimes = append(a.RetryPlan.ScheduledTimes, time.Unix(1, 0))
+
+	b, _ := s.Get("txn_cap")
+	b.RetryAttempts = append(b.RetryAttempts, domain.RetryAttempt{AttemptNumber: 2})
+	b.RetryPlan.Processors = append(b.RetryPlan.Processors, "adyen_apac")
+	b.RetryPlan.ScheduledTimes = append(b.RetryPlan.ScheduledTimes, time.Unix(2, 0))
+
+	if a.RetryAttempts[0].AttemptNumber != 1 {
+		t.Errorf("append on one copy leaked into another's attempts: got %+v", a.RetryAttempts)
+	}
+	if a.RetryPlan.Processors[0] != "stripe" {
+		t.Errorf("append on one copy leaked into another's processors: got %v", a.RetryPlan.Processors)
+	}
+	if !a.RetryPlan.ScheduledTimes[0].Equal(time.Unix(1, 0)) {
+		t.Errorf("append on one copy leaked into another's scheduled times: got %v", a.RetryPlan.ScheduledTimes)
+	}
+}
+
+func BenchmarkCopyTransaction_HardDecline(b *testing.B) {
+	tx := newTestTransaction("txn_bench", domain.StatusFailedFinal, domain.HardDecline)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		copyTransaction(tx)
+	}
+}
+
+func BenchmarkCopyTransaction_WithPlan(b *testing.B) {
+	tx := newTestTransaction("txn_bench", domain.StatusScheduled, domain.SoftDecline)
+	next := time.Now().UTC().Add(time.Hour)
+	tx.NextRetryAt = &next
+	tx.RetryAttempts = []domain.RetryAttempt{{AttemptNumber: 1}}
+	tx.RetryPlan = &domain.RetryPlan{
+		MaxAttempts:    3,
+		ScheduledTimes: []time.Time{next, next, next},
+		Processors:     []string{"stripe", "adyen", "stripe"},
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		copyTransaction(tx)
+	}
+}
+
+func BenchmarkStore_GetAll(b *testing.B) {
+	s := New()
+	for i := 0; i < 1000; i++ {
+		s.Save(newTestTransaction(fmt.Sprintf("txn_%04d", i), domain.StatusFailedFinal, domain.HardDecline))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.GetAll()
+	}
+}
+
+func TestStore_ListPage_StableAcrossInserts(t *testing.T) {
+	s := New()
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		tx := newTestTransaction(fmt.Sprintf("txn_%d", i), domain.StatusScheduled, domain.SoftDecline)
+		tx.CreatedAt = base.Add(time.Duration(i) * time.Minute)
+		s.Save(tx)
+	}
+	// Same CreatedAt as txn_2: ordering falls back to ID
+	tie := newTestTransaction("txn_2b", domain.StatusScheduled, domain.SoftDecline)
+	tie.CreatedAt = base.Add(2 * time.Minute)
+	s.Save(tie)
+
+	seen := map[string]int{}
+	var order []string
+	page, more := s.ListPage("", nil, 2)
+	for {
+		for _, tx := range page {
+			seen[tx.ID]++
+			order = append(order, tx.ID)
+		}
+		if !more {
+			break
+		}
+		// Insert a newer transaction and an older one backdated into the pages
+		// already returned between page fetches; neither may show up later
+		last := page[len(page)-1]
+		newer := newTestTransaction(fmt.Sprintf("txn_new_%d", len(order)), domain.StatusScheduled, domain.SoftDecline)
+		newer.CreatedAt = base.Add(time.Hour)
+		s.Save(newer)
+		older := newTestTransaction(fmt.Sprintf("txn_old_%d", len(order)), domain.StatusScheduled, domain.SoftDecline)
+		older.CreatedAt = last.CreatedAt.Add(time.Second)
+		s.Save(older)
+
+		page, more = s.ListPage("", &Cursor{CreatedAt: last.CreatedAt, ID: last.ID}, 2)
+	}
+
+	want := []string{"txn_4", "txn_3", "txn_2b", "txn_2", "txn_1", "txn_0"}
+	if s.Count() != len(want)+4 {
+		t.Fatalf("expected a newer and an older insert after each of 2 pages, got %d transactions", s.Count())
+	}
+	if len(order) != len(want) {
+		t.Fatalf("expected %v, got %v", want, order)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, order)
+		}
+	}
+	for id, n := range seen {
+		if n != 1 {
+			t.Errorf("%s returned %d times", id, n)
+		}
+	}
+}
+
+func TestStore_ListPage_StatusFilter(t *testing.T) {
+	s := New()
+	s.Save(newTestTransaction("txn_a", domain.StatusRecovered, domain.SoftDecline))
+	s.Save(newTestTransaction("txn_b", domain.StatusScheduled, domain.SoftDecline))
+
+	page, more := s.ListPage(string(domain.StatusRecovered), nil, 10)
+	if more || len(page) != 1 || page[0].ID != "txn_a" {
+		t.Errorf("expected only txn_a, got %d results (more=%v)", len(page), more)
+	}
+}
+
+func TestStore_GetByCardToken(t *testing.T) {
+	s := New()
+	a := newTestTransaction("txn_a", domain.StatusScheduled, domain.SoftDecline)
+	a.CardToken = "tok_1"
+	b := newTestTransaction("txn_b", domain.StatusScheduled, domain.SoftDecline)
+	b.CardToken = "tok_1"
+	c := newTestTransaction("txn_c", domain.StatusScheduled, domain.SoftDecline)
+	c.CardToken = "tok_2"
+	s.Save(a)
+	s.SaveIfNotExists(b)
+	s.Save(c)
+
+	if got := s.GetByCardToken("tok_1"); len(got) != 2 {
+		t.Errorf("expected 2 transactions for tok_1, got %d", len(got))
+	}
+
+	// Changing the token moves the transaction between buckets
+	s.UpdateFunc("txn_b", func(tx *domain.Transaction) error {
+		tx.CardToken = "tok_2"
+		return nil
+	})
+	if got := s.GetByCardToken("tok_1"); len(got) != 1 || got[0].ID != "txn_a" {
+		t.Errorf("expected only txn_a for tok_1 after update, got %d", len(got))
+	}
+	if got := s.GetByCardToken("tok_2"); len(got) != 2 {
+		t.Errorf("expected 2 transactions for tok_2, got %d", len(got))
+	}
+
+	s.Clear()
+	if got := s.GetByCardToken("tok_2"); len(got) != 0 {
+		t.Errorf("expected empty index after clear, got %d", len(got))
+	}
+}
+
+func TestStore_SameCardCallbacks(t *testing.T) {
+	s := New()
+	a := newTestTransaction("txn_a", domain.StatusScheduled, domain.SoftDecline)
+	a.CardToken = "tok_1"
+	s.Save(a)
+
+	b := newTestTransaction("txn_b", domain.StatusScheduled, domain.SoftDecline)
+	b.CardToken = "tok_1"
+	var seen []string
+	err := s.SaveIfNotExistsCappedFunc(b, 0, func(tx *domain.Transaction, sameCard []*domain.Transaction) {
+		for _, other := range sameCard {
+			seen = append(seen, other.ID)
+		}
+		tx.ResolutionReason = "prepared"
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(seen, []string{"txn_a"}) {
+		t.Errorf("expected prepare to see only txn_a, got %v", seen)
+	}
+	if got, _ := s.Get("txn_b"); got.ResolutionReason != "prepared" {
+		t.Error("expected prepare's changes to be saved")
+	}
+
+	seen = nil
+	err = s.UpdateFuncWithCard("txn_a", func(tx *domain.Transaction, sameCard []*domain.Transaction) error {
+		for _, other := range sameCard {
+			seen = append(seen, other.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(seen, []string{"txn_b"}) {
+		t.Errorf("expected update to see only txn_b, got %v", seen)
+	}
+}
+
+func TestStore_PurgeTerminal(t *testing.T) {
+	s := New()
+	now := time.Now().UTC()
+	old := now.Add(-60 * 24 * time.Hour)
+
+	s.Save(&domain.Transaction{ID: "txn_old_failed", MerchantID: "m1", CardToken: "tok_1", Status: domain.StatusFailedFinal, UpdatedAt: old})
+	s.Save(&domain.Transaction{ID: "txn_old_recovered", MerchantID: "m1", Status: domain.StatusRecovered, UpdatedAt: old})
+	s.Save(&domain.Transaction{ID: "txn_old_pending", MerchantID: "m1", Status: domain.StatusRetrying, UpdatedAt: old})
+	s.Save(&domain.Transaction{ID: "txn_old_unconfirmed", MerchantID: "m1", Status: domain.StatusPendingConfirmation, UpdatedAt: old})
+	s.Save(&domain.Transaction{ID: "txn_new_failed", MerchantID: "m1", Status: domain.StatusFailedFinal, UpdatedAt: now})
+	s.Save(&domain.Transaction{ID: "txn_new_pending", MerchantID: "m2", Status: domain.StatusScheduled, UpdatedAt: now})
+
+	cutoff := now.Add(-30 * 24 * time.Hour)
+	if purged := s.PurgeTerminal(cutoff, domain.StatusFailedFinal); purged != 1 {
+		t.Errorf("expected 1 failed_final purged, got %d", purged)
+	}
+	if s.Exists("txn_old_failed") {
+		t.Error("expected old failed_final transaction to be purged")
+	}
+	if !s.Exists("txn_old_recovered") {
+		t.Error("expected status filter to keep recovered transaction")
+	}
+
+	if purged := s.PurgeTerminal(cutoff); purged != 1 {
+		t.Errorf("expected remaining old terminal transaction purged, got %d", purged)
+	}
+	if purged := s.PurgeTerminal(cutoff, domain.StatusPendingConfirmation); purged != 0 {
+		t.Errorf("expected recoveries awaiting confirmation never purged, got %d", purged)
+	}
+	for _, id := range []string{"txn_old_pending", "txn_old_unconfirmed", "txn_new_failed", "txn_new_pending"} {
+		if !s.Exists(id) {
+			t.Errorf("expected %s to survive purge", id)
+		}
+	}
+	if got := len(s.GetPendingRetries()); got != 2 {
+		t.Errorf("expected 2 pending retries after purge, got %d", got)
+	}
+	if got := len(s.GetByMerchant("m1")); got != 3 {
+		t.Errorf("expected merchant index to drop purged transactions, got %d", got)
+	}
+	if got := len(s.GetByCardToken("tok_1")); got != 0 {
+		t.Errorf("expected card token index to drop purged transaction, got %d", got)
+	}
+}
+
+func TestStore_RecoveredTotal(t *testing.T) {
+	s := New()
+	s.Save(&domain.Transaction{ID: "txn_r1", MerchantID: "m_1", Currency: "USD", AmountCents: 1000, Status: domain.StatusRecovered})
+	s.Save(&domain.Transaction{ID: "txn_r2", MerchantID: "m_1", Currency: "USD", AmountCents: 2500, Status: domain.StatusRecovered})
+	s.Save(&domain.Transaction{ID: "txn_r3", MerchantID: "m_1", Currency: "BRL", AmountCents: 9000, Status: domain.StatusRecovered})
+	s.Save(&domain.Transaction{ID: "txn_r4", MerchantID: "m_1", Currency: "USD", AmountCents: 7000, Status: domain.StatusScheduled})
+	s.Save(&domain.Transaction{ID: "txn_r5", MerchantID: "m_2", Currency: "USD", AmountCents: 4000, Status: domain.StatusRecovered})
+
+	if cents, count := s.RecoveredTotal("m_1", "USD"); cents != 3500 || count != 2 {
+		t.Errorf("expected 3500 cents over 2 transactions, got %d over %d", cents, count)
+	}
+	if cents, count := s.RecoveredTotal("m_unknown", "USD"); cents != 0 || count != 0 {
+		t.Errorf("expected nothing for an unknown merchant, got %d over %d", cents, count)
+	}
+}
@@ -2,49 +2,655 @@ package webhook
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
+	neturl "net/url"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/eabugauch/zenithpay-retry/internal/domain"
 )
 
+// OverflowPolicy decides what happens to a delivery when the queue is full.
+type OverflowPolicy string
+
+const (
+	// OverflowDrop discards the delivery immediately and counts it as dropped.
+	OverflowDrop OverflowPolicy = "drop"
+	// OverflowBlock waits up to QueueConfig.BlockTimeout for space, then drops.
+	OverflowBlock OverflowPolicy = "block"
+)
+
+// ParseOverflowPolicy parses a policy name as used in WEBHOOK_OVERFLOW_POLICY.
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch p := OverflowPolicy(s); p {
+	case OverflowDrop, OverflowBlock:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown overflow policy %q (want drop or block)", s)
+}
+
+// DefaultURLMode selects how the notifier's default webhook URL combines with
+// a transaction's own URL.
+type DefaultURLMode string
+
+const (
+	// DefaultURLFallback delivers to the default only when the transaction has
+	// no URL for the event.
+	DefaultURLFallback DefaultURLMode = "fallback"
+	// DefaultURLCopy also delivers every event to the default, in addition to
+	// the transaction's URL.
+	DefaultURLCopy DefaultURLMode = "copy"
+)
+
+// ParseDefaultURLMode parses a mode name as used in DEFAULT_WEBHOOK_MODE.
+func ParseDefaultURLMode(s string) (DefaultURLMode, error) {
+	switch m := DefaultURLMode(s); m {
+	case DefaultURLFallback, DefaultURLCopy:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown default webhook mode %q (want fallback or copy)", s)
+}
+
+// QueueConfig bounds webhook delivery to a fixed worker pool fed by a queue.
+// Events are always recorded; only their HTTP delivery can be dropped.
+type QueueConfig struct {
+	Workers      int            // delivery goroutines
+	Size         int            // pending deliveries buffered ahead of the workers
+	Overflow     OverflowPolicy // what Send does when the queue is full
+	BlockTimeout time.Duration  // OverflowBlock only: max time Send waits for space
+}
+
+// dedupeWindow is how long an event's dedupe key suppresses delivery of
+// identical events, e.g. from a scheduler tick racing a manual retry. Expired
+// keys are pruned at most once per window.
+const dedupeWindow = 30 * time.Second
+
+// dedupeKey identifies an event for duplicate suppression. planSize is the
+// number of scheduled attempts in the transaction's plan when the event was
+// sent, so events that follow a plan extension (a fallback strategy or a
+// reset attempt budget) are not mistaken for repeats of an earlier event with
+// the same attempt number. A reschedule keeps the plan size, so it relies on
+// not re-sending events itself.
+type dedupeKey struct {
+	txID          string
+	eventType     string
+	attemptNumber int
+	planSize      int
+}
+
+type delivery struct {
+	url     string
+	event   domain.WebhookEvent
+	batch   []domain.WebhookEvent // non-nil: deliver these as one JSON array instead of event
+	attempt int                   // earlier attempts rejected by an ack mismatch
+}
+
+// maxAckAttempts bounds how often a delivery whose response does not echo the
+// expected ack is sent, counting the first attempt.
+const maxAckAttempts = 3
+
+// BatchConfig sets when a batching merchant's accumulated events are
+// delivered: once Size events are waiting for a URL, or Interval after the
+// first of them, whichever comes first.
+type BatchConfig struct {
+	Size     int
+	Interval time.Duration
+}
+
+// Default batch triggers, used until SetBatching changes them.
+const (
+	defaultBatchSize     = 50
+	defaultBatchInterval = 5 * time.Second
+)
+
+// delayedDelivery is an event waiting out its merchant's success delay.
+type delayedDelivery struct {
+	url     string
+	event   domain.WebhookEvent
+	batched bool
+	timer   *time.Timer
+}
+
+// batch collects events waiting for delivery to one URL.
+type batch struct {
+	events []domain.WebhookEvent
+	timer  *time.Timer // flushes the batch at its interval
+}
+
 // Notifier sends webhook notifications to merchants and records all events.
 type Notifier struct {
-	mu     sync.RWMutex
-	events []domain.WebhookEvent
-	client *http.Client
-	logger *slog.Logger
+	mu       sync.RWMutex
+	events   []domain.WebhookEvent
+	seen     map[dedupeKey]time.Time  // last delivery time per key, pruned after dedupeWindow
+	pruned   time.Time                // when seen was last pruned
+	ackIDs   map[string]bool          // merchants whose endpoints must echo the event ID
+	batchIDs map[string]bool          // merchants whose events are delivered in batches
+	delays   map[string]time.Duration // per-merchant delay before delivering retry.succeeded
+	client   *http.Client
+	timeout  time.Duration // default per-delivery timeout
+	logger   *slog.Logger
+
+	userAgent   string            // User-Agent of deliveries and probes; "" = Go's default
+	headers     map[string]string // extra headers of every delivery and probe
+	defaultURL  string            // delivery target for transactions without a URL; "" = none
+	defaultMode DefaultURLMode
+	stats       RecoveryStats // enriches retry.succeeded events; nil = off
+
+	batchMu        sync.Mutex
+	batches        map[string]*batch // URL -> events waiting to be delivered together
+	batchCfg       BatchConfig
+	batchesFlushed bool // set by Shutdown: later events are delivered without waiting for a batch
+
+	delayMu sync.Mutex
+	delayed map[*delayedDelivery]bool // events waiting out a success delay
+	closed  bool                      // set by Shutdown: later events are not delayed
+
+	queue     chan delivery // nil = unbounded, one goroutine per delivery
+	queued    QueueConfig
+	inflight  atomic.Int64 // deliveries handed to a goroutine or the queue and not yet run
+	dropped   atomic.Int64
+	delivered atomic.Int64
+	failed    atomic.Int64
 }
 
+// defaultDeliveryTimeout bounds a delivery when the transaction sets no timeout.
+const defaultDeliveryTimeout = 5 * time.Second
+
+// probeTimeout bounds a reachability probe, which runs while the submit
+// caller waits.
+const probeTimeout = 2 * time.Second
+
 // NewNotifier creates a new webhook notifier with an HTTP client for delivery.
+// Each delivery runs in its own goroutine; see NewQueuedNotifier for a bound.
 func NewNotifier(logger *slog.Logger) *Notifier {
 	return &Notifier{
-		events: []domain.WebhookEvent{},
-		client: &http.Client{Timeout: 5 * time.Second},
-		logger: logger,
+		events:  []domain.WebhookEvent{},
+		seen:    make(map[dedupeKey]time.Time),
+		ackIDs:  make(map[string]bool),
+		client:  &http.Client{},
+		timeout: defaultDeliveryTimeout,
+		logger:  logger,
+
+		batchIDs: make(map[string]bool),
+		delays:   make(map[string]time.Duration),
+		batches:  make(map[string]*batch),
+		batchCfg: BatchConfig{Size: defaultBatchSize, Interval: defaultBatchInterval},
+		delayed:  make(map[*delayedDelivery]bool),
+	}
+}
+
+// NewQueuedNotifier creates a notifier whose deliveries go through a bounded
+// queue drained by cfg.Workers goroutines, applying cfg.Overflow when a burst
+// fills the queue. Workers live for the lifetime of the process.
+func NewQueuedNotifier(logger *slog.Logger, cfg QueueConfig) *Notifier {
+	n := NewNotifier(logger)
+	n.queue = make(chan delivery, cfg.Size)
+	n.queued = cfg
+	for range cfg.Workers {
+		go func() {
+			for d := range n.queue {
+				n.run(d)
+			}
+		}()
+	}
+	return n
+}
+
+// RequireAck turns acknowledgment verification on or off for a merchant. While
+// on, a delivery to that merchant only counts as delivered when the response
+// body (ignoring surrounding whitespace) is the event's ID; anything else is a
+// failure.
+func (n *Notifier) RequireAck(merchantID string, required bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if required {
+		n.ackIDs[merchantID] = true
+	} else {
+		delete(n.ackIDs, merchantID)
+	}
+}
+
+// BatchDeliveries turns batched delivery on or off for a merchant. While on,
+// the merchant's events are still recorded one by one, but delivered as a JSON
+// array per URL when the notifier's batch triggers fire (see SetBatching).
+// Replays are delivered individually.
+func (n *Notifier) BatchDeliveries(merchantID string, enabled bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if enabled {
+		n.batchIDs[merchantID] = true
+	} else {
+		delete(n.batchIDs, merchantID)
+	}
+}
+
+// maxSuccessWebhookDelay bounds SetSuccessWebhookDelay, so a recovery is
+// never reported to the merchant more than this long after it happened.
+const maxSuccessWebhookDelay = time.Hour
+
+// SetSuccessWebhookDelay delays delivery of a merchant's retry.succeeded
+// events by delay, giving downstream systems time to settle. The event is
+// still recorded immediately; only the HTTP delivery waits. A zero delay
+// turns it off; it must not be negative or exceed one hour.
+func (n *Notifier) SetSuccessWebhookDelay(merchantID string, delay time.Duration) error {
+	if delay < 0 || delay > maxSuccessWebhookDelay {
+		return fmt.Errorf("success webhook delay must be between 0 and %s, got %s", maxSuccessWebhookDelay, delay)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if delay > 0 {
+		n.delays[merchantID] = delay
+	} else {
+		delete(n.delays, merchantID)
 	}
+	return nil
 }
 
-// Send delivers a webhook event to the merchant's endpoint (if configured)
-// and records the event in the internal log.
+// SetBatching sets the batch triggers (default 50 events or 5s). Must be set
+// before the notifier is used.
+func (n *Notifier) SetBatching(cfg BatchConfig) error {
+	if cfg.Size < 1 {
+		return fmt.Errorf("batch size must be at least 1, got %d", cfg.Size)
+	}
+	if cfg.Interval <= 0 {
+		return fmt.Errorf("batch interval must be positive, got %s", cfg.Interval)
+	}
+	n.batchCfg = cfg
+	return nil
+}
+
+// reservedHeaders are set by the notifier itself and cannot be configured as
+// extra headers. User-Agent has its own setting.
+var reservedHeaders = []string{"Content-Type", "Content-Length", "Host", "User-Agent"}
+
+// SetRequestHeaders sets the User-Agent and extra headers sent with every
+// delivery and probe, e.g. for merchants that allowlist by User-Agent or
+// require a static auth header. An empty userAgent keeps Go's default. Header
+// names must be valid HTTP tokens other than Content-Type, Content-Length,
+// Host and User-Agent; values must not contain line breaks. Must be set before
+// the notifier is used.
+func (n *Notifier) SetRequestHeaders(userAgent string, headers map[string]string) error {
+	if strings.ContainsAny(userAgent, "\r\n") {
+		return fmt.Errorf("user agent must not contain line breaks")
+	}
+	canonical := make(map[string]string, len(headers))
+	for name, value := range headers {
+		if !validHeaderName(name) {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+		name = http.CanonicalHeaderKey(name)
+		if slices.Contains(reservedHeaders, name) {
+			return fmt.Errorf("header %s is set by the notifier and cannot be configured", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("value of header %s must not contain line breaks", name)
+		}
+		canonical[name] = value
+	}
+	n.userAgent = userAgent
+	n.headers = canonical
+	return nil
+}
+
+// validHeaderName reports whether name is a non-empty RFC 9110 token.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("!#$%&'*+-.^_`|~", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// setHeaders applies the configured User-Agent and extra headers to req.
+func (n *Notifier) setHeaders(req *http.Request) {
+	if n.userAgent != "" {
+		req.Header.Set("User-Agent", n.userAgent)
+	}
+	for name, value := range n.headers {
+		req.Header.Set(name, value)
+	}
+}
+
+// RecoveryStats reports a merchant's running recovery totals. The store
+// implements it; the notifier only needs this much of it.
+type RecoveryStats interface {
+	RecoveredTotal(merchantID, currency string) (cents int64, count int)
+}
+
+// SetRecoveryStats makes retry.succeeded events of transactions with a
+// merchant carry the merchant's cumulative recovered amount in the
+// transaction's currency, read from stats when the event is sent. nil turns
+// it off. Must be set before the notifier is used.
+func (n *Notifier) SetRecoveryStats(stats RecoveryStats) {
+	n.stats = stats
+}
+
+// SetDefaultURL sets the endpoint that receives events of transactions without
+// a webhook URL of their own, or, with DefaultURLCopy, a copy of every
+// delivered event. An empty rawURL disables it. Must be set before the notifier
+// is used.
+func (n *Notifier) SetDefaultURL(rawURL string, mode DefaultURLMode) error {
+	if rawURL != "" {
+		u, err := neturl.Parse(rawURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("default webhook URL %q must be an absolute http(s) URL", rawURL)
+		}
+	}
+	if _, err := ParseDefaultURLMode(string(mode)); err != nil {
+		return err
+	}
+	n.defaultURL = rawURL
+	n.defaultMode = mode
+	return nil
+}
+
+// dispatchAll dispatches event to each of its targets (see targets). With
+// batched set, the event joins each target's batch instead.
+func (n *Notifier) dispatchAll(url string, event domain.WebhookEvent, batched bool) {
+	send := n.dispatch
+	if batched {
+		send = n.addToBatch
+	}
+	for _, target := range n.targets(url) {
+		send(target, event)
+	}
+}
+
+// targets returns url, the event's resolved target, which may be empty, and
+// in DefaultURLCopy mode the default URL as well.
+func (n *Notifier) targets(url string) []string {
+	var targets []string
+	if url != "" {
+		targets = append(targets, url)
+	}
+	if n.defaultMode == DefaultURLCopy && n.defaultURL != "" && n.defaultURL != url {
+		targets = append(targets, n.defaultURL)
+	}
+	return targets
+}
+
+// addToBatch appends event to url's batch, dispatching the batch once it
+// reaches the size trigger. A new batch starts its interval timer. After
+// Shutdown, the event is dispatched at once as a batch of one.
+func (n *Notifier) addToBatch(url string, event domain.WebhookEvent) {
+	n.batchMu.Lock()
+	if n.batchesFlushed {
+		n.batchMu.Unlock()
+		n.enqueue(delivery{url: url, batch: []domain.WebhookEvent{event}})
+		return
+	}
+	b, ok := n.batches[url]
+	if !ok {
+		b = &batch{}
+		b.timer = time.AfterFunc(n.batchCfg.Interval, func() { n.flushBatch(url, b) })
+		n.batches[url] = b
+	}
+	b.events = append(b.events, event)
+	var full []domain.WebhookEvent
+	if len(b.events) >= n.batchCfg.Size {
+		b.timer.Stop()
+		delete(n.batches, url)
+		full = b.events
+	}
+	n.batchMu.Unlock()
+
+	if full != nil {
+		n.enqueue(delivery{url: url, batch: full})
+	}
+}
+
+// flushBatch dispatches b when its interval elapses, unless the size trigger
+// already dispatched it.
+func (n *Notifier) flushBatch(url string, b *batch) {
+	n.batchMu.Lock()
+	if n.batches[url] != b {
+		n.batchMu.Unlock()
+		return
+	}
+	delete(n.batches, url)
+	n.batchMu.Unlock()
+	n.enqueue(delivery{url: url, batch: b.events})
+}
+
+// flushBatches dispatches every pending batch without waiting for its
+// triggers, and makes later events skip batching.
+func (n *Notifier) flushBatches() {
+	n.batchMu.Lock()
+	n.batchesFlushed = true
+	pending := n.batches
+	n.batches = make(map[string]*batch)
+	n.batchMu.Unlock()
+
+	for url, b := range pending {
+		b.timer.Stop()
+		n.enqueue(delivery{url: url, batch: b.events})
+	}
+}
+
+// maxAckBody caps how much of a response body is read for ack verification.
+const maxAckBody = 1024
+
+// Probe reports whether url answers a HEAD request within probeTimeout. Any
+// HTTP response counts, including 4xx and 5xx: the probe only catches
+// endpoints that cannot be reached at all (DNS failure, refused connection,
+// timeout). Nothing is recorded or counted.
+func (n *Notifier) Probe(ctx context.Context, url string) bool {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return false
+	}
+	n.setHeaders(req)
+	resp, err := n.client.Do(req)
+	if err != nil {
+		n.logger.Debug("webhook probe failed", "url", url, "error", err)
+		return false
+	}
+	resp.Body.Close()
+	return true
+}
+
+// DeliveryStats returns how many deliveries completed and how many failed
+// (transport error, timeout or ack mismatch).
+func (n *Notifier) DeliveryStats() (delivered, failed int64) {
+	return n.delivered.Load(), n.failed.Load()
+}
+
+// DroppedDeliveries returns how many deliveries were dropped on a full queue.
+func (n *Notifier) DroppedDeliveries() int64 {
+	return n.dropped.Load()
+}
+
+// dispatch hands a single-event delivery to enqueue.
+func (n *Notifier) dispatch(url string, event domain.WebhookEvent) {
+	n.enqueue(delivery{url: url, event: event})
+}
+
+// enqueue hands a delivery to the worker queue, or to a new goroutine when
+// the notifier is unbounded. Accepted deliveries count as in flight until run
+// finishes them.
+func (n *Notifier) enqueue(d delivery) {
+	n.inflight.Add(1)
+	if n.queue == nil {
+		go n.run(d)
+		return
+	}
+
+	select {
+	case n.queue <- d:
+		return
+	default:
+	}
+
+	if n.queued.Overflow == OverflowBlock {
+		timer := time.NewTimer(n.queued.BlockTimeout)
+		defer timer.Stop()
+		select {
+		case n.queue <- d:
+			return
+		case <-timer.C:
+		}
+	}
+
+	n.inflight.Add(-1)
+	n.dropped.Add(1)
+	n.logger.Warn("webhook queue full, delivery dropped",
+		"url", d.url,
+		"event_type", d.event.EventType,
+		"transaction_id", d.event.TransactionID,
+		"batch_size", len(d.batch),
+		"policy", n.queued.Overflow,
+	)
+}
+
+// enqueueWait is enqueue for bulk work such as replays: it waits for queue
+// space instead of applying the overflow policy, and on an unbounded notifier
+// runs the delivery in the caller's goroutine.
+func (n *Notifier) enqueueWait(d delivery) {
+	n.inflight.Add(1)
+	if n.queue == nil {
+		n.run(d)
+		return
+	}
+	n.queue <- d
+}
+
+// run performs a queued delivery. A delivery whose ack does not match goes
+// back on the queue until it has been attempted maxAckAttempts times, and only
+// then counts as failed.
+func (n *Notifier) run(d delivery) {
+	defer n.inflight.Add(-1)
+	var mismatch bool
+	if d.batch != nil {
+		mismatch = n.deliverBatch(d.url, d.batch)
+	} else {
+		mismatch = n.deliver(d.url, d.event)
+	}
+	if !mismatch {
+		return
+	}
+
+	d.attempt++
+	if d.attempt < maxAckAttempts {
+		n.logger.Info("webhook redelivery after ack mismatch",
+			"url", d.url,
+			"event_id", d.event.ID,
+			"batch_size", len(d.batch),
+			"attempt", d.attempt+1,
+		)
+		n.enqueue(d)
+		return
+	}
+	n.failed.Add(1)
+	n.logger.Warn("webhook ack never matched, delivery failed",
+		"url", d.url,
+		"event_id", d.event.ID,
+		"batch_size", len(d.batch),
+		"attempts", d.attempt,
+	)
+}
+
+// Send delivers a webhook event to the merchant's endpoint (if configured,
+// otherwise the notifier's default URL, if any) and records the event in the
+// internal log. An event repeating the same dedupeKey within dedupeWindow is
+// recorded flagged as a duplicate and not delivered, as is an event whose type
+// the transaction's webhook_events allow-list excludes (flagged filtered).
 func (n *Notifier) Send(tx *domain.Transaction, eventType string, attemptNumber int) {
+	url := tx.WebhookURLFor(eventType)
+	if url == "" {
+		url = n.defaultURL
+	}
+	now := time.Now().UTC()
 	event := domain.WebhookEvent{
+		ID:            fmt.Sprintf("%s:%s:%d", tx.ID, eventType, attemptNumber),
 		EventType:     eventType,
 		TransactionID: tx.ID,
 		Status:        tx.Status,
 		AttemptNumber: attemptNumber,
-		Timestamp:     time.Now().UTC(),
+		Timestamp:     now,
+		WebhookURL:    url,
+		Timeout:       time.Duration(tx.WebhookTimeoutMs) * time.Millisecond,
+		Filtered:      !tx.DeliversEvent(eventType),
+	}
+	if eventType == domain.EventRetrySucceeded && n.stats != nil && tx.MerchantID != "" {
+		cents, count := n.stats.RecoveredTotal(tx.MerchantID, tx.Currency)
+		event.Cumulative = &domain.RecoveryTotals{
+			MerchantID:     tx.MerchantID,
+			Currency:       tx.Currency,
+			RecoveredCents: cents,
+			RecoveredCount: count,
+		}
+	}
+	key := dedupeKey{txID: tx.ID, eventType: eventType, attemptNumber: attemptNumber}
+	if tx.RetryPlan != nil {
+		key.planSize = len(tx.RetryPlan.ScheduledTimes)
 	}
 
 	n.mu.Lock()
+	event.ExpectAck = n.ackIDs[tx.MerchantID]
+	batched := n.batchIDs[tx.MerchantID]
+	var delay time.Duration
+	if eventType == domain.EventRetrySucceeded {
+		delay = n.delays[tx.MerchantID]
+	}
+	if now.Sub(n.pruned) >= dedupeWindow {
+		for k, at := range n.seen {
+			if now.Sub(at) >= dedupeWindow {
+				delete(n.seen, k)
+			}
+		}
+		n.pruned = now
+	}
+	if at, ok := n.seen[key]; ok && now.Sub(at) < dedupeWindow {
+		event.Duplicate = true
+	} else {
+		n.seen[key] = now
+	}
 	n.events = append(n.events, event)
 	n.mu.Unlock()
 
-	if tx.WebhookURL != "" {
-		go n.deliver(tx.WebhookURL, event)
+	if event.Duplicate {
+		n.logger.Info("duplicate webhook event suppressed",
+			"event_type", eventType,
+			"transaction_id", tx.ID,
+			"attempt", attemptNumber,
+		)
+		return
+	}
+	if event.Filtered {
+		n.logger.Debug("webhook event recorded (type not in webhook_events)",
+			"event_type", eventType,
+			"transaction_id", tx.ID,
+		)
+		return
+	}
+
+	if url != "" && delay > 0 {
+		n.dispatchAfter(delay, &delayedDelivery{url: url, event: event, batched: batched})
+		n.logger.Debug("webhook delivery delayed",
+			"event_type", eventType,
+			"transaction_id", tx.ID,
+			"delay", delay,
+		)
+	} else if url != "" {
+		n.dispatchAll(url, event, batched)
 	} else {
 		n.logger.Debug("webhook event recorded (no URL configured)",
 			"event_type", eventType,
@@ -53,32 +659,241 @@ func (n *Notifier) Send(tx *domain.Transaction, eventType string, attemptNumber
 	}
 }
 
-// deliver attempts an HTTP POST to the merchant webhook URL.
-func (n *Notifier) deliver(url string, event domain.WebhookEvent) {
+// dispatchAfter dispatches d once delay has passed, unless Clear drops it or
+// Shutdown dispatches it first. After Shutdown, d is dispatched right away.
+func (n *Notifier) dispatchAfter(delay time.Duration, d *delayedDelivery) {
+	n.delayMu.Lock()
+	if n.closed {
+		n.delayMu.Unlock()
+		n.dispatchAll(d.url, d.event, d.batched)
+		return
+	}
+	defer n.delayMu.Unlock()
+	d.timer = time.AfterFunc(delay, func() {
+		n.delayMu.Lock()
+		due := n.delayed[d]
+		delete(n.delayed, d)
+		n.delayMu.Unlock()
+		if due {
+			n.dispatchAll(d.url, d.event, d.batched)
+		}
+	})
+	n.delayed[d] = true
+}
+
+// takeDelayed stops every pending success-delay timer and returns the
+// deliveries they were holding, oldest event first.
+func (n *Notifier) takeDelayed() []*delayedDelivery {
+	n.delayMu.Lock()
+	defer n.delayMu.Unlock()
+	pending := make([]*delayedDelivery, 0, len(n.delayed))
+	for d := range n.delayed {
+		d.timer.Stop()
+		pending = append(pending, d)
+	}
+	n.delayed = make(map[*delayedDelivery]bool)
+	slices.SortFunc(pending, func(a, b *delayedDelivery) int {
+		return a.event.Timestamp.Compare(b.event.Timestamp)
+	})
+	return pending
+}
+
+// shutdownPoll is how often Shutdown checks for in-flight deliveries.
+const shutdownPoll = 10 * time.Millisecond
+
+// Shutdown dispatches events still waiting out a success delay and pending
+// batches right away, then waits until every in-flight delivery has finished
+// or ctx is done, returning ctx's error in the latter case. Events sent
+// afterwards are neither delayed nor batched.
+func (n *Notifier) Shutdown(ctx context.Context) error {
+	n.delayMu.Lock()
+	n.closed = true
+	n.delayMu.Unlock()
+	for _, d := range n.takeDelayed() {
+		n.dispatchAll(d.url, d.event, d.batched)
+	}
+	n.flushBatches()
+
+	ticker := time.NewTicker(shutdownPoll)
+	defer ticker.Stop()
+	for n.inflight.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// deliver attempts an HTTP POST to the merchant webhook URL, bounded by the
+// event's own timeout or the notifier default. It reports whether the
+// response's ack did not match (see post).
+func (n *Notifier) deliver(url string, event domain.WebhookEvent) (ackMismatch bool) {
 	payload, err := json.Marshal(event)
 	if err != nil {
 		n.logger.Error("webhook marshal failed", "error", err)
-		return
+		return false
+	}
+	var ackID string
+	if event.ExpectAck {
+		ackID = event.ID
 	}
+	return n.post(url, payload, event.Timeout, ackID,
+		"event_type", event.EventType,
+		"transaction_id", event.TransactionID,
+	)
+}
 
-	resp, err := n.client.Post(url, "application/json", bytes.NewReader(payload))
+// deliverBatch POSTs events to url as one JSON array, bounded by the longest
+// of the notifier default and the events' timeouts. If any event expects an ack, the response body must be the
+// ID of the last event in the batch. It reports whether that ack did not
+// match (see post).
+func (n *Notifier) deliverBatch(url string, events []domain.WebhookEvent) (ackMismatch bool) {
+	payload, err := json.Marshal(events)
 	if err != nil {
-		n.logger.Warn("webhook delivery failed",
-			"url", url,
-			"event_type", event.EventType,
-			"transaction_id", event.TransactionID,
-			"error", err,
-		)
-		return
+		n.logger.Error("webhook batch marshal failed", "error", err)
+		return false
+	}
+	timeout := n.timeout
+	var ackID string
+	for _, e := range events {
+		timeout = max(timeout, e.Timeout)
+		if e.ExpectAck {
+			ackID = events[len(events)-1].ID
+		}
+	}
+	return n.post(url, payload, timeout, ackID, "batch_size", len(events))
+}
+
+// post sends payload to url and counts the outcome. A zero timeout uses the
+// notifier default; a non-empty ackID must come back as the response body.
+// An ack mismatch is reported to the caller, which decides whether to
+// redeliver, instead of being counted. attrs describe the payload in log lines.
+func (n *Notifier) post(url string, payload []byte, timeout time.Duration, ackID string, attrs ...any) (ackMismatch bool) {
+	if timeout <= 0 {
+		timeout = n.timeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		n.failed.Add(1)
+		n.logger.Error("webhook request build failed", "url", url, "error", err)
+		return false
+	}
+	n.setHeaders(req)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		n.failed.Add(1)
+		n.logger.Warn("webhook delivery failed", append([]any{"url", url, "error", err}, attrs...)...)
+		return false
 	}
 	defer resp.Body.Close()
 
-	n.logger.Info("webhook delivered",
-		"url", url,
-		"event_type", event.EventType,
-		"transaction_id", event.TransactionID,
-		"status_code", resp.StatusCode,
+	if ackID != "" {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxAckBody))
+		if err != nil || strings.TrimSpace(string(body)) != ackID {
+			n.logger.Warn("webhook ack mismatch", append([]any{"url", url, "event_id", ackID}, attrs...)...)
+			return true
+		}
+	}
+
+	n.delivered.Add(1)
+	n.logger.Info("webhook delivered", append([]any{"url", url, "status_code", resp.StatusCode}, attrs...)...)
+	return false
+}
+
+// Replay re-delivers recorded events whose timestamp falls within [from, to],
+// optionally restricted to one transaction, to the URL each event was
+// originally sent to. Events without a URL, duplicates and filtered events
+// are skipped. At most limit events
+// are dispatched (oldest first); matched reports how many were eligible.
+// Deliveries run in the background through the worker queue, waiting for
+// space rather than being dropped, or one at a time on an unbounded notifier.
+func (n *Notifier) Replay(from, to time.Time, txID string, limit int) (dispatched int, matched int) {
+	n.mu.RLock()
+	var replay []domain.WebhookEvent
+	for _, e := range n.events {
+		if e.WebhookURL == "" || e.Duplicate || e.Filtered || e.Timestamp.Before(from) || e.Timestamp.After(to) {
+			continue
+		}
+		if txID != "" && e.TransactionID != txID {
+			continue
+		}
+		matched++
+		if len(replay) < limit {
+			replay = append(replay, e)
+		}
+	}
+	n.mu.RUnlock()
+
+	go func() {
+		for _, e := range replay {
+			for _, target := range n.targets(e.WebhookURL) {
+				n.enqueueWait(delivery{url: target, event: e})
+			}
+		}
+	}()
+	n.logger.Info("webhook replay dispatched",
+		"from", from,
+		"to", to,
+		"transaction_id", txID,
+		"dispatched", len(replay),
+		"matched", matched,
 	)
+	return len(replay), matched
+}
+
+// EventFilter selects recorded events for ExportEvents. Zero fields match
+// everything; From and To are inclusive.
+type EventFilter struct {
+	EventType     string
+	TransactionID string
+	From, To      time.Time
+}
+
+func (f EventFilter) matches(e domain.WebhookEvent) bool {
+	return (f.EventType == "" || e.EventType == f.EventType) &&
+		(f.TransactionID == "" || e.TransactionID == f.TransactionID) &&
+		(f.From.IsZero() || !e.Timestamp.Before(f.From)) &&
+		(f.To.IsZero() || !e.Timestamp.After(f.To))
+}
+
+// exportBatch is how many recorded events ExportEvents copies per lock hold.
+const exportBatch = 256
+
+// ExportEvents calls fn for each recorded event matching f, oldest first, and
+// stops at the first error fn returns. Events are copied out in small batches
+// so fn (e.g. a slow client write) never runs under the lock and memory stays
+// flat regardless of history size. Events recorded during the export are
+// included if the export has not passed them yet.
+func (n *Notifier) ExportEvents(f EventFilter, fn func(domain.WebhookEvent) error) error {
+	batch := make([]domain.WebhookEvent, 0, exportBatch)
+	for next := 0; ; {
+		batch = batch[:0]
+		n.mu.RLock()
+		end := min(next+exportBatch, len(n.events))
+		for _, e := range n.events[min(next, end):end] {
+			if f.matches(e) {
+				batch = append(batch, e)
+			}
+		}
+		n.mu.RUnlock()
+		if end <= next {
+			return nil
+		}
+		next = end
+
+		for _, e := range batch {
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+	}
 }
 
 // GetEvents returns all recorded webhook events.
@@ -103,9 +918,12 @@ func (n *Notifier) GetEventsByTransaction(txID string) []domain.WebhookEvent {
 	return result
 }
 
-// Clear removes all recorded events.
+// Clear removes all recorded events and drops deliveries still waiting out a
+// success delay.
 func (n *Notifier) Clear() {
+	n.takeDelayed()
 	n.mu.Lock()
 	defer n.mu.Unlock()
 	n.events = []domain.WebhookEvent{}
+	n.seen = make(map[dedupeKey]time.Time)
 }
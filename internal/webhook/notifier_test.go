@@ -1,10 +1,13 @@
 package webhook
 
 import (
+	"context"
+	"encoding/json"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -136,6 +139,48 @@ func TestNotifier_WebhookHTTPDelivery(t *testing.T) {
 	}
 }
 
+func TestNotifier_CustomRequestHeaders(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Clone()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := NewNotifier(testLogger())
+	if err := n.SetRequestHeaders("ZenithPay-Webhooks/1.0", map[string]string{"x-api-key": "secret"}); err != nil {
+		t.Fatalf("SetRequestHeaders: %v", err)
+	}
+	n.Send(testTransaction("txn_headers", server.URL), domain.EventRetryScheduled, 0)
+
+	select {
+	case h := <-headers:
+		if got := h.Get("User-Agent"); got != "ZenithPay-Webhooks/1.0" {
+			t.Errorf("expected configured User-Agent, got %q", got)
+		}
+		if got := h.Get("X-Api-Key"); got != "secret" {
+			t.Errorf("expected X-Api-Key header, got %q", got)
+		}
+		if got := h.Get("Content-Type"); got != "application/json" {
+			t.Errorf("expected application/json content type, got %q", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("webhook not delivered")
+	}
+
+	for _, bad := range []map[string]string{
+		{"bad header": "x"},
+		{"": "x"},
+		{"content-type": "text/plain"},
+		{"user-agent": "Other/2.0"},
+		{"X-Ok": "a\r\nInjected: b"},
+	} {
+		if err := n.SetRequestHeaders("", bad); err == nil {
+			t.Errorf("expected error for headers %q", bad)
+		}
+	}
+}
+
 func TestNotifier_WebhookDeliveryFailure(t *testing.T) {
 	// Unreachable URL — should not panic
 	n := NewNotifier(testLogger())
@@ -197,3 +242,811 @@ func TestNotifier_ConcurrentSendAndGet(t *testing.T) {
 		t.Errorf("expected 50 events, got %d", len(events))
 	}
 }
+
+func TestNotifier_ReplayWindow(t *testing.T) {
+	var mu sync.Mutex
+	received := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event domain.WebhookEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		mu.Lock()
+		received[event.TransactionID]++
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := NewNotifier(testLogger())
+	n.Send(testTransaction("txn_before", server.URL), domain.EventRetryScheduled, 0)
+	time.Sleep(10 * time.Millisecond)
+	from := time.Now().UTC()
+	n.Send(testTransaction("txn_in_1", server.URL), domain.EventRetryScheduled, 0)
+	n.Send(testTransaction("txn_in_2", server.URL), domain.EventRetryFailed, 1)
+	n.Send(testTransaction("txn_no_url", ""), domain.EventRetryFailed, 1)
+	to := time.Now().UTC()
+
+	// Let the original deliveries land before replaying
+	time.Sleep(200 * time.Millisecond)
+
+	dispatched, matched := n.Replay(from, to, "", 10)
+	if dispatched != 2 || matched != 2 {
+		t.Errorf("expected 2 dispatched and 2 matched, got %d and %d", dispatched, matched)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if received["txn_before"] != 1 {
+		t.Errorf("event outside window should not be replayed, got %d deliveries", received["txn_before"])
+	}
+	if received["txn_in_1"] != 2 || received["txn_in_2"] != 2 {
+		t.Errorf("expected events in window delivered twice, got %v", received)
+	}
+}
+
+func TestNotifier_ReplayFilterAndLimit(t *testing.T) {
+	n := NewNotifier(testLogger())
+	from := time.Now().UTC()
+	for i := 0; i < 5; i++ {
+		n.Send(testTransaction("txn_many", "http://127.0.0.1:1"), domain.EventRetryFailed, i+1)
+	}
+	n.Send(testTransaction("txn_other", "http://127.0.0.1:1"), domain.EventRetryFailed, 1)
+	to := time.Now().UTC()
+
+	dispatched, matched := n.Replay(from, to, "txn_many", 3)
+	if dispatched != 3 || matched != 5 {
+		t.Errorf("expected 3 dispatched of 5 matched, got %d of %d", dispatched, matched)
+	}
+}
+
+func TestNotifier_ReplayIsBounded(t *testing.T) {
+	var active, peak, hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := active.Add(1)
+		for p := peak.Load(); now > p && !peak.CompareAndSwap(p, now); p = peak.Load() {
+		}
+		time.Sleep(10 * time.Millisecond)
+		active.Add(-1)
+		hits.Add(1)
+	}))
+	defer server.Close()
+
+	for _, tt := range []struct {
+		name     string
+		n        *Notifier
+		maxPeak  int32
+		wantHits int32
+	}{
+		// Unbounded notifier: replays go out one at a time.
+		{"unbounded", NewNotifier(testLogger()), 1, 10},
+		// Queued notifier: replays wait for queue space instead of being
+		// dropped, and never exceed the worker pool.
+		{"queued", NewQueuedNotifier(testLogger(), QueueConfig{Workers: 2, Size: 1, Overflow: OverflowDrop}), 2, 10},
+	} {
+		peak.Store(0)
+		hits.Store(0)
+		from := time.Now().UTC()
+		for i := range 10 {
+			tt.n.Send(testTransaction("txn_replay_bound", "http://127.0.0.1:1"), domain.EventRetryFailed, i+1)
+		}
+		to := time.Now().UTC()
+		tt.n.mu.Lock()
+		for i := range tt.n.events {
+			tt.n.events[i].WebhookURL = server.URL
+		}
+		tt.n.mu.Unlock()
+
+		if dispatched, _ := tt.n.Replay(from, to, "", 10); dispatched != 10 {
+			t.Fatalf("%s: expected 10 dispatched, got %d", tt.name, dispatched)
+		}
+		deadline := time.Now().Add(2 * time.Second)
+		for hits.Load() < tt.wantHits && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if got := hits.Load(); got != tt.wantHits {
+			t.Errorf("%s: expected %d replayed deliveries, got %d", tt.name, tt.wantHits, got)
+		}
+		if got := peak.Load(); got > tt.maxPeak {
+			t.Errorf("%s: expected at most %d concurrent replay deliveries, got %d", tt.name, tt.maxPeak, got)
+		}
+	}
+}
+
+func TestNotifier_SendRoutesByEventType(t *testing.T) {
+	newRecorder := func() (*httptest.Server, func() []string) {
+		var mu sync.Mutex
+		var got []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var event domain.WebhookEvent
+			json.NewDecoder(r.Body).Decode(&event)
+			mu.Lock()
+			got = append(got, event.EventType)
+			mu.Unlock()
+			w.WriteHeader(http.StatusOK)
+		}))
+		return server, func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]string(nil), got...)
+		}
+	}
+	billing, billingEvents := newRecorder()
+	defer billing.Close()
+	alerting, alertingEvents := newRecorder()
+	defer alerting.Close()
+
+	tx := testTransaction("txn_routes", "")
+	tx.WebhookRoutes = map[string]string{
+		domain.EventRetrySucceeded: billing.URL,
+		domain.EventRetryExhausted: alerting.URL,
+	}
+
+	n := NewNotifier(testLogger())
+	n.Send(tx, domain.EventRetrySucceeded, 1)
+	n.Send(tx, domain.EventRetryExhausted, 3)
+	n.Send(tx, domain.EventRetryFailed, 2) // no route and no fallback URL: recorded only
+
+	time.Sleep(200 * time.Millisecond)
+
+	if got := billingEvents(); len(got) != 1 || got[0] != domain.EventRetrySucceeded {
+		t.Errorf("billing server expected only %s, got %v", domain.EventRetrySucceeded, got)
+	}
+	if got := alertingEvents(); len(got) != 1 || got[0] != domain.EventRetryExhausted {
+		t.Errorf("alerting server expected only %s, got %v", domain.EventRetryExhausted, got)
+	}
+	if len(n.GetEvents()) != 3 {
+		t.Errorf("expected 3 recorded events, got %d", len(n.GetEvents()))
+	}
+}
+
+func TestNotifier_DefaultURL(t *testing.T) {
+	newRecorder := func() (*httptest.Server, func() []string) {
+		var mu sync.Mutex
+		var got []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var event domain.WebhookEvent
+			json.NewDecoder(r.Body).Decode(&event)
+			mu.Lock()
+			got = append(got, event.TransactionID)
+			mu.Unlock()
+			w.WriteHeader(http.StatusOK)
+		}))
+		return server, func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return slices.Sorted(slices.Values(got))
+		}
+	}
+
+	for _, tc := range []struct {
+		mode        DefaultURLMode
+		wantDefault []string
+	}{
+		{DefaultURLFallback, []string{"txn_no_url"}},
+		{DefaultURLCopy, []string{"txn_no_url", "txn_own_url"}},
+	} {
+		merchant, merchantGot := newRecorder()
+		fallback, fallbackGot := newRecorder()
+
+		n := NewNotifier(testLogger())
+		if err := n.SetDefaultURL(fallback.URL, tc.mode); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.mode, err)
+		}
+		n.Send(testTransaction("txn_no_url", ""), domain.EventRetryScheduled, 0)
+		n.Send(testTransaction("txn_own_url", merchant.URL), domain.EventRetryScheduled, 0)
+
+		time.Sleep(200 * time.Millisecond)
+
+		if got := merchantGot(); !slices.Equal(got, []string{"txn_own_url"}) {
+			t.Errorf("%s: merchant URL expected only txn_own_url, got %v", tc.mode, got)
+		}
+		if got := fallbackGot(); !slices.Equal(got, tc.wantDefault) {
+			t.Errorf("%s: default URL expected %v, got %v", tc.mode, tc.wantDefault, got)
+		}
+		merchant.Close()
+		fallback.Close()
+	}
+
+	n := NewNotifier(testLogger())
+	if err := n.SetDefaultURL("ftp://example.com/hook", DefaultURLFallback); err == nil {
+		t.Error("expected a non-http default URL to be rejected")
+	}
+	if _, err := ParseDefaultURLMode("both"); err == nil {
+		t.Error("expected an unknown mode to be rejected")
+	}
+}
+
+type fakeRecoveryStats map[string]int64
+
+func (f fakeRecoveryStats) RecoveredTotal(merchantID, currency string) (int64, int) {
+	return f[merchantID+"/"+currency], 1
+}
+
+func TestNotifier_RecoveryEventCarriesCumulativeTotals(t *testing.T) {
+	var mu sync.Mutex
+	var got []domain.WebhookEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event domain.WebhookEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		mu.Lock()
+		got = append(got, event)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := NewNotifier(testLogger())
+	n.SetRecoveryStats(fakeRecoveryStats{"m_1/USD": 42500})
+
+	tx := testTransaction("txn_cumulative", server.URL)
+	tx.MerchantID, tx.Currency = "m_1", "USD"
+	n.Send(tx, domain.EventRetryFailed, 1)
+	n.Send(tx, domain.EventRetrySucceeded, 2)
+
+	time.Sleep(200 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 2 {
+		t.Fatalf("expected 2 deliveries, got %d", len(got))
+	}
+	for _, event := range got {
+		switch event.EventType {
+		case domain.EventRetrySucceeded:
+			want := domain.RecoveryTotals{MerchantID: "m_1", Currency: "USD", RecoveredCents: 42500, RecoveredCount: 1}
+			if event.Cumulative == nil || *event.Cumulative != want {
+				t.Errorf("expected cumulative %+v on the recovery event, got %+v", want, event.Cumulative)
+			}
+		default:
+			if event.Cumulative != nil {
+				t.Errorf("expected no cumulative totals on %s", event.EventType)
+			}
+		}
+	}
+}
+
+func TestNotifier_SendHonorsEventAllowList(t *testing.T) {
+	var mu sync.Mutex
+	var delivered []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event domain.WebhookEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		mu.Lock()
+		delivered = append(delivered, event.EventType)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	tx := testTransaction("txn_allow_list", server.URL)
+	tx.WebhookEvents = []string{domain.EventRetrySucceeded, domain.EventRetryExhausted}
+
+	n := NewNotifier(testLogger())
+	n.Send(tx, domain.EventRetryScheduled, 0)
+	n.Send(tx, domain.EventRetryFailed, 1)
+	n.Send(tx, domain.EventRetrySucceeded, 2)
+
+	time.Sleep(200 * time.Millisecond)
+
+	mu.Lock()
+	got := append([]string(nil), delivered...)
+	mu.Unlock()
+	if len(got) != 1 || got[0] != domain.EventRetrySucceeded {
+		t.Errorf("expected only %s delivered, got %v", domain.EventRetrySucceeded, got)
+	}
+
+	events := n.GetEvents()
+	if len(events) != 3 {
+		t.Fatalf("expected all 3 events recorded, got %d", len(events))
+	}
+	for _, e := range events {
+		if want := e.EventType != domain.EventRetrySucceeded; e.Filtered != want {
+			t.Errorf("%s: expected filtered=%v, got %v", e.EventType, want, e.Filtered)
+		}
+	}
+	if dispatched, _ := n.Replay(time.Time{}, time.Now().Add(time.Minute), tx.ID, 10); dispatched != 1 {
+		t.Errorf("expected replay to skip filtered events, dispatched %d", dispatched)
+	}
+}
+
+// blockingServer accepts webhook requests but holds them until release is closed.
+func blockingServer(t *testing.T) (url string, release func()) {
+	t.Helper()
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+		w.WriteHeader(http.StatusOK)
+	}))
+	var once sync.Once
+	release = func() {
+		once.Do(func() { close(unblock) })
+		server.Close()
+	}
+	t.Cleanup(release)
+	return server.URL, release
+}
+
+func TestNotifier_QueueFullDropPolicy(t *testing.T) {
+	url, _ := blockingServer(t)
+	n := NewQueuedNotifier(testLogger(), QueueConfig{Workers: 1, Size: 1, Overflow: OverflowDrop})
+	tx := testTransaction("txn_q_drop", url)
+
+	for i := range 5 {
+		n.Send(tx, domain.EventRetryFailed, i+1)
+	}
+
+	// One delivery in flight plus one queued at most; the rest are dropped.
+	if dropped := n.DroppedDeliveries(); dropped < 3 {
+		t.Errorf("expected at least 3 dropped deliveries, got %d", dropped)
+	}
+	if events := n.GetEvents(); len(events) != 5 {
+		t.Errorf("expected all 5 events recorded despite drops, got %d", len(events))
+	}
+}
+
+func TestNotifier_QueueFullBlockPolicy(t *testing.T) {
+	url, _ := blockingServer(t)
+	timeout := 50 * time.Millisecond
+	n := NewQueuedNotifier(testLogger(), QueueConfig{Workers: 1, Size: 1, Overflow: OverflowBlock, BlockTimeout: timeout})
+	tx := testTransaction("txn_q_block", url)
+
+	start := time.Now()
+	for i := range 3 {
+		n.Send(tx, domain.EventRetryFailed, i+1)
+	}
+	elapsed := time.Since(start)
+
+	if elapsed < timeout {
+		t.Errorf("expected Send to block for the timeout on a full queue, returned after %v", elapsed)
+	}
+	if elapsed > 2*time.Second {
+		t.Errorf("expected Send to give up after the timeout, took %v", elapsed)
+	}
+	if dropped := n.DroppedDeliveries(); dropped < 1 {
+		t.Errorf("expected the timed-out delivery to be dropped, got %d", dropped)
+	}
+	if events := n.GetEvents(); len(events) != 3 {
+		t.Errorf("expected all 3 events recorded, got %d", len(events))
+	}
+}
+
+func TestParseOverflowPolicy(t *testing.T) {
+	if p, err := ParseOverflowPolicy("block"); err != nil || p != OverflowBlock {
+		t.Errorf("expected block, got %q (%v)", p, err)
+	}
+	if _, err := ParseOverflowPolicy("spill"); err == nil {
+		t.Error("expected error for unknown policy")
+	}
+}
+
+func TestNotifier_DedupesSameAttempt(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n := NewNotifier(testLogger())
+	tx := testTransaction("txn_dup", server.URL)
+
+	n.Send(tx, domain.EventRetryFailed, 1)
+	n.Send(tx, domain.EventRetryFailed, 1)
+	n.Send(tx, domain.EventRetryFailed, 2)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for hits.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := hits.Load(); got != 2 {
+		t.Errorf("expected 2 deliveries (attempt 1 once, attempt 2), got %d", got)
+	}
+
+	events := n.GetEvents()
+	if len(events) != 3 {
+		t.Fatalf("expected all 3 events recorded, got %d", len(events))
+	}
+	if events[0].Duplicate || !events[1].Duplicate || events[2].Duplicate {
+		t.Errorf("expected only the repeated attempt to be flagged duplicate, got %+v", events)
+	}
+}
+
+func TestNotifier_DedupeKeyTracksPlanAndExpiry(t *testing.T) {
+	n := NewNotifier(testLogger())
+	tx := testTransaction("txn_dup_plan", "")
+	tx.RetryPlan = &domain.RetryPlan{ScheduledTimes: make([]time.Time, 3)}
+
+	n.Send(tx, domain.EventRetryScheduled, 0)
+	// A fallback or budget reset extends the plan; its event is not a repeat.
+	tx.RetryPlan.ScheduledTimes = make([]time.Time, 5)
+	n.Send(tx, domain.EventRetryScheduled, 0)
+
+	// A key older than the window no longer suppresses, even before pruning.
+	n.mu.Lock()
+	for k := range n.seen {
+		n.seen[k] = time.Now().Add(-dedupeWindow)
+	}
+	n.pruned = time.Now()
+	n.mu.Unlock()
+	n.Send(tx, domain.EventRetryScheduled, 0)
+
+	for i, e := range n.GetEvents() {
+		if e.Duplicate {
+			t.Errorf("event %d: expected delivery, got duplicate", i)
+		}
+	}
+
+	n.mu.Lock()
+	n.pruned = time.Now().Add(-dedupeWindow)
+	n.mu.Unlock()
+	n.Send(tx, domain.EventRetryFailed, 1)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.seen) != 2 {
+		t.Errorf("expected expired keys pruned, %d keys left", len(n.seen))
+	}
+}
+
+func TestNotifier_PerTransactionTimeout(t *testing.T) {
+	url, _ := blockingServer(t)
+
+	n := NewNotifier(testLogger())
+	tx := testTransaction("txn_timeout", url)
+	tx.WebhookTimeoutMs = 500
+
+	start := time.Now()
+	n.Send(tx, domain.EventRetryScheduled, 0)
+
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, failed := n.DeliveryStats(); failed > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	delivered, failed := n.DeliveryStats()
+	if failed != 1 || delivered != 0 {
+		t.Fatalf("expected slow endpoint to count as failed, got delivered=%d failed=%d", delivered, failed)
+	}
+	if elapsed := time.Since(start); elapsed > 1500*time.Millisecond {
+		t.Errorf("expected per-transaction timeout (500ms) to cut delivery short, took %v", elapsed)
+	}
+}
+
+// waitForStats polls DeliveryStats until delivered+failed reaches want.
+func waitForStats(t *testing.T, n *Notifier, want int64) (delivered, failed int64) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if delivered, failed = n.DeliveryStats(); delivered+failed >= want {
+			return delivered, failed
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return n.DeliveryStats()
+}
+
+func TestNotifier_AckVerification(t *testing.T) {
+	var echo atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event domain.WebhookEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		if echo.Load() {
+			io.WriteString(w, event.ID+"\n")
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+
+	n := NewNotifier(testLogger())
+	n.RequireAck("merchant_ack", true)
+	tx := testTransaction("txn_ack", server.URL)
+	tx.MerchantID = "merchant_ack"
+
+	n.Send(tx, domain.EventRetryScheduled, 0)
+	if delivered, failed := waitForStats(t, n, 1); delivered != 0 || failed != 1 {
+		t.Fatalf("expected wrong ack body to fail delivery, got delivered=%d failed=%d", delivered, failed)
+	}
+
+	echo.Store(true)
+	n.Send(tx, domain.EventRetryFailed, 1)
+	if delivered, failed := waitForStats(t, n, 2); delivered != 1 || failed != 1 {
+		t.Fatalf("expected echoed event ID to count as delivered, got delivered=%d failed=%d", delivered, failed)
+	}
+
+	other := testTransaction("txn_no_ack", server.URL)
+	other.MerchantID = "merchant_plain"
+	echo.Store(false)
+	n.Send(other, domain.EventRetryScheduled, 0)
+	if delivered, _ := waitForStats(t, n, 3); delivered != 2 {
+		t.Errorf("expected merchants without ack mode to accept any body, got delivered=%d", delivered)
+	}
+}
+
+func TestNotifier_AckMismatchRedelivers(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event domain.WebhookEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		// Only the second delivery of each event is acknowledged; events of
+		// txn_never_ack never are.
+		if hits.Add(1) == 2 && event.TransactionID != "txn_never_ack" {
+			io.WriteString(w, event.ID)
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+
+	n := NewNotifier(testLogger())
+	n.RequireAck("merchant_ack", true)
+	tx := testTransaction("txn_redeliver", server.URL)
+	tx.MerchantID = "merchant_ack"
+
+	n.Send(tx, domain.EventRetryScheduled, 0)
+	if delivered, failed := waitForStats(t, n, 1); delivered != 1 || failed != 0 {
+		t.Fatalf("expected the redelivered event to be acknowledged, got delivered=%d failed=%d", delivered, failed)
+	}
+	if got := hits.Load(); got != 2 {
+		t.Errorf("expected the event to be delivered twice, got %d requests", got)
+	}
+
+	hits.Store(0)
+	never := testTransaction("txn_never_ack", server.URL)
+	never.MerchantID = "merchant_ack"
+	n.Send(never, domain.EventRetryScheduled, 0)
+	if _, failed := waitForStats(t, n, 2); failed != 1 {
+		t.Fatalf("expected delivery to fail once redeliveries run out, got failed=%d", failed)
+	}
+	if got := hits.Load(); got != maxAckAttempts {
+		t.Errorf("expected %d delivery attempts, got %d", maxAckAttempts, got)
+	}
+}
+
+func TestNotifier_SuccessWebhookDelay(t *testing.T) {
+	arrived := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event domain.WebhookEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		arrived <- event.EventType
+	}))
+	defer server.Close()
+
+	const delay = 300 * time.Millisecond
+	n := NewNotifier(testLogger())
+	if err := n.SetSuccessWebhookDelay("merchant_settle", delay); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tx := testTransaction("txn_settle", server.URL)
+	tx.MerchantID = "merchant_settle"
+
+	start := time.Now()
+	n.Send(tx, domain.EventRetryFailed, 1)
+	n.Send(tx, domain.EventRetrySucceeded, 2)
+	if got := len(n.GetEventsByTransaction("txn_settle")); got != 2 {
+		t.Fatalf("expected both events recorded immediately, got %d", got)
+	}
+
+	for range 2 {
+		select {
+		case eventType := <-arrived:
+			elapsed := time.Since(start)
+			if eventType == domain.EventRetrySucceeded && elapsed < delay {
+				t.Errorf("retry.succeeded arrived after %s, before the %s delay", elapsed, delay)
+			}
+			if eventType == domain.EventRetryFailed && elapsed >= delay {
+				t.Errorf("retry.failed should not be delayed, arrived after %s", elapsed)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("webhook not delivered")
+		}
+	}
+
+	for _, bad := range []time.Duration{-time.Second, maxSuccessWebhookDelay + time.Second} {
+		if err := n.SetSuccessWebhookDelay("merchant_settle", bad); err == nil {
+			t.Errorf("expected error for delay %s", bad)
+		}
+	}
+}
+
+func TestNotifier_SuccessWebhookDelayClearAndShutdown(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer server.Close()
+
+	n := NewNotifier(testLogger())
+	n.SetSuccessWebhookDelay("merchant_settle", 100*time.Millisecond)
+	tx := testTransaction("txn_settle_clear", server.URL)
+	tx.MerchantID = "merchant_settle"
+
+	// Clear drops the delayed delivery along with the recorded events.
+	n.Send(tx, domain.EventRetrySucceeded, 1)
+	n.Clear()
+	time.Sleep(300 * time.Millisecond)
+	if got := hits.Load(); got != 0 {
+		t.Fatalf("expected the cleared delivery not to be sent, got %d requests", got)
+	}
+
+	// Shutdown delivers a delayed event without waiting out the delay.
+	n.SetSuccessWebhookDelay("merchant_settle", maxSuccessWebhookDelay)
+	n.Send(tx, domain.EventRetrySucceeded, 2)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := n.Shutdown(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := hits.Load(); got != 1 {
+		t.Fatalf("expected the delayed event delivered by Shutdown, got %d requests", got)
+	}
+
+	// Once shut down, events are no longer delayed.
+	n.Send(tx, domain.EventRetrySucceeded, 3)
+	if err := n.Shutdown(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := hits.Load(); got != 2 {
+		t.Errorf("expected an event sent after Shutdown to be delivered at once, got %d requests", got)
+	}
+}
+
+func TestNotifier_BatchesBySizeTrigger(t *testing.T) {
+	var mu sync.Mutex
+	var bodies [][]byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, body)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	n := NewNotifier(testLogger())
+	if err := n.SetBatching(BatchConfig{Size: 3, Interval: time.Minute}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	n.BatchDeliveries("merchant_batch", true)
+	tx := testTransaction("txn_batch", server.URL)
+	tx.MerchantID = "merchant_batch"
+
+	n.Send(tx, domain.EventRetryScheduled, 0)
+	n.Send(tx, domain.EventRetryFailed, 1)
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	if len(bodies) != 0 {
+		t.Fatalf("expected no delivery below the size trigger, got %d", len(bodies))
+	}
+	mu.Unlock()
+
+	n.Send(tx, domain.EventRetrySucceeded, 2)
+	if delivered, _ := waitForStats(t, n, 1); delivered != 1 {
+		t.Fatalf("expected one batch delivery, got %d", delivered)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(bodies) != 1 {
+		t.Fatalf("expected a single POST, got %d", len(bodies))
+	}
+	var events []domain.WebhookEvent
+	if err := json.Unmarshal(bodies[0], &events); err != nil {
+		t.Fatalf("expected a JSON array body: %v (%s)", err, bodies[0])
+	}
+	want := []string{domain.EventRetryScheduled, domain.EventRetryFailed, domain.EventRetrySucceeded}
+	if len(events) != len(want) {
+		t.Fatalf("expected %d events in the batch, got %d", len(want), len(events))
+	}
+	for i, e := range events {
+		if e.EventType != want[i] {
+			t.Errorf("event %d: expected %s, got %s", i, want[i], e.EventType)
+		}
+	}
+	if got := len(n.GetEventsByTransaction("txn_batch")); got != 3 {
+		t.Errorf("expected events still recorded individually, got %d", got)
+	}
+}
+
+func TestNotifier_BatchFlushesOnInterval(t *testing.T) {
+	received := make(chan []domain.WebhookEvent, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var events []domain.WebhookEvent
+		json.NewDecoder(r.Body).Decode(&events)
+		received <- events
+	}))
+	defer server.Close()
+
+	n := NewNotifier(testLogger())
+	if err := n.SetBatching(BatchConfig{Size: 100, Interval: 50 * time.Millisecond}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	n.BatchDeliveries("merchant_batch", true)
+	tx := testTransaction("txn_batch_timer", server.URL)
+	tx.MerchantID = "merchant_batch"
+	n.Send(tx, domain.EventRetryScheduled, 0)
+
+	select {
+	case events := <-received:
+		if len(events) != 1 {
+			t.Errorf("expected the partial batch of 1 event, got %d", len(events))
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("batch was not flushed after its interval")
+	}
+
+	if err := n.SetBatching(BatchConfig{Size: 0, Interval: time.Second}); err == nil {
+		t.Error("expected size 0 to be rejected")
+	}
+}
+
+func TestNotifier_ShutdownFlushesBatches(t *testing.T) {
+	received := make(chan []domain.WebhookEvent, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var events []domain.WebhookEvent
+		json.NewDecoder(r.Body).Decode(&events)
+		received <- events
+	}))
+	defer server.Close()
+
+	n := NewNotifier(testLogger())
+	n.SetBatching(BatchConfig{Size: 100, Interval: time.Hour})
+	n.BatchDeliveries("merchant_batch", true)
+	tx := testTransaction("txn_batch_shutdown", server.URL)
+	tx.MerchantID = "merchant_batch"
+	n.Send(tx, domain.EventRetryScheduled, 0)
+	n.Send(tx, domain.EventRetryFailed, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := n.Shutdown(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	select {
+	case events := <-received:
+		if len(events) != 2 {
+			t.Errorf("expected the pending batch of 2 events, got %d", len(events))
+		}
+	default:
+		t.Fatal("expected Shutdown to deliver the pending batch before returning")
+	}
+
+	// Once shut down, events are no longer held for a batch.
+	n.Send(tx, domain.EventRetrySucceeded, 2)
+	if err := n.Shutdown(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	select {
+	case events := <-received:
+		if len(events) != 1 {
+			t.Errorf("expected a batch of 1 event, got %d", len(events))
+		}
+	default:
+		t.Fatal("expected an event sent after Shutdown to be delivered at once")
+	}
+}
+
+func TestNotifier_BatchTimeoutAtLeastDefault(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	n := NewNotifier(testLogger())
+	n.SetBatching(BatchConfig{Size: 2, Interval: time.Hour})
+	n.BatchDeliveries("merchant_batch", true)
+	short := testTransaction("txn_batch_short", server.URL)
+	short.MerchantID = "merchant_batch"
+	short.WebhookTimeoutMs = 50
+	unset := testTransaction("txn_batch_unset", server.URL)
+	unset.MerchantID = "merchant_batch"
+
+	// One event asks for 50ms and the other for nothing: the batch still gets
+	// the notifier default, which the 200ms endpoint fits in.
+	n.Send(short, domain.EventRetryScheduled, 0)
+	n.Send(unset, domain.EventRetryScheduled, 0)
+	if delivered, failed := waitForStats(t, n, 1); delivered != 1 || failed != 0 {
+		t.Errorf("expected the batch delivered within the default timeout, got delivered=%d failed=%d", delivered, failed)
+	}
+}